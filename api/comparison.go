@@ -0,0 +1,58 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+// DefaultTolerancePct is the percent a metric can worsen, relative to a baseline,
+// before it's flagged as a regression when no tolerance is specified
+const DefaultTolerancePct = 10
+
+// Comparison metrics, see MetricDelta.Metric. Latency percentiles are named "P"
+// followed by the percentile (e.g., P99.9).
+const (
+	// CompareRqstRate is the number of requests per second
+	CompareRqstRate = "Rqsts/sec"
+	// CompareErrorRate is the percent of requests that failed
+	CompareErrorRate = "ErrorRate"
+)
+
+// RunComparison describes how a run's results differ from a baseline run's results
+type RunComparison struct {
+	// TolerancePct is the percent a metric can worsen before it's flagged as a
+	// regression. Error rates are compared in percentage points rather than
+	// relative to the baseline, i.e., an error rate increase from 1% to 3% is
+	// a regression if TolerancePct is less than 2.
+	TolerancePct float64
+	// Overall compares the results of all requests
+	Overall []MetricDelta
+	// Endpoints compares the results of each URL and method found in both runs
+	Endpoints []EndpointComparison
+	// MissingEndpoints are the URLs and methods in the baseline run that weren't in
+	// the current run. They aren't compared, so their Deltas are empty.
+	MissingEndpoints []EndpointComparison `json:",omitempty"`
+	// Regressions is the number of metrics flagged as regressions
+	Regressions int
+}
+
+// EndpointComparison compares the results of the requests to a single URL and method
+type EndpointComparison struct {
+	URL    string
+	Method string
+	Deltas []MetricDelta
+}
+
+// MetricDelta compares a single metric between a baseline and the current run
+type MetricDelta struct {
+	// Metric is the name of the metric, e.g., CompareRqstRate or P99
+	Metric string
+	// Baseline is the metric's value in the baseline run. Latencies are in seconds.
+	Baseline float64
+	// Current is the metric's value in the current run. Latencies are in seconds.
+	Current float64
+	// DeltaPct is the change from Baseline to Current as a percent of Baseline. For
+	// error rates it's the change in percentage points. It's 0 if Baseline is 0.
+	DeltaPct float64
+	// Regression is true if the metric worsened by more than the tolerance
+	Regression bool
+}
@@ -24,7 +24,8 @@ var MaxRunDuration = time.Duration(time.Hour * 3)
 // in the desired proportion to total requests, to a given
 // HTTP endpoint (e.g., someplace.com).
 type Endpoint struct {
-	// URL is the endpoint address
+	// URL is the endpoint address. URL, Headers, and RqstBody can be templates that
+	// are evaluated for each request, e.g., "http://someurl/users/{{ randInt 1 9 }}".
 	URL string
 	// Method is the HTTP Method
 	Method string
@@ -49,6 +50,291 @@ type Endpoint struct {
 	CertFile string
 	// Headers is an array of name-value pairs representing headers to send to the endpoint
 	Headers map[string]string
+	// Assertions, if specified, describes what a correct response from the endpoint
+	// looks like. Responses that don't satisfy all the assertions are counted as
+	// assertion failures.
+	Assertions *Assertions `json:",omitempty"`
+	// Transport, if specified, overrides the LoadTestConfig's Transport settings for
+	// requests to the endpoint. Settings that aren't specified are inherited.
+	Transport *Transport `json:",omitempty"`
+	// GRPC, if specified, makes the endpoint a gRPC endpoint. See GRPC for how the other
+	// Endpoint fields are used.
+	GRPC *GRPC `json:",omitempty"`
+	// WebSocket configures the messages sent and received on each connection to an
+	// endpoint whose URL is a 'ws://' or 'wss://' URL. See WebSocket for how the other
+	// Endpoint fields are used.
+	WebSocket *WebSocket `json:",omitempty"`
+}
+
+// WebSocket describes what's done on each connection to a WebSocket Endpoint. Each of
+// the Endpoint's requests opens a connection, sends Messages, waits for ExpectMsgs
+// messages, and then closes the connection. Headers are sent with the handshake, and
+// Method and RqstBody aren't used.
+type WebSocket struct {
+	// Messages are the text messages sent, in order, once the connection is open. Each
+	// message may be a request template.
+	Messages []string `json:",omitempty"`
+	// MsgRate is the number of messages sent per second on each connection. If it's 0
+	// each message is sent as soon as the previous one has been.
+	MsgRate int `json:",omitempty"`
+	// ExpectMsgs is the number of messages that must be received before the connection
+	// is closed
+	ExpectMsgs int `json:",omitempty"`
+}
+
+// GRPC describes the gRPC method an Endpoint calls. The Endpoint's URL is the server's
+// address, 'http://host:port' for a plaintext connection or 'https://host:port' for TLS,
+// and its RqstBody is the JSON form of the request message. Headers are sent as request
+// metadata and Method isn't used. Unary and server streaming methods can be called.
+type GRPC struct {
+	// Method is the full name of the method, e.g., "helloworld.Greeter/SayHello"
+	Method string
+	// DescriptorSetFile is the name of a file containing a FileDescriptorSet that
+	// describes the method, e.g., one created by
+	// 'protoc --include_imports --descriptor_set_out'. If it's not specified the method
+	// is described by the server, via server reflection, instead.
+	DescriptorSetFile string `json:",omitempty"`
+}
+
+// Transport.Protocol values
+const (
+	// ProtocolAuto uses HTTP/2 if the server agrees to it while negotiating TLS, and
+	// HTTP/1.1 otherwise, including for all 'http' URLs
+	ProtocolAuto = "auto"
+	// ProtocolHTTP1 only uses HTTP/1.1
+	ProtocolHTTP1 = "http1"
+	// ProtocolH2 requires HTTP/2 over TLS. Responses received via another protocol
+	// are failed.
+	ProtocolH2 = "h2"
+	// ProtocolH2C uses HTTP/2 without TLS, i.e., cleartext HTTP/2, with prior knowledge
+	// that the server supports it. It's for 'http' URLs.
+	ProtocolH2C = "h2c"
+	// ProtocolH3 uses HTTP/3, i.e., HTTP over QUIC, with prior knowledge that the server
+	// supports it. It's for 'https' URLs.
+	ProtocolH3 = "h3"
+)
+
+// Transport configures the connections and the HTTP client used to send requests. Timeouts
+// are expressed in the same way as LoadTestConfig.RunDuration (e.g., 5s). Settings that
+// aren't specified have their default values.
+type Transport struct {
+	// DialTimeout limits how long establishing a connection can take. The default is no
+	// limit other than RqstTimeout.
+	DialTimeout string `json:",omitempty"`
+	// TLSHandshakeTimeout limits how long a TLS handshake can take. The default is no
+	// limit other than RqstTimeout.
+	TLSHandshakeTimeout string `json:",omitempty"`
+	// ResponseHeaderTimeout limits how long to wait for a response's headers once the
+	// request has been written. The default is no limit other than RqstTimeout.
+	ResponseHeaderTimeout string `json:",omitempty"`
+	// RqstTimeout limits how long a request, including reading the response body, can
+	// take. The default is the run's duration or, if the run is limited by NumRequests,
+	// 15s.
+	RqstTimeout string `json:",omitempty"`
+	// IdleConnTimeout is how long an idle connection is kept open for reuse. The default
+	// is no limit.
+	IdleConnTimeout string `json:",omitempty"`
+	// MaxConnsPerHost limits the number of connections to each host, including those
+	// in use. Requests wait for a connection once the limit is reached. The default, 0,
+	// is no limit.
+	MaxConnsPerHost int `json:",omitempty"`
+	// KeepAlive, if false, closes each connection after a single request. The default
+	// is true.
+	KeepAlive *bool `json:",omitempty"`
+	// Compression, if false, stops responses from being requested with gzip compression.
+	// The default is true.
+	Compression *bool `json:",omitempty"`
+	// NoDelay, if false, enables Nagle's algorithm, i.e., clears TCP_NODELAY, on each
+	// connection. The default is true.
+	NoDelay *bool `json:",omitempty"`
+	// Protocol is the HTTP protocol used, one of "auto", the default, "http1", "h2",
+	// "h2c", or "h3". HTTP/2 and HTTP/3 send concurrent requests as streams on a shared
+	// connection. ResponseHeaderTimeout, IdleConnTimeout, MaxConnsPerHost, and KeepAlive
+	// don't apply to "h2c". Only DialTimeout, which limits the QUIC handshake,
+	// IdleConnTimeout, RqstTimeout, and Compression apply to "h3".
+	Protocol string `json:",omitempty"`
+}
+
+// Assertions describes what a correct response from an Endpoint looks like
+type Assertions struct {
+	// Status is a list of the acceptable HTTP statuses. Each entry is a single
+	// status (e.g., "200"), a class of statuses (e.g., "2xx"), or an inclusive
+	// range (e.g., "200-204").
+	Status []string
+	// Headers maps the name of each header a response must contain to a regular
+	// expression its value must match. An empty regular expression only requires
+	// the header to be present.
+	Headers map[string]string
+	// BodyRegex is a regular expression the response body must match
+	BodyRegex string
+	// JSONPath maps JSONPath expressions, e.g., $.data.items[0].id, to the value
+	// expected at that location in a JSON response body. Only child (.name or
+	// ['name']) and array index ([n]) selectors are supported.
+	JSONPath map[string]interface{}
+	// MaxBodySize is the largest acceptable response body, in bytes. Zero means
+	// there is no limit.
+	MaxBodySize int64
+}
+
+// Scenario is an ordered sequence of requests, Steps, that virtual users send over and
+// over, e.g., create a user, get the user, then delete the user. Values extracted from a
+// step's response can be used in the requests of the steps that follow it.
+type Scenario struct {
+	// Name identifies the scenario. It must be unique.
+	Name string
+	// RqstPercent is the scenario's share of all requests, in the same way as
+	// Endpoint.RqstPercent. The RqstPercent of all Endpoints and Scenarios must add
+	// to 100. The scenario's share of MaxConcurrentRqsts is its number of virtual users.
+	RqstPercent int
+	// Steps are the requests each virtual user sends, in order, during each iteration
+	// of the scenario
+	Steps []Step
+}
+
+// Step is a single request of a Scenario. Its URL, Headers, and RqstBody can refer to
+// variables extracted by earlier steps of the same iteration, e.g., {{.userID}}. The
+// Endpoint's RqstPercent and NumRequests are ignored.
+type Step struct {
+	// Name identifies the step in the report. The default is the step's Method and URL.
+	Name string
+	Endpoint
+	// Extract lists the values to extract from the step's response. A response that's
+	// missing any of them fails the iteration.
+	Extract []Extract `json:",omitempty"`
+}
+
+// Extract describes a value to extract from a response into a variable. Exactly one of
+// JSONPath, Header, or Regex must be specified.
+type Extract struct {
+	// Var is the name of the variable, e.g., userID, which later steps refer to as
+	// {{.userID}}. It must be a valid identifier.
+	Var string
+	// JSONPath selects the value from a JSON response body, e.g., $.data.id. See
+	// Assertions.JSONPath for the supported syntax.
+	JSONPath string `json:",omitempty"`
+	// Header is the name of the response header whose value is extracted
+	Header string `json:",omitempty"`
+	// Regex is matched against the response body. The value is the regular expression's
+	// first capture group, or the entire match if it doesn't have any.
+	Regex string `json:",omitempty"`
+}
+
+// DataSource modes, see DataSource.Mode
+const (
+	// DataSequential hands out rows in order, each request gets the next row
+	DataSequential = "sequential"
+	// DataRandom gives each request a randomly chosen row
+	DataRandom = "random"
+	// DataUnique gives each virtual user a row of its own that it uses for all of its
+	// requests
+	DataUnique = "unique"
+)
+
+// DataSource exhaustion policies, see DataSource.OnExhausted
+const (
+	// DataRecycle starts over from the first row
+	DataRecycle = "recycle"
+	// DataStop stops the requestors that need another row
+	DataStop = "stop"
+)
+
+// DataSource is a file of rows that requests can draw values from
+type DataSource struct {
+	// Name identifies the source in request templates. It must be unique.
+	Name string
+	// File is the path of the file containing the rows
+	File string
+	// Format is either "csv" or "jsonl". The default is taken from the File's
+	// extension, .csv, .jsonl, or .ndjson. The first line of a CSV file names its
+	// columns. Each line of a JSONL file is a JSON object whose fields are its columns.
+	Format string `json:",omitempty"`
+	// Mode is how rows are handed out, "sequential", the default, "random", or "unique".
+	// Sequential rows are shared by all the requests, and all the virtual users, that
+	// refer to the source.
+	Mode string `json:",omitempty"`
+	// OnExhausted is what happens when there are no more "sequential" or "unique" rows,
+	// either "recycle", the default, or "stop"
+	OnExhausted string `json:",omitempty"`
+}
+
+// Stage describes the load targeted during one stage of a staged load test run.
+// Stages allow ramp-up, steady-state, ramp-down, spike, and soak profiles to be
+// run as a single test.
+type Stage struct {
+	// Duration is how long the stage lasts. It's expressed in the same way as
+	// LoadTestConfig.RunDuration (e.g., 30s, 5m).
+	Duration string
+	// RqstRate is the overall requests per second targeted by the stage. When
+	// StageInterpolation is "linear" it must be greater than zero.
+	RqstRate int
+	// FromRqstRate, if specified, is the request rate a "linear" stage ramps from
+	// instead of the previous stage's RqstRate. Unlike RqstRate it can be zero, e.g.,
+	// to ramp up from no load at all.
+	FromRqstRate *int `json:",omitempty"`
+	// MaxConcurrentRqsts is the overall number of simultaneously running requests
+	// targeted by the stage
+	MaxConcurrentRqsts int
+}
+
+// Arrival describes how the times between requests are distributed. Whatever the
+// distribution, the average request rate is the configured request rate, unless a
+// "bursty" BurstSize and BurstPeriod are both specified.
+type Arrival struct {
+	// Type is one of "constant", the default, which sends requests at evenly spaced
+	// intervals, "poisson", which sends requests with exponentially distributed intervals,
+	// "uniform", which randomly varies each interval by up to Jitter, or "bursty", which
+	// sends requests in bursts of BurstSize every BurstPeriod.
+	Type string
+	// Jitter is the largest fraction, from 0 to 1, that a "uniform" interval can vary from
+	// the average interval. The default is 1, i.e., intervals vary uniformly from 0 to twice
+	// the average interval. A Jitter of 0 sends requests at evenly spaced intervals.
+	Jitter *float64 `json:",omitempty"`
+	// BurstSize is the number of requests sent together in each "bursty" burst. Without
+	// a BurstPeriod bursts are sent every BurstSize/RqstRate seconds, e.g., a RqstRate of
+	// 100 and a BurstSize of 50 sends 50 requests every 500ms, and it must be greater than 1.
+	BurstSize int
+	// BurstPeriod is the time between the start of successive "bursty" bursts, e.g., "5s".
+	// Without a BurstSize each burst is RqstRate*BurstPeriod requests so the average request
+	// rate is RqstRate. With a BurstSize, BurstSize requests are sent every BurstPeriod and
+	// the average request rate is BurstSize/BurstPeriod rather than RqstRate.
+	BurstPeriod string `json:",omitempty"`
+}
+
+// Threshold metrics, see Threshold.Metric
+const (
+	// MetricMinLatency is the smallest request latency
+	MetricMinLatency = "Min"
+	// MetricMaxLatency is the largest request latency
+	MetricMaxLatency = "Max"
+	// MetricAvgLatency is the average request latency
+	MetricAvgLatency = "Avg"
+	// MetricErrorRate is the percent of requests that failed
+	MetricErrorRate = "ErrorRate"
+	// MetricRqstRate is the number of requests per second
+	MetricRqstRate = "RqstRate"
+	// MetricAssertionFailureRate is the percent of checked responses that failed their assertions
+	MetricAssertionFailureRate = "AssertionFailureRate"
+)
+
+// Threshold is a service level objective a run must meet, e.g., a P95 latency of at
+// most 200ms or an error rate below 1%
+type Threshold struct {
+	// Metric is the measurement being checked. It's either a latency percentile,
+	// "P" followed by the percentile (e.g., P95, P99.9), or one of MetricMinLatency,
+	// MetricMaxLatency, MetricAvgLatency, MetricErrorRate, MetricRqstRate, or
+	// MetricAssertionFailureRate.
+	Metric string
+	// URL, if specified, limits the threshold to requests to the URL. Otherwise the
+	// threshold applies to all requests.
+	URL string
+	// Method, if specified, limits the threshold to requests to URL using the method.
+	// URL is required if Method is specified.
+	Method string
+	// Max is the largest acceptable value of Metric. Latencies are expressed as durations
+	// (e.g., 200ms), rates as numbers (e.g., 1.5). A "%" suffix is allowed on percents.
+	Max string
+	// Min is the smallest acceptable value of Metric, expressed in the same way as Max
+	Min string
 }
 
 // LoadTestConfig contains all the information needed to configure
@@ -69,6 +355,27 @@ type LoadTestConfig struct {
 	// both RunDuration and NumRequests is an error. See RunDuration
 	// above for a bit more info.
 	NumRequests int
+	// Stages, if specified, divides the run into a sequence of stages, each with
+	// its own target request rate and concurrency. The run lasts for the sum of
+	// the stage durations so RunDuration and NumRequests must both be zero.
+	Stages []Stage
+	// StageInterpolation specifies how the request rate and concurrency change
+	// over the course of each stage. "linear", the default, ramps linearly from the
+	// previous stage's targets to the stage's targets. The first stage ramps from
+	// RqstRate and MaxConcurrentRqsts if they're non-zero. "step" applies each
+	// stage's targets for the entirety of the stage.
+	StageInterpolation string
+	// LoadModel specifies how requests are scheduled. "closed", the default, has each
+	// concurrent requestor wait for a response before pacing and sending its next request.
+	// A slow server therefore lowers the offered load. "open" sends requests at their
+	// intended times, from a central pacer, regardless of how long earlier requests take.
+	// Latencies are then measured from each request's intended send time. Requests that
+	// can't be sent because all MaxConcurrentRqsts are in progress are dropped. "open"
+	// requires a RqstRate greater than zero, or Stages.
+	LoadModel string
+	// Arrival specifies how the times between requests are distributed. The default
+	// sends requests at evenly spaced intervals.
+	Arrival Arrival
 	// KeyFile is the name of a file, in PEM format, that contains an SSL private
 	//  key. It will only be used if it has a non-empty value. It can be overridden
 	// at the Endpoint level.
@@ -77,6 +384,33 @@ type LoadTestConfig struct {
 	// certificate. It will only be used if it has a non-empty value. It can be
 	// overridden, along with the KeyFile, at the Endpoint level.
 	CertFile string
+	// Transport configures the connections and the HTTP client used to send requests.
+	// It can be overridden at the Endpoint level.
+	Transport Transport
+	// HistogramSigDigits is the number of significant digits, 1 through 5, that
+	// request latencies are recorded with. Higher values are more precise, but use
+	// more memory. The default is 3.
+	HistogramSigDigits int
+	// HistogramMaxLatency is the largest request latency that can be recorded. It's
+	// expressed in the same way as RunDuration (e.g., 30s). Larger latencies will be
+	// recorded as HistogramMaxLatency. Smaller values use less memory. The default is
+	// MaxRunDuration.
+	HistogramMaxLatency string
+	// TimeSeriesInterval, if specified, buckets the run's results into intervals of
+	// this length, by when each response was received, so that changes over the
+	// course of the run are visible. It's expressed in the same way as RunDuration
+	// (e.g., 10s). See RunResults.TimeSeries.
+	TimeSeriesInterval string
+	// Thresholds are the service level objectives the run must meet. If any threshold
+	// isn't met heyyall exits with a non-zero exit code.
+	Thresholds []Threshold
 	// Endpoints is the set of endpoints (Endpoint) to make requests to
 	Endpoints []Endpoint
+	// Scenarios, if specified, are sequences of requests run by virtual users alongside
+	// the requests to Endpoints
+	Scenarios []Scenario `json:",omitempty"`
+	// DataSources, if specified, are files of rows, e.g., user IDs, that requests draw
+	// from. Their columns can be referred to in the URL, Headers, and RqstBody of
+	// Endpoints and Scenario Steps as {{ data "name" "column" }}.
+	DataSources []DataSource `json:",omitempty"`
 }
@@ -0,0 +1,187 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// DefaultHistogramSigDigits is the number of significant digits used by a
+// Histogram when none is specified.
+const DefaultHistogramSigDigits = 3
+
+// Histogram is a high dynamic range (HDR) histogram of durations. Its memory
+// use depends only on its precision and range, not on the number of durations
+// recorded. Percentiles are calculated directly from the histogram's buckets
+// and are accurate to the number of significant digits the Histogram was
+// created with. Histograms can be merged and serialized to and from JSON.
+type Histogram struct {
+	hist *hdrhistogram.Histogram
+}
+
+// HistogramBar describes how many recorded durations fall within the range
+// From - To, inclusive.
+type HistogramBar struct {
+	From  time.Duration
+	To    time.Duration
+	Count int64
+}
+
+// NewHistogram returns a Histogram that records durations from 1 nanosecond up
+// to 'maxDuration' with 'sigDigits' significant digits of precision. 'sigDigits'
+// must be between 1 and 5. A 'sigDigits' of 0 is replaced by DefaultHistogramSigDigits
+// and a 'maxDuration' of 0 is replaced by MaxRunDuration.
+func NewHistogram(sigDigits int, maxDuration time.Duration) *Histogram {
+	if sigDigits == 0 {
+		sigDigits = DefaultHistogramSigDigits
+	}
+	if maxDuration <= 0 {
+		maxDuration = MaxRunDuration
+	}
+	return &Histogram{hist: hdrhistogram.New(1, int64(maxDuration), sigDigits)}
+}
+
+// Record adds 'd' to the histogram. Durations outside of the range supported by
+// the histogram are recorded as the closest supported value.
+func (h *Histogram) Record(d time.Duration) {
+	v := int64(d)
+	if v < 0 {
+		v = 0
+	}
+	if v > h.hist.HighestTrackableValue() {
+		v = h.hist.HighestTrackableValue()
+	}
+	// Can't fail, 'v' has been constrained to the histogram's range
+	_ = h.hist.RecordValue(v)
+}
+
+// Merge adds all the durations recorded in 'other' to 'h'. It returns the number of
+// durations from 'other' that are outside of the range supported by 'h' and were
+// therefore dropped.
+func (h *Histogram) Merge(other *Histogram) (dropped int64) {
+	if other == nil {
+		return 0
+	}
+	return h.hist.Merge(other.hist)
+}
+
+// Reset clears all recorded durations
+func (h *Histogram) Reset() {
+	h.hist.Reset()
+}
+
+// Count returns the number of durations recorded
+func (h *Histogram) Count() int64 {
+	if h == nil {
+		return 0
+	}
+	return h.hist.TotalCount()
+}
+
+// Min returns the smallest recorded duration
+func (h *Histogram) Min() time.Duration {
+	if h.Count() == 0 {
+		return 0
+	}
+	return time.Duration(h.hist.Min())
+}
+
+// Max returns the largest recorded duration
+func (h *Histogram) Max() time.Duration {
+	if h.Count() == 0 {
+		return 0
+	}
+	return time.Duration(h.hist.Max())
+}
+
+// Percentile returns the duration at 'percentile', a value between 0 and 100
+// (e.g., 99.9). A 'percentile' of 0 returns the smallest recorded duration. The
+// median of an even number of durations is the mean of the middle 2 durations.
+// Other percentiles that fall between 2 durations are rounded up to the larger
+// one, e.g., the P75 of 100 durations is the 76th smallest duration.
+func (h *Histogram) Percentile(percentile float64) time.Duration {
+	n := h.Count()
+	if n == 0 {
+		return 0
+	}
+	if percentile <= 0 {
+		return h.Min()
+	}
+	if percentile > 100 {
+		percentile = 100
+	}
+	if percentile == 50 && n%2 == 0 {
+		return (h.valueAtRank(n/2-1) + h.valueAtRank(n/2)) / 2
+	}
+	return h.valueAtRank(int64(math.Ceil(float64(n-1) * percentile / 100)))
+}
+
+// valueAtRank returns the duration at 'rank', i.e., the 'rank'th smallest recorded
+// duration counting from 0
+func (h *Histogram) valueAtRank(rank int64) time.Duration {
+	// ValueAtPercentile returns the highest duration equivalent to the one whose
+	// position is percentile/100 * Count, rounded to the nearest position.
+	return time.Duration(h.hist.ValueAtPercentile(float64(rank+1) * 100 / float64(h.Count())))
+}
+
+// Equivalent reports whether 'd1' and 'd2' are indistinguishable at the precision
+// of the histogram.
+func (h *Histogram) Equivalent(d1, d2 time.Duration) bool {
+	return h.hist.ValuesAreEquivalent(int64(d1), int64(d2))
+}
+
+// SigDigits returns the number of significant digits the histogram records durations with
+func (h *Histogram) SigDigits() int {
+	return int(h.hist.SignificantFigures())
+}
+
+// MaxTrackable returns the largest duration the histogram can record
+func (h *Histogram) MaxTrackable() time.Duration {
+	return time.Duration(h.hist.HighestTrackableValue())
+}
+
+// Distribution returns the histogram's bars in ascending order of duration, up to
+// and including the bar containing the largest recorded duration.
+func (h *Histogram) Distribution() []HistogramBar {
+	if h.Count() == 0 {
+		return nil
+	}
+	bars := h.hist.Distribution()
+	dist := make([]HistogramBar, 0, len(bars))
+	for _, b := range bars {
+		dist = append(dist, HistogramBar{From: time.Duration(b.From), To: time.Duration(b.To), Count: b.Count})
+	}
+	return dist
+}
+
+// MarshalJSON encodes the histogram as a string containing the base64 encoded,
+// compressed, HdrHistogram V2 representation of the histogram. This format can
+// be read by HdrHistogram implementations in other languages.
+func (h *Histogram) MarshalJSON() ([]byte, error) {
+	encoded, err := h.hist.Encode(hdrhistogram.V2CompressedEncodingCookieBase)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding histogram: %w", err)
+	}
+	return json.Marshal(string(encoded))
+}
+
+// UnmarshalJSON decodes a histogram encoded by MarshalJSON
+func (h *Histogram) UnmarshalJSON(b []byte) error {
+	var encoded string
+	if err := json.Unmarshal(b, &encoded); err != nil {
+		return err
+	}
+	hist, err := hdrhistogram.Decode([]byte(encoded))
+	if err != nil {
+		return fmt.Errorf("error decoding histogram: %w", err)
+	}
+	h.hist = hist
+	return nil
+}
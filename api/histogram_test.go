@@ -0,0 +1,97 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestHistogramPercentiles(t *testing.T) {
+	tests := []struct {
+		name       string
+		sigDigits  int
+		numVals    int
+		percentile float64
+		expected   time.Duration
+	}{
+		{name: "empty", sigDigits: 3, numVals: 0, percentile: 99, expected: 0},
+		{name: "min", sigDigits: 3, numVals: 10000, percentile: 0, expected: time.Microsecond},
+		{name: "P50", sigDigits: 3, numVals: 10000, percentile: 50, expected: 5000 * time.Microsecond},
+		// NOTE: Percentiles that fall between 2 durations are rounded up to the larger one
+		{name: "P99.9", sigDigits: 3, numVals: 10000, percentile: 99.9, expected: 9991 * time.Microsecond},
+		{name: "P99.99", sigDigits: 3, numVals: 10000, percentile: 99.99, expected: 10000 * time.Microsecond},
+		{name: "P99.99 5 sig digits", sigDigits: 5, numVals: 10000, percentile: 99.99, expected: 10000 * time.Microsecond},
+		{name: "even median", sigDigits: 3, numVals: 10000, percentile: 50, expected: 5000500 * time.Nanosecond},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			h := NewHistogram(tc.sigDigits, time.Second)
+			for i := 1; i <= tc.numVals; i++ {
+				h.Record(time.Duration(i) * time.Microsecond)
+			}
+			actual := h.Percentile(tc.percentile)
+			if !h.Equivalent(tc.expected, actual) {
+				t.Errorf("expected %s, got %s", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestHistogramOutOfRange(t *testing.T) {
+	h := NewHistogram(2, time.Second)
+	h.Record(time.Minute)
+	h.Record(-time.Second)
+
+	if h.Count() != 2 {
+		t.Errorf("expected 2 recorded durations, got %d", h.Count())
+	}
+	if !h.Equivalent(time.Second, h.Max()) {
+		t.Errorf("expected max of %s, got %s", time.Second, h.Max())
+	}
+	if h.Min() != 0 {
+		t.Errorf("expected min of 0, got %s", h.Min())
+	}
+}
+
+func TestHistogramMergeAndJSON(t *testing.T) {
+	h1 := NewHistogram(3, time.Minute)
+	h2 := NewHistogram(3, time.Minute)
+	for i := 1; i <= 100; i++ {
+		h1.Record(time.Duration(i) * time.Millisecond)
+		h2.Record(time.Duration(i+100) * time.Millisecond)
+	}
+
+	if dropped := h1.Merge(h2); dropped != 0 {
+		t.Errorf("expected no dropped durations, got %d", dropped)
+	}
+	if h1.Count() != 200 {
+		t.Errorf("expected 200 durations after merge, got %d", h1.Count())
+	}
+
+	b, err := json.Marshal(struct{ H *Histogram }{H: h1})
+	if err != nil {
+		t.Fatalf("unexpected error marshaling histogram: %s", err)
+	}
+	actual := struct{ H *Histogram }{}
+	if err = json.Unmarshal(b, &actual); err != nil {
+		t.Fatalf("unexpected error unmarshaling histogram: %s", err)
+	}
+
+	if actual.H.Count() != h1.Count() {
+		t.Errorf("expected %d durations, got %d", h1.Count(), actual.H.Count())
+	}
+	if actual.H.SigDigits() != h1.SigDigits() || actual.H.MaxTrackable() != h1.MaxTrackable() {
+		t.Errorf("expected histogram config %d/%s, got %d/%s", h1.SigDigits(), h1.MaxTrackable(),
+			actual.H.SigDigits(), actual.H.MaxTrackable())
+	}
+	for _, p := range []float64{0, 50, 90, 99, 99.9} {
+		if actual.H.Percentile(p) != h1.Percentile(p) {
+			t.Errorf("P%v: expected %s, got %s", p, h1.Percentile(p), actual.H.Percentile(p))
+		}
+	}
+}
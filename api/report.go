@@ -6,13 +6,51 @@ package api
 
 import "time"
 
+// Classifications of request errors reported in EndpointDetail.HTTPMethodErrorDist
+const (
+	// ErrDNS indicates the endpoint's hostname couldn't be resolved
+	ErrDNS = "DNSFailure"
+	// ErrConnRefused indicates the endpoint refused the connection
+	ErrConnRefused = "ConnectionRefused"
+	// ErrConnReset indicates the connection was reset or closed by the endpoint
+	ErrConnReset = "ConnectionReset"
+	// ErrTLSHandshake indicates the TLS handshake with the endpoint failed
+	ErrTLSHandshake = "TLSHandshakeFailure"
+	// ErrTimeout indicates the request exceeded the client's timeout
+	ErrTimeout = "ClientTimeout"
+	// ErrCtxCancelled indicates the request was cancelled before it completed
+	ErrCtxCancelled = "ContextCancelled"
+	// ErrBodyRead indicates a response was received, but its body couldn't be read
+	ErrBodyRead = "BodyReadError"
+	// ErrExtract indicates a response didn't contain a value a scenario Step extracts
+	ErrExtract = "ExtractionFailure"
+	// ErrProtocol indicates a response wasn't received via the protocol the Endpoint's
+	// Transport requires, e.g., HTTP/1.1 rather than HTTP/2
+	ErrProtocol = "ProtocolMismatch"
+	// ErrWSClosed indicates a WebSocket connection was closed by the endpoint before all
+	// of its messages were sent and received
+	ErrWSClosed = "WebSocketClosed"
+	// ErrAssertion indicates a response was received, but it failed its Endpoint's Assertions
+	ErrAssertion = "AssertionFailure"
+	// ErrGRPCPrefix prefixes the status code of gRPC calls that didn't return OK, e.g.,
+	// "grpc_Unavailable"
+	ErrGRPCPrefix = "grpc_"
+	// ErrOther is any error that doesn't fit one of the other classifications
+	ErrOther = "Other"
+)
+
 // RqstStats contains a set of common runtime stats reported at both the
 // Summary and Endpoint level
 type RqstStats struct {
-	// TimingResultsNanos contains the duration of each request.
-	TimingResultsNanos []time.Duration
+	// TimingResultsNanos is a histogram of request durations. It's populated as
+	// responses arrive so its size is independent of the number of requests.
+	TimingResultsNanos *Histogram
 	// TotalRqsts is the overall number of requests made during the run
 	TotalRqsts int64
+	// TotalErrors is the number of requests that failed to get a response, or
+	// whose response couldn't be read. These requests are included in TotalRqsts,
+	// but not in any of the duration stats.
+	TotalErrors int64
 	// TotalRequestDurationNanos is the sum of all request run durations
 	TotalRequestDurationNanos time.Duration
 	// MaxRqstDurationNanos is the longest request duration
@@ -34,16 +72,97 @@ type EndpointDetail struct {
 	// HTTPMethodStatusDist summarizes, by HTTP method, the number of times a
 	// given status was returned (e.g., 200, 201, 404, etc). More specifically,
 	// it is a map keyed by HTTP method containing a map keyed by HTTP status
-	// referencing the number of times that status was returned.
+	// referencing the number of times that status was returned. The responses of gRPC
+	// endpoints are keyed by gRPC method and status code (e.g., 0 for OK, 5 for
+	// NOT_FOUND) instead.
 	HTTPMethodStatusDist map[string]map[int]int
+	// HTTPMethodErrorDist summarizes, by HTTP method, the number of times a given
+	// class of error occurred (e.g., ErrConnRefused). It is a map keyed by HTTP method
+	// containing a map keyed by error classification referencing the number of times
+	// that error occurred.
+	HTTPMethodErrorDist map[string]map[string]int
 	// HTTPMethodRqstStats provides summary request statistics by HTTP Method. It is
 	// map of RqstStats keyed by HTTP method.
 	HTTPMethodRqstStats map[string]*RqstStats
+	// HTTPMethodAssertionResults summarizes, by HTTP method, how many responses
+	// passed or failed the Endpoint's Assertions. It's only populated for Endpoints
+	// with Assertions.
+	HTTPMethodAssertionResults map[string]*AssertionResults `json:",omitempty"`
+	// ProtocolDist counts the endpoint's responses by the protocol they were received
+	// with, e.g., "HTTP/1.1" or "HTTP/2.0"
+	ProtocolDist map[string]int64 `json:",omitempty"`
+	// ConnsOpened is the number of new connections the endpoint's requests were sent on.
+	// Requests sent on a connection that was already open, e.g., one kept alive after an
+	// earlier request or an HTTP/2 connection carrying other streams, aren't counted.
+	ConnsOpened int64 `json:",omitempty"`
+	// RqstsPerConn is the average number of the endpoint's responses, i.e., streams when
+	// HTTP/2 or HTTP/3 is used, per connection opened
+	RqstsPerConn float64 `json:",omitempty"`
+	// WebSocket summarizes the messages sent and received on the endpoint's WebSocket
+	// connections. It's only populated for WebSocket endpoints.
+	WebSocket *WebSocketStats `json:",omitempty"`
+}
+
+// WebSocketStats summarizes the connections to a WebSocket endpoint
+type WebSocketStats struct {
+	// ConnectNanos is a histogram of the time taken to open each connection, from
+	// dialing until the WebSocket handshake completed
+	ConnectNanos *Histogram
+	// MsgRoundTripNanos is a histogram of message round trip times. A message's round
+	// trip time is from when it was sent until a message was received in reply. Messages
+	// are matched with replies in the order they were sent.
+	MsgRoundTripNanos *Histogram
+	// MsgsSent is the number of messages sent
+	MsgsSent int64
+	// MsgsRcvd is the number of messages received
+	MsgsRcvd int64
+	// MsgsSentPerSec is the rate at which messages were sent over the run
+	MsgsSentPerSec float64
+	// MsgsRcvdPerSec is the rate at which messages were received over the run
+	MsgsRcvdPerSec float64
+	// AbnormalCloseDist counts the connections closed by the endpoint with a close code
+	// other than 1000, normal closure, by close code. Connections that were lost without
+	// a close message are counted as 1006, abnormal closure.
+	AbnormalCloseDist map[int]int64 `json:",omitempty"`
 }
 
+// Assertion failure classifications, see AssertionResults.FailureDist
+const (
+	// AssertStatus indicates the response's HTTP status wasn't one of those expected
+	AssertStatus = "Status"
+	// AssertHeader indicates a required header was missing or its value didn't match
+	AssertHeader = "Header"
+	// AssertBodyRegex indicates the response body didn't match the expected regular expression
+	AssertBodyRegex = "BodyRegex"
+	// AssertJSONPath indicates a value in the response body wasn't the expected value
+	AssertJSONPath = "JSONPath"
+	// AssertBodySize indicates the response body was larger than allowed
+	AssertBodySize = "BodySize"
+)
+
+// AssertionResults counts the responses that passed or failed an Endpoint's Assertions
+type AssertionResults struct {
+	// Passed is the number of responses that satisfied all the assertions
+	Passed int64
+	// Failed is the number of responses that failed at least one assertion
+	Failed int64
+	// FailureDist is the number of times each kind of assertion (e.g., AssertStatus)
+	// failed. A single response can fail more than one kind of assertion.
+	FailureDist map[string]int64
+}
+
+// ReportVersion is the version of the RunResults JSON schema, see RunResults.Version.
+// It's incremented whenever existing fields change incompatibly. Version 1 reports,
+// which have no Version, recorded each request's duration in a TimingResultsNanos
+// array rather than in a histogram.
+const ReportVersion = 2
+
 // RunResults is used to report an overview of the results of a
 // load test run
 type RunResults struct {
+	// Version is the version of the report's JSON schema, ReportVersion for reports
+	// produced by this version of heyyall
+	Version int
 	// RunSummary is a roll-up of the detailed run results
 	RunSummary RunSummary
 	// EndpointSummary describes how often each endpoint was called.
@@ -53,6 +172,103 @@ type RunResults struct {
 	EndpointSummary map[string]map[string]int
 	// EndpointDetails is the per endpoint summary of results keyed by URL
 	EndpointDetails map[string]*EndpointDetail `json:",omitempty"`
+	// StageSummaries summarizes the results of each stage of a staged run
+	StageSummaries []*StageSummary `json:",omitempty"`
+	// ThresholdResults is the outcome of evaluating each of LoadTestConfig.Thresholds
+	ThresholdResults []ThresholdResult `json:",omitempty"`
+	// Comparison describes how the results differ from a baseline run, if one was specified
+	Comparison *RunComparison `json:",omitempty"`
+	// TimeSeries summarizes each LoadTestConfig.TimeSeriesInterval of the run, in order.
+	// It's only populated if TimeSeriesInterval is specified.
+	TimeSeries []*TimeSeriesBucket `json:",omitempty"`
+	// ScenarioSummaries summarizes the results of each of LoadTestConfig.Scenarios
+	ScenarioSummaries []*ScenarioSummary `json:",omitempty"`
+}
+
+// TimeSeriesBucket summarizes the responses received during a single interval of a run
+type TimeSeriesBucket struct {
+	// StartNanos is when the interval started relative to the start of the run
+	StartNanos time.Duration
+	// DurationNanos is the length of the interval. The last interval may be shorter
+	// than LoadTestConfig.TimeSeriesInterval.
+	DurationNanos time.Duration
+	// Stats summarizes all the responses received during the interval
+	Stats IntervalStats
+	// EndpointStats summarizes, by endpoint and HTTP method, the responses received
+	// during the interval. It is a map keyed by URL containing a map keyed by HTTP
+	// method. Endpoints with no responses during the interval are omitted.
+	EndpointStats map[string]map[string]*IntervalStats `json:",omitempty"`
+}
+
+// IntervalStats summarizes the responses received during a TimeSeriesBucket's interval.
+// Unlike RqstStats the latency distribution isn't kept, only selected percentiles.
+type IntervalStats struct {
+	// TotalRqsts is the number of responses received during the interval
+	TotalRqsts int64
+	// TotalErrors is the number of failed requests included in TotalRqsts
+	TotalErrors int64
+	// RqstRatePerSec is the rate at which responses were received during the interval
+	RqstRatePerSec float64
+	// MinRqstDurationNanos is the shortest request duration
+	MinRqstDurationNanos time.Duration
+	// MedianRqstDurationNanos is the median request duration
+	MedianRqstDurationNanos time.Duration
+	// P90RqstDurationNanos is the 90th percentile request duration
+	P90RqstDurationNanos time.Duration
+	// P99RqstDurationNanos is the 99th percentile request duration
+	P99RqstDurationNanos time.Duration
+	// MaxRqstDurationNanos is the longest request duration
+	MaxRqstDurationNanos time.Duration
+}
+
+// ThresholdResult is the outcome of evaluating a Threshold against a run's results
+type ThresholdResult struct {
+	Threshold Threshold
+	// Actual is the measured value of Threshold.Metric
+	Actual string
+	// Passed is true if Actual is within the Threshold's limits
+	Passed bool
+}
+
+// StageSummary is a roll-up of the results of a single stage of a staged run.
+// Requests are attributed to the stage during which they were sent.
+type StageSummary struct {
+	// Stage is the stage's position, starting from 1, in LoadTestConfig.Stages
+	Stage int
+	// DurationNanos is the configured duration of the stage
+	DurationNanos time.Duration
+	// TargetRqstRate is the request rate targeted by the stage
+	TargetRqstRate int
+	// TargetConcurrentRqsts is the concurrency targeted by the stage
+	TargetConcurrentRqsts int
+	// RqstRatePerSec is the request rate achieved during the stage
+	RqstRatePerSec float64
+	// DroppedRqsts is the number of requests the open load model dropped during the stage
+	DroppedRqsts int64 `json:",omitempty"`
+	// RqstStats is a summary of the stage's runtime statistics
+	RqstStats RqstStats
+}
+
+// ScenarioSummary is a roll-up of the results of a single Scenario
+type ScenarioSummary struct {
+	// Name is the scenario's name
+	Name string
+	// IterationStats summarizes the scenario's completed iterations. TotalRqsts is the
+	// number of iterations and TotalErrors the number that failed. A failed iteration is
+	// one that ended early because one of its steps failed, or failed its Assertions.
+	// The duration stats only include successful iterations, each of which is timed
+	// from when its first step was due until its last step completed.
+	IterationStats RqstStats
+	// Steps summarizes each of the scenario's steps, in order
+	Steps []*StepSummary
+}
+
+// StepSummary is a roll-up of the results of a single Scenario Step
+type StepSummary struct {
+	// Name is the step's name
+	Name string
+	// RqstStats is a summary of the step's runtime statistics
+	RqstStats RqstStats
 }
 
 // RunSummary is a roll-up of the detailed run results
@@ -62,6 +278,16 @@ type RunSummary struct {
 	RqstRatePerSec float64
 	// RunDurationNanos is the wall clock duration of the test
 	RunDurationNanos time.Duration
+	// OfferedRqstRatePerSec is the request rate the run attempted, including requests
+	// that were dropped
+	OfferedRqstRatePerSec float64
+	// LateRqsts is the number of requests that were sent later than their intended
+	// send time. It's only meaningful when LoadTestConfig.LoadModel is "open".
+	LateRqsts int64
+	// DroppedRqsts is the number of requests that weren't sent because all
+	// MaxConcurrentRqsts were in progress. It's only meaningful when
+	// LoadTestConfig.LoadModel is "open".
+	DroppedRqsts int64
 
 	// MaxRqstRatePerSec is the maximum request rate per second
 	// over 1/10th of the run duration or number of requests
@@ -73,13 +299,17 @@ type RunSummary struct {
 	// RqstStats is a summary of runtime statistics
 	RqstStats RqstStats
 	// DNSLookupNanos records how long it took to resolve the hostname to an IP Address
-	DNSLookupNanos []time.Duration
+	DNSLookupNanos *Histogram
 	// TCPConnSetupNanos records how long it took to setup the TCP connection
-	TCPConnSetupNanos []time.Duration
+	TCPConnSetupNanos *Histogram
 	// RqstRoundTripNanos records duration from the time the TCP connection was setup
 	// until the response was received
-	RqstRoundTripNanos []time.Duration
+	RqstRoundTripNanos *Histogram
 	// TLSHandshakeNanos records the time it took to complete the TLS negotiation with
 	// the server. It's only meaningful for HTTPS connections
-	TLSHandshakeNanos []time.Duration
+	TLSHandshakeNanos *Histogram
+	// QUICHandshakeNanos records the time it took to complete the QUIC handshake, which
+	// includes TLS, with the server. It's only populated if responses were received via
+	// HTTP/3.
+	QUICHandshakeNanos *Histogram `json:",omitempty"`
 }
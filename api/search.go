@@ -0,0 +1,42 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import "time"
+
+// SearchResults reports the outcome of a capacity search, which looks for the highest
+// request rate at which a service still meets its Thresholds
+type SearchResults struct {
+	// KneeRqstRate is the highest target request rate that met the Thresholds. It's 0
+	// if none of the rates tried did.
+	KneeRqstRate int
+	// Thresholds are the limits each iteration was required to meet
+	Thresholds []Threshold
+	// Iterations are the runs made during the search ordered by TargetRqstRate
+	Iterations []SearchIteration
+}
+
+// SearchIteration summarizes a single fixed rate run made during a capacity search
+type SearchIteration struct {
+	// TargetRqstRate is the request rate the iteration targeted
+	TargetRqstRate int
+	// RqstRatePerSec is the request rate achieved during the iteration
+	RqstRatePerSec float64
+	// TotalRqsts is the number of requests made during the iteration
+	TotalRqsts int64
+	// ErrorRatePct is the percent of the iteration's requests that failed
+	ErrorRatePct float64
+	// MedianRqstDurationNanos is the iteration's median request duration
+	MedianRqstDurationNanos time.Duration
+	// P90RqstDurationNanos is the iteration's 90th percentile request duration
+	P90RqstDurationNanos time.Duration
+	// P99RqstDurationNanos is the iteration's 99th percentile request duration
+	P99RqstDurationNanos time.Duration
+	// Passed is true if the iteration met all the Thresholds and achieved close to
+	// its TargetRqstRate
+	Passed bool
+	// ThresholdResults is the outcome of evaluating each of the Thresholds
+	ThresholdResults []ThresholdResult
+}
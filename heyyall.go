@@ -6,16 +6,21 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"os/signal"
 	"runtime"
 	"runtime/pprof"
+	"strings"
 	"syscall"
 	"time"
 
@@ -28,13 +33,42 @@ import (
 	"github.com/vbauerster/mpb/v5/decor"
 )
 
+// workerResultsWait is how long, beyond the end of a run, a controller waits for its
+// workers' results
+const workerResultsWait = 30 * time.Second
+
 func main() {
+	os.Exit(run())
+}
+
+// run executes heyyall and returns the process exit code. The exit code is 0 unless
+// heyyall was misconfigured, the run failed or its results couldn't be reported, the run
+// didn't meet all of its Thresholds, or the run regressed relative to a baseline.
+func run() int {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "compare":
+			return runCompare(os.Args[2:])
+		case "worker":
+			return runWorker(os.Args[2:])
+		case "search":
+			return runSearch(os.Args[2:])
+		}
+	}
+
 	usage := `
 Usage: heyyall -config <ConfigFileLocation> [flags...]
+       heyyall -u <URL> [-m <Method>] [-d <Body>] [-H <Header>]... [-n <NumRqsts>] [-c <Concurrency>]
+               [-q <RqstRatePerWorker>] [-z <Duration>] [flags...]
+       heyyall compare -baseline <ReportFile> -current <ReportFile> [flags...]
+       heyyall worker [-listen <Address>] [-token <Token>] [flags...]
+       heyyall search -config <ConfigFileLocation> [flags...]
 
 Options:
+  -config    The path of the load test configuration
   -loglevel  Logging level. Default is 'WARN' (2). 0 is DEBUG, 1 INFO, up to 4 FATAL
-  -out       Type of output report, 'text' or 'json'. Default is 'text'
+  -out       Type of output report, 'text', 'json', or 'html'. Default is 'text'. 'html' produces
+             a self-contained HTML page, with charts, that can be redirected to a file and shared.
   -nf        Normalization factor used to compress the output histogram by eliminating long tails. 
              Lower values provide a finer grained view of the data at the expense of dropping data
              associated with the tail of the latency distribution. The latter is partly mitigated by 
@@ -48,16 +82,61 @@ Options:
              the issue.
   -cpus      Specifies how many CPUs to use for the test run. The default is 0 which specifies that
 			 all CPUs should be used.
+  -baseline  A JSON report, produced by an earlier run with '-out json', to compare this run's
+             results to. The run exits with a non-zero status if any metric regressed.
+  -tolerance The percent a metric can worsen, relative to the baseline, before it's considered a
+             regression. Error rates are compared in percentage points. The default is 10.
+  -metrics-addr
+             The address, e.g., ':9100', to serve live Prometheus metrics on, at '/metrics', during
+             the run. Metrics aren't served by default.
+  -workers   A comma separated list of the addresses (host:port) of 'heyyall worker' processes.
+             The run's request rate, concurrency, and number of requests are split across the
+             workers, which run together, and their results are merged into a single report.
+  -worker-token
+             The token shared with the workers, see 'heyyall worker -help'. It's required with
+             -workers.
   -help     This usage message
+
+Quick run options, which build a configuration with a single endpoint instead of reading
+one from -config. They're a subset of 'hey's, except that the URL is given with -u.
+  -u         The URL to send requests to
+  -m         The HTTP method. The default is 'GET'.
+  -d         The request body
+  -D         A file containing the request body. It takes precedence over -d.
+  -T         The Content-Type header of the requests. The default is 'text/html'.
+  -H         A request header, e.g., -H 'X-Trace: abc'. Can be repeated, and takes precedence
+             over -T.
+  -A         The Accept header of the requests. It takes precedence over -H.
+  -a         Basic authentication credentials, 'username:password'. They take precedence
+             over an Authorization header given with -H.
+  -h2        Send the requests using HTTP/2, i.e., Transport.Protocol 'h2'
+  -disable-keepalive
+             Close each connection after a single request
+  -disable-compression
+             Don't request compressed responses
+  -n         The number of requests to send. The default is 200. Ignored if -z is given.
+  -c         The number of concurrent requests, i.e., workers. The default is 50.
+  -q         The request rate per second of each worker. The overall request rate is -q times
+             -c. The default is 0, no rate limit.
+  -z         How long to send requests for, e.g., '30s'. The run is limited by -n by default.
+  -t         The timeout of each request in seconds. The default is 20. 0 uses the default
+             Transport.RqstTimeout, the run's duration or, if the run is limited by -n, 15s.
 `
 
 	configFile := flag.String("config", "", "path and filename containing the runtime configuration")
 	logLevel := flag.Int("loglevel", int(zerolog.WarnLevel), "log level, 0 for debug, 1 info, 2 warn, ...")
-	outputType := flag.String("out", "text", "what type of report is desired, 'text' or 'json'")
+	outputType := flag.String("out", "text", "what type of report is desired, 'text', 'json', or 'html'")
 	normalizationFactor := flag.Int("nf", 0, "normalization factor used to compress the output histogram by eliminating long tails. If provided, the value must be at least 10. The default is 0 which signifies no normalization will be done")
 	cpus := flag.Int("cpus", 0, "number of CPUs to use for the test run. Default is 0 which specifies all CPUs are to be used.")
 	help := flag.Bool("help", false, "help will emit detailed usage instructions and exit")
 	cpuprofile := flag.String("cpuprofile", "", "write cpu profile to file")
+	baselineFile := flag.String("baseline", "", "JSON report of an earlier run to compare this run's results to")
+	metricsAddr := flag.String("metrics-addr", "", "address, e.g., :9100, to serve live Prometheus metrics on during the run")
+	workers := flag.String("workers", "", "comma separated addresses (host:port) of workers to split the run across")
+	workerToken := flag.String("worker-token", "", "token shared with the workers, required with -workers")
+	tolerance := flag.Float64("tolerance", api.DefaultTolerancePct, "percent a metric can worsen relative to the baseline before it's considered a regression")
+	var quick quickRun
+	quick.addFlags(flag.CommandLine)
 
 	flag.Parse()
 
@@ -72,13 +151,18 @@ Options:
 
 	if *help {
 		fmt.Println(usage)
-		return
+		return 0
 	}
 
-	if *configFile == "" {
-		fmt.Println("Config file location not provided")
+	if *configFile == "" && quick.url == "" {
+		fmt.Println("Config file location or URL not provided")
+		fmt.Println(usage)
+		return 1
+	}
+	if *configFile != "" && quick.url != "" {
+		fmt.Println("Only one of -config and -u can be provided")
 		fmt.Println(usage)
-		os.Exit(1)
+		return 1
 	}
 
 	if *normalizationFactor == 1 {
@@ -87,13 +171,35 @@ Options:
 
 	zerolog.SetGlobalLevel(zerolog.Level(*logLevel))
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.StampMilli})
-	log.Info().Msgf("heyyall started with config from %s", *configFile)
-
-	config, err := getConfig(*configFile)
+	var config api.LoadTestConfig
+	var err error
+	if quick.url != "" {
+		log.Info().Msgf("heyyall started with requests to %s", quick.url)
+		config, err = quick.config()
+	} else {
+		log.Info().Msgf("heyyall started with config from %s", *configFile)
+		config, err = internal.LoadConfig(*configFile)
+	}
 	if err != nil {
 		log.Fatal().Err(err).Msg("error loading configuration")
 	}
 
+	if err = internal.ValidateThresholds(config.Thresholds); err != nil {
+		log.Fatal().Err(err).Msg("invalid Thresholds configuration")
+	}
+
+	var baseline *api.RunResults
+	if *baselineFile != "" {
+		if *tolerance < 0 {
+			log.Fatal().Msgf("-tolerance is %.2f, it can't be negative", *tolerance)
+		}
+		rr, err := internal.LoadRunResults(*baselineFile)
+		if err != nil {
+			log.Fatal().Err(err).Msg("error loading baseline")
+		}
+		baseline = &rr
+	}
+
 	availCPUs := runtime.NumCPU()
 	if *cpus > availCPUs {
 		log.Fatal().Msgf("-cpus specfied %d CPUs are to be used. Only %d are available", *cpus, availCPUs)
@@ -103,111 +209,709 @@ Options:
 		runtime.GOMAXPROCS(runtime.NumCPU())
 	}
 
-	responseC := make(chan internal.Response, config.MaxConcurrentRqsts)
-	doneC := make(chan interface{})
-	progressC := make(chan interface{})
-
 	var reportDetail internal.OutputType = internal.JSON
-	if *outputType == "text" {
+	switch *outputType {
+	case "text":
 		reportDetail = internal.Text
+	case "html":
+		reportDetail = internal.HTML
 	}
-	responseHandler := &internal.ResponseHandler{
-		OutputType: reportDetail,
-		ResponseC:  responseC,
-		ProgressC:  progressC,
-		DoneC:      doneC,
-		NumRqsts:   config.NumRequests,
-		NormFactor: *normalizationFactor,
+	opts := runOptions{
+		outputType:   reportDetail,
+		normFactor:   *normalizationFactor,
+		metricsAddr:  *metricsAddr,
+		baseline:     baseline,
+		tolerancePct: *tolerance,
+	}
+
+	var responseHandler *internal.ResponseHandler
+	if *workers != "" {
+		responseHandler, err = runController(config, opts, strings.Split(*workers, ","), *workerToken)
+	} else {
+		responseHandler, err = loadTest(config, opts)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("heyyall: run failed")
+		return 1
+	}
+
+	log.Info().Msg("heyyall: DONE")
+
+	if !responseHandler.ThresholdsPassed() {
+		log.Error().Msg("heyyall: one or more thresholds weren't met")
+		return 1
+	}
+	if responseHandler.Regressed() {
+		log.Error().Msg("heyyall: one or more metrics regressed relative to the baseline")
+		return 1
+	}
+	return 0
+}
+
+// runOptions control how a load test is run and reported
+type runOptions struct {
+	outputType   internal.OutputType
+	normFactor   int
+	metricsAddr  string
+	baseline     *api.RunResults
+	tolerancePct float64
+	// silent, if true, suppresses the progress bar and the report. The results are
+	// available from the returned ResponseHandler.
+	silent bool
+	// startAt, if not zero, is when the run is to start
+	startAt time.Time
+}
+
+// loadTest runs the load test described by 'config' and returns its ResponseHandler once
+// the run is complete and has been reported
+func loadTest(config api.LoadTestConfig, opts runOptions) (*internal.ResponseHandler, error) {
+	profile, err := internal.NewLoadProfile(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Stages configuration: %w", err)
+	}
+	concurrency := config.MaxConcurrentRqsts
+	if profile != nil {
+		concurrency = profile.MaxConcurrency()
+	}
+
+	if config.HistogramSigDigits < 0 || config.HistogramSigDigits > 5 {
+		return nil, fmt.Errorf("HistogramSigDigits is %d, it must be between 1 and 5", config.HistogramSigDigits)
+	}
+	var histMaxLatency time.Duration
+	if config.HistogramMaxLatency != "" {
+		histMaxLatency, err = time.ParseDuration(config.HistogramMaxLatency)
+		if err != nil {
+			return nil, fmt.Errorf("HistogramMaxLatency: %s, must be of the form 'xs' or xm where 'x' is an integer and 's' indicates seconds and 'm' indicates minutes",
+				config.HistogramMaxLatency)
+		}
+	}
+
+	var timeSeriesInterval time.Duration
+	if config.TimeSeriesInterval != "" {
+		timeSeriesInterval, err = time.ParseDuration(config.TimeSeriesInterval)
+		if err != nil || timeSeriesInterval <= 0 {
+			return nil, fmt.Errorf("TimeSeriesInterval: %s, must be of the form 'xs' or xm where 'x' is a positive integer and 's' indicates seconds and 'm' indicates minutes",
+				config.TimeSeriesInterval)
+		}
 	}
-	go responseHandler.Start()
 
 	var cert tls.Certificate
 	if config.CertFile != "" && config.KeyFile != "" {
 		cert, err = tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
 		if err != nil {
-			log.Fatal().Err(err).Msg("Error creating x509 keypair")
+			return nil, fmt.Errorf("error creating x509 keypair: %w", err)
 		}
 	}
 
-	// TODO: Make Transport configurable, including timeout that's currently on the client below
-	t := &http.Transport{
-		MaxIdleConnsPerHost: config.MaxConcurrentRqsts,
-		DisableCompression:  false,
-		DisableKeepAlives:   false,
-		TLSClientConfig: &tls.Config{
-			Certificates: []tls.Certificate{cert},
-		},
-	}
-	dur, err := time.ParseDuration(config.RunDuration)
+	dur, err := runDuration(config, profile)
 	if err != nil {
-		log.Fatal().Err(err).Msg(fmt.Sprintf("runDur: %s, must be of the form 'xs' or xm where 'x' is an integer and 's' indicates seconds and 'm' indicates minutes",
-			config.RunDuration))
+		return nil, err
+	}
+
+	var metrics *internal.Metrics
+	if opts.metricsAddr != "" {
+		metrics = internal.NewMetrics(func(elapsed time.Duration) float64 {
+			if profile != nil {
+				return profile.TargetRate(elapsed)
+			}
+			return float64(config.RqstRate)
+		})
+	}
+
+	responseC := make(chan internal.Response, concurrency)
+	doneC := make(chan interface{})
+	progressC := make(chan interface{})
+
+	responseHandler := &internal.ResponseHandler{
+		OutputType:         opts.outputType,
+		ResponseC:          responseC,
+		ProgressC:          progressC,
+		DoneC:              doneC,
+		NumRqsts:           config.NumRequests,
+		NormFactor:         opts.normFactor,
+		HistSigDigits:      config.HistogramSigDigits,
+		HistMaxLatency:     histMaxLatency,
+		LoadProfile:        profile,
+		Thresholds:         config.Thresholds,
+		TimeSeriesInterval: timeSeriesInterval,
+		Metrics:            metrics,
+		Baseline:           opts.baseline,
+		TolerancePct:       opts.tolerancePct,
+		Silent:             opts.silent,
+		Scenarios:          config.Scenarios,
+	}
+
+	startAt := opts.startAt
+	if startAt.IsZero() {
+		startAt = time.Now()
 	}
 
 	var (
-		client http.Client
-		ctx    context.Context
-		cancel context.CancelFunc
+		ctx            context.Context
+		cancel         context.CancelFunc
+		defaultTimeout time.Duration
 	)
 
+	// The run's deadline is relative to its start time, which may be in the future
 	if int64(dur) > 0 {
-		ctx, cancel = context.WithTimeout(context.Background(), dur)
-		client = http.Client{Transport: t, Timeout: dur}
+		ctx, cancel = context.WithDeadline(context.Background(), startAt.Add(dur))
+		defaultTimeout = dur
 	} else {
 		ctx, cancel = context.WithCancel(context.Background())
-		// TODO: Make Client.Timeout configurable?
-		client = http.Client{Transport: t, Timeout: 15 * time.Second}
+		defaultTimeout = 15 * time.Second
 	}
 	defer cancel()
 
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+	client, err := internal.NewClient(config.Transport, tlsConfig, concurrency, defaultTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Transport configuration: %w", err)
+	}
+
+	defer internal.CloseClient(client)
+
+	clients, err := internal.NewClients(ctx, client, config.Transport, config.Endpoints, config.Scenarios)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Endpoint configuration: %w", err)
+	}
+	defer clients.Close()
+
+	data, err := internal.NewDataSources(config.DataSources)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load data sources: %w", err)
+	}
+
 	rqstr := internal.Requestor{
-		Ctx:       ctx,
-		ResponseC: responseC,
-		Client:    client,
+		Ctx:         ctx,
+		ResponseC:   responseC,
+		Client:      client,
+		Transport:   config.Transport,
+		Metrics:     metrics,
+		Sequences:   internal.NewSequences(),
+		DataSources: data,
+		Clients:     clients,
 	}
 
-	scheduler, err := internal.NewScheduler(config.MaxConcurrentRqsts, config.RqstRate, dur,
-		config.NumRequests, config.Endpoints, rqstr)
+	scheduler, err := internal.NewScheduler(concurrency, config.RqstRate, dur,
+		config.NumRequests, config.Endpoints, config.Scenarios, data, rqstr)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Unexpected error configuring new Requestor")
-		return
+		return nil, fmt.Errorf("unexpected error configuring new Requestor: %w", err)
+	}
+	if profile != nil {
+		scheduler.SetLoadProfile(profile)
+	}
+	if err = scheduler.SetLoadModel(config.LoadModel); err != nil {
+		return nil, fmt.Errorf("invalid LoadModel configuration: %w", err)
+	}
+	if err = scheduler.SetArrival(config.Arrival); err != nil {
+		return nil, fmt.Errorf("invalid Arrival configuration: %w", err)
+	}
+
+	if wait := time.Until(startAt); wait > 0 {
+		log.Info().Msgf("heyyall: waiting %s to start the run", wait.Round(time.Millisecond))
+		time.Sleep(wait)
 	}
 
-	go startProgressBar(progressC, doneC, dur, config.NumRequests)
+	// Live metrics were asked for, so the run isn't started without them
+	if metrics != nil {
+		if err = metrics.Start(ctx, opts.metricsAddr); err != nil {
+			return nil, fmt.Errorf("unable to serve metrics on %s: %w", opts.metricsAddr, err)
+		}
+		defer metrics.Stop()
+	}
 
-	go scheduler.Start()
+	go responseHandler.Start()
+
+	if opts.silent {
+		// Progress must still be consumed, the ResponseHandler reports it for every response
+		// when the run is limited by the number of requests
+		go func() {
+			for {
+				select {
+				case <-doneC:
+					return
+				case <-progressC:
+				}
+			}
+		}()
+	} else {
+		// Keep stdout clean for JSON and HTML reports so they can be redirected to a file
+		progressOut := os.Stdout
+		if opts.outputType != internal.Text {
+			progressOut = os.Stderr
+		}
+		go startProgressBar(progressOut, progressC, doneC, dur, config.NumRequests)
+	}
+
+	go scheduler.Start(ctx)
 
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
 
 	select {
 	case <-sigs:
 		signal.Stop(sigs)
 		log.Debug().Msg("heyyall: SIGTERM caught")
+		cancel()
 		<-doneC // Wait for graceful shutdown to complete
 	case <-doneC:
 	}
 
-	log.Info().Msg("heyyall: DONE")
+	if err := responseHandler.Err(); err != nil {
+		return nil, err
+	}
+	return responseHandler, nil
+}
+
+// runDuration returns how long the run described by 'config' and 'profile' is to last. It
+// returns 0 if the run is only limited by the number of requests.
+func runDuration(config api.LoadTestConfig, profile *internal.LoadProfile) (time.Duration, error) {
+	if profile != nil {
+		return profile.Duration(), nil
+	}
+	dur, err := time.ParseDuration(config.RunDuration)
+	if err != nil {
+		return 0, fmt.Errorf("runDur: %s, must be of the form 'xs' or xm where 'x' is an integer and 's' indicates seconds and 'm' indicates minutes",
+			config.RunDuration)
+	}
+	return dur, nil
+}
+
+// runController splits the load test described by 'config' across 'workers', the addresses
+// of 'heyyall worker' processes sharing 'token', and reports their merged results. It returns
+// the ResponseHandler that reported them.
+func runController(config api.LoadTestConfig, opts runOptions, workers []string, token string) (*internal.ResponseHandler, error) {
+	configs, err := internal.SplitConfig(config, len(workers))
+	if err != nil {
+		return nil, err
+	}
+
+	profile, err := internal.NewLoadProfile(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Stages configuration: %w", err)
+	}
+	dur, err := runDuration(config, profile)
+	if err != nil {
+		return nil, err
+	}
+	// Runs limited by the number of requests can take any amount of time
+	var timeout time.Duration
+	if dur > 0 {
+		timeout = internal.WorkerStartDelay + dur + workerResultsWait
+	}
+	if opts.metricsAddr != "" {
+		log.Warn().Msg("heyyall: -metrics-addr is ignored by the controller, use it with 'heyyall worker' instead")
+	}
+
+	log.Info().Msgf("heyyall: running on %d workers", len(workers))
+	results, err := internal.RunWorkers(workers, configs, token, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	responseHandler := &internal.ResponseHandler{
+		OutputType:   opts.outputType,
+		NormFactor:   opts.normFactor,
+		Thresholds:   config.Thresholds,
+		Baseline:     opts.baseline,
+		TolerancePct: opts.tolerancePct,
+	}
+	responseHandler.Report(internal.MergeRunResults(results))
+	if err := responseHandler.Err(); err != nil {
+		return nil, err
+	}
+	return responseHandler, nil
+}
+
+// runWorker executes the 'worker' subcommand, which runs load tests on behalf of a
+// controller, and returns the process exit code
+func runWorker(args []string) int {
+	usage := `
+Usage: heyyall worker [flags...]
+
+Listens for load tests from a controller, 'heyyall -config <ConfigFileLocation> -workers ...',
+runs its share of each test, and returns the results to the controller to be merged.
+
+Options:
+  -listen    The address to listen for load tests on. The default is 'localhost:8090', use e.g.
+             ':8090' to accept load tests from other hosts.
+  -token     A token the controller must send, via its -worker-token flag, with each load test.
+             Workers on the same network should share the same token. If it isn't given a
+             token is generated and logged.
+  -loglevel  Logging level. Default is 'INFO' (1). 0 is DEBUG, 1 INFO, up to 4 FATAL
+  -metrics-addr
+             The address, e.g., ':9100', to serve live Prometheus metrics on, at '/metrics', during
+             each run. Metrics aren't served by default.
+  -help      This usage message
+`
+
+	flags := flag.NewFlagSet("worker", flag.ContinueOnError)
+	listen := flags.String("listen", "localhost:8090", "address to listen for load tests on")
+	token := flags.String("token", "", "token the controller must send with each load test")
+	logLevel := flags.Int("loglevel", int(zerolog.InfoLevel), "log level, 0 for debug, 1 info, 2 warn, ...")
+	metricsAddr := flags.String("metrics-addr", "", "address, e.g., :9100, to serve live Prometheus metrics on during each run")
+	help := flags.Bool("help", false, "help will emit detailed usage instructions and exit")
+	if err := flags.Parse(args); err != nil {
+		fmt.Println(usage)
+		return 1
+	}
+
+	if *help {
+		fmt.Println(usage)
+		return 0
+	}
+
+	zerolog.SetGlobalLevel(zerolog.Level(*logLevel))
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.StampMilli})
+
+	// Any process that can reach the worker, including web pages in a local browser, could
+	// otherwise have it run load tests
+	if *token == "" {
+		generated, err := newWorkerToken()
+		if err != nil {
+			log.Error().Err(err).Msg("heyyall worker: unable to generate a token")
+			return 1
+		}
+		*token = generated
+		log.Info().Msgf("heyyall worker: no -token given, the controller must use -worker-token %s", *token)
+	}
+
+	handler := internal.NewWorkerHandler(func(config api.LoadTestConfig, startAt time.Time) (api.RunResults, error) {
+		rh, err := loadTest(config, runOptions{metricsAddr: *metricsAddr, silent: true, startAt: startAt})
+		if err != nil {
+			return api.RunResults{}, err
+		}
+		return rh.Results(), nil
+	}, *token)
+
+	log.Info().Msgf("heyyall worker listening on %s", *listen)
+	if err := http.ListenAndServe(*listen, handler); err != nil {
+		log.Error().Err(err).Msg("heyyall worker failed")
+		return 1
+	}
+	return 0
 }
 
-func getConfig(fileName string) (api.LoadTestConfig, error) {
-	contents, err := ioutil.ReadFile(fileName)
+// newWorkerToken returns a random token for a worker started without one
+func newWorkerToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// runSearch executes the 'search' subcommand, which looks for the highest request rate
+// that meets the configured Thresholds, and returns the process exit code. The exit code
+// is 1 if no rate met the Thresholds.
+func runSearch(args []string) int {
+	usage := `
+Usage: heyyall search -config <ConfigFileLocation> [flags...]
+
+Finds the highest request rate at which the configuration's Thresholds are met, e.g., a
+P99 latency and an ErrorRate limit. The configuration is run for a short time at each
+rate tried. The rate is doubled, starting at the minimum rate, until the Thresholds aren't
+met, then the highest passing rate is binary searched for. Each rate must also achieve at
+least 90% of its target. RunDuration, NumRequests, and Stages are ignored.
+
+Options:
+  -config    The path of the load test configuration
+  -min       The first, and lowest, request rate to try. The default is 10.
+  -max       The highest request rate to try. The default is 10000.
+  -duration  How long to run each rate for. The default is '10s'.
+  -precision The search ends when the highest passing rate and the lowest failing rate are
+             within this percent of each other. The default is 5.
+  -out       Type of output report, 'text' or 'json'. Default is 'text'
+  -loglevel  Logging level. Default is 'INFO' (1). 0 is DEBUG, 1 INFO, up to 4 FATAL
+  -help      This usage message
+`
+
+	flags := flag.NewFlagSet("search", flag.ContinueOnError)
+	configFile := flags.String("config", "", "path and filename containing the runtime configuration")
+	minRate := flags.Int("min", 10, "first, and lowest, request rate to try")
+	maxRate := flags.Int("max", 10000, "highest request rate to try")
+	iterDur := flags.Duration("duration", 10*time.Second, "how long to run each rate for")
+	precision := flags.Float64("precision", 5, "percent the highest passing and lowest failing rates must be within")
+	outputType := flags.String("out", "text", "what type of report is desired, 'text' or 'json'")
+	logLevel := flags.Int("loglevel", int(zerolog.InfoLevel), "log level, 0 for debug, 1 info, 2 warn, ...")
+	help := flags.Bool("help", false, "help will emit detailed usage instructions and exit")
+	if err := flags.Parse(args); err != nil {
+		fmt.Println(usage)
+		return 1
+	}
+
+	if *help {
+		fmt.Println(usage)
+		return 0
+	}
+
+	if *configFile == "" {
+		fmt.Println("Config file location not provided")
+		fmt.Println(usage)
+		return 1
+	}
+
+	zerolog.SetGlobalLevel(zerolog.Level(*logLevel))
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.StampMilli})
+
+	config, err := internal.LoadConfig(*configFile)
 	if err != nil {
-		return api.LoadTestConfig{}, fmt.Errorf("unable to read config file %s", fileName)
+		log.Error().Err(err).Msg("error loading configuration")
+		return 1
 	}
 
-	log.Debug().Msgf("Raw config file contents: %s", string(contents))
+	sc := internal.SearchConfig{
+		MinRqstRate:       *minRate,
+		MaxRqstRate:       *maxRate,
+		IterationDuration: *iterDur,
+		PrecisionPct:      *precision,
+	}
+	results, err := internal.Search(config, sc, func(config api.LoadTestConfig, startAt time.Time) (api.RunResults, error) {
+		rh, err := loadTest(config, runOptions{silent: true, startAt: startAt})
+		if err != nil {
+			return api.RunResults{}, err
+		}
+		return rh.Results(), nil
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("heyyall: search failed")
+		return 1
+	}
 
-	config := api.LoadTestConfig{}
-	if err = json.Unmarshal(contents, &config); err != nil {
-		return api.LoadTestConfig{}, fmt.Errorf("error unmarshaling test config bytes: %s", string(contents))
+	if *outputType == "text" {
+		internal.PrintSearchResults(results)
+	} else {
+		sjson, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			log.Error().Err(err).Msg("error marshaling search results")
+			return 1
+		}
+		fmt.Printf("%s\n", string(sjson))
+	}
+
+	if results.KneeRqstRate == 0 {
+		return 1
+	}
+	return 0
+}
+
+// runCompare executes the 'compare' subcommand, which compares two JSON reports, and
+// returns the process exit code. The exit code is 1 if any metric regressed.
+func runCompare(args []string) int {
+	usage := `
+Usage: heyyall compare -baseline <ReportFile> -current <ReportFile> [flags...]
+
+Compares the JSON reports of two runs, produced with '-out json', and reports how
+throughput, latency percentiles, and error rates changed overall and for each endpoint
+and HTTP method. Exits with a non-zero status if any metric regressed.
+
+Options:
+  -baseline  The JSON report of the run being compared to
+  -current   The JSON report of the run being compared
+  -tolerance The percent a metric can worsen before it's considered a regression. Error
+             rates are compared in percentage points. The default is 10.
+  -out       Type of output report, 'text' or 'json'. Default is 'text'
+  -help      This usage message
+`
+
+	flags := flag.NewFlagSet("compare", flag.ContinueOnError)
+	baselineFile := flags.String("baseline", "", "JSON report of the run being compared to")
+	currentFile := flags.String("current", "", "JSON report of the run being compared")
+	tolerance := flags.Float64("tolerance", api.DefaultTolerancePct, "percent a metric can worsen before it's considered a regression")
+	outputType := flags.String("out", "text", "what type of report is desired, 'text' or 'json'")
+	help := flags.Bool("help", false, "help will emit detailed usage instructions and exit")
+	if err := flags.Parse(args); err != nil {
+		fmt.Println(usage)
+		return 1
+	}
+
+	if *help {
+		fmt.Println(usage)
+		return 0
+	}
+
+	if *baselineFile == "" || *currentFile == "" {
+		fmt.Println("Both -baseline and -current must be provided")
+		fmt.Println(usage)
+		return 1
+	}
+
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.StampMilli})
+
+	if *tolerance < 0 {
+		log.Error().Msgf("-tolerance is %.2f, it can't be negative", *tolerance)
+		return 1
+	}
+
+	baseline, err := internal.LoadRunResults(*baselineFile)
+	if err != nil {
+		log.Error().Err(err).Msg("error loading baseline")
+		return 1
+	}
+	current, err := internal.LoadRunResults(*currentFile)
+	if err != nil {
+		log.Error().Err(err).Msg("error loading current results")
+		return 1
+	}
+
+	comparison := internal.CompareRunResults(baseline, current, *tolerance)
+	if *outputType == "text" {
+		internal.PrintComparison(comparison)
+	} else {
+		cjson, err := json.MarshalIndent(comparison, "", "  ")
+		if err != nil {
+			log.Error().Err(err).Msg("error marshaling comparison")
+			return 1
+		}
+		fmt.Printf("%s\n", string(cjson))
+	}
+
+	if comparison.Regressions > 0 {
+		return 1
+	}
+	return 0
+}
+
+// quickRun describes a run of a single endpoint specified by 'hey' compatible flags
+type quickRun struct {
+	url    string
+	method string
+	body   string
+	// bodyFile, if specified, is the name of a file containing the request body, it
+	// overrides body
+	bodyFile    string
+	contentType string
+	headers     headerFlags
+	accept      string
+	// basicAuth is the 'username:password' used for basic authentication
+	basicAuth   string
+	h2          bool
+	noKeepAlive bool
+	noCompress  bool
+	numRqsts    int
+	concurrency int
+	// workerRate is the request rate of each of the 'concurrency' workers, as in 'hey'
+	workerRate int
+	dur        time.Duration
+	// timeout is the request timeout in seconds, 0 uses the default request timeout
+	timeout int
+}
+
+// addFlags defines the flags that describe 'q' in 'fs'
+func (q *quickRun) addFlags(fs *flag.FlagSet) {
+	fs.StringVar(&q.url, "u", "", "URL to send requests to instead of using -config")
+	fs.StringVar(&q.method, "m", http.MethodGet, "HTTP method of the -u requests")
+	fs.StringVar(&q.body, "d", "", "body of the -u requests")
+	fs.StringVar(&q.bodyFile, "D", "", "file containing the body of the -u requests, overrides -d")
+	fs.StringVar(&q.contentType, "T", "text/html", "Content-Type header of the -u requests")
+	fs.Var(&q.headers, "H", "header, 'Name: value', of the -u requests, can be repeated")
+	fs.StringVar(&q.accept, "A", "", "Accept header of the -u requests, overrides -H")
+	fs.StringVar(&q.basicAuth, "a", "", "'username:password' used for basic authentication of the -u requests")
+	fs.BoolVar(&q.h2, "h2", false, "send the -u requests using HTTP/2")
+	fs.BoolVar(&q.noKeepAlive, "disable-keepalive", false, "close each connection after a single -u request")
+	fs.BoolVar(&q.noCompress, "disable-compression", false, "don't request compressed -u responses")
+	fs.IntVar(&q.numRqsts, "n", 200, "number of -u requests to send")
+	fs.IntVar(&q.concurrency, "c", 50, "number of concurrent -u requests")
+	fs.IntVar(&q.workerRate, "q", 0, "request rate per second of each of the -c workers, 0 is no limit")
+	fs.DurationVar(&q.dur, "z", 0, "how long to send -u requests for, overrides -n")
+	fs.IntVar(&q.timeout, "t", 20, "timeout of each -u request in seconds, 0 uses the default timeout")
+}
+
+// config returns the configuration of the run described by 'q'
+func (q quickRun) config() (api.LoadTestConfig, error) {
+	if q.concurrency < 1 {
+		return api.LoadTestConfig{}, fmt.Errorf("-c is %d, it must be greater than zero", q.concurrency)
+	}
+	if q.workerRate < 0 {
+		return api.LoadTestConfig{}, fmt.Errorf("-q is %d, it can't be negative", q.workerRate)
+	}
+	if q.dur < 0 {
+		return api.LoadTestConfig{}, fmt.Errorf("-z is %s, it can't be negative", q.dur)
+	}
+	if q.timeout < 0 {
+		return api.LoadTestConfig{}, fmt.Errorf("-t is %d, it can't be negative", q.timeout)
+	}
+	// As with 'hey', -H headers take precedence over -T
+	headers := make(map[string]string, len(q.headers)+1)
+	if q.contentType != "" {
+		headers["Content-Type"] = q.contentType
+	}
+	for _, h := range q.headers {
+		i := strings.Index(h, ":")
+		if i < 1 {
+			return api.LoadTestConfig{}, fmt.Errorf("-H %s is invalid, it must be of the form 'Name: value'", h)
+		}
+		headers[http.CanonicalHeaderKey(strings.TrimSpace(h[:i]))] = strings.TrimSpace(h[i+1:])
+	}
+	// As with 'hey', -A and -a take precedence over -H
+	if q.accept != "" {
+		headers["Accept"] = q.accept
+	}
+	if q.basicAuth != "" {
+		i := strings.Index(q.basicAuth, ":")
+		if i < 1 {
+			return api.LoadTestConfig{}, fmt.Errorf("-a %s is invalid, it must be of the form 'username:password'", q.basicAuth)
+		}
+		headers["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(q.basicAuth))
+	}
+	body := q.body
+	if q.bodyFile != "" {
+		contents, err := ioutil.ReadFile(q.bodyFile)
+		if err != nil {
+			return api.LoadTestConfig{}, fmt.Errorf("unable to read -D file %s: %w", q.bodyFile, err)
+		}
+		body = string(contents)
+	}
+
+	config := api.LoadTestConfig{
+		RqstRate:           q.workerRate * q.concurrency,
+		MaxConcurrentRqsts: q.concurrency,
+		RunDuration:        q.dur.String(),
+		Endpoints: []api.Endpoint{
+			{
+				URL:         q.url,
+				Method:      strings.ToUpper(q.method),
+				RqstBody:    body,
+				Headers:     headers,
+				RqstPercent: 100,
+			},
+		},
+	}
+	if q.dur == 0 {
+		config.NumRequests = q.numRqsts
+	}
+	if q.timeout > 0 {
+		config.Transport.RqstTimeout = (time.Duration(q.timeout) * time.Second).String()
+	}
+	if q.h2 {
+		config.Transport.Protocol = api.ProtocolH2
+	}
+	if q.noKeepAlive {
+		config.Transport.KeepAlive = new(bool)
+	}
+	if q.noCompress {
+		config.Transport.Compression = new(bool)
 	}
 	return config, nil
 }
 
-func startProgressBar(progressC chan interface{}, doneC chan interface{}, dur time.Duration, numRqsts int) {
-	progress := mpb.New(mpb.WithWidth(64))
+// headerFlags collects the values of a repeated header flag
+type headerFlags []string
+
+func (h *headerFlags) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerFlags) Set(value string) error {
+	*h = append(*h, value)
+	return nil
+}
+
+func startProgressBar(out io.Writer, progressC chan interface{}, doneC chan interface{}, dur time.Duration, numRqsts int) {
+	progress := mpb.New(mpb.WithWidth(64), mpb.WithOutput(out))
 	var total int64
 	if int64(dur) > 0 {
 		total = int64(dur / time.Second)
@@ -237,7 +941,7 @@ func startProgressBar(progressC chan interface{}, doneC chan interface{}, dur ti
 		pC = nil
 	}
 
-	fmt.Printf("\nBegin load test...\n\n")
+	fmt.Fprintf(out, "\nBegin load test...\n\n")
 
 LOOP:
 	for {
@@ -0,0 +1,120 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+func TestQuickRunConfig(t *testing.T) {
+	url := "http://someurl/users"
+	bodyFile, err := ioutil.TempFile("", "heyyall")
+	if err != nil {
+		t.Fatalf("unable to create body file: %s", err)
+	}
+	defer os.Remove(bodyFile.Name())
+	if _, err = bodyFile.WriteString(`{"name": "bob"}`); err != nil {
+		t.Fatalf("unable to write body file: %s", err)
+	}
+	bodyFile.Close()
+
+	tests := []struct {
+		name       string
+		args       []string
+		expected   api.LoadTestConfig
+		shouldFail bool
+	}{
+		{
+			name: "Defaults",
+			args: []string{"-u", url},
+			expected: api.LoadTestConfig{MaxConcurrentRqsts: 50, NumRequests: 200, RunDuration: "0s",
+				Transport: api.Transport{RqstTimeout: "20s"},
+				Endpoints: []api.Endpoint{{URL: url, Method: "GET", RqstPercent: 100,
+					Headers: map[string]string{"Content-Type": "text/html"}}}},
+		},
+		{
+			name: "AllFlags",
+			args: []string{"-u", url, "-m", "post", "-d", `{"name": "alice"}`, "-T", "application/json",
+				"-H", "Accept: application/json", "-H", "X-Trace:abc", "-c", "10", "-q", "5", "-z", "30s", "-t", "3"},
+			expected: api.LoadTestConfig{RqstRate: 50, MaxConcurrentRqsts: 10, RunDuration: "30s",
+				Transport: api.Transport{RqstTimeout: "3s"},
+				Endpoints: []api.Endpoint{{URL: url, Method: "POST", RqstBody: `{"name": "alice"}`, RqstPercent: 100,
+					Headers: map[string]string{"Content-Type": "application/json", "Accept": "application/json", "X-Trace": "abc"}}}},
+		},
+		{
+			name: "NumRqsts",
+			args: []string{"-u", url, "-n", "1000", "-c", "1"},
+			expected: api.LoadTestConfig{MaxConcurrentRqsts: 1, NumRequests: 1000, RunDuration: "0s",
+				Transport: api.Transport{RqstTimeout: "20s"},
+				Endpoints: []api.Endpoint{{URL: url, Method: "GET", RqstPercent: 100,
+					Headers: map[string]string{"Content-Type": "text/html"}}}},
+		},
+		{
+			// -H takes precedence over -T, as in 'hey'
+			name: "HeaderOverridesContentType",
+			args: []string{"-u", url, "-T", "application/json", "-H", "content-type: text/plain"},
+			expected: api.LoadTestConfig{MaxConcurrentRqsts: 50, NumRequests: 200, RunDuration: "0s",
+				Transport: api.Transport{RqstTimeout: "20s"},
+				Endpoints: []api.Endpoint{{URL: url, Method: "GET", RqstPercent: 100,
+					Headers: map[string]string{"Content-Type": "text/plain"}}}},
+		},
+		{
+			name: "NoTimeoutOrContentType",
+			args: []string{"-u", url, "-t", "0", "-T", ""},
+			expected: api.LoadTestConfig{MaxConcurrentRqsts: 50, NumRequests: 200, RunDuration: "0s",
+				Endpoints: []api.Endpoint{{URL: url, Method: "GET", RqstPercent: 100, Headers: map[string]string{}}}},
+		},
+		{
+			// As with 'hey', -A and -a take precedence over -H and -D over -d
+			name: "HeyFlags",
+			args: []string{"-u", url, "-m", "PUT", "-d", `{"name": "alice"}`, "-D", bodyFile.Name(), "-A", "application/json",
+				"-H", "Accept: text/plain", "-a", "alice:secret", "-h2", "-disable-keepalive", "-disable-compression"},
+			expected: api.LoadTestConfig{MaxConcurrentRqsts: 50, NumRequests: 200, RunDuration: "0s",
+				Transport: api.Transport{RqstTimeout: "20s", Protocol: api.ProtocolH2, KeepAlive: new(bool), Compression: new(bool)},
+				Endpoints: []api.Endpoint{{URL: url, Method: "PUT", RqstBody: `{"name": "bob"}`, RqstPercent: 100,
+					Headers: map[string]string{"Content-Type": "text/html", "Accept": "application/json",
+						"Authorization": "Basic YWxpY2U6c2VjcmV0"}}}},
+		},
+		{name: "FailPath - zero concurrency", args: []string{"-u", url, "-c", "0"}, shouldFail: true},
+		{name: "FailPath - negative rate", args: []string{"-u", url, "-q", "-1"}, shouldFail: true},
+		{name: "FailPath - negative duration", args: []string{"-u", url, "-z", "-1s"}, shouldFail: true},
+		{name: "FailPath - negative timeout", args: []string{"-u", url, "-t", "-1"}, shouldFail: true},
+		{name: "FailPath - invalid header", args: []string{"-u", url, "-H", "Accept"}, shouldFail: true},
+		{name: "FailPath - invalid basic auth", args: []string{"-u", url, "-a", "alice"}, shouldFail: true},
+		{name: "FailPath - missing body file", args: []string{"-u", url, "-D", bodyFile.Name() + ".missing"}, shouldFail: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("heyyall", flag.ContinueOnError)
+			fs.SetOutput(ioutil.Discard)
+			var quick quickRun
+			quick.addFlags(fs)
+			if err := fs.Parse(tc.args); err != nil {
+				t.Fatalf("unexpected error parsing flags: %s", err)
+			}
+
+			config, err := quick.config()
+			if tc.shouldFail {
+				if err == nil {
+					t.Errorf("expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(config, tc.expected) {
+				t.Errorf("expected %+v, got %+v", tc.expected, config)
+			}
+		})
+	}
+}
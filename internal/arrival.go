@@ -0,0 +1,153 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// Arrival process types, see api.Arrival.Type
+const (
+	// ConstantArrival sends requests at evenly spaced intervals
+	ConstantArrival = "constant"
+	// PoissonArrival sends requests with exponentially distributed intervals
+	PoissonArrival = "poisson"
+	// UniformArrival randomly varies each interval by up to api.Arrival.Jitter
+	UniformArrival = "uniform"
+	// BurstyArrival sends requests in bursts of api.Arrival.BurstSize every api.Arrival.BurstPeriod
+	BurstyArrival = "bursty"
+)
+
+// arrivalSeed makes sure arrival processes created at the same time are seeded differently
+var arrivalSeed int64
+
+// arrivalProcess determines the time between successive requests. An arrivalProcess
+// isn't safe for concurrent use.
+type arrivalProcess interface {
+	// next returns the time until the next request as a multiple of 'interval', the
+	// current average time between requests. The values returned average 1 over time
+	// unless the process has a fixed period.
+	next(interval time.Duration) float64
+}
+
+// newArrivalProcess returns the arrivalProcess described by 'config'
+func newArrivalProcess(config api.Arrival) (arrivalProcess, error) {
+	seed := time.Now().UnixNano() + atomic.AddInt64(&arrivalSeed, 1)
+	switch config.Type {
+	case "", ConstantArrival:
+		return constantArrival{}, nil
+	case PoissonArrival:
+		return &poissonArrival{rnd: rand.New(rand.NewSource(seed))}, nil
+	case UniformArrival:
+		jitter := 1.0
+		if config.Jitter != nil {
+			jitter = *config.Jitter
+		}
+		if jitter < 0 || jitter > 1 {
+			return nil, fmt.Errorf("Arrival.Jitter is %f, it must be from 0 to 1", jitter)
+		}
+		return &uniformArrival{rnd: rand.New(rand.NewSource(seed)), jitter: jitter}, nil
+	case BurstyArrival:
+		return newBurstyArrival(config)
+	}
+	return nil, fmt.Errorf("Arrival.Type %s is invalid, it must be one of %s, %s, %s, or %s", config.Type,
+		ConstantArrival, PoissonArrival, UniformArrival, BurstyArrival)
+}
+
+// constantArrival sends requests at evenly spaced intervals
+type constantArrival struct{}
+
+func (a constantArrival) next(interval time.Duration) float64 {
+	return 1
+}
+
+// poissonArrival models requests that arrive independently of each other, the
+// times between them are exponentially distributed
+type poissonArrival struct {
+	rnd *rand.Rand
+}
+
+func (a *poissonArrival) next(interval time.Duration) float64 {
+	return a.rnd.ExpFloat64()
+}
+
+// uniformArrival varies each interval, uniformly, by up to 'jitter' of the average interval
+type uniformArrival struct {
+	rnd    *rand.Rand
+	jitter float64
+}
+
+func (a *uniformArrival) next(interval time.Duration) float64 {
+	return 1 + a.jitter*(2*a.rnd.Float64()-1)
+}
+
+// burstyArrival sends requests together in bursts. If 'period' is 0 bursts of 'burstSize'
+// are followed by a gap long enough to maintain the average request rate. Otherwise bursts
+// start every 'period' and, if 'burstSize' is 0, each is sized to maintain the average
+// request rate.
+type burstyArrival struct {
+	burstSize int
+	period    time.Duration
+	// size is the size of the current burst
+	size int
+	sent int
+}
+
+// newBurstyArrival returns the burstyArrival described by 'config'
+func newBurstyArrival(config api.Arrival) (*burstyArrival, error) {
+	a := &burstyArrival{burstSize: config.BurstSize}
+	if config.BurstPeriod != "" {
+		period, err := time.ParseDuration(config.BurstPeriod)
+		if err != nil {
+			return nil, fmt.Errorf("Arrival.BurstPeriod %s is invalid: %w", config.BurstPeriod, err)
+		}
+		if period <= 0 {
+			return nil, fmt.Errorf("Arrival.BurstPeriod is %s, it must be greater than 0", config.BurstPeriod)
+		}
+		a.period = period
+	}
+
+	switch {
+	case a.period == 0 && config.BurstSize < 2:
+		return nil, fmt.Errorf("Arrival.BurstSize is %d, it must be greater than 1 if BurstPeriod isn't specified",
+			config.BurstSize)
+	case config.BurstSize < 0:
+		return nil, fmt.Errorf("Arrival.BurstSize is %d, it can't be negative", config.BurstSize)
+	}
+	return a, nil
+}
+
+func (a *burstyArrival) next(interval time.Duration) float64 {
+	if a.sent == 0 {
+		a.size = a.burstSize
+		if a.size == 0 {
+			a.size = 1
+			if interval > 0 {
+				a.size = int(math.Max(1, math.Round(float64(a.period)/float64(interval))))
+			}
+		}
+	}
+
+	a.sent++
+	if a.sent < a.size {
+		return 0
+	}
+	a.sent = 0
+	if a.period == 0 || interval <= 0 {
+		return float64(a.size)
+	}
+	return float64(a.period) / float64(interval)
+}
+
+// scaleInterval returns 'factor', a value returned by arrivalProcess.next(), multiples of 'interval'
+func scaleInterval(interval time.Duration, factor float64) time.Duration {
+	return time.Duration(float64(interval) * factor)
+}
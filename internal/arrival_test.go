@@ -0,0 +1,121 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+func TestArrivalProcesses(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     api.Arrival
+		shouldFail bool
+		// min and max are the bounds of the values returned by next()
+		min float64
+		max float64
+	}{
+		{name: "Default", config: api.Arrival{}, min: 1, max: 1},
+		{name: "Constant", config: api.Arrival{Type: ConstantArrival}, min: 1, max: 1},
+		{name: "Poisson", config: api.Arrival{Type: PoissonArrival}, min: 0, max: math.MaxFloat64},
+		{name: "UniformDefaultJitter", config: api.Arrival{Type: UniformArrival}, min: 0, max: 2},
+		{name: "Uniform", config: api.Arrival{Type: UniformArrival, Jitter: floatPtr(0.25)}, min: 0.75, max: 1.25},
+		{name: "UniformNoJitter", config: api.Arrival{Type: UniformArrival, Jitter: floatPtr(0)}, min: 1, max: 1},
+		{name: "Bursty", config: api.Arrival{Type: BurstyArrival, BurstSize: 5}, min: 0, max: 5},
+		{name: "BurstyPeriod", config: api.Arrival{Type: BurstyArrival, BurstPeriod: "50ms"}, min: 0, max: 5},
+		{name: "FailPath - invalid type", config: api.Arrival{Type: "metronome"}, shouldFail: true},
+		{name: "FailPath - jitter too large", config: api.Arrival{Type: UniformArrival, Jitter: floatPtr(1.5)}, shouldFail: true},
+		{name: "FailPath - negative jitter", config: api.Arrival{Type: UniformArrival, Jitter: floatPtr(-0.5)}, shouldFail: true},
+		{name: "FailPath - burst too small", config: api.Arrival{Type: BurstyArrival, BurstSize: 1}, shouldFail: true},
+		{name: "FailPath - invalid burst period", config: api.Arrival{Type: BurstyArrival, BurstPeriod: "often"}, shouldFail: true},
+		{name: "FailPath - zero burst period", config: api.Arrival{Type: BurstyArrival, BurstPeriod: "0s"}, shouldFail: true},
+		{name: "FailPath - negative burst size", config: api.Arrival{Type: BurstyArrival, BurstSize: -1, BurstPeriod: "1s"}, shouldFail: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			arrival, err := newArrivalProcess(tc.config)
+			if tc.shouldFail {
+				if err == nil {
+					t.Errorf("expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			n := 100000
+			sum := 0.0
+			for i := 0; i < n; i++ {
+				f := arrival.next(10 * time.Millisecond)
+				if f < tc.min || f > tc.max {
+					t.Fatalf("expected values between %f and %f, got %f", tc.min, tc.max, f)
+				}
+				sum += f
+			}
+			if mean := sum / float64(n); math.Abs(mean-1) > 0.02 {
+				t.Errorf("expected a mean of 1, got %f", mean)
+			}
+		})
+	}
+}
+
+func TestBurstyArrival(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   api.Arrival
+		interval time.Duration
+		expected []float64
+	}{
+		{
+			name:     "BurstSize",
+			config:   api.Arrival{Type: BurstyArrival, BurstSize: 3},
+			interval: 10 * time.Millisecond,
+			expected: []float64{0, 0, 3, 0, 0, 3},
+		},
+		{
+			// Each burst is sized to maintain the average request rate
+			name:     "BurstPeriod",
+			config:   api.Arrival{Type: BurstyArrival, BurstPeriod: "30ms"},
+			interval: 10 * time.Millisecond,
+			expected: []float64{0, 0, 3, 0, 0, 3},
+		},
+		{
+			// A burst of 2 every 100ms regardless of the average interval
+			name:     "BurstSizeAndPeriod",
+			config:   api.Arrival{Type: BurstyArrival, BurstSize: 2, BurstPeriod: "100ms"},
+			interval: 10 * time.Millisecond,
+			expected: []float64{0, 10, 0, 10},
+		},
+		{
+			name:     "BurstPeriodUnthrottled",
+			config:   api.Arrival{Type: BurstyArrival, BurstPeriod: "30ms"},
+			expected: []float64{1, 1},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			arrival, err := newArrivalProcess(tc.config)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			for i, x := range tc.expected {
+				if actual := arrival.next(tc.interval); actual != x {
+					t.Errorf("interval %d: expected %f, got %f", i, x, actual)
+				}
+			}
+		})
+	}
+}
+
+func floatPtr(f float64) *float64 {
+	return &f
+}
@@ -0,0 +1,200 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// maxAssertedBodySize is the most of a response body that will be read to evaluate
+// BodyRegex and JSONPath assertions when Assertions.MaxBodySize isn't specified
+const maxAssertedBodySize = 10 * 1024 * 1024
+
+// statusRange is an inclusive range of HTTP statuses
+type statusRange struct {
+	from, to int
+}
+
+// jsonPathAssertion is a JSONPath expression and the value it's expected to select
+type jsonPathAssertion struct {
+	path     jsonPath
+	expected interface{}
+}
+
+// assertions is the compiled form of api.Assertions
+type assertions struct {
+	statuses    []statusRange
+	headers     map[string]*regexp.Regexp
+	bodyRegex   *regexp.Regexp
+	jsonPaths   []jsonPathAssertion
+	maxBodySize int64
+}
+
+// newAssertions compiles 'a'. It returns nil if 'a' is nil.
+func newAssertions(a *api.Assertions) (*assertions, error) {
+	if a == nil {
+		return nil, nil
+	}
+
+	asserts := assertions{maxBodySize: a.MaxBodySize, headers: make(map[string]*regexp.Regexp)}
+	if a.MaxBodySize < 0 {
+		return nil, fmt.Errorf("Assertions.MaxBodySize is %d, it can't be negative", a.MaxBodySize)
+	}
+
+	for _, status := range a.Status {
+		sr, err := parseStatusRange(status)
+		if err != nil {
+			return nil, err
+		}
+		asserts.statuses = append(asserts.statuses, sr)
+	}
+
+	for name, expr := range a.Headers {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("Assertions.Headers %s regular expression %s is invalid: %w", name, expr, err)
+		}
+		asserts.headers[name] = re
+	}
+
+	if a.BodyRegex != "" {
+		re, err := regexp.Compile(a.BodyRegex)
+		if err != nil {
+			return nil, fmt.Errorf("Assertions.BodyRegex %s is invalid: %w", a.BodyRegex, err)
+		}
+		asserts.bodyRegex = re
+	}
+
+	for expr, expected := range a.JSONPath {
+		jp, err := parseJSONPath(expr)
+		if err != nil {
+			return nil, err
+		}
+		// Normalize the expected value to the types encoding/json decodes response bodies
+		// into (e.g., float64 for all numbers) so it can be compared to the actual value
+		b, err := json.Marshal(expected)
+		if err != nil {
+			return nil, fmt.Errorf("Assertions.JSONPath %s expected value %v is invalid: %w", expr, expected, err)
+		}
+		var normalized interface{}
+		if err = json.Unmarshal(b, &normalized); err != nil {
+			return nil, fmt.Errorf("Assertions.JSONPath %s expected value %v is invalid: %w", expr, expected, err)
+		}
+		asserts.jsonPaths = append(asserts.jsonPaths, jsonPathAssertion{path: jp, expected: normalized})
+	}
+
+	return &asserts, nil
+}
+
+// parseStatusRange parses a single status ("200"), a class of statuses ("2xx"), or an
+// inclusive range of statuses ("200-204")
+func parseStatusRange(status string) (statusRange, error) {
+	s := strings.TrimSpace(status)
+	if len(s) == 3 && strings.HasSuffix(strings.ToLower(s), "xx") {
+		class, err := strconv.Atoi(s[:1])
+		if err == nil && class >= 1 && class <= 5 {
+			return statusRange{from: class * 100, to: class*100 + 99}, nil
+		}
+	}
+
+	parts := strings.SplitN(s, "-", 2)
+	from, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return statusRange{}, fmt.Errorf("Assertions.Status %s is invalid", status)
+	}
+	to := from
+	if len(parts) == 2 {
+		to, err = strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || to < from {
+			return statusRange{}, fmt.Errorf("Assertions.Status %s is invalid", status)
+		}
+	}
+	return statusRange{from: from, to: to}, nil
+}
+
+// needsBody returns true if the response body must be read to evaluate the assertions
+func (a *assertions) needsBody() bool {
+	return a.bodyRegex != nil || len(a.jsonPaths) > 0
+}
+
+// maxBodyRead returns how much of the response body must be read to evaluate the assertions
+func (a *assertions) maxBodyRead() int64 {
+	if a.maxBodySize > 0 && a.maxBodySize < maxAssertedBodySize {
+		// One more than the max so that oversized bodies can be detected
+		return a.maxBodySize + 1
+	}
+	return maxAssertedBodySize
+}
+
+// check evaluates the assertions against a response with 'status', 'header', and 'body'
+// whose total size is 'bodySize'. 'body' may be a prefix of the full body if it's larger
+// than maxBodyRead(). It returns the kinds of assertions (e.g., api.AssertStatus) that failed.
+func (a *assertions) check(status int, header http.Header, body []byte, bodySize int64) []string {
+	var failures []string
+
+	if len(a.statuses) > 0 {
+		ok := false
+		for _, sr := range a.statuses {
+			if status >= sr.from && status <= sr.to {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			failures = append(failures, api.AssertStatus)
+		}
+	}
+
+	for name, re := range a.headers {
+		vals, ok := header[http.CanonicalHeaderKey(name)]
+		if !ok || !matchesAny(re, vals) {
+			failures = append(failures, api.AssertHeader)
+			break
+		}
+	}
+
+	if a.maxBodySize > 0 && bodySize > a.maxBodySize {
+		failures = append(failures, api.AssertBodySize)
+	}
+
+	if a.bodyRegex != nil && !a.bodyRegex.Match(body) {
+		failures = append(failures, api.AssertBodyRegex)
+	}
+
+	if len(a.jsonPaths) > 0 {
+		var doc interface{}
+		err := json.Unmarshal(body, &doc)
+		for _, jpa := range a.jsonPaths {
+			if err != nil {
+				failures = append(failures, api.AssertJSONPath)
+				break
+			}
+			actual, ok := jpa.path.lookup(doc)
+			if !ok || !reflect.DeepEqual(actual, jpa.expected) {
+				failures = append(failures, api.AssertJSONPath)
+				break
+			}
+		}
+	}
+
+	return failures
+}
+
+func matchesAny(re *regexp.Regexp, vals []string) bool {
+	for _, v := range vals {
+		if re.MatchString(v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+func TestAssertions(t *testing.T) {
+	okBody := []byte(`{"status": "ok", "data": {"id": 42, "tags": ["a", "b"]}}`)
+	errBody := []byte(`{"status": "error", "message": "database unavailable"}`)
+	jsonHeader := http.Header{"Content-Type": []string{"application/json; charset=utf-8"}}
+
+	tests := []struct {
+		name       string
+		assertions *api.Assertions
+		shouldFail bool
+		status     int
+		header     http.Header
+		body       []byte
+		expected   []string
+	}{
+		{name: "StatusPass", assertions: &api.Assertions{Status: []string{"200", "204"}}, status: 204},
+		{name: "StatusClassPass", assertions: &api.Assertions{Status: []string{"2xx"}}, status: 201},
+		{name: "StatusRangePass", assertions: &api.Assertions{Status: []string{"300-399", "200-201"}}, status: 201},
+		{name: "StatusFail", assertions: &api.Assertions{Status: []string{"2xx"}}, status: 500,
+			expected: []string{api.AssertStatus}},
+		{name: "HeaderPass", assertions: &api.Assertions{Headers: map[string]string{"content-type": "^application/json"}},
+			status: 200, header: jsonHeader},
+		{name: "HeaderPresent", assertions: &api.Assertions{Headers: map[string]string{"Content-Type": ""}},
+			status: 200, header: jsonHeader},
+		{name: "HeaderMissing", assertions: &api.Assertions{Headers: map[string]string{"X-Request-Id": ""}},
+			status: 200, header: jsonHeader, expected: []string{api.AssertHeader}},
+		{name: "HeaderMismatch", assertions: &api.Assertions{Headers: map[string]string{"Content-Type": "text/html"}},
+			status: 200, header: jsonHeader, expected: []string{api.AssertHeader}},
+		{name: "BodyRegexPass", assertions: &api.Assertions{BodyRegex: `"status":\s*"ok"`}, status: 200, body: okBody},
+		{name: "BodyRegexFail", assertions: &api.Assertions{BodyRegex: `"status":\s*"ok"`}, status: 200, body: errBody,
+			expected: []string{api.AssertBodyRegex}},
+		{name: "JSONPathPass", assertions: &api.Assertions{JSONPath: map[string]interface{}{
+			"$.status": "ok", "$.data.id": 42, "$.data.tags": []string{"a", "b"}}}, status: 200, body: okBody},
+		{name: "JSONPathFail", assertions: &api.Assertions{JSONPath: map[string]interface{}{"$.status": "ok"}},
+			status: 200, body: errBody, expected: []string{api.AssertJSONPath}},
+		{name: "JSONPathNotJSON", assertions: &api.Assertions{JSONPath: map[string]interface{}{"$.status": "ok"}},
+			status: 200, body: []byte("<html></html>"), expected: []string{api.AssertJSONPath}},
+		{name: "BodySizeFail", assertions: &api.Assertions{MaxBodySize: 10}, status: 200, body: okBody,
+			expected: []string{api.AssertBodySize}},
+		{name: "MultipleFailures", assertions: &api.Assertions{Status: []string{"200"}, JSONPath: map[string]interface{}{"$.status": "ok"}},
+			status: 503, body: errBody, expected: []string{api.AssertStatus, api.AssertJSONPath}},
+		{name: "FailPath - invalid status", assertions: &api.Assertions{Status: []string{"2xy"}}, shouldFail: true},
+		{name: "FailPath - invalid status range", assertions: &api.Assertions{Status: []string{"299-200"}}, shouldFail: true},
+		{name: "FailPath - invalid regex", assertions: &api.Assertions{BodyRegex: "("}, shouldFail: true},
+		{name: "FailPath - invalid JSONPath", assertions: &api.Assertions{JSONPath: map[string]interface{}{"status": "ok"}},
+			shouldFail: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			asserts, err := newAssertions(tc.assertions)
+			if tc.shouldFail {
+				if err == nil {
+					t.Errorf("expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			actual := asserts.check(tc.status, tc.header, tc.body, int64(len(tc.body)))
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Errorf("expected failures %v, got %v", tc.expected, actual)
+			}
+		})
+	}
+}
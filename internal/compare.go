@@ -0,0 +1,207 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/youngkin/heyyall/api"
+)
+
+// comparedPercentiles are the latency percentiles compared between runs
+var comparedPercentiles = []float64{50, 75, 90, 95, 99, 99.9, 99.99}
+
+// LoadRunResults reads a JSON report, produced by '-out json', from 'fileName'. Reports
+// produced by earlier versions of heyyall, which omitted the enclosing braces, are
+// also accepted as long as their Version is api.ReportVersion.
+func LoadRunResults(fileName string) (api.RunResults, error) {
+	contents, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return api.RunResults{}, fmt.Errorf("unable to read report file %s: %w", fileName, err)
+	}
+
+	contents = bytes.TrimSpace(contents)
+	if len(contents) > 0 && contents[0] != '{' {
+		contents = append(append([]byte("{"), contents...), '}')
+	}
+
+	rr, err := decodeRunResults(contents)
+	if err != nil {
+		return api.RunResults{}, fmt.Errorf("report file %s: %w", fileName, err)
+	}
+	return rr, nil
+}
+
+// decodeRunResults decodes the JSON encoded 'contents'. The report's Version is checked
+// first so that reports with a different schema are reported as such rather than as
+// whatever decoding error their differences cause.
+func decodeRunResults(contents []byte) (api.RunResults, error) {
+	var v struct{ Version int }
+	if err := json.Unmarshal(contents, &v); err != nil {
+		return api.RunResults{}, fmt.Errorf("error unmarshaling report: %w", err)
+	}
+	if v.Version != api.ReportVersion {
+		version := v.Version
+		if version == 0 {
+			version = 1
+		}
+		return api.RunResults{}, fmt.Errorf("report is version %d, only version %d reports are supported, "+
+			"rerun the test to produce a current report", version, api.ReportVersion)
+	}
+
+	rr := api.RunResults{}
+	if err := json.Unmarshal(contents, &rr); err != nil {
+		return api.RunResults{}, fmt.Errorf("error unmarshaling report: %w", err)
+	}
+	return rr, nil
+}
+
+// CompareRunResults compares 'current' to 'baseline'. Metrics that worsened by more than
+// 'tolerancePct' percent are flagged as regressions.
+func CompareRunResults(baseline, current api.RunResults, tolerancePct float64) *api.RunComparison {
+	c := api.RunComparison{TolerancePct: tolerancePct}
+	c.Overall = compareStats(baseline.RunSummary.RqstStats, current.RunSummary.RqstStats,
+		baseline.RunSummary.RunDurationNanos, current.RunSummary.RunDurationNanos, tolerancePct)
+
+	urls := make([]string, 0, len(current.EndpointDetails))
+	for url := range current.EndpointDetails {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+
+	for _, url := range urls {
+		baseDetail, ok := baseline.EndpointDetails[url]
+		if !ok {
+			log.Warn().Msgf("compare: %s isn't in the baseline, it won't be compared", url)
+			continue
+		}
+		methods := make([]string, 0, len(current.EndpointDetails[url].HTTPMethodRqstStats))
+		for method := range current.EndpointDetails[url].HTTPMethodRqstStats {
+			methods = append(methods, method)
+		}
+		sort.Strings(methods)
+
+		for _, method := range methods {
+			baseStats, ok := baseDetail.HTTPMethodRqstStats[method]
+			if !ok {
+				log.Warn().Msgf("compare: %s %s isn't in the baseline, it won't be compared", method, url)
+				continue
+			}
+			c.Endpoints = append(c.Endpoints, api.EndpointComparison{
+				URL:    url,
+				Method: method,
+				Deltas: compareStats(*baseStats, *current.EndpointDetails[url].HTTPMethodRqstStats[method],
+					baseline.RunSummary.RunDurationNanos, current.RunSummary.RunDurationNanos, tolerancePct),
+			})
+		}
+	}
+
+	c.MissingEndpoints = missingEndpoints(baseline, current)
+
+	for _, d := range c.Overall {
+		if d.Regression {
+			c.Regressions++
+		}
+	}
+	for _, ep := range c.Endpoints {
+		for _, d := range ep.Deltas {
+			if d.Regression {
+				c.Regressions++
+			}
+		}
+	}
+
+	return &c
+}
+
+// missingEndpoints returns the URLs and methods in 'baseline' that aren't in 'current'
+func missingEndpoints(baseline, current api.RunResults) []api.EndpointComparison {
+	urls := make([]string, 0, len(baseline.EndpointDetails))
+	for url := range baseline.EndpointDetails {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+
+	var missing []api.EndpointComparison
+	for _, url := range urls {
+		methods := make([]string, 0, len(baseline.EndpointDetails[url].HTTPMethodRqstStats))
+		for method := range baseline.EndpointDetails[url].HTTPMethodRqstStats {
+			methods = append(methods, method)
+		}
+		sort.Strings(methods)
+
+		curDetail := current.EndpointDetails[url]
+		for _, method := range methods {
+			if curDetail != nil {
+				if _, ok := curDetail.HTTPMethodRqstStats[method]; ok {
+					continue
+				}
+			}
+			log.Warn().Msgf("compare: %s %s is in the baseline but not in this run, it won't be compared", method, url)
+			missing = append(missing, api.EndpointComparison{URL: url, Method: method})
+		}
+	}
+	return missing
+}
+
+// compareStats compares the throughput, error rate, and latency percentiles of 'current',
+// from a run lasting 'currentDur', to 'baseline', from a run lasting 'baselineDur'
+func compareStats(baseline, current api.RqstStats, baselineDur, currentDur time.Duration,
+	tolerancePct float64) []api.MetricDelta {
+
+	var deltas []api.MetricDelta
+
+	if baselineDur > 0 && currentDur > 0 {
+		deltas = append(deltas, newDelta(api.CompareRqstRate, float64(baseline.TotalRqsts)/baselineDur.Seconds(),
+			float64(current.TotalRqsts)/currentDur.Seconds(), false, tolerancePct))
+	}
+
+	if baseline.TotalRqsts > 0 && current.TotalRqsts > 0 {
+		b := float64(baseline.TotalErrors) * 100 / float64(baseline.TotalRqsts)
+		c := float64(current.TotalErrors) * 100 / float64(current.TotalRqsts)
+		// Error rates are already percents, so they're compared in percentage points
+		deltas = append(deltas, api.MetricDelta{
+			Metric:     api.CompareErrorRate,
+			Baseline:   b,
+			Current:    c,
+			DeltaPct:   c - b,
+			Regression: c-b > tolerancePct,
+		})
+	}
+
+	// Percentiles can't be compared if either run's latencies weren't recorded
+	if baseline.TimingResultsNanos.Count() > 0 && current.TimingResultsNanos.Count() > 0 {
+		for _, p := range comparedPercentiles {
+			deltas = append(deltas, newDelta("P"+strconv.FormatFloat(p, 'f', -1, 64),
+				baseline.TimingResultsNanos.Percentile(p).Seconds(), current.TimingResultsNanos.Percentile(p).Seconds(),
+				true, tolerancePct))
+		}
+	}
+
+	return deltas
+}
+
+// newDelta returns the MetricDelta from 'baseline' to 'current'. If 'higherIsWorse' an
+// increase of more than 'tolerancePct' percent is a regression, otherwise a decrease is.
+func newDelta(metric string, baseline, current float64, higherIsWorse bool, tolerancePct float64) api.MetricDelta {
+	d := api.MetricDelta{Metric: metric, Baseline: baseline, Current: current}
+	if baseline == 0 {
+		return d
+	}
+	d.DeltaPct = (current - baseline) * 100 / baseline
+	worsened := d.DeltaPct
+	if !higherIsWorse {
+		worsened = -worsened
+	}
+	d.Regression = worsened > tolerancePct
+	return d
+}
@@ -0,0 +1,187 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// newCompareResults returns the results of a 10 second run of 'numRqsts' GETs to 'url',
+// 'numErrors' of which failed, with the successful requests taking 'latency'
+func newCompareResults(t *testing.T, url string, numRqsts, numErrors int, latency time.Duration) api.RunResults {
+	rh := ResponseHandler{OutputType: JSON}
+	runResults := rh.newRunResults()
+	epRunSummary := make(map[string]*api.EndpointDetail)
+	totalRunTime := time.Duration(0)
+
+	for i := 0; i < numRqsts; i++ {
+		resp := Response{HTTPStatus: http.StatusOK, Endpoint: api.Endpoint{URL: url, Method: http.MethodGet},
+			RequestDuration: latency}
+		if i < numErrors {
+			resp = Response{ErrorType: api.ErrConnRefused, Endpoint: api.Endpoint{URL: url, Method: http.MethodGet}}
+		}
+		rh.accumulateResponseStats(resp, &totalRunTime, &runResults, epRunSummary)
+	}
+	if err := rh.finalizeResponseStats(time.Now(), &totalRunTime, &runResults, epRunSummary); err != nil {
+		t.Fatalf("unexpected error finalizing response stats: %s", err)
+	}
+	runResults.RunSummary.RunDurationNanos = 10 * time.Second
+	return runResults
+}
+
+func TestCompareRunResults(t *testing.T) {
+	url := "http://someurl/users"
+	baseline := newCompareResults(t, url, 1000, 10, 100*time.Millisecond)
+
+	tests := []struct {
+		name        string
+		current     api.RunResults
+		tolerance   float64
+		regressions map[string]bool
+	}{
+		{
+			name:        "NoChange",
+			current:     newCompareResults(t, url, 1000, 10, 100*time.Millisecond),
+			tolerance:   api.DefaultTolerancePct,
+			regressions: map[string]bool{},
+		},
+		{
+			name:        "Improved",
+			current:     newCompareResults(t, url, 2000, 0, 50*time.Millisecond),
+			tolerance:   api.DefaultTolerancePct,
+			regressions: map[string]bool{},
+		},
+		{
+			name:      "SlowerAndFewerRqsts",
+			current:   newCompareResults(t, url, 800, 8, 150*time.Millisecond),
+			tolerance: api.DefaultTolerancePct,
+			regressions: map[string]bool{api.CompareRqstRate: true, "P50": true, "P75": true, "P90": true,
+				"P95": true, "P99": true, "P99.9": true, "P99.99": true},
+		},
+		{
+			name:        "WithinTolerance",
+			current:     newCompareResults(t, url, 800, 8, 150*time.Millisecond),
+			tolerance:   60,
+			regressions: map[string]bool{},
+		},
+		{
+			name:        "MoreErrors",
+			current:     newCompareResults(t, url, 1000, 300, 100*time.Millisecond),
+			tolerance:   api.DefaultTolerancePct,
+			regressions: map[string]bool{api.CompareErrorRate: true},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := CompareRunResults(baseline, tc.current, tc.tolerance)
+			if len(c.Endpoints) != 1 || c.Endpoints[0].URL != url || c.Endpoints[0].Method != http.MethodGet {
+				t.Fatalf("expected a single %s %s endpoint comparison, got %+v", http.MethodGet, url, c.Endpoints)
+			}
+			if c.Regressions != 2*len(tc.regressions) {
+				t.Errorf("expected %d regressions, got %d", 2*len(tc.regressions), c.Regressions)
+			}
+			for _, deltas := range [][]api.MetricDelta{c.Overall, c.Endpoints[0].Deltas} {
+				if len(deltas) != 2+len(comparedPercentiles) {
+					t.Errorf("expected %d deltas, got %d", 2+len(comparedPercentiles), len(deltas))
+				}
+				for _, d := range deltas {
+					if d.Regression != tc.regressions[d.Metric] {
+						t.Errorf("%s: expected regression %t, got %t, %+v", d.Metric, tc.regressions[d.Metric], d.Regression, d)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestLoadRunResults(t *testing.T) {
+	rr := newCompareResults(t, "http://someurl/users", 100, 1, 10*time.Millisecond)
+	rrjson, err := json.MarshalIndent(rr, "    ", "  ")
+	if err != nil {
+		t.Fatalf("unexpected error marshaling run results: %s", err)
+	}
+
+	dir, err := ioutil.TempDir("", "heyyall")
+	if err != nil {
+		t.Fatalf("unexpected error creating temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name       string
+		contents   []byte
+		shouldFail bool
+	}{
+		{name: "JSON", contents: rrjson},
+		// Earlier versions of heyyall omitted the enclosing braces
+		{name: "LegacyJSON", contents: rrjson[2 : len(rrjson)-1]},
+		// Version 1 reports recorded each request's duration rather than a histogram
+		{name: "FailPath - version 1", contents: []byte(`{"RunSummary": {"RqstStats": {"TimingResultsNanos": [1000, 2000]}}}`),
+			shouldFail: true},
+		{name: "FailPath - future version", contents: []byte(`{"Version": 99}`), shouldFail: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fileName := filepath.Join(dir, tc.name+".json")
+			if err := ioutil.WriteFile(fileName, tc.contents, 0644); err != nil {
+				t.Fatalf("unexpected error writing report: %s", err)
+			}
+			got, err := LoadRunResults(fileName)
+			if tc.shouldFail {
+				if err == nil {
+					t.Errorf("expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error loading report: %s", err)
+			}
+			if got.RunSummary.RqstStats.TotalRqsts != 100 || got.RunSummary.RqstStats.TotalErrors != 1 {
+				t.Errorf("expected 100 requests and 1 error, got %+v", got.RunSummary.RqstStats)
+			}
+			if got.RunSummary.RqstStats.TimingResultsNanos.Count() != 99 {
+				t.Errorf("expected 99 latencies, got %d", got.RunSummary.RqstStats.TimingResultsNanos.Count())
+			}
+		})
+	}
+
+	if _, err := LoadRunResults(filepath.Join(dir, "missing.json")); err == nil {
+		t.Errorf("expected an error loading a missing report")
+	}
+}
+
+func TestCompareRunResultsMissingEndpoints(t *testing.T) {
+	url := "http://someurl/users"
+	otherURL := "http://someurl/orders"
+	baseline := newCompareResults(t, url, 1000, 10, 100*time.Millisecond)
+	baseline.EndpointDetails[otherURL] = newCompareResults(t, otherURL, 1000, 10, 100*time.Millisecond).EndpointDetails[otherURL]
+	baseline.EndpointDetails[url].HTTPMethodRqstStats[http.MethodPost] = baseline.EndpointDetails[url].HTTPMethodRqstStats[http.MethodGet]
+
+	c := CompareRunResults(baseline, newCompareResults(t, url, 1000, 10, 100*time.Millisecond), api.DefaultTolerancePct)
+	if len(c.Endpoints) != 1 || c.Endpoints[0].URL != url || c.Endpoints[0].Method != http.MethodGet {
+		t.Errorf("expected a single %s %s endpoint comparison, got %+v", http.MethodGet, url, c.Endpoints)
+	}
+	expected := []api.EndpointComparison{
+		{URL: otherURL, Method: http.MethodGet},
+		{URL: url, Method: http.MethodPost},
+	}
+	if !reflect.DeepEqual(c.MissingEndpoints, expected) {
+		t.Errorf("expected missing endpoints %+v, got %+v", expected, c.MissingEndpoints)
+	}
+	if c.Regressions != 0 {
+		t.Errorf("expected no regressions, got %d", c.Regressions)
+	}
+}
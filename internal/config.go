@@ -0,0 +1,428 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/rs/zerolog/log"
+	"github.com/youngkin/heyyall/api"
+	"gopkg.in/yaml.v3"
+)
+
+// LoadConfig reads a load test configuration from 'fileName'. The format is determined
+// by the file's extension, '.yaml' or '.yml' for YAML, '.toml' for TOML, and JSON
+// otherwise. Fields that aren't part of api.LoadTestConfig are reported as errors, along
+// with the line they're on, rather than ignored.
+func LoadConfig(fileName string) (api.LoadTestConfig, error) {
+	contents, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return api.LoadTestConfig{}, fmt.Errorf("unable to read config file %s: %w", fileName, err)
+	}
+
+	log.Debug().Msgf("Raw config file contents: %s", string(contents))
+
+	config := api.LoadTestConfig{}
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".yaml", ".yml":
+		err = decodeYAMLConfig(contents, &config)
+	case ".toml":
+		err = decodeTOMLConfig(contents, &config)
+	default:
+		err = decodeJSONConfig(contents, &config)
+	}
+	if err != nil {
+		return api.LoadTestConfig{}, fmt.Errorf("config file %s: %w", fileName, err)
+	}
+	return config, nil
+}
+
+// configValue is a configuration value along with the line it's on. It's used to find
+// fields that don't belong in a configuration regardless of the configuration's format.
+type configValue struct {
+	line int
+	// fields are set if the value is an object
+	fields []configField
+	// elems are set if the value is an array
+	elems []*configValue
+}
+
+// configField is a named field of an object
+type configField struct {
+	name  string
+	line  int
+	value *configValue
+}
+
+// checkFields returns an error if 'v', or any value it contains, has a field that isn't
+// part of 't'. Fields are matched to struct fields the same way encoding/json matches
+// them. 'path' is the location of 'v' in the configuration, e.g., "Endpoints[1]".
+func checkFields(v *configValue, t reflect.Type, path string) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		for _, f := range v.fields {
+			sf, ok := structField(t, f.name)
+			if !ok {
+				return fmt.Errorf("line %d: unknown field %s", f.line, joinPath(path, f.name))
+			}
+			if err := checkFields(f.value, sf.Type, joinPath(path, f.name)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		for _, f := range v.fields {
+			if err := checkFields(f.value, t.Elem(), joinPath(path, f.name)); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i, elem := range v.elems {
+			if err := checkFields(elem, t.Elem(), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// structField returns the field of 't' that encoding/json would decode the object field
+// 'name' into. An exact match is preferred to a case insensitive one.
+func structField(t reflect.Type, name string) (reflect.StructField, bool) {
+	var match reflect.StructField
+	found := false
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		jsonName := sf.Name
+		if tag := strings.Split(sf.Tag.Get("json"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			jsonName = tag
+		}
+		if jsonName == name {
+			return sf, true
+		}
+		if !found && strings.EqualFold(jsonName, name) {
+			match, found = sf, true
+		}
+	}
+	return match, found
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// decodeJSONConfig decodes the JSON configuration 'contents' into 'config'
+func decodeJSONConfig(contents []byte, config *api.LoadTestConfig) error {
+	dec := json.NewDecoder(bytes.NewReader(contents))
+	v, err := jsonConfigValue(dec, contents)
+	if err != nil {
+		return jsonError(err, contents)
+	}
+	if err = checkFields(v, reflect.TypeOf(config), ""); err != nil {
+		return err
+	}
+	if err = json.Unmarshal(contents, config); err != nil {
+		return jsonError(err, contents)
+	}
+	return nil
+}
+
+// jsonConfigValue reads the next JSON value from 'dec', which is reading 'contents'
+func jsonConfigValue(dec *json.Decoder, contents []byte) (*configValue, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	v := &configValue{line: lineAt(contents, dec.InputOffset())}
+
+	switch tok {
+	case json.Delim('{'):
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			line := lineAt(contents, dec.InputOffset())
+			value, err := jsonConfigValue(dec, contents)
+			if err != nil {
+				return nil, err
+			}
+			v.fields = append(v.fields, configField{name: key.(string), line: line, value: value})
+		}
+	case json.Delim('['):
+		for dec.More() {
+			elem, err := jsonConfigValue(dec, contents)
+			if err != nil {
+				return nil, err
+			}
+			v.elems = append(v.elems, elem)
+		}
+	default:
+		return v, nil
+	}
+
+	// The closing delimiter
+	if _, err = dec.Token(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// jsonError adds the line the JSON error 'err' occurred on, if it's known, to 'err'
+func jsonError(err error, contents []byte) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return fmt.Errorf("line %d: %w", lineAt(contents, syntaxErr.Offset), err)
+	case errors.As(err, &typeErr):
+		return fmt.Errorf("line %d: %w", lineAt(contents, typeErr.Offset), err)
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return fmt.Errorf("line %d: unexpected end of JSON input", lineAt(contents, int64(len(contents))))
+	}
+	return err
+}
+
+// lineAt returns the line number of 'offset' in 'contents'
+func lineAt(contents []byte, offset int64) int {
+	if offset > int64(len(contents)) {
+		offset = int64(len(contents))
+	}
+	return bytes.Count(contents[:offset], []byte("\n")) + 1
+}
+
+// decodeYAMLConfig decodes the YAML configuration 'contents' into 'config'. Fields are
+// named as they are in JSON configurations, e.g., 'RqstRate' not 'rqstrate'.
+func decodeYAMLConfig(contents []byte, config *api.LoadTestConfig) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(contents, &doc); err != nil {
+		return err
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	root := doc.Content[0]
+	if err := checkFields(yamlConfigValue(root), reflect.TypeOf(config), ""); err != nil {
+		return err
+	}
+
+	// Decoding via JSON keeps field names and value conversions the same as they are for
+	// JSON configurations
+	var yj yamlJSON
+	if err := yj.write(root, ""); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(yj.buf.Bytes(), config); err != nil {
+		return yj.error(err)
+	}
+	return nil
+}
+
+// yamlJSON converts a YAML document to JSON. It records where each YAML value is in the
+// JSON so that errors decoding the JSON can be reported at the YAML value's line.
+type yamlJSON struct {
+	buf bytes.Buffer
+	// values are the YAML values in the order they were written to buf
+	values []yamlJSONValue
+}
+
+// yamlJSONValue is a YAML value written to the JSON at 'offset'. 'path' is its location
+// in the configuration, e.g., "Endpoints[1].Method".
+type yamlJSONValue struct {
+	offset int64
+	line   int
+	path   string
+}
+
+// write writes the JSON form of 'node', which is at 'path', to yj.buf
+func (yj *yamlJSON) write(node *yaml.Node, path string) error {
+	if node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	yj.values = append(yj.values, yamlJSONValue{offset: int64(yj.buf.Len()), line: node.Line, path: path})
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		yj.buf.WriteByte('{')
+		if err := yj.writeFields(node, path); err != nil {
+			return err
+		}
+		yj.buf.WriteByte('}')
+	case yaml.SequenceNode:
+		yj.buf.WriteByte('[')
+		for i, elem := range node.Content {
+			if i > 0 {
+				yj.buf.WriteByte(',')
+			}
+			if err := yj.write(elem, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		yj.buf.WriteByte(']')
+	default:
+		var v interface{}
+		if err := node.Decode(&v); err != nil {
+			return yamlTypeError(err, node.Line, path)
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("line %d: %s: %w", node.Line, path, err)
+		}
+		yj.buf.Write(b)
+	}
+	return nil
+}
+
+// writeFields writes the fields of the mapping 'node', which is at 'path', to the JSON
+// object being written to yj.buf. The fields of merged mappings, '<<', are written first
+// so that the mapping's own fields override them, as they do in YAML.
+func (yj *yamlJSON) writeFields(node *yaml.Node, path string) error {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if key.Value != "<<" || key.Tag != "!!merge" {
+			continue
+		}
+		if value.Kind == yaml.AliasNode && value.Alias != nil {
+			value = value.Alias
+		}
+		merged := []*yaml.Node{value}
+		if value.Kind == yaml.SequenceNode {
+			merged = value.Content
+		}
+		for _, m := range merged {
+			if m.Kind == yaml.AliasNode && m.Alias != nil {
+				m = m.Alias
+			}
+			if m.Kind != yaml.MappingNode {
+				return fmt.Errorf("line %d: %s: only mappings can be merged", m.Line, path)
+			}
+			if err := yj.writeFields(m, path); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if key.Value == "<<" && key.Tag == "!!merge" {
+			continue
+		}
+		// Fields after the first are preceded by a comma
+		if b := yj.buf.Bytes(); b[len(b)-1] != '{' {
+			yj.buf.WriteByte(',')
+		}
+		name, err := json.Marshal(key.Value)
+		if err != nil {
+			return err
+		}
+		yj.buf.Write(name)
+		yj.buf.WriteByte(':')
+		if err = yj.write(value, joinPath(path, key.Value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// error adds the line and location of the YAML value the JSON decoding error 'err'
+// occurred on, if it's known, to 'err'
+func (yj *yamlJSON) error(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if !errors.As(err, &typeErr) {
+		return err
+	}
+	// The error's offset is just past the start of the value that couldn't be decoded
+	i := sort.Search(len(yj.values), func(i int) bool { return yj.values[i].offset >= typeErr.Offset }) - 1
+	if i < 0 {
+		return err
+	}
+	return fmt.Errorf("line %d: %s: %w", yj.values[i].line, yj.values[i].path, err)
+}
+
+// yamlTypeError adds the line and 'path' of the YAML value that couldn't be decoded to
+// 'err'. Each of the messages of a yaml.TypeError is given the value's location.
+func yamlTypeError(err error, line int, path string) error {
+	var typeErr *yaml.TypeError
+	if !errors.As(err, &typeErr) {
+		return fmt.Errorf("line %d: %s: %w", line, path, err)
+	}
+	msgs := make([]string, 0, len(typeErr.Errors))
+	for _, msg := range typeErr.Errors {
+		// yaml.v3 messages usually start with the line, e.g., "line 3: cannot ..."
+		if strings.HasPrefix(msg, "line ") {
+			if i := strings.Index(msg, ": "); i >= 0 {
+				msg = msg[i+2:]
+			}
+		}
+		msgs = append(msgs, fmt.Sprintf("line %d: %s: %s", line, path, msg))
+	}
+	return errors.New(strings.Join(msgs, "; "))
+}
+
+// yamlConfigValue returns the configValue of the YAML 'node'
+func yamlConfigValue(node *yaml.Node) *configValue {
+	if node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	v := &configValue{line: node.Line}
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			// Merge keys, '<<', bring in the fields of another mapping
+			if key.Value == "<<" && key.Tag == "!!merge" {
+				v.fields = append(v.fields, yamlConfigValue(value).fields...)
+				continue
+			}
+			v.fields = append(v.fields, configField{name: key.Value, line: key.Line, value: yamlConfigValue(value)})
+		}
+	case yaml.SequenceNode:
+		for _, elem := range node.Content {
+			v.elems = append(v.elems, yamlConfigValue(elem))
+		}
+	}
+	return v
+}
+
+// decodeTOMLConfig decodes the TOML configuration 'contents' into 'config'
+func decodeTOMLConfig(contents []byte, config *api.LoadTestConfig) error {
+	dec := toml.NewDecoder(bytes.NewReader(contents))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(config)
+
+	var strictErr *toml.StrictMissingError
+	var decodeErr *toml.DecodeError
+	switch {
+	case errors.As(err, &strictErr) && len(strictErr.Errors) > 0:
+		e := strictErr.Errors[0]
+		line, _ := e.Position()
+		return fmt.Errorf("line %d: unknown field %s", line, strings.Join(e.Key(), "."))
+	case errors.As(err, &decodeErr):
+		line, _ := decodeErr.Position()
+		return fmt.Errorf("line %d: %w", line, err)
+	}
+	return err
+}
@@ -0,0 +1,217 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+func TestLoadConfig(t *testing.T) {
+	expected := api.LoadTestConfig{
+		RqstRate:           10,
+		MaxConcurrentRqsts: 2,
+		RunDuration:        "5s",
+		Thresholds:         []api.Threshold{{Metric: "P99", Max: "500ms"}},
+		Endpoints: []api.Endpoint{
+			{
+				URL:         "http://someurl/users",
+				Method:      "POST",
+				RqstBody:    `{"name": "alice"}`,
+				RqstPercent: 100,
+				Headers:     map[string]string{"Content-Type": "application/json"},
+				Assertions:  &api.Assertions{Status: []string{"2xx"}},
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		fileName string
+		contents string
+		// expectErr, if not empty, is part of the expected error message
+		expectErr string
+	}{
+		{
+			name:     "JSON",
+			fileName: "config.json",
+			contents: `{
+    "RqstRate": 10,
+    "MaxConcurrentRqsts": 2,
+    "RunDuration": "5s",
+    "Thresholds": [{"Metric": "P99", "Max": "500ms"}],
+    "Endpoints": [
+        {
+            "URL": "http://someurl/users",
+            "method": "POST",
+            "RqstBody": "{\"name\": \"alice\"}",
+            "RqstPercent": 100,
+            "Headers": {"Content-Type": "application/json"},
+            "Assertions": {"Status": ["2xx"]}
+        }
+    ]
+}`,
+		},
+		{
+			name:     "YAML",
+			fileName: "config.yaml",
+			contents: `# A small run
+RqstRate: 10
+MaxConcurrentRqsts: 2
+RunDuration: 5s
+Thresholds:
+  - Metric: P99
+    Max: 500ms
+Endpoints:
+  - URL: http://someurl/users
+    method: POST # matched case insensitively, as in JSON
+    RqstBody: '{"name": "alice"}'
+    RqstPercent: 100
+    Headers:
+      Content-Type: application/json
+    Assertions:
+      Status: ["2xx"]
+`,
+		},
+		{
+			name:     "TOML",
+			fileName: "config.toml",
+			contents: `# A small run
+RqstRate = 10
+MaxConcurrentRqsts = 2
+RunDuration = "5s"
+Thresholds = [{ Metric = "P99", Max = "500ms" }]
+
+[[Endpoints]]
+URL = "http://someurl/users"
+method = "POST"
+RqstBody = '{"name": "alice"}'
+RqstPercent = 100
+Headers = { Content-Type = "application/json" }
+Assertions = { Status = ["2xx"] }
+`,
+		},
+		{
+			name:      "JSONUnknownField",
+			fileName:  "config.json",
+			contents:  "{\n  \"RqstRate\": 10,\n  \"OutputType\": \"JSON\"\n}",
+			expectErr: "config.json: line 3: unknown field OutputType",
+		},
+		{
+			name:      "JSONNestedUnknownField",
+			fileName:  "config.json",
+			contents:  "{\n  \"Endpoints\": [\n    {\"URL\": \"http://someurl\"},\n    {\n      \"URL\": \"http://someurl\",\n      \"Methd\": \"GET\"\n    }\n  ]\n}",
+			expectErr: "config.json: line 6: unknown field Endpoints[1].Methd",
+		},
+		{
+			name:      "JSONSyntaxError",
+			fileName:  "config.json",
+			contents:  "{\n  \"RqstRate\": 10,\n  \"RunDuration\" \"5s\"\n}",
+			expectErr: "config.json: line 3:",
+		},
+		{
+			name:      "JSONTypeError",
+			fileName:  "config.json",
+			contents:  "{\n  \"RqstRate\": 10,\n  \"RunDuration\": 5\n}",
+			expectErr: "config.json: line 3:",
+		},
+		{
+			name:      "JSONTruncated",
+			fileName:  "config.json",
+			contents:  "{\n  \"RqstRate\": 10,\n",
+			expectErr: "config.json: line 3: unexpected end of JSON input",
+		},
+		{
+			name:      "YAMLUnknownField",
+			fileName:  "config.yml",
+			contents:  "RqstRate: 10\nEndpoints:\n  - URL: http://someurl\n    Headers:\n      Accept: text/plain\n    Assertion:\n      Status: [\"200\"]\n",
+			expectErr: "config.yml: line 6: unknown field Endpoints[0].Assertion",
+		},
+		{
+			name:      "YAMLSyntaxError",
+			fileName:  "config.yaml",
+			contents:  "RqstRate: 10\nEndpoints: [\n",
+			expectErr: "config.yaml: yaml: line",
+		},
+		{
+			name:      "YAMLTypeError",
+			fileName:  "config.yaml",
+			contents:  "RqstRate: 10\nEndpoints:\n  - URL: http://someurl\n    RqstPercent: lots\n",
+			expectErr: "config.yaml: line 4: Endpoints[0].RqstPercent: json: cannot unmarshal string",
+		},
+		{
+			name:      "YAMLTaggedTypeError",
+			fileName:  "config.yaml",
+			contents:  "RqstRate: 10\nMaxConcurrentRqsts: !!int two\n",
+			expectErr: "config.yaml: line 2: MaxConcurrentRqsts: yaml: cannot decode !!str `two` as a !!int",
+		},
+		{
+			name:      "TOMLUnknownField",
+			fileName:  "config.toml",
+			contents:  "RqstRate = 10\n\n[[Endpoints]]\nURL = \"http://someurl\"\nPercent = 100\n",
+			expectErr: "config.toml: line 5: unknown field Endpoints.Percent",
+		},
+		{
+			name:      "TOMLSyntaxError",
+			fileName:  "config.toml",
+			contents:  "RqstRate = 10\nRunDuration = \n",
+			expectErr: "config.toml: line 2:",
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "heyyall")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fileName := filepath.Join(dir, tc.fileName)
+			if err := ioutil.WriteFile(fileName, []byte(tc.contents), 0600); err != nil {
+				t.Fatalf("unable to write config file: %s", err)
+			}
+			config, err := LoadConfig(fileName)
+			if tc.expectErr != "" {
+				if err == nil {
+					t.Fatalf("expected an error containing %q, got none", tc.expectErr)
+				}
+				if !strings.Contains(err.Error(), tc.expectErr) {
+					t.Errorf("expected an error containing %q, got %q", tc.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(config, expected) {
+				t.Errorf("expected %+v, got %+v", expected, config)
+			}
+		})
+	}
+
+	if _, err = LoadConfig(filepath.Join(dir, "missing.json")); err == nil {
+		t.Errorf("expected an error for a missing config file")
+	}
+}
+
+// TestExampleConfigs verifies that the example configurations only contain known fields
+func TestExampleConfigs(t *testing.T) {
+	fileNames, err := filepath.Glob("../testdata/*.json")
+	if err != nil || len(fileNames) == 0 {
+		t.Fatalf("expected example configurations, got %v, %v", fileNames, err)
+	}
+	for _, fileName := range fileNames {
+		if _, err := LoadConfig(fileName); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}
+}
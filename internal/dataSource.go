@@ -0,0 +1,268 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// Data source formats, see api.DataSource.Format
+const (
+	csvFormat   = "csv"
+	jsonlFormat = "jsonl"
+)
+
+// dataRow is a single row of a data source keyed by column
+type dataRow map[string]string
+
+// DataSources are the rows loaded from the run's api.DataSources
+type DataSources struct {
+	sources map[string]*dataSource
+}
+
+// dataSource is the loaded form of an api.DataSource
+type dataSource struct {
+	name        string
+	mode        string
+	onExhausted string
+	rows        []dataRow
+	// cursor is the number of rows that have been handed out by "sequential" and
+	// "unique" sources
+	cursor int64
+}
+
+// NewDataSources loads the rows of each of 'configs'
+func NewDataSources(configs []api.DataSource) (*DataSources, error) {
+	ds := &DataSources{sources: make(map[string]*dataSource, len(configs))}
+	for _, c := range configs {
+		if c.Name == "" {
+			return nil, fmt.Errorf("DataSource.Name must be specified")
+		}
+		if _, ok := ds.sources[c.Name]; ok {
+			return nil, fmt.Errorf("there's more than one data source named %s", c.Name)
+		}
+		src, err := newDataSource(c)
+		if err != nil {
+			return nil, fmt.Errorf("data source %s: %w", c.Name, err)
+		}
+		ds.sources[c.Name] = src
+	}
+	return ds, nil
+}
+
+// newDataSource validates 'c' and loads its rows
+func newDataSource(c api.DataSource) (*dataSource, error) {
+	src := &dataSource{name: c.Name, mode: c.Mode, onExhausted: c.OnExhausted}
+	switch src.mode {
+	case "":
+		src.mode = api.DataSequential
+	case api.DataSequential, api.DataRandom, api.DataUnique:
+	default:
+		return nil, fmt.Errorf("Mode %s is invalid, it must be one of %s, %s, or %s", c.Mode,
+			api.DataSequential, api.DataRandom, api.DataUnique)
+	}
+	switch src.onExhausted {
+	case "":
+		src.onExhausted = api.DataRecycle
+	case api.DataRecycle, api.DataStop:
+	default:
+		return nil, fmt.Errorf("OnExhausted %s is invalid, it must be either %s or %s", c.OnExhausted,
+			api.DataRecycle, api.DataStop)
+	}
+
+	format := strings.ToLower(c.Format)
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(c.File)) {
+		case ".csv":
+			format = csvFormat
+		case ".jsonl", ".ndjson":
+			format = jsonlFormat
+		default:
+			return nil, fmt.Errorf("Format isn't specified and can't be determined from the File %s", c.File)
+		}
+	}
+
+	f, err := os.Open(c.File)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch format {
+	case csvFormat:
+		src.rows, err = readCSVRows(f)
+	case jsonlFormat:
+		src.rows, err = readJSONLRows(f)
+	default:
+		return nil, fmt.Errorf("Format %s is invalid, it must be either %s or %s", c.Format, csvFormat, jsonlFormat)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", c.File, err)
+	}
+	if len(src.rows) == 0 {
+		return nil, fmt.Errorf("%s has no rows", c.File)
+	}
+	return src, nil
+}
+
+// readCSVRows reads the rows of a CSV file whose first line names its columns
+func readCSVRows(r io.Reader) ([]dataRow, error) {
+	cr := csv.NewReader(r)
+	columns, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []dataRow
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		row := make(dataRow, len(columns))
+		for i, col := range columns {
+			row[col] = record[i]
+		}
+		rows = append(rows, row)
+	}
+}
+
+// readJSONLRows reads the rows of a file containing a JSON object per line. Fields that
+// aren't strings are kept as JSON, e.g., a field whose value is [1, 2] is "[1,2]".
+func readJSONLRows(r io.Reader) ([]dataRow, error) {
+	var rows []dataRow
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var obj map[string]interface{}
+		if err := json.Unmarshal(line, &obj); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		row := make(dataRow, len(obj))
+		for col, val := range obj {
+			if s, ok := val.(string); ok {
+				row[col] = s
+				continue
+			}
+			b, err := json.Marshal(val)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNum, err)
+			}
+			row[col] = string(b)
+		}
+		rows = append(rows, row)
+	}
+	return rows, scanner.Err()
+}
+
+// next returns the source's next row. 'ok' is false if the source is exhausted.
+func (src *dataSource) next(rnd *rand.Rand) (row dataRow, ok bool) {
+	if src.mode == api.DataRandom {
+		return src.rows[rnd.Intn(len(src.rows))], true
+	}
+	i := atomic.AddInt64(&src.cursor, 1) - 1
+	if i >= int64(len(src.rows)) && src.onExhausted == api.DataStop {
+		return nil, false
+	}
+	return src.rows[i%int64(len(src.rows))], true
+}
+
+// dataRows are the rows a single virtual user, or requestor, is currently using. Rows
+// are taken from their sources the first time they're referred to, and are kept until
+// next is called, so all of a request's references to a source refer to the same row.
+// A dataRows isn't safe for concurrent use.
+type dataRows struct {
+	sources *DataSources
+	rnd     *rand.Rand
+	// current are the rows being used by the current request, keyed by source
+	current map[string]dataRow
+	// unique are the rows assigned to the virtual user by "unique" sources
+	unique map[string]dataRow
+	// started is false until next is first called. Until then rows are only looked at,
+	// not taken, so that templates can be validated without using up any rows.
+	started bool
+	// exhausted is set when a "stop" source has run out of rows
+	exhausted bool
+}
+
+func newDataRows(sources *DataSources, rnd *rand.Rand) *dataRows {
+	return &dataRows{sources: sources, rnd: rnd, current: make(map[string]dataRow), unique: make(map[string]dataRow)}
+}
+
+// next moves on to the next request, or scenario iteration
+func (d *dataRows) next() {
+	d.started = true
+	for name := range d.current {
+		delete(d.current, name)
+	}
+}
+
+// value returns the value of 'column' in the current row of the source named 'source'
+func (d *dataRows) value(source, column string) (string, error) {
+	row, err := d.row(source)
+	if err != nil {
+		return "", err
+	}
+	val, ok := row[column]
+	if !ok {
+		return "", fmt.Errorf("data source %s has no column %s", source, column)
+	}
+	return val, nil
+}
+
+// row returns the current row of the source named 'source'
+func (d *dataRows) row(source string) (dataRow, error) {
+	if d == nil {
+		return nil, fmt.Errorf("there's no data source named %s", source)
+	}
+	if row, ok := d.current[source]; ok {
+		return row, nil
+	}
+	var src *dataSource
+	if d.sources != nil {
+		src = d.sources.sources[source]
+	}
+	if src == nil {
+		return nil, fmt.Errorf("there's no data source named %s", source)
+	}
+	if !d.started {
+		return src.rows[0], nil
+	}
+
+	row, ok := d.unique[source]
+	if !ok {
+		if row, ok = src.next(d.rnd); !ok {
+			d.exhausted = true
+			return nil, fmt.Errorf("data source %s has run out of rows", source)
+		}
+		if src.mode == api.DataUnique {
+			d.unique[source] = row
+		}
+	}
+	d.current[source] = row
+	return row, nil
+}
@@ -0,0 +1,238 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+func TestNewDataSources(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    api.DataSource
+		expected  []dataRow
+		expectErr bool
+	}{
+		{
+			name:   "CSV",
+			config: api.DataSource{Name: "users", File: "testdata/users.csv"},
+			expected: []dataRow{
+				{"id": "1", "name": "alice"},
+				{"id": "2", "name": "bob"},
+				{"id": "3", "name": "carol, jr"},
+			},
+		},
+		{
+			name:   "JSONL",
+			config: api.DataSource{Name: "users", File: "testdata/users.jsonl", Mode: api.DataRandom, OnExhausted: api.DataStop},
+			expected: []dataRow{
+				{"id": "1", "name": "alice", "tags": `["a"]`},
+				{"id": "2", "name": "bob", "tags": "[]"},
+			},
+		},
+		{
+			name:   "ExplicitFormat",
+			config: api.DataSource{Name: "users", File: "testdata/users.csv", Format: "CSV", Mode: api.DataUnique},
+			expected: []dataRow{
+				{"id": "1", "name": "alice"},
+				{"id": "2", "name": "bob"},
+				{"id": "3", "name": "carol, jr"},
+			},
+		},
+		{
+			name:      "NoName",
+			config:    api.DataSource{File: "testdata/users.csv"},
+			expectErr: true,
+		},
+		{
+			name:      "WrongFormat",
+			config:    api.DataSource{Name: "users", File: "testdata/users.csv", Format: "jsonl"},
+			expectErr: true,
+		},
+		{
+			name:      "UnknownFormat",
+			config:    api.DataSource{Name: "users", File: "testdata/HappyPath.golden"},
+			expectErr: true,
+		},
+		{
+			name:      "NoRows",
+			config:    api.DataSource{Name: "users", File: "testdata/empty.csv"},
+			expectErr: true,
+		},
+		{
+			name:      "MissingFile",
+			config:    api.DataSource{Name: "users", File: "testdata/missing.csv"},
+			expectErr: true,
+		},
+		{
+			name:      "InvalidMode",
+			config:    api.DataSource{Name: "users", File: "testdata/users.csv", Mode: "shuffled"},
+			expectErr: true,
+		},
+		{
+			name:      "InvalidOnExhausted",
+			config:    api.DataSource{Name: "users", File: "testdata/users.csv", OnExhausted: "wrap"},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ds, err := NewDataSources([]api.DataSource{tc.config})
+			if tc.expectErr {
+				if err == nil {
+					t.Errorf("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			rows := ds.sources[tc.config.Name].rows
+			if len(rows) != len(tc.expected) {
+				t.Fatalf("expected %d rows, got %d", len(tc.expected), len(rows))
+			}
+			for i, row := range rows {
+				for col, val := range tc.expected[i] {
+					if row[col] != val {
+						t.Errorf("row %d: expected %s to be %q, got %q", i, col, val, row[col])
+					}
+				}
+			}
+		})
+	}
+
+	// Names must be unique
+	config := api.DataSource{Name: "users", File: "testdata/users.csv"}
+	if _, err := NewDataSources([]api.DataSource{config, config}); err == nil {
+		t.Errorf("expected an error for duplicate data source names")
+	}
+}
+
+func TestDataRows(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     string
+		onExh    string
+		expected []string
+	}{
+		{name: "SequentialRecycle", mode: api.DataSequential, expected: []string{"1", "2", "3", "1", "2"}},
+		{name: "SequentialStop", mode: api.DataSequential, onExh: api.DataStop, expected: []string{"1", "2", "3", "", ""}},
+		{name: "Unique", mode: api.DataUnique, expected: []string{"1", "1", "1", "1", "1"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ds, err := NewDataSources([]api.DataSource{{Name: "users", File: "testdata/users.csv", Mode: tc.mode, OnExhausted: tc.onExh}})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			rows := newDataRows(ds, newRand())
+
+			// Validation doesn't use up any rows
+			if _, err := rows.value("users", "id"); err != nil {
+				t.Fatalf("unexpected error validating: %s", err)
+			}
+
+			for i, exp := range tc.expected {
+				rows.next()
+				id, err := rows.value("users", "id")
+				if exp == "" {
+					if err == nil || !rows.exhausted {
+						t.Errorf("request %d: expected the data source to be exhausted, got %s", i, id)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("request %d: unexpected error: %s", i, err)
+				}
+				// The same row is used for the rest of the request
+				name, _ := rows.value("users", "name")
+				if id != exp || (id == "1" && name != "alice") {
+					t.Errorf("request %d: expected id %s, got %s and name %s", i, exp, id, name)
+				}
+			}
+		})
+	}
+
+	ds, err := NewDataSources([]api.DataSource{{Name: "users", File: "testdata/users.csv", Mode: api.DataRandom}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	rows := newDataRows(ds, newRand())
+	if _, err = rows.value("accounts", "id"); err == nil {
+		t.Errorf("expected an error for an unknown data source")
+	}
+	if _, err = rows.value("users", "email"); err == nil {
+		t.Errorf("expected an error for an unknown column")
+	}
+	for i := 0; i < 100; i++ {
+		rows.next()
+		if _, err = rows.value("users", "id"); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+}
+
+// TestRqstDataSource verifies that requestors share a "sequential" data source and that each
+// row is only used once before the source stops
+func TestRqstDataSource(t *testing.T) {
+	var mux sync.Mutex
+	var names []string
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		defer mux.Unlock()
+		names = append(names, r.Header.Get("X-Name"))
+	}))
+	defer testSrv.Close()
+
+	ds, err := NewDataSources([]api.DataSource{{Name: "users", File: "testdata/users.csv", OnExhausted: api.DataStop}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	respC := make(chan Response, 10)
+	rqstr := Requestor{
+		Ctx:         context.Background(),
+		ResponseC:   respC,
+		Client:      http.Client{Timeout: time.Second},
+		DataSources: ds,
+	}
+	ep := api.Endpoint{
+		URL:     testSrv.URL + `/users/{{ data "users" "id" }}`,
+		Method:  http.MethodGet,
+		Headers: map[string]string{"X-Name": `{{ data "users" "name" }}`},
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			rqstr.ProcessRqst(ep, newRatePacer(5, 0, constantArrival{}))
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	close(respC)
+
+	numResps := 0
+	for range respC {
+		numResps++
+	}
+	if numResps != 3 {
+		t.Errorf("expected 3 responses, one per row, got %d", numResps)
+	}
+	sort.Strings(names)
+	if len(names) != 3 || names[0] != "alice" || names[1] != "bob" || names[2] != "carol, jr" {
+		t.Errorf("expected each user to be requested once, got %v", names)
+	}
+}
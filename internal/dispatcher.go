@@ -0,0 +1,238 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/youngkin/heyyall/api"
+)
+
+// Load models, see api.LoadTestConfig.LoadModel
+const (
+	// ClosedModel has each requestor send its next request only after the previous one completes
+	ClosedModel = "closed"
+	// OpenModel sends requests at their intended times regardless of whether earlier requests completed
+	OpenModel = "open"
+)
+
+// lateRqstThreshold is how long after its intended send time a request can be sent
+// before it's considered late
+const lateRqstThreshold = 5 * time.Millisecond
+
+// dispatch is a single request issued by a dispatcher
+type dispatch struct {
+	due   time.Time
+	stage int
+}
+
+// epDispatch tracks the requests a dispatcher issues to a single endpoint
+type epDispatch struct {
+	ep api.Endpoint
+	// share is the endpoint's share of the overall request rate and concurrency,
+	// i.e., Endpoint.RqstPercent/100
+	share float64
+	// maxSlots is the number of goroutines sending requests to the endpoint
+	maxSlots int
+	// busy is the number of requests issued to the endpoint that haven't completed
+	busy int32
+	// dispatchC delivers issued requests to the goroutines sending requests to the endpoint
+	dispatchC chan dispatch
+	// dropped is the number of requests, due during 'droppedStage', that couldn't be issued
+	// because all slots were busy and that haven't been reported yet
+	dropped      int
+	droppedStage int
+	// current is the endpoint's current weight used to select the endpoint for the next request
+	current int
+}
+
+// dispatcher is the central pacer of an open model run. It issues requests to endpoints,
+// in proportion to their RqstPercent, at their intended send times regardless of how long
+// previously issued requests take to complete. Requests that can't be issued because all
+// of an endpoint's goroutines are busy are dropped.
+type dispatcher struct {
+	eps      []*epDispatch
+	rqstRate int
+	runDur   time.Duration
+	numRqsts int
+	profile  *LoadProfile
+	arrival  arrivalProcess
+	// respC is where dropped requests are reported to the response handler
+	respC chan Response
+}
+
+// newDispatcher returns a dispatcher that will issue 'numRqsts' requests, or issue requests
+// for 'runDur', at an average of 'rqstRate' requests per second with the times between requests
+// determined by 'arrival'. If 'profile' isn't nil its stages determine the request rate and run
+// duration instead. Dropped requests are reported on 'respC'.
+func newDispatcher(rqstRate int, runDur time.Duration, numRqsts int, profile *LoadProfile,
+	arrival arrivalProcess, respC chan Response) *dispatcher {
+	if numRqsts == 0 {
+		numRqsts = api.MaxRqsts
+	}
+	return &dispatcher{rqstRate: rqstRate, runDur: runDur, numRqsts: numRqsts, profile: profile, arrival: arrival,
+		respC: respC}
+}
+
+// addEndpoint registers 'ep', which will be sent requests by 'numGoroutines' goroutines, and
+// returns its epDispatch
+func (d *dispatcher) addEndpoint(ep api.Endpoint, numGoroutines int) *epDispatch {
+	epd := &epDispatch{
+		ep:        ep,
+		share:     float64(ep.RqstPercent) / float64(100),
+		maxSlots:  numGoroutines,
+		dispatchC: make(chan dispatch, numGoroutines),
+	}
+	d.eps = append(d.eps, epd)
+	return epd
+}
+
+// run issues requests until the run is complete or 'ctx' is done. It expects to be run
+// as a goroutine.
+func (d *dispatcher) run(ctx context.Context) {
+	defer func() {
+		for _, epd := range d.eps {
+			d.reportDropped(epd)
+			close(epd.dispatchC)
+		}
+	}()
+
+	start := time.Now()
+	if d.profile != nil {
+		start = d.profile.start
+	}
+	due := start
+	for n := 0; n < d.numRqsts; {
+		rate, concurrency, stage := float64(d.rqstRate), 0, 0
+		if d.profile != nil {
+			var ok bool
+			rate, concurrency, stage, ok = d.profile.at(due.Sub(start))
+			if !ok {
+				return
+			}
+		} else if d.runDur > 0 && due.Sub(start) >= d.runDur {
+			return
+		}
+		if rate <= 0 {
+			due = due.Add(stagePollInterval)
+			continue
+		}
+
+		if !sleepCtx(ctx, time.Until(due)) {
+			return
+		}
+
+		epd := d.nextEndpoint()
+		slots := epd.maxSlots
+		if d.profile != nil {
+			slots = int(math.Min(float64(slots), math.Ceil(float64(concurrency)*epd.share)))
+		}
+		if int(atomic.LoadInt32(&epd.busy)) >= slots {
+			d.drop(epd, stage)
+		} else {
+			atomic.AddInt32(&epd.busy, 1)
+			epd.dispatchC <- dispatch{due: due, stage: stage}
+		}
+
+		n++
+		// The next request is due relative to when this one was due, not when it was issued,
+		// so the dispatcher catches up if it falls behind.
+		interval := time.Duration(float64(time.Second) / rate)
+		due = due.Add(scaleInterval(interval, d.arrival.next(interval)))
+	}
+}
+
+// nextEndpoint selects the endpoint for the next request using smooth weighted round-robin
+// so that requests are spread evenly across endpoints in proportion to their RqstPercent
+func (d *dispatcher) nextEndpoint() *epDispatch {
+	var selected *epDispatch
+	total := 0
+	for _, epd := range d.eps {
+		epd.current += epd.ep.RqstPercent
+		total += epd.ep.RqstPercent
+		if selected == nil || epd.current > selected.current {
+			selected = epd
+		}
+	}
+	selected.current -= total
+	return selected
+}
+
+// drop records that a request to 'epd', due during 'stage', was dropped. Dropped requests
+// are reported as soon as the response handler is ready for them so that reporting them
+// doesn't delay the requests that follow.
+func (d *dispatcher) drop(epd *epDispatch, stage int) {
+	if stage != epd.droppedStage {
+		d.reportDropped(epd)
+		epd.droppedStage = stage
+	}
+	epd.dropped++
+
+	select {
+	case d.respC <- epd.droppedResponse():
+		epd.dropped = 0
+	default:
+	}
+}
+
+// reportDropped reports the requests to 'epd' that were dropped and haven't been reported yet
+func (d *dispatcher) reportDropped(epd *epDispatch) {
+	if epd.dropped == 0 {
+		return
+	}
+	// The response handler receives responses until the dispatcher, and every requestor,
+	// has exited so this won't block indefinitely
+	d.respC <- epd.droppedResponse()
+	epd.dropped = 0
+}
+
+// droppedResponse returns the Response reporting the requests to the endpoint that were
+// dropped and haven't been reported yet
+func (epd *epDispatch) droppedResponse() Response {
+	log.Debug().Msgf("dispatcher: EP: %s, %d requests dropped", epd.ep.URL, epd.dropped)
+	return Response{
+		Endpoint:     api.Endpoint{URL: epd.ep.URL, Method: epd.ep.Method},
+		Stage:        epd.droppedStage,
+		DroppedRqsts: epd.dropped,
+	}
+}
+
+// dispatchPacer is the Pacer used by a goroutine sending requests to an endpoint
+// during an open model run. Requests are due when the dispatcher issues them.
+type dispatchPacer struct {
+	epd *epDispatch
+	// inProgress is true if the goroutine has a request in progress
+	inProgress bool
+}
+
+func (p *dispatchPacer) Next(ctx context.Context) (time.Time, int, bool) {
+	// The goroutine is asking for its next request, so its previous one is complete
+	p.close()
+
+	select {
+	case <-ctx.Done():
+		return time.Time{}, 0, false
+	case d, ok := <-p.epd.dispatchC:
+		if !ok {
+			return time.Time{}, 0, false
+		}
+		p.inProgress = true
+		return d.due, d.stage, true
+	}
+}
+
+// close frees the slot taken by the goroutine's request in progress, if any. It must be
+// called when the goroutine exits, whether or not its last request completed, so that the
+// slot can be used by the endpoint's other goroutines.
+func (p *dispatchPacer) close() {
+	if p.inProgress {
+		atomic.AddInt32(&p.epd.busy, -1)
+		p.inProgress = false
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+func TestNextEndpoint(t *testing.T) {
+	d := newDispatcher(100, time.Second, 0, nil, constantArrival{}, nil)
+	eps := []api.Endpoint{
+		{URL: "http://somewhere.com/1", RqstPercent: 50},
+		{URL: "http://somewhere.com/2", RqstPercent: 30},
+		{URL: "http://somewhere.com/3", RqstPercent: 20},
+	}
+	for _, ep := range eps {
+		d.addEndpoint(ep, 1)
+	}
+
+	actual := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		actual[d.nextEndpoint().ep.URL]++
+	}
+	for _, ep := range eps {
+		if actual[ep.URL] != ep.RqstPercent {
+			t.Errorf("EP: %s, expected %d requests, got %d", ep.URL, ep.RqstPercent, actual[ep.URL])
+		}
+	}
+}
+
+// TestDispatcherDrops validates that the dispatcher keeps issuing requests at the configured
+// rate when requests don't complete, and that requests it can't issue are dropped.
+func TestDispatcherDrops(t *testing.T) {
+	rqstRate := 100
+	numRqsts := 20
+	respC := make(chan Response, numRqsts)
+	d := newDispatcher(rqstRate, 0, numRqsts, nil, constantArrival{}, respC)
+	epd := d.addEndpoint(api.Endpoint{URL: "http://somewhere.com", RqstPercent: 100}, 2)
+
+	start := time.Now()
+	go d.run(context.Background())
+
+	// Two requests are issued, one per slot, and never completed. All other requests are dropped.
+	pacers := []*dispatchPacer{{epd: epd}, {epd: epd}}
+	for _, p := range pacers {
+		due, _, ok := p.Next(context.Background())
+		if !ok {
+			t.Fatalf("expected a request to be issued")
+		}
+		if due.Before(start) {
+			t.Errorf("expected request due after %s, got %s", start, due)
+		}
+	}
+	for range epd.dispatchC {
+		t.Errorf("expected no more requests to be issued")
+	}
+
+	// 20 requests at 100/sec is 190ms between the first and last
+	if elapsed := time.Since(start); elapsed < 190*time.Millisecond {
+		t.Errorf("expected requests to be issued over at least 190ms, got %s", elapsed)
+	}
+	close(respC)
+	if dropped := sumDropped(respC); dropped != numRqsts-len(pacers) {
+		t.Errorf("expected %d dropped requests, got %d", numRqsts-len(pacers), dropped)
+	}
+}
+
+// TestDispatcherCancel validates that a goroutine's slot is freed when it exits with a
+// request in progress, and that the dispatcher stops issuing, and dropping, requests once
+// its context is done.
+func TestDispatcherCancel(t *testing.T) {
+	respC := make(chan Response, 100)
+	d := newDispatcher(100, 0, 0, nil, constantArrival{}, respC)
+	epd := d.addEndpoint(api.Endpoint{URL: "http://somewhere.com", RqstPercent: 100}, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	doneC := make(chan struct{})
+	go func() {
+		d.run(ctx)
+		close(doneC)
+	}()
+
+	// The goroutine exits with its request in progress, e.g., because it ran out of data
+	p := &dispatchPacer{epd: epd}
+	if _, _, ok := p.Next(ctx); !ok {
+		t.Fatalf("expected a request to be issued")
+	}
+	p.close()
+	p = &dispatchPacer{epd: epd}
+	nextCtx, nextCancel := context.WithTimeout(ctx, time.Second)
+	defer nextCancel()
+	if _, _, ok := p.Next(nextCtx); !ok {
+		t.Fatalf("expected a request to be issued once the slot was freed")
+	}
+	p.close()
+
+	cancel()
+	select {
+	case <-doneC:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the dispatcher to stop once its context was done")
+	}
+	close(respC)
+	if dropped := sumDropped(respC); dropped != 0 {
+		t.Errorf("expected no dropped requests, got %d", dropped)
+	}
+}
+
+// sumDropped returns the total number of dropped requests reported on 'respC'
+func sumDropped(respC chan Response) int {
+	dropped := 0
+	for resp := range respC {
+		dropped += resp.DroppedRqsts
+	}
+	return dropped
+}
+
+func TestSetLoadModel(t *testing.T) {
+	tests := []struct {
+		name       string
+		model      string
+		rqstRate   int
+		profile    *LoadProfile
+		shouldFail bool
+		open       bool
+	}{
+		{name: "Default", model: "", rqstRate: 0},
+		{name: "Closed", model: ClosedModel, rqstRate: 0},
+		{name: "Open", model: OpenModel, rqstRate: 10, open: true},
+		{name: "OpenStaged", model: OpenModel, rqstRate: 0, profile: &LoadProfile{}, open: true},
+		{name: "OpenStepStages", model: OpenModel, profile: &LoadProfile{stages: []loadStage{{rqstRate: 10}}}, open: true},
+		{name: "OpenLinearZeroRateStage", model: OpenModel, profile: &LoadProfile{linear: true, stages: []loadStage{{rqstRate: 0}}},
+			open: true},
+		{name: "FailPath - open without rate", model: OpenModel, rqstRate: 0, shouldFail: true},
+		{name: "FailPath - open zero rate step stage", model: OpenModel,
+			profile: &LoadProfile{stages: []loadStage{{rqstRate: 10}, {rqstRate: 0}}}, shouldFail: true},
+		{name: "FailPath - invalid model", model: "ajar", rqstRate: 10, shouldFail: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := Scheduler{rqstRate: tc.rqstRate}
+			s.SetLoadProfile(tc.profile)
+			err := s.SetLoadModel(tc.model)
+			if tc.shouldFail != (err != nil) {
+				t.Errorf("expected failure: %t, got error %v", tc.shouldFail, err)
+			}
+			if s.openModel != tc.open {
+				t.Errorf("expected openModel %t, got %t", tc.open, s.openModel)
+			}
+		})
+	}
+}
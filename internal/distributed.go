@@ -0,0 +1,230 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/youngkin/heyyall/api"
+)
+
+// WorkerRunPath is the path workers accept runs on
+const WorkerRunPath = "/run"
+
+// WorkerStartDelay is how far in the future a controller schedules its workers' runs to
+// start. It allows time for every worker to receive its share of the run so that all the
+// workers start together.
+const WorkerStartDelay = 2 * time.Second
+
+// WorkRequest is sent by a controller to a worker to start the worker's share of a run
+type WorkRequest struct {
+	// Config is the worker's share of the run's LoadTestConfig
+	Config api.LoadTestConfig
+	// StartAt is when the worker should start its run. Controller and worker clocks
+	// are expected to be synchronized, e.g., via NTP.
+	StartAt time.Time
+}
+
+// RunFunc runs the load test described by 'config', starting at 'startAt', and returns
+// its results
+type RunFunc func(config api.LoadTestConfig, startAt time.Time) (api.RunResults, error)
+
+// NewWorkerHandler returns an http.Handler that accepts WorkRequests at WorkerRunPath,
+// runs them with 'run', one at a time, and responds with the JSON encoded api.RunResults.
+// WorkRequests are only accepted with an 'Authorization: Bearer <token>' header, so none
+// are accepted if 'token' is empty. They must also have a JSON Content-Type, which web
+// pages can't send to other sites without the browser asking first, see CORS.
+func NewWorkerHandler(run RunFunc, token string) http.Handler {
+	// busy is 1 while a run is in progress
+	var busy int32
+	mux := http.NewServeMux()
+	mux.HandleFunc(WorkerRunPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, fmt.Sprintf("method %s isn't allowed", r.Method), http.StatusMethodNotAllowed)
+			return
+		}
+		if token == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			log.Warn().Msgf("worker: rejected a run from %s with a missing or invalid token", r.RemoteAddr)
+			http.Error(w, "missing or invalid token", http.StatusUnauthorized)
+			return
+		}
+		if ct, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || ct != "application/json" {
+			log.Warn().Msgf("worker: rejected a run from %s with Content-Type %q", r.RemoteAddr, r.Header.Get("Content-Type"))
+			http.Error(w, "work requests must be application/json", http.StatusUnsupportedMediaType)
+			return
+		}
+		wr := WorkRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&wr); err != nil {
+			http.Error(w, fmt.Sprintf("invalid work request: %s", err), http.StatusBadRequest)
+			return
+		}
+
+		// Only one run at a time, concurrent runs would compete for the worker's resources
+		if !atomic.CompareAndSwapInt32(&busy, 0, 1) {
+			http.Error(w, "a run is already in progress", http.StatusConflict)
+			return
+		}
+		defer atomic.StoreInt32(&busy, 0)
+
+		log.Info().Msgf("worker: starting run from %s at %s", r.RemoteAddr, wr.StartAt.Format(time.StampMilli))
+		rr, err := run(wr.Config, wr.StartAt)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Info().Msgf("worker: run complete, %d requests", rr.RunSummary.RqstStats.TotalRqsts)
+
+		w.Header().Set("Content-Type", "application/json")
+		if err = json.NewEncoder(w).Encode(rr); err != nil {
+			log.Error().Err(err).Msg("worker: error sending run results")
+		}
+	})
+	return mux
+}
+
+// SplitConfig splits 'config' into 'n' configs, one per worker, that together generate
+// the load described by 'config'. The request rate, concurrency, and number of requests,
+// including those of each stage, are divided as evenly as possible. Thresholds are left to
+// the controller to evaluate against the merged results.
+func SplitConfig(config api.LoadTestConfig, n int) ([]api.LoadTestConfig, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("there must be at least 1 worker, not %d", n)
+	}
+	if config.RqstRate > 0 && config.RqstRate < n {
+		return nil, fmt.Errorf("RqstRate %d can't be split across %d workers", config.RqstRate, n)
+	}
+	if config.NumRequests > 0 && config.NumRequests < n {
+		return nil, fmt.Errorf("NumRequests %d can't be split across %d workers", config.NumRequests, n)
+	}
+	numEPs := len(config.Endpoints) + len(config.Scenarios)
+	if len(config.Stages) == 0 && config.MaxConcurrentRqsts < n*numEPs {
+		return nil, fmt.Errorf("MaxConcurrentRqsts %d can't be split across %d workers, each worker needs at least %d, one per endpoint and scenario",
+			config.MaxConcurrentRqsts, n, numEPs)
+	}
+	for i, s := range config.Stages {
+		if s.RqstRate > 0 && s.RqstRate < n {
+			return nil, fmt.Errorf("stage %d RqstRate %d can't be split across %d workers", i+1, s.RqstRate, n)
+		}
+		if s.MaxConcurrentRqsts < n {
+			return nil, fmt.Errorf("stage %d MaxConcurrentRqsts %d can't be split across %d workers", i+1, s.MaxConcurrentRqsts, n)
+		}
+	}
+
+	configs := make([]api.LoadTestConfig, 0, n)
+	for i := 0; i < n; i++ {
+		c := config
+		c.RqstRate = share(config.RqstRate, n, i)
+		c.MaxConcurrentRqsts = share(config.MaxConcurrentRqsts, n, i)
+		c.NumRequests = share(config.NumRequests, n, i)
+		c.Thresholds = nil
+		c.Stages = make([]api.Stage, 0, len(config.Stages))
+		for _, s := range config.Stages {
+			s.RqstRate = share(s.RqstRate, n, i)
+			s.MaxConcurrentRqsts = share(s.MaxConcurrentRqsts, n, i)
+			if s.FromRqstRate != nil {
+				fromRate := share(*s.FromRqstRate, n, i)
+				s.FromRqstRate = &fromRate
+			}
+			c.Stages = append(c.Stages, s)
+		}
+		configs = append(configs, c)
+	}
+	return configs, nil
+}
+
+// share returns worker 'i' of 'n's share of 'total'. Any remainder is spread across the
+// first workers.
+func share(total, n, i int) int {
+	s := total / n
+	if i < total%n {
+		s++
+	}
+	return s
+}
+
+// RunWorkers runs 'configs[i]' on 'workers[i]', the addresses (host:port) of workers, and
+// returns each worker's results. The workers' runs are scheduled to start together after
+// WorkerStartDelay. 'token' is the workers' shared token, it's required. 'timeout' limits
+// how long to wait for the workers' results, 0 means there's no limit.
+func RunWorkers(workers []string, configs []api.LoadTestConfig, token string, timeout time.Duration) ([]api.RunResults, error) {
+	if len(workers) != len(configs) {
+		return nil, fmt.Errorf("there are %d workers, but %d configurations", len(workers), len(configs))
+	}
+	if token == "" {
+		return nil, fmt.Errorf("a token is required to run on workers")
+	}
+
+	client := http.Client{Timeout: timeout}
+	startAt := time.Now().Add(WorkerStartDelay)
+	results := make([]api.RunResults, len(workers))
+	errs := make([]error, len(workers))
+
+	var wg sync.WaitGroup
+	for i := range workers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = runWorker(client, workers[i], token, WorkRequest{Config: configs[i], StartAt: startAt})
+		}(i)
+	}
+	wg.Wait()
+
+	var msgs []string
+	for i, err := range errs {
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("worker %s: %s", workers[i], err))
+		}
+	}
+	if len(msgs) > 0 {
+		return nil, fmt.Errorf("%d of %d workers failed: %s", len(msgs), len(workers), strings.Join(msgs, "; "))
+	}
+	return results, nil
+}
+
+// runWorker sends 'wr' to 'worker', authenticated by 'token', and returns the results of its run
+func runWorker(client http.Client, worker string, token string, wr WorkRequest) (api.RunResults, error) {
+	body, err := json.Marshal(wr)
+	if err != nil {
+		return api.RunResults{}, fmt.Errorf("error marshaling work request: %w", err)
+	}
+
+	url := worker
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		url = "http://" + url
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(url, "/")+WorkerRunPath, bytes.NewReader(body))
+	if err != nil {
+		return api.RunResults{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := client.Do(req)
+	if err != nil {
+		return api.RunResults{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return api.RunResults{}, fmt.Errorf("run failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	contents, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return api.RunResults{}, fmt.Errorf("error reading run results: %w", err)
+	}
+	return decodeRunResults(contents)
+}
@@ -0,0 +1,284 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+func TestSplitConfig(t *testing.T) {
+	eps := []api.Endpoint{{URL: "http://someurl/users", Method: http.MethodGet, RqstPercent: 100}}
+
+	tests := []struct {
+		name          string
+		config        api.LoadTestConfig
+		numWorkers    int
+		expectErr     bool
+		expectedRates []int
+		expectedConc  []int
+		expectedNum   []int
+		expectedStage []api.Stage
+	}{
+		{
+			name:          "EvenSplit",
+			config:        api.LoadTestConfig{RqstRate: 100, MaxConcurrentRqsts: 10, RunDuration: "10s", Endpoints: eps},
+			numWorkers:    2,
+			expectedRates: []int{50, 50},
+			expectedConc:  []int{5, 5},
+			expectedNum:   []int{0, 0},
+		},
+		{
+			name:          "Remainder",
+			config:        api.LoadTestConfig{RqstRate: 100, MaxConcurrentRqsts: 10, NumRequests: 1000, Endpoints: eps},
+			numWorkers:    3,
+			expectedRates: []int{34, 33, 33},
+			expectedConc:  []int{4, 3, 3},
+			expectedNum:   []int{334, 333, 333},
+		},
+		{
+			name: "Stages",
+			config: api.LoadTestConfig{Endpoints: eps, Stages: []api.Stage{
+				{Duration: "10s", FromRqstRate: intPtr(3), RqstRate: 11, MaxConcurrentRqsts: 4},
+			}},
+			numWorkers:    2,
+			expectedRates: []int{0, 0},
+			expectedConc:  []int{0, 0},
+			expectedNum:   []int{0, 0},
+			expectedStage: []api.Stage{
+				{Duration: "10s", FromRqstRate: intPtr(2), RqstRate: 6, MaxConcurrentRqsts: 2},
+				{Duration: "10s", FromRqstRate: intPtr(1), RqstRate: 5, MaxConcurrentRqsts: 2},
+			},
+		},
+		{
+			name:       "NoWorkers",
+			config:     api.LoadTestConfig{RqstRate: 100, MaxConcurrentRqsts: 10, Endpoints: eps},
+			numWorkers: 0,
+			expectErr:  true,
+		},
+		{
+			name:       "RateTooLow",
+			config:     api.LoadTestConfig{RqstRate: 2, MaxConcurrentRqsts: 10, Endpoints: eps},
+			numWorkers: 3,
+			expectErr:  true,
+		},
+		{
+			name:       "ConcurrencyTooLow",
+			config:     api.LoadTestConfig{RqstRate: 100, MaxConcurrentRqsts: 2, Endpoints: eps},
+			numWorkers: 3,
+			expectErr:  true,
+		},
+		{
+			name: "StageConcurrencyTooLow",
+			config: api.LoadTestConfig{Endpoints: eps, Stages: []api.Stage{
+				{Duration: "10s", RqstRate: 100, MaxConcurrentRqsts: 1},
+			}},
+			numWorkers: 2,
+			expectErr:  true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.config.Thresholds = []api.Threshold{{Metric: "P99", Max: "100ms"}}
+			configs, err := SplitConfig(tc.config, tc.numWorkers)
+			if tc.expectErr {
+				if err == nil {
+					t.Errorf("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if len(configs) != tc.numWorkers {
+				t.Fatalf("expected %d configs, got %d", tc.numWorkers, len(configs))
+			}
+			for i, c := range configs {
+				if c.RqstRate != tc.expectedRates[i] || c.MaxConcurrentRqsts != tc.expectedConc[i] || c.NumRequests != tc.expectedNum[i] {
+					t.Errorf("worker %d: expected rate %d, concurrency %d, and %d requests, got %d, %d, and %d", i,
+						tc.expectedRates[i], tc.expectedConc[i], tc.expectedNum[i], c.RqstRate, c.MaxConcurrentRqsts, c.NumRequests)
+				}
+				if len(c.Thresholds) != 0 {
+					t.Errorf("worker %d: expected no thresholds, got %d", i, len(c.Thresholds))
+				}
+				if tc.expectedStage != nil && (len(c.Stages) != 1 || !reflect.DeepEqual(c.Stages[0], tc.expectedStage[i])) {
+					t.Errorf("worker %d: expected stages %+v, got %+v", i, tc.expectedStage[i:i+1], c.Stages)
+				}
+			}
+		})
+	}
+}
+
+func TestRunWorkers(t *testing.T) {
+	url := "http://someurl/users"
+	startAtC := make(chan time.Time, 2)
+	run := func(config api.LoadTestConfig, startAt time.Time) (api.RunResults, error) {
+		if config.RqstRate == 0 {
+			return api.RunResults{}, errors.New("RqstRate must be greater than 0")
+		}
+		startAtC <- startAt
+		return newCompareResults(t, url, config.RqstRate*10, 0, 10*time.Millisecond), nil
+	}
+
+	w1 := httptest.NewServer(NewWorkerHandler(run, "secret"))
+	defer w1.Close()
+	w2 := httptest.NewServer(NewWorkerHandler(run, "secret"))
+	defer w2.Close()
+	workers := []string{w1.URL, strings.TrimPrefix(w2.URL, "http://")}
+
+	configs, err := SplitConfig(api.LoadTestConfig{RqstRate: 30, MaxConcurrentRqsts: 2, RunDuration: "10s",
+		Endpoints: []api.Endpoint{{URL: url, Method: http.MethodGet, RqstPercent: 100}}}, len(workers))
+	if err != nil {
+		t.Fatalf("unexpected error splitting config: %s", err)
+	}
+	results, err := RunWorkers(workers, configs, "secret", 0)
+	if err != nil {
+		t.Fatalf("unexpected error running workers: %s", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+	if results[0].RunSummary.RqstStats.TotalRqsts != 150 || results[1].RunSummary.RqstStats.TotalRqsts != 150 {
+		t.Errorf("expected 150 requests from each worker, got %d and %d", results[0].RunSummary.RqstStats.TotalRqsts,
+			results[1].RunSummary.RqstStats.TotalRqsts)
+	}
+	// The histograms must survive the trip from the workers
+	if count := results[0].RunSummary.RqstStats.TimingResultsNanos.Count(); count != 150 {
+		t.Errorf("expected 150 latencies, got %d", count)
+	}
+	startAt1, startAt2 := <-startAtC, <-startAtC
+	if !startAt1.Equal(startAt2) {
+		t.Errorf("expected the workers to start together, got %s and %s", startAt1, startAt2)
+	}
+
+	// A failed worker fails the run
+	configs[1].RqstRate = 0
+	if _, err = RunWorkers(workers, configs, "secret", 0); err == nil || !strings.Contains(err.Error(), "1 of 2 workers failed") {
+		t.Errorf("expected 1 of 2 workers to fail, got %v", err)
+	}
+	<-startAtC
+
+	// Mismatched workers and configs
+	if _, err = RunWorkers(workers, configs[:1], "secret", 0); err == nil {
+		t.Errorf("expected an error for mismatched workers and configs")
+	}
+}
+
+func TestWorkerHandler(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	run := func(config api.LoadTestConfig, startAt time.Time) (api.RunResults, error) {
+		close(started)
+		<-release
+		return api.RunResults{}, nil
+	}
+	w := httptest.NewServer(NewWorkerHandler(run, "secret"))
+	defer w.Close()
+	post := func(contentType, body string) (*http.Response, error) {
+		req, err := http.NewRequest(http.MethodPost, w.URL+WorkerRunPath, strings.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Authorization", "Bearer secret")
+		return http.DefaultClient.Do(req)
+	}
+
+	resp, err := http.Get(w.URL + WorkerRunPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected status %d for a GET, got %d", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+
+	resp, err = post("application/json", "{")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status %d for an invalid request, got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+
+	// Web pages can send text/plain requests to other sites without the browser asking
+	// the site first
+	resp, err = post("text/plain", "{}")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("expected status %d for a text/plain request, got %d", http.StatusUnsupportedMediaType, resp.StatusCode)
+	}
+
+	// Only one run at a time is allowed
+	doneC := make(chan int)
+	go func() {
+		resp, err := post("application/json", "{}")
+		if err != nil {
+			doneC <- 0
+			return
+		}
+		resp.Body.Close()
+		doneC <- resp.StatusCode
+	}()
+	<-started
+	resp, err = post("application/json", "{}")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("expected status %d for a concurrent run, got %d", http.StatusConflict, resp.StatusCode)
+	}
+	close(release)
+	if status := <-doneC; status != http.StatusOK {
+		t.Errorf("expected status %d for the first run, got %d", http.StatusOK, status)
+	}
+}
+
+func TestWorkerToken(t *testing.T) {
+	run := func(config api.LoadTestConfig, startAt time.Time) (api.RunResults, error) {
+		return newCompareResults(t, "http://someurl/users", 10, 0, 10*time.Millisecond), nil
+	}
+	w := httptest.NewServer(NewWorkerHandler(run, "secret"))
+	defer w.Close()
+	configs := []api.LoadTestConfig{{RqstRate: 1}}
+
+	if _, err := RunWorkers([]string{w.URL}, configs, "secret", 0); err != nil {
+		t.Errorf("unexpected error running a worker with the right token: %s", err)
+	}
+	if _, err := RunWorkers([]string{w.URL}, configs, "wrong", 0); err == nil || !strings.Contains(err.Error(), "status 401") {
+		t.Errorf("expected status 401 for the wrong token, got %v", err)
+	}
+	resp, err := http.Post(w.URL+WorkerRunPath, "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected status %d without a token, got %d", http.StatusUnauthorized, resp.StatusCode)
+	}
+
+	// The controller always requires a token, as do workers
+	if _, err := RunWorkers([]string{w.URL}, configs, "", 0); err == nil || !strings.Contains(err.Error(), "token") {
+		t.Errorf("expected a token to be required, got %v", err)
+	}
+	noToken := httptest.NewServer(NewWorkerHandler(run, ""))
+	defer noToken.Close()
+	if _, err := RunWorkers([]string{noToken.URL}, configs, "secret", 0); err == nil || !strings.Contains(err.Error(), "status 401") {
+		t.Errorf("expected status 401 from a worker without a token, got %v", err)
+	}
+}
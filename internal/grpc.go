@@ -0,0 +1,469 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/youngkin/heyyall/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// grpcStatusOK is the status of gRPC calls that return OK. It's the HTTP status gRPC
+// responses are sent with, gRPC's OK status code, 0, is the status of failed requests.
+const grpcStatusOK = http.StatusOK
+
+// grpcEndpoint is the gRPC method called by an Endpoint along with the connection to
+// the server it's called on
+type grpcEndpoint struct {
+	conn *grpc.ClientConn
+	// name is the method's name as it's sent in requests, i.e., "/package.Service/Method"
+	name   string
+	method protoreflect.MethodDescriptor
+}
+
+// parseGRPCMethod splits the full method name 'name', e.g., "package.Service/Method",
+// into its service and method names
+func parseGRPCMethod(name string) (service, method string, err error) {
+	parts := strings.Split(strings.TrimPrefix(name, "/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("GRPC.Method %s is invalid, it must be of the form package.Service/Method", name)
+	}
+	return parts[0], parts[1], nil
+}
+
+// grpcTarget returns the address of the server 'rawURL' refers to and whether TLS is
+// used to connect to it
+func grpcTarget(rawURL string) (target string, useTLS bool, err error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", false, fmt.Errorf("URL %s is invalid: %w", rawURL, err)
+	}
+	port := "80"
+	switch u.Scheme {
+	case "http":
+	case "https":
+		port, useTLS = "443", true
+	default:
+		return "", false, fmt.Errorf("URL %s is invalid, gRPC endpoint URLs must be http://host:port or https://host:port", rawURL)
+	}
+	if u.Host == "" || (u.Path != "" && u.Path != "/") {
+		return "", false, fmt.Errorf("URL %s is invalid, gRPC endpoint URLs must be http://host:port or https://host:port", rawURL)
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	return net.JoinHostPort(u.Hostname(), port), useTLS, nil
+}
+
+// validateGRPC returns an error if the gRPC method called by 'ep' can't be. Methods that
+// are described by the server can't be checked until the server is connected to.
+func validateGRPC(ep api.Endpoint) error {
+	if _, _, err := grpcTarget(ep.URL); err != nil {
+		return err
+	}
+	service, method, err := parseGRPCMethod(ep.GRPC.Method)
+	if err != nil {
+		return err
+	}
+	if ep.GRPC.DescriptorSetFile == "" {
+		return nil
+	}
+	files, err := loadDescriptorSet(ep.GRPC.DescriptorSetFile)
+	if err != nil {
+		return err
+	}
+	_, err = findGRPCMethod(files, service, method)
+	return err
+}
+
+// loadDescriptorSet returns the files described by the FileDescriptorSet in 'fileName'
+func loadDescriptorSet(fileName string) (*protoregistry.Files, error) {
+	contents, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read GRPC.DescriptorSetFile %s: %w", fileName, err)
+	}
+	fds := &descriptorpb.FileDescriptorSet{}
+	if err = proto.Unmarshal(contents, fds); err != nil {
+		return nil, fmt.Errorf("GRPC.DescriptorSetFile %s doesn't contain a FileDescriptorSet: %w", fileName, err)
+	}
+	files, err := protodesc.NewFiles(fds)
+	if err != nil {
+		return nil, fmt.Errorf("GRPC.DescriptorSetFile %s is invalid: %w", fileName, err)
+	}
+	return files, nil
+}
+
+// reflectDescriptors returns the files that describe 'service', and the files they
+// depend on, as described by the server 'conn' is connected to via server reflection
+func reflectDescriptors(ctx context.Context, conn *grpc.ClientConn, service string) (*protoregistry.Files, error) {
+	// v1alpha is the version of the reflection service that's most widely supported
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("server reflection: %w", err)
+	}
+	defer stream.CloseSend()
+
+	fdps := make(map[string]*descriptorpb.FileDescriptorProto)
+	pending := []*reflectionpb.ServerReflectionRequest{
+		{MessageRequest: &reflectionpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: service}},
+	}
+	for len(pending) > 0 {
+		if err = stream.Send(pending[0]); err != nil {
+			return nil, fmt.Errorf("server reflection: %w", err)
+		}
+		pending = pending[1:]
+		resp, err := stream.Recv()
+		if err != nil {
+			return nil, fmt.Errorf("server reflection: %w", err)
+		}
+		if errResp := resp.GetErrorResponse(); errResp != nil {
+			return nil, fmt.Errorf("server reflection: unable to describe %s: %s", service, errResp.GetErrorMessage())
+		}
+
+		for _, b := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			fdp := &descriptorpb.FileDescriptorProto{}
+			if err = proto.Unmarshal(b, fdp); err != nil {
+				return nil, fmt.Errorf("server reflection: %w", err)
+			}
+			if _, ok := fdps[fdp.GetName()]; ok {
+				continue
+			}
+			fdps[fdp.GetName()] = fdp
+			// The server may not include the files 'fdp' depends on
+			for _, dep := range fdp.GetDependency() {
+				if _, ok := fdps[dep]; !ok {
+					pending = append(pending, &reflectionpb.ServerReflectionRequest{
+						MessageRequest: &reflectionpb.ServerReflectionRequest_FileByFilename{FileByFilename: dep},
+					})
+				}
+			}
+		}
+	}
+
+	fds := &descriptorpb.FileDescriptorSet{}
+	for _, fdp := range fdps {
+		fds.File = append(fds.File, fdp)
+	}
+	files, err := protodesc.NewFiles(fds)
+	if err != nil {
+		return nil, fmt.Errorf("server reflection: %w", err)
+	}
+	return files, nil
+}
+
+// findGRPCMethod returns the descriptor of 'method' of 'service' from 'files'
+func findGRPCMethod(files *protoregistry.Files, service, method string) (protoreflect.MethodDescriptor, error) {
+	d, err := files.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil, fmt.Errorf("service %s wasn't found: %w", service, err)
+	}
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s isn't a service", service)
+	}
+	md := sd.Methods().ByName(protoreflect.Name(method))
+	if md == nil {
+		return nil, fmt.Errorf("service %s doesn't have a method named %s", service, method)
+	}
+	if md.IsStreamingClient() {
+		return nil, fmt.Errorf("method %s/%s is a client streaming method, only unary and server streaming methods are supported",
+			service, method)
+	}
+	return md, nil
+}
+
+// newGRPCEndpoint connects to the server 'ep' refers to, using 'tlsConfig' if the
+// connection uses TLS, and looks up the method 'ep' calls
+func newGRPCEndpoint(ctx context.Context, ep api.Endpoint, tlsConfig *tls.Config) (*grpcEndpoint, error) {
+	target, useTLS, err := grpcTarget(ep.URL)
+	if err != nil {
+		return nil, err
+	}
+	service, method, err := parseGRPCMethod(ep.GRPC.Method)
+	if err != nil {
+		return nil, err
+	}
+
+	creds := insecure.NewCredentials()
+	if useTLS {
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	conn, err := grpc.NewClient(target, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to %s: %w", target, err)
+	}
+
+	var files *protoregistry.Files
+	if ep.GRPC.DescriptorSetFile != "" {
+		files, err = loadDescriptorSet(ep.GRPC.DescriptorSetFile)
+	} else {
+		files, err = reflectDescriptors(ctx, conn, service)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	md, err := findGRPCMethod(files, service, method)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &grpcEndpoint{conn: conn, name: "/" + service + "/" + method, method: md}, nil
+}
+
+// close closes the connection to the server
+func (g *grpcEndpoint) close() {
+	if err := g.conn.Close(); err != nil {
+		log.Debug().Err(err).Msgf("error closing the connection to %s", g.conn.Target())
+	}
+}
+
+// call calls the method with the request message whose JSON form is 'body', and with
+// 'headers' as the request metadata. It returns the response metadata and the JSON form
+// of the response message. The responses of server streaming methods are returned as a
+// JSON array of messages. 'err' is the call's status if it didn't return OK.
+func (g *grpcEndpoint) call(ctx context.Context, body string, headers map[string]string) (
+	header http.Header, respBody []byte, err error) {
+
+	req := dynamicpb.NewMessage(g.method.Input())
+	if strings.TrimSpace(body) != "" {
+		if err = protojson.Unmarshal([]byte(body), req); err != nil {
+			return nil, nil, fmt.Errorf("RqstBody isn't a valid %s message: %w", g.method.Input().FullName(), err)
+		}
+	}
+	for name, val := range headers {
+		ctx = metadata.AppendToOutgoingContext(ctx, strings.ToLower(name), val)
+	}
+
+	var md metadata.MD
+	var resps []proto.Message
+	if g.method.IsStreamingServer() {
+		md, resps, err = g.recvStream(ctx, req)
+	} else {
+		resp := dynamicpb.NewMessage(g.method.Output())
+		err = g.conn.Invoke(ctx, g.name, req, resp, grpc.Header(&md))
+		resps = append(resps, resp)
+	}
+	if err != nil {
+		return metadataHeader(md), nil, err
+	}
+
+	if g.method.IsStreamingServer() {
+		respBody = append(respBody, '[')
+	}
+	for i, resp := range resps {
+		b, err := protojson.Marshal(resp)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to convert the %s response to JSON: %w", g.method.Output().FullName(), err)
+		}
+		if i > 0 {
+			respBody = append(respBody, ',')
+		}
+		respBody = append(respBody, b...)
+	}
+	if g.method.IsStreamingServer() {
+		respBody = append(respBody, ']')
+	}
+	return metadataHeader(md), respBody, nil
+}
+
+// classifyGRPCError returns the api.Err* that describes 'err', the error returned by a
+// call made with 'ctx'. Calls that didn't return OK are classified by their status code,
+// e.g., "grpc_Unavailable", unless they timed out.
+func classifyGRPCError(ctx context.Context, err error) string {
+	if ctx.Err() != nil {
+		return classifyError(ctx.Err())
+	}
+	if st, ok := status.FromError(err); ok {
+		return api.ErrGRPCPrefix + st.Code().String()
+	}
+	return api.ErrOther
+}
+
+// metadataHeader returns 'md' as a header. Metadata keys are lower case, header names
+// are canonicalized so that they can be looked up, and asserted on, as HTTP headers are.
+func metadataHeader(md metadata.MD) http.Header {
+	header := make(http.Header, len(md))
+	for name, vals := range md {
+		for _, val := range vals {
+			header.Add(name, val)
+		}
+	}
+	return header
+}
+
+// recvStream calls the server streaming method with 'req' and returns all the messages
+// the server sends
+func (g *grpcEndpoint) recvStream(ctx context.Context, req proto.Message) (metadata.MD, []proto.Message, error) {
+	// Cancelling the stream's context releases its resources if it ends early
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := g.conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, g.name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = stream.SendMsg(req); err != nil && !errors.Is(err, io.EOF) {
+		return nil, nil, err
+	}
+	if err = stream.CloseSend(); err != nil {
+		return nil, nil, err
+	}
+
+	var resps []proto.Message
+	for {
+		resp := dynamicpb.NewMessage(g.method.Output())
+		err = stream.RecvMsg(resp)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			md, _ := stream.Header()
+			return md, nil, err
+		}
+		resps = append(resps, resp)
+	}
+	md, err := stream.Header()
+	return md, resps, err
+}
+
+// processGRPC is ProcessRqst for endpoints that call a gRPC method
+func (r Requestor) processGRPC(ep api.Endpoint, pacer Pacer) {
+	rows := newDataRows(r.DataSources, newRand())
+	tmplts, err := newRqstTemplates(ep, r.Sequences.counter(endpointKey(ep)), rows)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Requestor - endpoint %s has an invalid template", ep.URL)
+		return
+	}
+	asserts, err := newAssertions(ep.Assertions)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Requestor - endpoint %s has invalid Assertions", ep.URL)
+		return
+	}
+
+	// The gRPC client uses the endpoint's TLS configuration and request timeout
+	client, release, err := r.clientFor(ep)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Requestor - endpoint %s has an invalid client configuration", ep.URL)
+		return
+	}
+	defer release()
+	g, ok := r.Clients.grpcEndpoint(ep)
+	if !ok {
+		g, err = newGRPCEndpoint(r.Ctx, ep, clientTLSConfig(client))
+		if err != nil {
+			log.Warn().Err(err).Msgf("Requestor - endpoint %s, unable to call gRPC method %s", ep.URL, ep.GRPC.Method)
+			return
+		}
+		defer g.close()
+	}
+
+	var maxBodyRead int64
+	if asserts != nil && asserts.needsBody() {
+		maxBodyRead = asserts.maxBodyRead()
+	}
+
+	for {
+		due, stage, ok := pacer.Next(r.Ctx)
+		if !ok {
+			return
+		}
+
+		rows.next()
+		tmplts.next()
+		_, body, headers, err := tmplts.render(nil)
+		if err != nil && rows.exhausted {
+			log.Debug().Err(err).Msgf("Requestor: endpoint %s has run out of data, exiting", ep.URL)
+			return
+		}
+		if err != nil {
+			log.Warn().Err(err).Msgf("Requestor unable to create gRPC request, dropping remaining requests")
+			return
+		}
+
+		response, respBody, ok := r.sendGRPC(g, client.Timeout, body, headers, ep, due, stage)
+		if !ok {
+			r.sendCancelled(response)
+			return
+		}
+		if response.ErrorType == "" && asserts != nil {
+			response.Asserted = true
+			bodySize := int64(len(respBody))
+			if maxBodyRead > 0 && bodySize > maxBodyRead {
+				respBody = respBody[:maxBodyRead]
+			}
+			response.AssertionFailures = asserts.check(response.HTTPStatus, response.Header, respBody, bodySize)
+		}
+
+		if !r.sendResponse(response) {
+			return
+		}
+	}
+}
+
+// sendGRPC calls the method of 'g', for 'ep', with the request message 'body' and the
+// request metadata 'headers'. The call was due at 'due' during 'stage' and can take up
+// to 'timeout'. It returns the Response describing the call's outcome and the JSON form
+// of the response message(s). 'ok' is false if the Requestor was cancelled, or the run
+// duration expired, before the call completed, see interrupted().
+func (r Requestor) sendGRPC(g *grpcEndpoint, timeout time.Duration, body string, headers map[string]string,
+	ep api.Endpoint, due time.Time, stage int) (response Response, respBody []byte, ok bool) {
+
+	ctx := r.Ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	late := time.Since(due) > lateRqstThreshold
+	r.Metrics.rqstStarted()
+	header, respBody, err := g.call(ctx, body, headers)
+	r.Metrics.rqstDone()
+
+	response = Response{
+		HTTPStatus:      grpcStatusOK,
+		Endpoint:        api.Endpoint{URL: ep.URL, Method: ep.GRPC.Method},
+		Stage:           stage,
+		Late:            late,
+		Header:          header,
+		RequestDuration: time.Since(due),
+	}
+	if r.Ctx.Err() != nil {
+		return r.interrupted(response), nil, false
+	}
+	if err != nil {
+		log.Debug().Err(err).Msgf("Requestor: error calling %s", g.name)
+		response.HTTPStatus = 0
+		response.ErrorType = classifyGRPCError(ctx, err)
+		response.Error = err.Error()
+	}
+	return response, bytes.TrimSpace(respBody), true
+}
@@ -0,0 +1,255 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/youngkin/heyyall/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// testHealthServer is a health service whose Check method reports the status of any
+// service other than "unknown" as SERVING, and whose Watch method sends 3 statuses
+type testHealthServer struct {
+	grpc_health_v1.UnimplementedHealthServer
+}
+
+func (testHealthServer) Check(ctx context.Context, req *grpc_health_v1.HealthCheckRequest) (
+	*grpc_health_v1.HealthCheckResponse, error) {
+	if req.Service == "unknown" {
+		return nil, status.Error(codes.NotFound, "unknown service")
+	}
+	// The request metadata is echoed back to the client
+	md, _ := metadata.FromIncomingContext(ctx)
+	if v := md.Get("x-test"); len(v) > 0 {
+		grpc.SetHeader(ctx, metadata.Pairs("x-test", v[0]))
+	}
+	return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}, nil
+}
+
+func (testHealthServer) Watch(req *grpc_health_v1.HealthCheckRequest, stream grpc_health_v1.Health_WatchServer) error {
+	for i := 0; i < 3; i++ {
+		if err := stream.Send(&grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TestGRPC verifies that gRPC methods described by a descriptor set or via server
+// reflection are called, and their outcomes reported
+func TestGRPC(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %s", err)
+	}
+	srv := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(srv, testHealthServer{})
+	reflection.Register(srv)
+	go srv.Serve(lis)
+	defer srv.Stop()
+	srvURL := "http://" + lis.Addr().String()
+
+	dir, err := ioutil.TempDir("", "heyyall")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	fds := &descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{protodesc.ToFileDescriptorProto(grpc_health_v1.File_grpc_health_v1_health_proto)},
+	}
+	contents, err := proto.Marshal(fds)
+	if err != nil {
+		t.Fatalf("unable to marshal descriptor set: %s", err)
+	}
+	descFile := filepath.Join(dir, "health.pb")
+	if err = ioutil.WriteFile(descFile, contents, 0600); err != nil {
+		t.Fatalf("unable to write descriptor set: %s", err)
+	}
+
+	const method = "grpc.health.v1.Health/Check"
+	tests := []struct {
+		name string
+		ep   api.Endpoint
+		// expectedStatus is the expected status of calls that return OK
+		expectedStatus int
+		// expectedErr, if not empty, is the expected ErrorType of the calls
+		expectedErr    string
+		expectedHeader string
+		assertFailed   bool
+		// setupFails is true if the endpoint's method can't be called, which fails the run
+		setupFails bool
+	}{
+		{
+			name:           "DescriptorSet",
+			ep:             api.Endpoint{URL: srvURL, GRPC: &api.GRPC{Method: method, DescriptorSetFile: descFile}},
+			expectedStatus: grpcStatusOK,
+		},
+		{
+			name:           "Reflection",
+			ep:             api.Endpoint{URL: srvURL, GRPC: &api.GRPC{Method: "/" + method}},
+			expectedStatus: grpcStatusOK,
+		},
+		{
+			name: "Metadata",
+			ep: api.Endpoint{URL: srvURL, GRPC: &api.GRPC{Method: method}, RqstBody: `{"service": "{{ seq }}"}`,
+				Headers: map[string]string{"X-Test": "abc"}},
+			expectedStatus: grpcStatusOK,
+			expectedHeader: "abc",
+		},
+		{
+			name:        "NotFound",
+			ep:          api.Endpoint{URL: srvURL, GRPC: &api.GRPC{Method: method}, RqstBody: `{"service": "unknown"}`},
+			expectedErr: api.ErrGRPCPrefix + codes.NotFound.String(),
+		},
+		{
+			name:           "ServerStreaming",
+			ep:             api.Endpoint{URL: srvURL, GRPC: &api.GRPC{Method: "grpc.health.v1.Health/Watch"}},
+			expectedStatus: grpcStatusOK,
+		},
+		{
+			name: "Assertions",
+			ep: api.Endpoint{URL: srvURL, GRPC: &api.GRPC{Method: method}, Assertions: &api.Assertions{
+				Status:   []string{"200"},
+				JSONPath: map[string]interface{}{"$.status": "SERVING"},
+			}},
+			expectedStatus: grpcStatusOK,
+		},
+		{
+			name: "FailedAssertions",
+			ep: api.Endpoint{URL: srvURL, GRPC: &api.GRPC{Method: "grpc.health.v1.Health/Watch"}, Assertions: &api.Assertions{
+				JSONPath: map[string]interface{}{"$[0].status": "NOT_SERVING"},
+			}},
+			expectedStatus: grpcStatusOK,
+			assertFailed:   true,
+		},
+		{
+			name:        "InvalidRqstBody",
+			ep:          api.Endpoint{URL: srvURL, GRPC: &api.GRPC{Method: method}, RqstBody: `{"svc": "x"}`},
+			expectedErr: api.ErrOther,
+		},
+		{
+			name:       "UnknownMethod",
+			ep:         api.Endpoint{URL: srvURL, GRPC: &api.GRPC{Method: "grpc.health.v1.Health/Ping"}},
+			setupFails: true,
+		},
+		{
+			name:       "UnknownService",
+			ep:         api.Endpoint{URL: srvURL, GRPC: &api.GRPC{Method: "grpc.health.v1.Sickness/Check"}},
+			setupFails: true,
+		},
+	}
+
+	const concurrency, numRqsts = 2, 3
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			clients, err := NewClients(context.Background(), http.Client{}, api.Transport{}, []api.Endpoint{tc.ep}, nil)
+			if tc.setupFails {
+				if err == nil {
+					t.Errorf("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			defer clients.Close()
+
+			// Concurrent calls share the endpoint's connection
+			respC := make(chan Response, concurrency*numRqsts)
+			rqstr := Requestor{
+				Ctx:       context.Background(),
+				ResponseC: respC,
+				Clients:   clients,
+			}
+			var wg sync.WaitGroup
+			for i := 0; i < concurrency; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					rqstr.ProcessRqst(tc.ep, newRatePacer(numRqsts, 0, constantArrival{}))
+				}()
+			}
+			wg.Wait()
+			close(respC)
+
+			numResps := 0
+			for resp := range respC {
+				numResps++
+				if tc.expectedErr != "" {
+					if resp.ErrorType != tc.expectedErr || resp.HTTPStatus != 0 {
+						t.Errorf("expected a %s error, got %+v", tc.expectedErr, resp)
+					}
+					if tc.name == "InvalidRqstBody" && !strings.Contains(resp.Error, "RqstBody") {
+						t.Errorf("expected an invalid RqstBody error, got %+v", resp)
+					}
+					continue
+				}
+				if resp.ErrorType != "" || resp.HTTPStatus != tc.expectedStatus {
+					t.Errorf("expected status %d, got %+v", tc.expectedStatus, resp)
+				}
+				if resp.Endpoint.Method != tc.ep.GRPC.Method || resp.Endpoint.URL != tc.ep.URL {
+					t.Errorf("expected the response to be for %s %s, got %+v", tc.ep.URL, tc.ep.GRPC.Method, resp.Endpoint)
+				}
+				if tc.expectedHeader != "" && resp.Header.Get("x-test") != tc.expectedHeader {
+					t.Errorf("expected the x-test header to be %s, got %v", tc.expectedHeader, resp.Header)
+				}
+				if tc.ep.Assertions != nil && (!resp.Asserted || (len(resp.AssertionFailures) > 0) != tc.assertFailed) {
+					t.Errorf("expected assertions to fail to be %t, got %+v", tc.assertFailed, resp)
+				}
+			}
+			if numResps != concurrency*numRqsts {
+				t.Errorf("expected %d responses, got %d", concurrency*numRqsts, numResps)
+			}
+		})
+	}
+}
+
+func TestValidateGRPC(t *testing.T) {
+	tests := []struct {
+		name      string
+		ep        api.Endpoint
+		expectErr bool
+	}{
+		{name: "Valid", ep: api.Endpoint{URL: "http://localhost:50051", GRPC: &api.GRPC{Method: "pkg.Service/Method"}}},
+		{name: "ValidTLS", ep: api.Endpoint{URL: "https://localhost", GRPC: &api.GRPC{Method: "/pkg.Service/Method"}}},
+		{name: "InvalidScheme", ep: api.Endpoint{URL: "grpc://localhost:50051", GRPC: &api.GRPC{Method: "pkg.Service/Method"}},
+			expectErr: true},
+		{name: "WebSocketURL", ep: api.Endpoint{URL: "ws://localhost/chat", GRPC: &api.GRPC{Method: "pkg.Service/Method"}},
+			expectErr: true},
+		{name: "URLPath", ep: api.Endpoint{URL: "http://localhost:50051/pkg", GRPC: &api.GRPC{Method: "pkg.Service/Method"}},
+			expectErr: true},
+		{name: "NoMethod", ep: api.Endpoint{URL: "http://localhost:50051", GRPC: &api.GRPC{Method: "pkg.Service"}},
+			expectErr: true},
+		{name: "MissingDescriptorSet", ep: api.Endpoint{URL: "http://localhost:50051",
+			GRPC: &api.GRPC{Method: "pkg.Service/Method", DescriptorSetFile: "missing.pb"}}, expectErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateGRPC(tc.ep)
+			if tc.expectErr != (err != nil) {
+				t.Errorf("expected an error to be %t, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
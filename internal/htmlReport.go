@@ -0,0 +1,376 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// Chart dimensions, in pixels
+const (
+	chartWidth        = 800
+	chartHeight       = 260
+	chartMarginLeft   = 70
+	chartMarginRight  = 110
+	chartMarginTop    = 20
+	chartMarginBottom = 40
+)
+
+// svgBar is a single bar of a bar chart
+type svgBar struct {
+	X, Y, Width, Height float64
+	// Title is shown when hovering over the bar
+	Title string
+}
+
+// svgLine is a single series of a line chart
+type svgLine struct {
+	Name   string
+	Color  string
+	Points string
+	// LegendY is the vertical position of the line's legend entry
+	LegendY float64
+}
+
+// svgLabel is an x axis label
+type svgLabel struct {
+	X    float64
+	Text string
+}
+
+// svgChart describes a bar or line chart to be drawn as inline SVG
+type svgChart struct {
+	Width, Height            float64
+	Left, Right, Top, Bottom float64
+	// YMax labels the top of the y axis
+	YMax    string
+	YLabel  string
+	XLabel  string
+	XLabels []svgLabel
+	Bars    []svgBar
+	Lines   []svgLine
+}
+
+// htmlReport is the data rendered by htmlReportTmplt
+type htmlReport struct {
+	api.RunResults
+	GeneratedAt    string
+	HasAssertions  bool
+	HasConnDetails bool
+	HasWebSocket   bool
+	Histogram      *svgChart
+	Throughput     *svgChart
+	Latency        *svgChart
+}
+
+// newSVGChart returns an empty chart with the standard dimensions
+func newSVGChart(xLabel, yLabel string) *svgChart {
+	return &svgChart{
+		Width:  chartWidth,
+		Height: chartHeight,
+		Left:   chartMarginLeft,
+		Right:  chartWidth - chartMarginRight,
+		Top:    chartMarginTop,
+		Bottom: chartHeight - chartMarginBottom,
+		XLabel: xLabel,
+		YLabel: yLabel,
+	}
+}
+
+// histogramChart returns a bar chart of the latency histogram built by generateHistogram().
+// It returns nil if there's nothing to chart.
+func (rh *ResponseHandler) histogramChart() *svgChart {
+	keys := rh.histogramKeys()
+	maxCount := 0
+	for _, k := range keys {
+		if rh.histogram[k] > maxCount {
+			maxCount = rh.histogram[k]
+		}
+	}
+	if len(keys) == 0 || maxCount == 0 {
+		return nil
+	}
+
+	c := newSVGChart("Latency (secs)", "Observations")
+	c.YMax = fmt.Sprintf("%d", maxCount)
+	barWidth := (c.Right - c.Left) / float64(len(keys))
+	for i, k := range keys {
+		cnt := rh.histogram[k]
+		height := float64(cnt) / float64(maxCount) * (c.Bottom - c.Top)
+		x := c.Left + float64(i)*barWidth
+		label := fmt.Sprintf("%4.4f", k/float64(time.Second))
+		c.Bars = append(c.Bars, svgBar{
+			X:      x + 1,
+			Y:      c.Bottom - height,
+			Width:  math.Max(barWidth-2, 1),
+			Height: height,
+			Title:  fmt.Sprintf("<= %s secs: %d", label, cnt),
+		})
+		c.XLabels = append(c.XLabels, svgLabel{X: x + barWidth/2, Text: label})
+	}
+	return c
+}
+
+// timeSeriesCharts returns line charts of the request rate and the median and P99
+// latencies of each bucket. They're nil if there are too few buckets to chart.
+func timeSeriesCharts(buckets []*api.TimeSeriesBucket) (throughput, latency *svgChart) {
+	if len(buckets) < 2 {
+		return nil, nil
+	}
+
+	rates := make([]float64, 0, len(buckets))
+	medians := make([]float64, 0, len(buckets))
+	p99s := make([]float64, 0, len(buckets))
+	for _, b := range buckets {
+		rates = append(rates, b.Stats.RqstRatePerSec)
+		medians = append(medians, b.Stats.MedianRqstDurationNanos.Seconds())
+		p99s = append(p99s, b.Stats.P99RqstDurationNanos.Seconds())
+	}
+
+	throughput = newSVGChart("Time (secs)", "Rqsts/sec")
+	throughput.addLines(buckets, []string{"Rqsts/sec"}, []string{"#2a6fdb"}, rates)
+
+	latency = newSVGChart("Time (secs)", "Latency (secs)")
+	latency.addLines(buckets, []string{"Median", "P99"}, []string{"#2a9d3a", "#d2462f"}, medians, p99s)
+
+	return throughput, latency
+}
+
+// addLines adds a line for each of 'series', with the corresponding name and color, to the
+// chart. Each series has a value per bucket. All series share the same y axis.
+func (c *svgChart) addLines(buckets []*api.TimeSeriesBucket, names, colors []string, series ...[]float64) {
+	max := 0.0
+	for _, values := range series {
+		for _, v := range values {
+			max = math.Max(max, v)
+		}
+	}
+	c.YMax = fmt.Sprintf("%4.4f", max)
+	if max == 0 {
+		max = 1
+	}
+
+	step := (c.Right - c.Left) / float64(len(buckets)-1)
+	x := func(i int) float64 { return c.Left + float64(i)*step }
+
+	for i, values := range series {
+		var sb strings.Builder
+		for j, v := range values {
+			fmt.Fprintf(&sb, "%.1f,%.1f ", x(j), c.Bottom-v/max*(c.Bottom-c.Top))
+		}
+		c.Lines = append(c.Lines, svgLine{
+			Name:    names[i],
+			Color:   colors[i],
+			Points:  strings.TrimSpace(sb.String()),
+			LegendY: c.Top + 10 + float64(i)*18,
+		})
+	}
+
+	// Label about 10 evenly spaced buckets so the labels don't overlap
+	every := int(math.Ceil(float64(len(buckets)) / 10))
+	for i := 0; i < len(buckets); i += every {
+		c.XLabels = append(c.XLabels, svgLabel{X: x(i), Text: fmt.Sprintf("%.0f", buckets[i].StartNanos.Seconds())})
+	}
+}
+
+// writeHTMLReport writes 'rr' to 'w' as a standalone HTML page. 'histogram' is a chart
+// of the run's latency histogram, it's omitted from the page if nil.
+func writeHTMLReport(w io.Writer, rr api.RunResults, histogram *svgChart) error {
+	report := htmlReport{
+		RunResults:     rr,
+		GeneratedAt:    time.Now().Format(time.RFC1123),
+		HasAssertions:  hasAssertionResults(rr.EndpointDetails),
+		HasConnDetails: hasConnDetails(rr.EndpointDetails),
+		HasWebSocket:   hasWebSocketDetails(rr.EndpointDetails),
+		Histogram:      histogram,
+	}
+	report.Throughput, report.Latency = timeSeriesCharts(rr.TimeSeries)
+
+	tmplt, err := template.New("htmlReport").Funcs(template.FuncMap(tmpltFuncs)).Parse(htmlReportTmplt)
+	if err != nil {
+		return fmt.Errorf("error parsing HTML report template: %w", err)
+	}
+	if err = tmplt.Execute(w, report); err != nil {
+		return fmt.Errorf("error executing HTML report template: %w", err)
+	}
+	return nil
+}
+
+var htmlReportTmplt = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>heyyall Load Test Report</title>
+<style>
+	body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #222; }
+	h1 { margin-bottom: 0; }
+	h2 { margin-top: 1.6em; border-bottom: 1px solid #ccc; padding-bottom: 0.2em; }
+	h3 { margin-bottom: 0.3em; }
+	table { border-collapse: collapse; margin: 0.5em 0; }
+	th, td { border: 1px solid #ddd; padding: 0.3em 0.7em; text-align: right; }
+	th { background: #f3f3f3; }
+	td.label, th.label { text-align: left; }
+	.meta { color: #666; }
+	.pass { color: #2a9d3a; font-weight: bold; }
+	.fail { color: #d2462f; font-weight: bold; }
+	svg text { font-size: 10px; fill: #444; }
+	svg .axis { stroke: #888; stroke-width: 1; }
+</style>
+</head>
+<body>
+<h1>heyyall Load Test Report</h1>
+<p class="meta">Generated {{ .GeneratedAt }}</p>
+
+<h2>Run Summary</h2>
+<table>
+	<tr><th class="label">Total Rqsts</th><td>{{ .RunSummary.RqstStats.TotalRqsts }}</td></tr>
+	<tr><th class="label">Rqsts/sec</th><td>{{ formatFloat .RunSummary.RqstRatePerSec }}</td></tr>
+	<tr><th class="label">Offered Rqsts/sec</th><td>{{ formatFloat .RunSummary.OfferedRqstRatePerSec }}</td></tr>
+	<tr><th class="label">Late Rqsts</th><td>{{ .RunSummary.LateRqsts }}</td></tr>
+	<tr><th class="label">Dropped Rqsts</th><td>{{ .RunSummary.DroppedRqsts }}</td></tr>
+	<tr><th class="label">Total Errors</th><td>{{ .RunSummary.RqstStats.TotalErrors }}</td></tr>
+	<tr><th class="label">Error Rate</th><td>{{ formatPercent .RunSummary.RqstStats.TotalErrors .RunSummary.RqstStats.TotalRqsts }}%</td></tr>
+	<tr><th class="label">Run Duration (secs)</th><td>{{ formatSeconds .RunSummary.RunDurationNanos }}</td></tr>
+</table>
+
+<h2>Request Latency (secs)</h2>
+<table>
+	<tr><th>Min</th><th>Median</th><th>P75</th><th>P90</th><th>P95</th><th>P99</th><th>P99.9</th><th>P99.99</th><th>Max</th></tr>
+	{{ with .RunSummary.RqstStats.TimingResultsNanos }}<tr><td>{{ formatPercentile 0 . }}</td><td>{{ formatPercentile 50 . }}</td><td>{{ formatPercentile 75 . }}</td><td>{{ formatPercentile 90 . }}</td><td>{{ formatPercentile 95 . }}</td><td>{{ formatPercentile 99 . }}</td><td>{{ formatPercentile 99.9 . }}</td><td>{{ formatPercentile 99.99 . }}</td><td>{{ formatPercentile 100 . }}</td></tr>{{ end }}
+</table>
+{{ with .Histogram }}
+<h3>Request Latency Histogram</h3>
+{{ template "chart" . }}{{ end }}
+
+<h2>Endpoint Details (secs)</h2>
+{{ range $url, $epDetail := .EndpointDetails }}
+<h3>{{ $url }}</h3>
+<table>
+	<tr><th class="label">Method</th><th>Requests</th><th>Errors</th><th>Min</th><th>Median</th><th>P75</th><th>P90</th><th>P95</th><th>P99</th><th>P99.9</th><th>P99.99</th></tr>
+	{{ range $method, $stats := $epDetail.HTTPMethodRqstStats }}{{ with $stats.TimingResultsNanos }}<tr><td class="label">{{ $method }}</td><td>{{ $stats.TotalRqsts }}</td><td>{{ $stats.TotalErrors }}</td><td>{{ formatPercentile 0 . }}</td><td>{{ formatPercentile 50 . }}</td><td>{{ formatPercentile 75 . }}</td><td>{{ formatPercentile 90 . }}</td><td>{{ formatPercentile 95 . }}</td><td>{{ formatPercentile 99 . }}</td><td>{{ formatPercentile 99.9 . }}</td><td>{{ formatPercentile 99.99 . }}</td></tr>
+	{{ end }}{{ end }}
+</table>
+{{ if $epDetail.HTTPMethodStatusDist }}<table>
+	<tr><th class="label">Method</th><th class="label">Status Counts</th></tr>
+	{{ range $method, $dist := $epDetail.HTTPMethodStatusDist }}<tr><td class="label">{{ $method }}</td><td class="label">{{ range $status, $count := $dist }}{{ $status }}: {{ $count }}&nbsp;&nbsp; {{ end }}</td></tr>
+	{{ end }}
+</table>{{ end }}
+{{ if $epDetail.HTTPMethodErrorDist }}<table>
+	<tr><th class="label">Method</th><th class="label">Errors</th></tr>
+	{{ range $method, $dist := $epDetail.HTTPMethodErrorDist }}<tr><td class="label">{{ $method }}</td><td class="label">{{ range $errType, $count := $dist }}{{ $errType }}: {{ $count }}&nbsp;&nbsp; {{ end }}</td></tr>
+	{{ end }}
+</table>{{ end }}
+{{ end }}
+
+<h2>Network Details (secs)</h2>
+<table>
+	<tr><th class="label">Phase</th><th>Min</th><th>Median</th><th>P75</th><th>P90</th><th>P95</th><th>P99</th></tr>
+	{{ with .RunSummary.DNSLookupNanos }}<tr><td class="label">DNS Lookup</td><td>{{ formatPercentile 0 . }}</td><td>{{ formatPercentile 50 . }}</td><td>{{ formatPercentile 75 . }}</td><td>{{ formatPercentile 90 . }}</td><td>{{ formatPercentile 95 . }}</td><td>{{ formatPercentile 99 . }}</td></tr>{{ end }}
+	{{ with .RunSummary.TCPConnSetupNanos }}<tr><td class="label">TCP Conn Setup</td><td>{{ formatPercentile 0 . }}</td><td>{{ formatPercentile 50 . }}</td><td>{{ formatPercentile 75 . }}</td><td>{{ formatPercentile 90 . }}</td><td>{{ formatPercentile 95 . }}</td><td>{{ formatPercentile 99 . }}</td></tr>{{ end }}
+	{{ with .RunSummary.TLSHandshakeNanos }}<tr><td class="label">TLS Handshake</td><td>{{ formatPercentile 0 . }}</td><td>{{ formatPercentile 50 . }}</td><td>{{ formatPercentile 75 . }}</td><td>{{ formatPercentile 90 . }}</td><td>{{ formatPercentile 95 . }}</td><td>{{ formatPercentile 99 . }}</td></tr>{{ end }}
+	{{ with .RunSummary.QUICHandshakeNanos }}<tr><td class="label">QUIC Handshake</td><td>{{ formatPercentile 0 . }}</td><td>{{ formatPercentile 50 . }}</td><td>{{ formatPercentile 75 . }}</td><td>{{ formatPercentile 90 . }}</td><td>{{ formatPercentile 95 . }}</td><td>{{ formatPercentile 99 . }}</td></tr>{{ end }}
+	{{ with .RunSummary.RqstRoundTripNanos }}<tr><td class="label">Rqst Roundtrip</td><td>{{ formatPercentile 0 . }}</td><td>{{ formatPercentile 50 . }}</td><td>{{ formatPercentile 75 . }}</td><td>{{ formatPercentile 90 . }}</td><td>{{ formatPercentile 95 . }}</td><td>{{ formatPercentile 99 . }}</td></tr>{{ end }}
+</table>
+{{ if .HasConnDetails }}
+<h2>Connection Details</h2>
+<table>
+	<tr><th class="label">URL</th><th>Conns Opened</th><th>Rqsts/Conn</th><th class="label">Protocols</th></tr>
+	{{ range $url, $epDetail := .EndpointDetails }}{{ if or $epDetail.ConnsOpened $epDetail.ProtocolDist }}<tr><td class="label">{{ $url }}</td><td>{{ $epDetail.ConnsOpened }}</td><td>{{ formatFloat $epDetail.RqstsPerConn }}</td><td class="label">{{ range $protocol, $count := $epDetail.ProtocolDist }}{{ $protocol }}: {{ $count }}&nbsp;&nbsp; {{ end }}</td></tr>
+	{{ end }}{{ end }}
+</table>{{ end }}
+{{ if .HasWebSocket }}
+<h2>WebSocket Details (secs)</h2>
+<table>
+	<tr><th class="label">URL</th><th class="label">Phase</th><th>Min</th><th>Median</th><th>P75</th><th>P90</th><th>P95</th><th>P99</th></tr>
+	{{ range $url, $epDetail := .EndpointDetails }}{{ with $epDetail.WebSocket }}{{ with .ConnectNanos }}<tr><td class="label">{{ $url }}</td><td class="label">Connect</td><td>{{ formatPercentile 0 . }}</td><td>{{ formatPercentile 50 . }}</td><td>{{ formatPercentile 75 . }}</td><td>{{ formatPercentile 90 . }}</td><td>{{ formatPercentile 95 . }}</td><td>{{ formatPercentile 99 . }}</td></tr>{{ end }}
+	{{ with .MsgRoundTripNanos }}<tr><td class="label">{{ $url }}</td><td class="label">Msg Roundtrip</td><td>{{ formatPercentile 0 . }}</td><td>{{ formatPercentile 50 . }}</td><td>{{ formatPercentile 75 . }}</td><td>{{ formatPercentile 90 . }}</td><td>{{ formatPercentile 95 . }}</td><td>{{ formatPercentile 99 . }}</td></tr>{{ end }}
+	{{ end }}{{ end }}
+</table>
+<table>
+	<tr><th class="label">URL</th><th>Msgs Sent</th><th>Sent/sec</th><th>Msgs Rcvd</th><th>Rcvd/sec</th><th class="label">Abnormal Closes</th></tr>
+	{{ range $url, $epDetail := .EndpointDetails }}{{ with $epDetail.WebSocket }}<tr><td class="label">{{ $url }}</td><td>{{ .MsgsSent }}</td><td>{{ formatFloat .MsgsSentPerSec }}</td><td>{{ .MsgsRcvd }}</td><td>{{ formatFloat .MsgsRcvdPerSec }}</td><td class="label">{{ range $code, $count := .AbnormalCloseDist }}{{ $code }}: {{ $count }}&nbsp;&nbsp; {{ end }}</td></tr>
+	{{ end }}{{ end }}
+</table>{{ end }}
+{{ if .HasAssertions }}
+<h2>Assertions</h2>
+<table>
+	<tr><th class="label">URL</th><th class="label">Method</th><th>Passed</th><th>Failed</th><th class="label">Failures</th></tr>
+	{{ range $url, $epDetail := .EndpointDetails }}{{ range $method, $results := $epDetail.HTTPMethodAssertionResults }}<tr><td class="label">{{ $url }}</td><td class="label">{{ $method }}</td><td>{{ $results.Passed }}</td><td>{{ $results.Failed }}</td><td class="label">{{ range $failure, $count := $results.FailureDist }}{{ $failure }}: {{ $count }}&nbsp;&nbsp; {{ end }}</td></tr>
+	{{ end }}{{ end }}
+</table>{{ end }}
+{{ if .StageSummaries }}
+<h2>Stage Details (secs)</h2>
+<table>
+	<tr><th>Stage</th><th>Duration</th><th>Target Rate</th><th>Target Conc</th><th>Rqsts/sec</th><th>Requests</th><th>Errors</th><th>Dropped</th><th>Median</th><th>P90</th><th>P99</th></tr>
+	{{ range .StageSummaries }}<tr><td>{{ .Stage }}</td><td>{{ formatSeconds .DurationNanos }}</td><td>{{ .TargetRqstRate }}</td><td>{{ .TargetConcurrentRqsts }}</td><td>{{ formatFloat .RqstRatePerSec }}</td><td>{{ .RqstStats.TotalRqsts }}</td><td>{{ .RqstStats.TotalErrors }}</td><td>{{ .DroppedRqsts }}</td><td>{{ formatPercentile 50 .RqstStats.TimingResultsNanos }}</td><td>{{ formatPercentile 90 .RqstStats.TimingResultsNanos }}</td><td>{{ formatPercentile 99 .RqstStats.TimingResultsNanos }}</td></tr>
+	{{ end }}
+</table>{{ end }}
+{{ if .ScenarioSummaries }}
+<h2>Scenario Details (secs)</h2>
+<table>
+	<tr><th class="label">Scenario</th><th class="label">Step</th><th>Requests</th><th>Errors</th><th>Median</th><th>P90</th><th>P99</th></tr>
+	{{ range .ScenarioSummaries }}<tr><td class="label">{{ .Name }}</td><td class="label">Iterations</td><td>{{ .IterationStats.TotalRqsts }}</td><td>{{ .IterationStats.TotalErrors }}</td><td>{{ formatPercentile 50 .IterationStats.TimingResultsNanos }}</td><td>{{ formatPercentile 90 .IterationStats.TimingResultsNanos }}</td><td>{{ formatPercentile 99 .IterationStats.TimingResultsNanos }}</td></tr>
+	{{ $name := .Name }}{{ range .Steps }}<tr><td class="label">{{ $name }}</td><td class="label">{{ .Name }}</td><td>{{ .RqstStats.TotalRqsts }}</td><td>{{ .RqstStats.TotalErrors }}</td><td>{{ formatPercentile 50 .RqstStats.TimingResultsNanos }}</td><td>{{ formatPercentile 90 .RqstStats.TimingResultsNanos }}</td><td>{{ formatPercentile 99 .RqstStats.TimingResultsNanos }}</td></tr>
+	{{ end }}{{ end }}
+</table>{{ end }}
+{{ if .TimeSeries }}
+<h2>Time Series</h2>
+{{ with .Throughput }}<h3>Request Rate</h3>
+{{ template "chart" . }}{{ end }}
+{{ with .Latency }}<h3>Request Latency</h3>
+{{ template "chart" . }}{{ end }}
+<table>
+	<tr><th>Start (secs)</th><th>Requests</th><th>Errors</th><th>Rqsts/sec</th><th>Min</th><th>Median</th><th>P90</th><th>P99</th><th>Max</th></tr>
+	{{ range .TimeSeries }}<tr><td>{{ formatSeconds .StartNanos }}</td><td>{{ .Stats.TotalRqsts }}</td><td>{{ .Stats.TotalErrors }}</td><td>{{ formatFloat .Stats.RqstRatePerSec }}</td><td>{{ formatSeconds .Stats.MinRqstDurationNanos }}</td><td>{{ formatSeconds .Stats.MedianRqstDurationNanos }}</td><td>{{ formatSeconds .Stats.P90RqstDurationNanos }}</td><td>{{ formatSeconds .Stats.P99RqstDurationNanos }}</td><td>{{ formatSeconds .Stats.MaxRqstDurationNanos }}</td></tr>
+	{{ end }}
+</table>{{ end }}
+{{ if .ThresholdResults }}
+<h2>Thresholds</h2>
+<table>
+	<tr><th class="label">Result</th><th class="label">Threshold</th><th class="label">Actual</th></tr>
+	{{ range .ThresholdResults }}<tr><td class="label">{{ if .Passed }}<span class="pass">PASS</span>{{ else }}<span class="fail">FAIL</span>{{ end }}</td><td class="label">{{ describeThreshold .Threshold }}</td><td class="label">{{ .Actual }}</td></tr>
+	{{ end }}
+</table>{{ end }}
+{{ with .Comparison }}
+<h2>Comparison with Baseline</h2>
+<p>Tolerance {{ printf "%.2f" .TolerancePct }}%, latencies in secs. Regressions: <span class="{{ if .Regressions }}fail{{ else }}pass{{ end }}">{{ .Regressions }}</span></p>
+<h3>All Requests</h3>
+{{ template "deltas" .Overall }}
+{{ range .Endpoints }}<h3>{{ .URL }} {{ .Method }}</h3>
+{{ template "deltas" .Deltas }}
+{{ end }}{{ if .MissingEndpoints }}<h3>Not in this run</h3>
+<ul>
+	{{ range .MissingEndpoints }}<li>{{ .URL }} {{ .Method }}</li>
+	{{ end }}
+</ul>{{ end }}{{ end }}
+</body>
+</html>
+{{ define "deltas" }}<table>
+	<tr><th class="label">Metric</th><th>Baseline</th><th>Current</th><th>Delta</th><th class="label"></th></tr>
+	{{ range . }}<tr><td class="label">{{ .Metric }}</td><td>{{ printf "%.4f" .Baseline }}</td><td>{{ printf "%.4f" .Current }}</td><td>{{ printf "%+.2f" .DeltaPct }}%</td><td class="label">{{ if .Regression }}<span class="fail">REGRESSION</span>{{ end }}</td></tr>
+	{{ end }}
+</table>{{ end }}
+{{ define "chart" }}<svg xmlns="http://www.w3.org/2000/svg" width="{{ .Width }}" height="{{ .Height }}" viewBox="0 0 {{ .Width }} {{ .Height }}">
+	<line class="axis" x1="{{ .Left }}" y1="{{ .Top }}" x2="{{ .Left }}" y2="{{ .Bottom }}"/>
+	<line class="axis" x1="{{ .Left }}" y1="{{ .Bottom }}" x2="{{ .Right }}" y2="{{ .Bottom }}"/>
+	<text x="{{ .Left }}" y="{{ .Top }}" dx="-4" text-anchor="end">{{ .YMax }}</text>
+	<text x="{{ .Left }}" y="{{ .Bottom }}" dx="-4" text-anchor="end">0</text>
+	<text x="{{ .Left }}" y="{{ .Top }}" dx="4" dy="-8">{{ .YLabel }}</text>
+	<text x="{{ .Right }}" y="{{ .Height }}" dy="-4" text-anchor="end">{{ .XLabel }}</text>
+	{{ $bottom := .Bottom }}{{ range .XLabels }}<text x="{{ .X }}" y="{{ $bottom }}" dy="14" text-anchor="middle">{{ .Text }}</text>
+	{{ end }}{{ range .Bars }}<rect x="{{ .X }}" y="{{ .Y }}" width="{{ .Width }}" height="{{ .Height }}" fill="#2a6fdb"><title>{{ .Title }}</title></rect>
+	{{ end }}{{ $right := .Right }}{{ range .Lines }}<polyline points="{{ .Points }}" fill="none" stroke="{{ .Color }}" stroke-width="2"/>
+	<line x1="{{ $right }}" y1="{{ .LegendY }}" x2="{{ $right }}" y2="{{ .LegendY }}" stroke="{{ .Color }}" stroke-width="10" stroke-linecap="round" transform="translate(16 0)"/>
+	<text x="{{ $right }}" y="{{ .LegendY }}" dx="28" dy="4">{{ .Name }}</text>
+	{{ end }}
+</svg>{{ end }}
+`
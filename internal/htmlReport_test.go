@@ -0,0 +1,104 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+func TestWriteHTMLReport(t *testing.T) {
+	url := "http://someurl/users?name=<script>"
+	rh := ResponseHandler{OutputType: HTML}
+	runResults := rh.newRunResults()
+	epRunSummary := make(map[string]*api.EndpointDetail)
+	totalRunTime := time.Duration(0)
+	ts := newTimeSeries(time.Second, rh.newRqstStats)
+
+	for i := 1; i <= 100; i++ {
+		resp := Response{HTTPStatus: http.StatusOK, Endpoint: api.Endpoint{URL: url, Method: http.MethodGet},
+			RequestDuration: time.Duration(i) * time.Millisecond}
+		rh.accumulateResponseStats(resp, &totalRunTime, &runResults, epRunSummary)
+		ts.add(resp, time.Duration(i)*30*time.Millisecond)
+	}
+	err := rh.finalizeResponseStats(time.Now().Add(-3*time.Second), &totalRunTime, &runResults, epRunSummary)
+	if err != nil {
+		t.Fatalf("unexpected error finalizing response stats: %s", err)
+	}
+	runResults.TimeSeries = ts.finish(runResults.RunSummary.RunDurationNanos)
+	runResults.ThresholdResults = []api.ThresholdResult{{Threshold: api.Threshold{Metric: "P99", Max: "10ms"}, Actual: "99ms"}}
+	rh.generateHistogram(&runResults)
+
+	var buf bytes.Buffer
+	if err = writeHTMLReport(&buf, runResults, rh.histogramChart()); err != nil {
+		t.Fatalf("unexpected error writing HTML report: %s", err)
+	}
+	report := buf.String()
+
+	expected := []string{
+		"<!DOCTYPE html>",
+		"<h2>Run Summary</h2>",
+		"<h3>Request Latency Histogram</h3>",
+		"<h2>Endpoint Details (secs)</h2>",
+		"<h2>Network Details (secs)</h2>",
+		"<h3>Request Rate</h3>",
+		"<h3>Request Latency</h3>",
+		"<h2>Thresholds</h2>",
+		`<span class="fail">FAIL</span>`,
+		"http://someurl/users?name=&lt;script&gt;",
+	}
+	for _, e := range expected {
+		if !strings.Contains(report, e) {
+			t.Errorf("expected the report to contain %q", e)
+		}
+	}
+
+	// The report must be self-contained
+	for _, unexpected := range []string{"<script", "<link", "src=", "@import", "ZgotmplZ"} {
+		if strings.Contains(report, unexpected) {
+			t.Errorf("expected the report not to contain %q", unexpected)
+		}
+	}
+
+	if n := strings.Count(report, "<svg"); n != 3 {
+		t.Errorf("expected 3 charts, got %d", n)
+	}
+	if n := strings.Count(report, "<polyline"); n != 3 {
+		t.Errorf("expected 3 time series lines, got %d", n)
+	}
+}
+
+func TestTimeSeriesCharts(t *testing.T) {
+	buckets := []*api.TimeSeriesBucket{
+		{StartNanos: 0, Stats: api.IntervalStats{RqstRatePerSec: 100, P99RqstDurationNanos: 10 * time.Millisecond}},
+		{StartNanos: time.Second, Stats: api.IntervalStats{RqstRatePerSec: 50, P99RqstDurationNanos: 20 * time.Millisecond}},
+	}
+
+	throughput, latency := timeSeriesCharts(buckets[:1])
+	if throughput != nil || latency != nil {
+		t.Errorf("expected no charts for a single bucket")
+	}
+
+	throughput, latency = timeSeriesCharts(buckets)
+	if throughput == nil || latency == nil {
+		t.Fatalf("expected charts for 2 buckets")
+	}
+	// The highest value is drawn at the top of the chart, the lowest proportionally below it
+	expectedPoints := "70.0,20.0 690.0,120.0"
+	if throughput.Lines[0].Points != expectedPoints {
+		t.Errorf("expected throughput points %s, got %s", expectedPoints, throughput.Lines[0].Points)
+	}
+	if throughput.YMax != "100.0000" {
+		t.Errorf("expected a y axis max of 100.0000, got %s", throughput.YMax)
+	}
+	if len(latency.Lines) != 2 || latency.Lines[1].Points != "70.0,120.0 690.0,20.0" {
+		t.Errorf("expected median and P99 lines, got %+v", latency.Lines)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonPathStep is a single selector in a JSONPath expression. It selects either
+// a child of an object, by 'name', or an element of an array, by 'index'.
+type jsonPathStep struct {
+	name    string
+	index   int
+	isIndex bool
+}
+
+// jsonPath is a parsed JSONPath expression. Only the child (.name and ['name'])
+// and array index ([n]) selectors are supported.
+type jsonPath struct {
+	expr  string
+	steps []jsonPathStep
+}
+
+// parseJSONPath parses 'expr', e.g., $.data.items[0]['display name']
+func parseJSONPath(expr string) (jsonPath, error) {
+	if !strings.HasPrefix(expr, "$") {
+		return jsonPath{}, fmt.Errorf("JSONPath %s must start with '$'", expr)
+	}
+
+	jp := jsonPath{expr: expr}
+	rest := expr[1:]
+	for len(rest) > 0 {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end == -1 {
+				end = len(rest) - 1
+			}
+			name := rest[1 : end+1]
+			if name == "" {
+				return jsonPath{}, fmt.Errorf("JSONPath %s has an empty name", expr)
+			}
+			jp.steps = append(jp.steps, jsonPathStep{name: name})
+			rest = rest[end+1:]
+		case '[':
+			end := strings.Index(rest, "]")
+			if end == -1 {
+				return jsonPath{}, fmt.Errorf("JSONPath %s has an unterminated '['", expr)
+			}
+			sel := rest[1:end]
+			if len(sel) >= 2 && sel[0] == '\'' && sel[len(sel)-1] == '\'' {
+				jp.steps = append(jp.steps, jsonPathStep{name: sel[1 : len(sel)-1]})
+			} else {
+				index, err := strconv.Atoi(sel)
+				if err != nil || index < 0 {
+					return jsonPath{}, fmt.Errorf("JSONPath %s has an unsupported selector [%s]", expr, sel)
+				}
+				jp.steps = append(jp.steps, jsonPathStep{index: index, isIndex: true})
+			}
+			rest = rest[end+1:]
+		default:
+			return jsonPath{}, fmt.Errorf("JSONPath %s has an unexpected character '%c'", expr, rest[0])
+		}
+	}
+
+	return jp, nil
+}
+
+// lookup returns the value 'jp' selects from 'doc', a JSON document decoded by
+// encoding/json into an interface{}. 'ok' is false if 'doc' doesn't contain the value.
+func (jp jsonPath) lookup(doc interface{}) (val interface{}, ok bool) {
+	val = doc
+	for _, step := range jp.steps {
+		if step.isIndex {
+			arr, isArr := val.([]interface{})
+			if !isArr || step.index >= len(arr) {
+				return nil, false
+			}
+			val = arr[step.index]
+			continue
+		}
+		obj, isObj := val.(map[string]interface{})
+		if !isObj {
+			return nil, false
+		}
+		val, ok = obj[step.name]
+		if !ok {
+			return nil, false
+		}
+	}
+	return val, true
+}
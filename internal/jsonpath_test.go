@@ -0,0 +1,59 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestJSONPath(t *testing.T) {
+	doc := `{"status": "ok", "data": {"items": [{"id": 1}, {"id": 2, "display name": "two"}]}, "count": 2}`
+	var decoded interface{}
+	if err := json.Unmarshal([]byte(doc), &decoded); err != nil {
+		t.Fatalf("unexpected error decoding test document: %s", err)
+	}
+
+	tests := []struct {
+		name        string
+		expr        string
+		shouldFail  bool
+		expected    interface{}
+		expectFound bool
+	}{
+		{name: "Root", expr: "$", expected: decoded, expectFound: true},
+		{name: "Child", expr: "$.status", expected: "ok", expectFound: true},
+		{name: "Number", expr: "$.count", expected: float64(2), expectFound: true},
+		{name: "Nested", expr: "$.data.items[1].id", expected: float64(2), expectFound: true},
+		{name: "Bracketed", expr: "$['data'].items[1]['display name']", expected: "two", expectFound: true},
+		{name: "MissingChild", expr: "$.data.total", expectFound: false},
+		{name: "IndexOutOfRange", expr: "$.data.items[2]", expectFound: false},
+		{name: "IndexIntoObject", expr: "$.data[0]", expectFound: false},
+		{name: "FailPath - no root", expr: "data.items", shouldFail: true},
+		{name: "FailPath - empty name", expr: "$..items", shouldFail: true},
+		{name: "FailPath - unterminated", expr: "$.items[0", shouldFail: true},
+		{name: "FailPath - wildcard", expr: "$.items[*]", shouldFail: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			jp, err := parseJSONPath(tc.expr)
+			if tc.shouldFail {
+				if err == nil {
+					t.Errorf("expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			actual, found := jp.lookup(decoded)
+			if found != tc.expectFound || !reflect.DeepEqual(actual, tc.expected) {
+				t.Errorf("expected %v (found: %t), got %v (found: %t)", tc.expected, tc.expectFound, actual, found)
+			}
+		})
+	}
+}
@@ -0,0 +1,326 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// MergeRunResults merges the results of runs that were run at the same time, e.g., by
+// distributed workers, into the results of a single run. Latency histograms are merged
+// so the merged percentiles are as accurate as those of a single run. Time series
+// buckets only keep selected percentiles, so a merged bucket's percentiles are the
+// largest of the runs' percentiles. Thresholds and baseline comparisons aren't merged.
+func MergeRunResults(results []api.RunResults) api.RunResults {
+	merged := api.RunResults{
+		Version:         api.ReportVersion,
+		EndpointSummary: make(map[string]map[string]int),
+		EndpointDetails: make(map[string]*api.EndpointDetail),
+	}
+	if len(results) == 0 {
+		return merged
+	}
+
+	rs := &merged.RunSummary
+	first := results[0].RunSummary
+	rs.RqstStats = newMergedRqstStats(first.RqstStats)
+	rs.DNSLookupNanos = newMergedHistogram(first.DNSLookupNanos)
+	rs.TCPConnSetupNanos = newMergedHistogram(first.TCPConnSetupNanos)
+	rs.RqstRoundTripNanos = newMergedHistogram(first.RqstRoundTripNanos)
+	rs.TLSHandshakeNanos = newMergedHistogram(first.TLSHandshakeNanos)
+
+	for _, rr := range results {
+		if rr.RunSummary.RunDurationNanos > rs.RunDurationNanos {
+			rs.RunDurationNanos = rr.RunSummary.RunDurationNanos
+		}
+		rs.LateRqsts += rr.RunSummary.LateRqsts
+		rs.DroppedRqsts += rr.RunSummary.DroppedRqsts
+		mergeRqstStats(&rs.RqstStats, rr.RunSummary.RqstStats)
+		mergeHistogram(rs.DNSLookupNanos, rr.RunSummary.DNSLookupNanos)
+		mergeHistogram(rs.TCPConnSetupNanos, rr.RunSummary.TCPConnSetupNanos)
+		mergeHistogram(rs.RqstRoundTripNanos, rr.RunSummary.RqstRoundTripNanos)
+		mergeHistogram(rs.TLSHandshakeNanos, rr.RunSummary.TLSHandshakeNanos)
+		if rr.RunSummary.QUICHandshakeNanos != nil {
+			if rs.QUICHandshakeNanos == nil {
+				rs.QUICHandshakeNanos = newMergedHistogram(rr.RunSummary.QUICHandshakeNanos)
+			}
+			mergeHistogram(rs.QUICHandshakeNanos, rr.RunSummary.QUICHandshakeNanos)
+		}
+
+		for url, methods := range rr.EndpointSummary {
+			if _, ok := merged.EndpointSummary[url]; !ok {
+				merged.EndpointSummary[url] = make(map[string]int)
+			}
+			for method, count := range methods {
+				merged.EndpointSummary[url][method] += count
+			}
+		}
+
+		for url, epDetail := range rr.EndpointDetails {
+			mergeEndpointDetail(merged.EndpointDetails, url, epDetail)
+		}
+
+		mergeStageSummaries(&merged, rr.StageSummaries)
+		mergeTimeSeries(&merged, rr.TimeSeries)
+		mergeScenarioSummaries(&merged, rr.ScenarioSummaries)
+	}
+
+	if rs.RunDurationNanos > 0 {
+		rs.RqstRatePerSec = float64(rs.RqstStats.TotalRqsts) / rs.RunDurationNanos.Seconds()
+		rs.OfferedRqstRatePerSec = float64(rs.RqstStats.TotalRqsts+rs.DroppedRqsts) / rs.RunDurationNanos.Seconds()
+	}
+	clearMinMax(&rs.RqstStats)
+	for _, epDetail := range merged.EndpointDetails {
+		finalizeConnStats(epDetail)
+		finalizeWebSocketStats(epDetail, rs.RunDurationNanos)
+		for _, methodRqstStats := range epDetail.HTTPMethodRqstStats {
+			clearMinMax(methodRqstStats)
+		}
+	}
+	for _, stage := range merged.StageSummaries {
+		stage.RqstStats.MinRqstDurationNanos = stage.RqstStats.TimingResultsNanos.Min()
+		stage.RqstStats.MaxRqstDurationNanos = stage.RqstStats.TimingResultsNanos.Max()
+		stage.RqstRatePerSec = float64(stage.RqstStats.TotalRqsts) / stage.DurationNanos.Seconds()
+	}
+	for _, sc := range merged.ScenarioSummaries {
+		sc.IterationStats.MinRqstDurationNanos = sc.IterationStats.TimingResultsNanos.Min()
+		sc.IterationStats.MaxRqstDurationNanos = sc.IterationStats.TimingResultsNanos.Max()
+		for _, step := range sc.Steps {
+			step.RqstStats.MinRqstDurationNanos = step.RqstStats.TimingResultsNanos.Min()
+			step.RqstStats.MaxRqstDurationNanos = step.RqstStats.TimingResultsNanos.Max()
+		}
+	}
+
+	return merged
+}
+
+// mergeEndpointDetail merges 'epDetail', the details of the requests to 'url', into 'details'
+func mergeEndpointDetail(details map[string]*api.EndpointDetail, url string, epDetail *api.EndpointDetail) {
+	dst, ok := details[url]
+	if !ok {
+		dst = &api.EndpointDetail{
+			URL:                  url,
+			HTTPMethodStatusDist: make(map[string]map[int]int),
+			HTTPMethodErrorDist:  make(map[string]map[string]int),
+			HTTPMethodRqstStats:  make(map[string]*api.RqstStats),
+		}
+		details[url] = dst
+	}
+
+	for method, dist := range epDetail.HTTPMethodStatusDist {
+		if _, ok := dst.HTTPMethodStatusDist[method]; !ok {
+			dst.HTTPMethodStatusDist[method] = make(map[int]int)
+		}
+		for status, count := range dist {
+			dst.HTTPMethodStatusDist[method][status] += count
+		}
+	}
+
+	for method, dist := range epDetail.HTTPMethodErrorDist {
+		if _, ok := dst.HTTPMethodErrorDist[method]; !ok {
+			dst.HTTPMethodErrorDist[method] = make(map[string]int)
+		}
+		for errType, count := range dist {
+			dst.HTTPMethodErrorDist[method][errType] += count
+		}
+	}
+
+	for method, stats := range epDetail.HTTPMethodRqstStats {
+		if _, ok := dst.HTTPMethodRqstStats[method]; !ok {
+			rs := newMergedRqstStats(*stats)
+			dst.HTTPMethodRqstStats[method] = &rs
+		}
+		mergeRqstStats(dst.HTTPMethodRqstStats[method], *stats)
+	}
+
+	dst.ConnsOpened += epDetail.ConnsOpened
+	for protocol, count := range epDetail.ProtocolDist {
+		if dst.ProtocolDist == nil {
+			dst.ProtocolDist = make(map[string]int64)
+		}
+		dst.ProtocolDist[protocol] += count
+	}
+
+	if ws := epDetail.WebSocket; ws != nil {
+		if dst.WebSocket == nil {
+			dst.WebSocket = &api.WebSocketStats{
+				ConnectNanos:      newMergedHistogram(ws.ConnectNanos),
+				MsgRoundTripNanos: newMergedHistogram(ws.MsgRoundTripNanos),
+			}
+		}
+		mergeHistogram(dst.WebSocket.ConnectNanos, ws.ConnectNanos)
+		mergeHistogram(dst.WebSocket.MsgRoundTripNanos, ws.MsgRoundTripNanos)
+		dst.WebSocket.MsgsSent += ws.MsgsSent
+		dst.WebSocket.MsgsRcvd += ws.MsgsRcvd
+		for code, count := range ws.AbnormalCloseDist {
+			if dst.WebSocket.AbnormalCloseDist == nil {
+				dst.WebSocket.AbnormalCloseDist = make(map[int]int64)
+			}
+			dst.WebSocket.AbnormalCloseDist[code] += count
+		}
+	}
+
+	for method, ar := range epDetail.HTTPMethodAssertionResults {
+		if dst.HTTPMethodAssertionResults == nil {
+			dst.HTTPMethodAssertionResults = make(map[string]*api.AssertionResults)
+		}
+		results, ok := dst.HTTPMethodAssertionResults[method]
+		if !ok {
+			results = &api.AssertionResults{FailureDist: make(map[string]int64)}
+			dst.HTTPMethodAssertionResults[method] = results
+		}
+		results.Passed += ar.Passed
+		results.Failed += ar.Failed
+		for failure, count := range ar.FailureDist {
+			results.FailureDist[failure] += count
+		}
+	}
+}
+
+// mergeStageSummaries merges 'stages' into the stage summaries of 'merged'. Stage
+// targets are summed since each run targeted its share of the stage's load.
+func mergeStageSummaries(merged *api.RunResults, stages []*api.StageSummary) {
+	for i, stage := range stages {
+		if i == len(merged.StageSummaries) {
+			merged.StageSummaries = append(merged.StageSummaries, &api.StageSummary{
+				Stage:         stage.Stage,
+				DurationNanos: stage.DurationNanos,
+				RqstStats:     newMergedRqstStats(stage.RqstStats),
+			})
+		}
+		dst := merged.StageSummaries[i]
+		dst.TargetRqstRate += stage.TargetRqstRate
+		dst.TargetConcurrentRqsts += stage.TargetConcurrentRqsts
+		dst.DroppedRqsts += stage.DroppedRqsts
+		mergeRqstStats(&dst.RqstStats, stage.RqstStats)
+	}
+}
+
+// mergeScenarioSummaries merges 'scenarios' into the scenario summaries of 'merged'.
+// Every run has the same scenarios, so they're merged by position.
+func mergeScenarioSummaries(merged *api.RunResults, scenarios []*api.ScenarioSummary) {
+	for i, sc := range scenarios {
+		if i == len(merged.ScenarioSummaries) {
+			merged.ScenarioSummaries = append(merged.ScenarioSummaries, &api.ScenarioSummary{
+				Name:           sc.Name,
+				IterationStats: newMergedRqstStats(sc.IterationStats),
+			})
+		}
+		dst := merged.ScenarioSummaries[i]
+		mergeRqstStats(&dst.IterationStats, sc.IterationStats)
+		for j, step := range sc.Steps {
+			if j == len(dst.Steps) {
+				dst.Steps = append(dst.Steps, &api.StepSummary{Name: step.Name, RqstStats: newMergedRqstStats(step.RqstStats)})
+			}
+			mergeRqstStats(&dst.Steps[j].RqstStats, step.RqstStats)
+		}
+	}
+}
+
+// mergeTimeSeries merges 'buckets' into the time series of 'merged'. The runs are
+// expected to have started at the same time, so buckets are merged by position.
+func mergeTimeSeries(merged *api.RunResults, buckets []*api.TimeSeriesBucket) {
+	for i, b := range buckets {
+		if i == len(merged.TimeSeries) {
+			merged.TimeSeries = append(merged.TimeSeries, &api.TimeSeriesBucket{
+				StartNanos:    b.StartNanos,
+				DurationNanos: b.DurationNanos,
+			})
+		}
+		dst := merged.TimeSeries[i]
+		if b.DurationNanos > dst.DurationNanos {
+			dst.DurationNanos = b.DurationNanos
+		}
+		mergeIntervalStats(&dst.Stats, b.Stats)
+
+		for url, methods := range b.EndpointStats {
+			if dst.EndpointStats == nil {
+				dst.EndpointStats = make(map[string]map[string]*api.IntervalStats)
+			}
+			if _, ok := dst.EndpointStats[url]; !ok {
+				dst.EndpointStats[url] = make(map[string]*api.IntervalStats)
+			}
+			for method, is := range methods {
+				if _, ok := dst.EndpointStats[url][method]; !ok {
+					dst.EndpointStats[url][method] = &api.IntervalStats{}
+				}
+				mergeIntervalStats(dst.EndpointStats[url][method], *is)
+			}
+		}
+	}
+}
+
+// mergeIntervalStats merges 'src' into 'dst'. Percentiles can't be merged exactly, so
+// the larger of each is kept.
+func mergeIntervalStats(dst *api.IntervalStats, src api.IntervalStats) {
+	hadOK := dst.TotalRqsts-dst.TotalErrors > 0
+	dst.TotalRqsts += src.TotalRqsts
+	dst.TotalErrors += src.TotalErrors
+	dst.RqstRatePerSec += src.RqstRatePerSec
+	if src.TotalRqsts-src.TotalErrors == 0 {
+		return
+	}
+	if !hadOK || src.MinRqstDurationNanos < dst.MinRqstDurationNanos {
+		dst.MinRqstDurationNanos = src.MinRqstDurationNanos
+	}
+	dst.MedianRqstDurationNanos = maxDuration(dst.MedianRqstDurationNanos, src.MedianRqstDurationNanos)
+	dst.P90RqstDurationNanos = maxDuration(dst.P90RqstDurationNanos, src.P90RqstDurationNanos)
+	dst.P99RqstDurationNanos = maxDuration(dst.P99RqstDurationNanos, src.P99RqstDurationNanos)
+	dst.MaxRqstDurationNanos = maxDuration(dst.MaxRqstDurationNanos, src.MaxRqstDurationNanos)
+}
+
+func maxDuration(d1, d2 time.Duration) time.Duration {
+	if d1 > d2 {
+		return d1
+	}
+	return d2
+}
+
+// newMergedRqstStats returns empty stats, whose histogram matches that of 'rs', for
+// other stats to be merged into
+func newMergedRqstStats(rs api.RqstStats) api.RqstStats {
+	return api.RqstStats{
+		TimingResultsNanos:   newMergedHistogram(rs.TimingResultsNanos),
+		MaxRqstDurationNanos: -1,
+		MinRqstDurationNanos: time.Duration(1<<63 - 1),
+	}
+}
+
+// newMergedHistogram returns an empty histogram with the same precision and range as 'h'
+func newMergedHistogram(h *api.Histogram) *api.Histogram {
+	if h == nil {
+		return api.NewHistogram(0, 0)
+	}
+	return api.NewHistogram(h.SigDigits(), h.MaxTrackable())
+}
+
+func mergeHistogram(dst, src *api.Histogram) {
+	if src != nil {
+		dst.Merge(src)
+	}
+}
+
+// mergeRqstStats merges 'src' into 'dst'
+func mergeRqstStats(dst *api.RqstStats, src api.RqstStats) {
+	dst.TotalRqsts += src.TotalRqsts
+	dst.TotalErrors += src.TotalErrors
+	dst.TotalRequestDurationNanos += src.TotalRequestDurationNanos
+	mergeHistogram(dst.TimingResultsNanos, src.TimingResultsNanos)
+
+	// Min and max durations are only meaningful if there were successful requests
+	if src.TotalRqsts-src.TotalErrors > 0 {
+		if src.MinRqstDurationNanos < dst.MinRqstDurationNanos {
+			dst.MinRqstDurationNanos = src.MinRqstDurationNanos
+		}
+		if src.MaxRqstDurationNanos > dst.MaxRqstDurationNanos {
+			dst.MaxRqstDurationNanos = src.MaxRqstDurationNanos
+		}
+	}
+	if numOK := dst.TotalRqsts - dst.TotalErrors; numOK > 0 {
+		dst.AvgRqstDurationNanos = dst.TotalRequestDurationNanos / time.Duration(numOK)
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+func TestMergeRunResults(t *testing.T) {
+	url := "http://someurl/users"
+	fast := newCompareResults(t, url, 1000, 10, 10*time.Millisecond)
+	slow := newCompareResults(t, url, 1000, 0, 100*time.Millisecond)
+	slow.RunSummary.RunDurationNanos = 20 * time.Second
+	slow.RunSummary.DroppedRqsts = 5
+	fast.TimeSeries = []*api.TimeSeriesBucket{
+		{DurationNanos: time.Second, Stats: api.IntervalStats{TotalRqsts: 10, RqstRatePerSec: 10,
+			MinRqstDurationNanos: 10 * time.Millisecond, P99RqstDurationNanos: 10 * time.Millisecond}},
+	}
+	slow.TimeSeries = []*api.TimeSeriesBucket{
+		{DurationNanos: time.Second, Stats: api.IntervalStats{TotalRqsts: 20, RqstRatePerSec: 20,
+			MinRqstDurationNanos: 100 * time.Millisecond, P99RqstDurationNanos: 100 * time.Millisecond}},
+		{StartNanos: time.Second, DurationNanos: time.Second, Stats: api.IntervalStats{TotalRqsts: 5, RqstRatePerSec: 5}},
+	}
+
+	fast.EndpointDetails[url].ConnsOpened = 10
+	fast.EndpointDetails[url].ProtocolDist = map[string]int64{"HTTP/2.0": 1000}
+	slow.EndpointDetails[url].ConnsOpened = 40
+	slow.EndpointDetails[url].ProtocolDist = map[string]int64{"HTTP/2.0": 900, "HTTP/1.1": 100}
+	slow.EndpointDetails[url].WebSocket = &api.WebSocketStats{
+		ConnectNanos:      api.NewHistogram(0, 0),
+		MsgRoundTripNanos: api.NewHistogram(0, 0),
+		MsgsSent:          300,
+		MsgsRcvd:          200,
+		AbnormalCloseDist: map[int]int64{1011: 2},
+	}
+	slow.EndpointDetails[url].WebSocket.ConnectNanos.Record(5 * time.Millisecond)
+
+	merged := MergeRunResults([]api.RunResults{fast, slow})
+	rs := merged.RunSummary
+	epStats := merged.EndpointDetails[url].HTTPMethodRqstStats[http.MethodGet]
+
+	tests := []struct {
+		name     string
+		actual   interface{}
+		expected interface{}
+	}{
+		{name: "TotalRqsts", actual: rs.RqstStats.TotalRqsts, expected: int64(2000)},
+		{name: "TotalErrors", actual: rs.RqstStats.TotalErrors, expected: int64(10)},
+		{name: "DroppedRqsts", actual: rs.DroppedRqsts, expected: int64(5)},
+		{name: "RunDuration", actual: rs.RunDurationNanos, expected: 20 * time.Second},
+		{name: "RqstRate", actual: rs.RqstRatePerSec, expected: float64(100)},
+		{name: "Min", actual: rs.RqstStats.MinRqstDurationNanos, expected: 10 * time.Millisecond},
+		{name: "Max", actual: rs.RqstStats.MaxRqstDurationNanos, expected: 100 * time.Millisecond},
+		{name: "Avg", actual: rs.RqstStats.AvgRqstDurationNanos, expected: time.Duration(int64(990*10+1000*100) * int64(time.Millisecond) / 1990)},
+		{name: "HistogramCount", actual: rs.RqstStats.TimingResultsNanos.Count(), expected: int64(1990)},
+		{name: "EndpointSummary", actual: merged.EndpointSummary[url][http.MethodGet], expected: 2000},
+		{name: "EndpointRqsts", actual: epStats.TotalRqsts, expected: int64(2000)},
+		{name: "EndpointMin", actual: epStats.MinRqstDurationNanos, expected: 10 * time.Millisecond},
+		{name: "EndpointStatus", actual: merged.EndpointDetails[url].HTTPMethodStatusDist[http.MethodGet][http.StatusOK], expected: 1990},
+		{name: "EndpointErrors", actual: merged.EndpointDetails[url].HTTPMethodErrorDist[http.MethodGet][api.ErrConnRefused], expected: 10},
+		{name: "ConnsOpened", actual: merged.EndpointDetails[url].ConnsOpened, expected: int64(50)},
+		{name: "RqstsPerConn", actual: merged.EndpointDetails[url].RqstsPerConn, expected: float64(40)},
+		{name: "ProtocolDist", actual: merged.EndpointDetails[url].ProtocolDist["HTTP/2.0"], expected: int64(1900)},
+		{name: "WSConnects", actual: merged.EndpointDetails[url].WebSocket.ConnectNanos.Count(), expected: int64(1)},
+		{name: "WSMsgsSent", actual: merged.EndpointDetails[url].WebSocket.MsgsSent, expected: int64(300)},
+		{name: "WSMsgsRcvdRate", actual: merged.EndpointDetails[url].WebSocket.MsgsRcvdPerSec, expected: float64(10)},
+		{name: "WSAbnormalCloses", actual: merged.EndpointDetails[url].WebSocket.AbnormalCloseDist[1011], expected: int64(2)},
+		{name: "TimeSeriesBuckets", actual: len(merged.TimeSeries), expected: 2},
+		{name: "TimeSeriesRqsts", actual: merged.TimeSeries[0].Stats.TotalRqsts, expected: int64(30)},
+		{name: "TimeSeriesRate", actual: merged.TimeSeries[0].Stats.RqstRatePerSec, expected: float64(30)},
+		{name: "TimeSeriesMin", actual: merged.TimeSeries[0].Stats.MinRqstDurationNanos, expected: 10 * time.Millisecond},
+		{name: "TimeSeriesP99", actual: merged.TimeSeries[0].Stats.P99RqstDurationNanos, expected: 100 * time.Millisecond},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.actual != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, tc.actual)
+			}
+		})
+	}
+
+	// About half the successful requests took 10ms, the rest 100ms
+	p25 := rs.RqstStats.TimingResultsNanos.Percentile(25)
+	if !rs.RqstStats.TimingResultsNanos.Equivalent(p25, 10*time.Millisecond) {
+		t.Errorf("expected a P25 of 10ms, got %s", p25)
+	}
+	p99 := rs.RqstStats.TimingResultsNanos.Percentile(99)
+	if !rs.RqstStats.TimingResultsNanos.Equivalent(p99, 100*time.Millisecond) {
+		t.Errorf("expected a P99 of 100ms, got %s", p99)
+	}
+}
+
+func TestMergeRunResultsAllFailed(t *testing.T) {
+	url := "http://someurl/users"
+	merged := MergeRunResults([]api.RunResults{newCompareResults(t, url, 10, 10, 0), newCompareResults(t, url, 5, 5, 0)})
+
+	// Min and max are zero, as they are for a single run, when no requests succeeded
+	rs := merged.RunSummary.RqstStats
+	if rs.TotalRqsts != 15 || rs.TotalErrors != 15 {
+		t.Errorf("expected 15 requests and 15 errors, got %d and %d", rs.TotalRqsts, rs.TotalErrors)
+	}
+	if rs.MinRqstDurationNanos != 0 || rs.MaxRqstDurationNanos != 0 {
+		t.Errorf("expected a min and max of 0, got %s and %s", rs.MinRqstDurationNanos, rs.MaxRqstDurationNanos)
+	}
+	get := merged.EndpointDetails[url].HTTPMethodRqstStats[http.MethodGet]
+	if get.MinRqstDurationNanos != 0 || get.MaxRqstDurationNanos != 0 {
+		t.Errorf("expected a GET min and max of 0, got %s and %s", get.MinRqstDurationNanos, get.MaxRqstDurationNanos)
+	}
+}
+
+func TestMergeStageSummaries(t *testing.T) {
+	newStageResults := func(numRqsts int, latency time.Duration) api.RunResults {
+		rh := ResponseHandler{}
+		rr := api.RunResults{StageSummaries: []*api.StageSummary{
+			{Stage: 1, DurationNanos: 10 * time.Second, TargetRqstRate: 50, TargetConcurrentRqsts: 5, RqstStats: *rh.newRqstStats()},
+		}}
+		for i := 0; i < numRqsts; i++ {
+			accumulateRqstStats(&rr.StageSummaries[0].RqstStats, Response{RequestDuration: latency})
+		}
+		return rr
+	}
+
+	merged := MergeRunResults([]api.RunResults{newStageResults(500, time.Millisecond), newStageResults(500, 2*time.Millisecond)})
+	if len(merged.StageSummaries) != 1 {
+		t.Fatalf("expected 1 stage, got %d", len(merged.StageSummaries))
+	}
+	stage := merged.StageSummaries[0]
+	if stage.TargetRqstRate != 100 || stage.TargetConcurrentRqsts != 10 {
+		t.Errorf("expected a target rate of 100 and concurrency of 10, got %d and %d", stage.TargetRqstRate, stage.TargetConcurrentRqsts)
+	}
+	if stage.RqstStats.TotalRqsts != 1000 || math.Abs(stage.RqstRatePerSec-100) > 0.001 {
+		t.Errorf("expected 1000 requests at 100 rqsts/sec, got %d at %f", stage.RqstStats.TotalRqsts, stage.RqstRatePerSec)
+	}
+	if !stage.RqstStats.TimingResultsNanos.Equivalent(stage.RqstStats.MaxRqstDurationNanos, 2*time.Millisecond) {
+		t.Errorf("expected a max of 2ms, got %s", stage.RqstStats.MaxRqstDurationNanos)
+	}
+
+	// Scenarios are merged by position
+	newScenarioResults := func(numIters int, latency time.Duration) api.RunResults {
+		rh := ResponseHandler{Scenarios: []api.Scenario{{Name: "user", Steps: []api.Step{
+			{Name: "get", Endpoint: api.Endpoint{URL: "http://someurl/users", Method: http.MethodGet}},
+		}}}}
+		rr := rh.newRunResults()
+		for i := 0; i < numIters; i++ {
+			accumulateScenarioStats(rr.ScenarioSummaries, Response{Scenario: "user", Step: 1, RequestDuration: latency,
+				IterationDone: true, IterationDuration: latency})
+		}
+		return rr
+	}
+	merged = MergeRunResults([]api.RunResults{newScenarioResults(10, time.Millisecond), newScenarioResults(30, 2*time.Millisecond)})
+	if len(merged.ScenarioSummaries) != 1 || len(merged.ScenarioSummaries[0].Steps) != 1 {
+		t.Fatalf("expected 1 scenario with 1 step, got %+v", merged.ScenarioSummaries)
+	}
+	sc := merged.ScenarioSummaries[0]
+	if sc.IterationStats.TotalRqsts != 40 || sc.Steps[0].RqstStats.TotalRqsts != 40 {
+		t.Errorf("expected 40 iterations and step requests, got %d and %d", sc.IterationStats.TotalRqsts, sc.Steps[0].RqstStats.TotalRqsts)
+	}
+	if !sc.IterationStats.TimingResultsNanos.Equivalent(sc.IterationStats.MinRqstDurationNanos, time.Millisecond) {
+		t.Errorf("expected a min iteration duration of 1ms, got %s", sc.IterationStats.MinRqstDurationNanos)
+	}
+
+	// Nothing to merge
+	if merged = MergeRunResults(nil); merged.RunSummary.RqstStats.TotalRqsts != 0 {
+		t.Errorf("expected no requests, got %d", merged.RunSummary.RqstStats.TotalRqsts)
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+)
+
+// metricsNamespace prefixes the names of all the metrics exposed by Metrics
+const metricsNamespace = "heyyall"
+
+// achievedRateInterval is how often the achieved request rate is recalculated
+const achievedRateInterval = time.Second
+
+// Metrics exposes live Prometheus metrics describing a run. Requests, errors, and
+// latencies are fed from the responses received by the ResponseHandler, in-flight
+// requests from the Requestor. A nil *Metrics can be used, it does nothing.
+type Metrics struct {
+	registry     *prometheus.Registry
+	rqsts        *prometheus.CounterVec
+	errs         *prometheus.CounterVec
+	latency      *prometheus.HistogramVec
+	dropped      prometheus.Counter
+	inFlight     prometheus.Gauge
+	achievedRate prometheus.Gauge
+
+	// targetRate returns the target request rate 'elapsed' after the start of the run
+	targetRate func(elapsed time.Duration) float64
+
+	mu sync.Mutex
+	// start is when the run started
+	start time.Time
+	// numRqsts is the number of responses received, it's used to calculate the achieved rate
+	numRqsts int64
+	server   *http.Server
+}
+
+// NewMetrics returns a Metrics. 'targetRate' returns the target request rate 'elapsed'
+// after the start of the run, or 0 if the rate isn't limited.
+func NewMetrics(targetRate func(elapsed time.Duration) float64) *Metrics {
+	m := &Metrics{
+		registry:   prometheus.NewRegistry(),
+		targetRate: targetRate,
+		rqsts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "requests_total",
+			Help:      "Number of requests completed, by endpoint URL, HTTP method, and HTTP status. Failed requests have a status of 0.",
+		}, []string{"url", "method", "status"}),
+		errs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "request_errors_total",
+			Help:      "Number of requests that failed to get a response, or whose response couldn't be read, by endpoint URL, HTTP method, and error type.",
+		}, []string{"url", "method", "error"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "request_duration_seconds",
+			Help:      "Latency of successful requests by endpoint URL and HTTP method.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 15),
+		}, []string{"url", "method"}),
+		dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "dropped_requests_total",
+			Help:      "Number of requests that weren't sent because all concurrent requests were in progress.",
+		}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "requests_in_flight",
+			Help:      "Number of requests that have been sent, but haven't completed.",
+		}),
+		achievedRate: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "achieved_request_rate",
+			Help:      "Requests completed per second over the last second.",
+		}),
+	}
+
+	targetRateGauge := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "target_request_rate",
+		Help:      "Target requests per second, 0 if the request rate isn't limited.",
+	}, m.currentTargetRate)
+
+	m.registry.MustRegister(m.rqsts, m.errs, m.latency, m.dropped, m.inFlight, m.achievedRate, targetRateGauge)
+	return m
+}
+
+// Start serves the metrics, at '/metrics' on 'addr', until 'ctx' is done. It also
+// marks the start of the run.
+func (m *Metrics) Start(ctx context.Context, addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{EnableOpenMetrics: true}))
+
+	m.mu.Lock()
+	m.start = time.Now()
+	m.server = &http.Server{Handler: mux}
+	m.mu.Unlock()
+
+	go func() {
+		err := m.server.Serve(ln)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Err(err).Msg("metrics server failed")
+		}
+	}()
+	go m.updateAchievedRate(ctx)
+
+	return nil
+}
+
+// Stop stops serving the metrics
+func (m *Metrics) Stop() {
+	if m == nil || m.server == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	m.server.Shutdown(ctx)
+}
+
+// updateAchievedRate recalculates the achieved request rate every achievedRateInterval
+// until 'ctx' is done
+func (m *Metrics) updateAchievedRate(ctx context.Context) {
+	ticker := time.NewTicker(achievedRateInterval)
+	defer ticker.Stop()
+
+	var prevRqsts int64
+	prevTime := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.mu.Lock()
+			numRqsts := m.numRqsts
+			m.mu.Unlock()
+			m.achievedRate.Set(float64(numRqsts-prevRqsts) / now.Sub(prevTime).Seconds())
+			prevRqsts, prevTime = numRqsts, now
+		}
+	}
+}
+
+func (m *Metrics) currentTargetRate() float64 {
+	m.mu.Lock()
+	start := m.start
+	m.mu.Unlock()
+	if m.targetRate == nil || start.IsZero() {
+		return 0
+	}
+	return m.targetRate(time.Since(start))
+}
+
+// observe records 'resp'
+func (m *Metrics) observe(resp Response) {
+	if m == nil {
+		return
+	}
+	if resp.DroppedRqsts > 0 {
+		m.dropped.Add(float64(resp.DroppedRqsts))
+		return
+	}
+
+	m.mu.Lock()
+	m.numRqsts++
+	m.mu.Unlock()
+
+	url, method := resp.Endpoint.URL, resp.Endpoint.Method
+	if resp.ErrorType != "" {
+		m.rqsts.WithLabelValues(url, method, "0").Inc()
+		m.errs.WithLabelValues(url, method, resp.ErrorType).Inc()
+		return
+	}
+	m.rqsts.WithLabelValues(url, method, strconv.Itoa(resp.HTTPStatus)).Inc()
+	if failure := resp.failure(); failure != "" {
+		m.errs.WithLabelValues(url, method, failure).Inc()
+		return
+	}
+	m.latency.WithLabelValues(url, method).Observe(resp.RequestDuration.Seconds())
+}
+
+// rqstStarted records that a request has been sent
+func (m *Metrics) rqstStarted() {
+	if m == nil {
+		return
+	}
+	m.inFlight.Inc()
+}
+
+// rqstDone records that a request sent by rqstStarted() has completed
+func (m *Metrics) rqstDone() {
+	if m == nil {
+		return
+	}
+	m.inFlight.Dec()
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/youngkin/heyyall/api"
+)
+
+func TestMetrics(t *testing.T) {
+	url := "http://someurl/users"
+	m := NewMetrics(func(elapsed time.Duration) float64 { return 100 })
+
+	for i := 0; i < 5; i++ {
+		m.observe(Response{HTTPStatus: http.StatusOK, Endpoint: api.Endpoint{URL: url, Method: http.MethodGet},
+			RequestDuration: 10 * time.Millisecond})
+	}
+	m.observe(Response{HTTPStatus: http.StatusNotFound, Endpoint: api.Endpoint{URL: url, Method: http.MethodGet},
+		RequestDuration: 10 * time.Millisecond})
+	m.observe(Response{ErrorType: api.ErrConnRefused, Endpoint: api.Endpoint{URL: url, Method: http.MethodPost}})
+	m.observe(Response{DroppedRqsts: 3, Endpoint: api.Endpoint{URL: url, Method: http.MethodGet}})
+	m.rqstStarted()
+	m.rqstStarted()
+	m.rqstDone()
+
+	tests := []struct {
+		name     string
+		actual   float64
+		expected float64
+	}{
+		{name: "200s", actual: testutil.ToFloat64(m.rqsts.WithLabelValues(url, http.MethodGet, "200")), expected: 5},
+		{name: "404s", actual: testutil.ToFloat64(m.rqsts.WithLabelValues(url, http.MethodGet, "404")), expected: 1},
+		{name: "FailedRqsts", actual: testutil.ToFloat64(m.rqsts.WithLabelValues(url, http.MethodPost, "0")), expected: 1},
+		{name: "Errors", actual: testutil.ToFloat64(m.errs.WithLabelValues(url, http.MethodPost, api.ErrConnRefused)), expected: 1},
+		{name: "Dropped", actual: testutil.ToFloat64(m.dropped), expected: 3},
+		{name: "InFlight", actual: testutil.ToFloat64(m.inFlight), expected: 1},
+		// The target rate is 0 until the run starts
+		{name: "TargetRate", actual: m.currentTargetRate(), expected: 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.actual != tc.expected {
+				t.Errorf("expected %f, got %f", tc.expected, tc.actual)
+			}
+		})
+	}
+
+	srv := httptest.NewServer(promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("unexpected error getting metrics: %s", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("unexpected error reading metrics: %s", err)
+	}
+	expected := []string{
+		`heyyall_requests_total{method="GET",status="200",url="http://someurl/users"} 5`,
+		`heyyall_request_duration_seconds_count{method="GET",url="http://someurl/users"} 6`,
+		`heyyall_requests_in_flight 1`,
+		`heyyall_dropped_requests_total 3`,
+		`heyyall_target_request_rate 0`,
+		`heyyall_achieved_request_rate 0`,
+	}
+	for _, e := range expected {
+		if !strings.Contains(string(body), e) {
+			t.Errorf("expected metrics to contain %q", e)
+		}
+	}
+
+	// A nil Metrics does nothing
+	var nilMetrics *Metrics
+	nilMetrics.observe(Response{HTTPStatus: http.StatusOK})
+	nilMetrics.rqstStarted()
+	nilMetrics.rqstDone()
+	nilMetrics.Stop()
+}
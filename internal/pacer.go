@@ -0,0 +1,332 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/youngkin/heyyall/api"
+)
+
+// Stage interpolation types, see api.LoadTestConfig.StageInterpolation
+const (
+	// LinearInterpolation ramps linearly between stage targets
+	LinearInterpolation = "linear"
+	// StepInterpolation applies each stage's targets for the entire stage
+	StepInterpolation = "step"
+)
+
+// stagePollInterval is the longest a stagedPacer will wait before re-evaluating
+// the current rate and concurrency targets.
+const stagePollInterval = 100 * time.Millisecond
+
+// Pacer determines when a Requestor sends each of its requests
+type Pacer interface {
+	// Next blocks until the next request is due. It returns the time the request
+	// was due and the index of the load stage it belongs to. 'ok' is false when
+	// there are no more requests to send or 'ctx' is done.
+	Next(ctx context.Context) (due time.Time, stage int, ok bool)
+}
+
+// ratePacer paces the requests sent by a single goroutine at a constant average rate
+// for a fixed number of requests. Since requests are sent one after the other a
+// request is never sent before the previous one completes.
+type ratePacer struct {
+	remaining int
+	// interval is the average time between requests
+	interval time.Duration
+	arrival  arrivalProcess
+	// factor scales 'interval' to get the time between the last request and the next one
+	factor float64
+	last   time.Time
+}
+
+// newRatePacer returns a Pacer that allows 'numRqsts' requests to be sent at an average of
+// 'rqstRate' requests per second with the times between requests determined by 'arrival'.
+// A 'numRqsts' of 0 allows api.MaxRqsts requests and a 'rqstRate' of 0 is completely unthrottled.
+func newRatePacer(numRqsts int, rqstRate int, arrival arrivalProcess) *ratePacer {
+	if numRqsts == 0 {
+		log.Debug().Msgf("newRatePacer: numRqsts was 0, setting to %d", api.MaxRqsts)
+		numRqsts = api.MaxRqsts
+	}
+	p := ratePacer{remaining: numRqsts, arrival: arrival}
+	if rqstRate > 0 {
+		p.interval = time.Second / time.Duration(rqstRate)
+	}
+	return &p
+}
+
+func (p *ratePacer) Next(ctx context.Context) (time.Time, int, bool) {
+	if p.remaining <= 0 {
+		return time.Time{}, 0, false
+	}
+	p.remaining--
+
+	if !p.last.IsZero() && p.interval > 0 {
+		if !sleepCtx(ctx, time.Until(p.last.Add(scaleInterval(p.interval, p.factor)))) {
+			return time.Time{}, 0, false
+		}
+	}
+	p.last = time.Now()
+	p.factor = p.arrival.next(p.interval)
+	return p.last, 0, true
+}
+
+// stagedPacer paces the requests sent by a single goroutine, one of several sending
+// requests to the same endpoint, according to a LoadProfile. The goroutine's share of
+// the endpoint's request rate changes as the number of active goroutines changes.
+// Goroutines beyond the number needed to meet the current concurrency target don't
+// send any requests until the concurrency target increases.
+type stagedPacer struct {
+	profile *LoadProfile
+	// epShare is the endpoint's share of the overall request rate and concurrency,
+	// i.e., Endpoint.RqstPercent/100
+	epShare float64
+	// worker is the index of the goroutine among those sending requests to the endpoint
+	worker    int
+	remaining int
+	arrival   arrivalProcess
+	// factor scales the current average interval to get the time between the last
+	// request and the next one
+	factor float64
+	last   time.Time
+}
+
+func (p *stagedPacer) Next(ctx context.Context) (time.Time, int, bool) {
+	if p.remaining <= 0 {
+		return time.Time{}, 0, false
+	}
+
+	for {
+		rate, concurrency, stage, ok := p.profile.at(time.Since(p.profile.start))
+		if !ok {
+			return time.Time{}, 0, false
+		}
+
+		active := int(math.Ceil(float64(concurrency) * p.epShare))
+		// A linear stage's rate is zero at the start of a ramp from zero and at the end of a
+		// ramp to zero, the rate of other stages is zero if it's unlimited
+		if p.worker >= active || (p.profile.linear && rate <= 0) {
+			if !sleepCtx(ctx, stagePollInterval) {
+				return time.Time{}, 0, false
+			}
+			continue
+		}
+
+		var interval time.Duration
+		if rate > 0 {
+			interval = time.Duration(float64(time.Second) * float64(active) / (rate * p.epShare))
+		}
+		// A linear profile's first requests are paced from the start of the run so that
+		// a ramp from a low rate starts gradually
+		if p.last.IsZero() && p.profile.linear {
+			p.last = p.profile.start
+			p.factor = p.arrival.next(interval)
+		}
+		wait := time.Until(p.last.Add(scaleInterval(interval, p.factor)))
+		if p.last.IsZero() || wait <= 0 {
+			p.remaining--
+			p.last = time.Now()
+			p.factor = p.arrival.next(interval)
+			return p.last, stage, true
+		}
+
+		// Wait in short increments so rate changes are picked up promptly
+		if wait > stagePollInterval {
+			wait = stagePollInterval
+		}
+		if !sleepCtx(ctx, wait) {
+			return time.Time{}, 0, false
+		}
+	}
+}
+
+// sleepCtx sleeps for 'd'. It returns false if 'ctx' is done before 'd' elapses.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// loadStage is a parsed api.Stage
+type loadStage struct {
+	dur         time.Duration
+	rqstRate    float64
+	concurrency int
+	// fromRate, if not nil, is the rate a linear stage ramps from
+	fromRate *float64
+}
+
+// LoadProfile describes how the targeted request rate and concurrency change over the
+// course of a staged run.
+type LoadProfile struct {
+	stages []loadStage
+	linear bool
+	// initRate and initConcurrency are the targets the first stage ramps from
+	initRate        float64
+	initConcurrency int
+	start           time.Time
+}
+
+// NewLoadProfile returns the LoadProfile described by 'config'. It returns nil if
+// 'config' doesn't specify any stages.
+func NewLoadProfile(config api.LoadTestConfig) (*LoadProfile, error) {
+	if len(config.Stages) == 0 {
+		return nil, nil
+	}
+
+	runDur := time.Duration(0)
+	if config.RunDuration != "" {
+		var err error
+		runDur, err = time.ParseDuration(config.RunDuration)
+		if err != nil {
+			return nil, fmt.Errorf("RunDuration %s is invalid: %w", config.RunDuration, err)
+		}
+	}
+	if runDur > 0 || config.NumRequests > 0 {
+		return nil, fmt.Errorf("RunDuration is %s and NumRequests is %d, both must be zero when Stages are specified",
+			config.RunDuration, config.NumRequests)
+	}
+
+	p := LoadProfile{}
+	switch config.StageInterpolation {
+	case "", LinearInterpolation:
+		p.linear = true
+	case StepInterpolation:
+	default:
+		return nil, fmt.Errorf("StageInterpolation %s is invalid, it must be either %s or %s",
+			config.StageInterpolation, LinearInterpolation, StepInterpolation)
+	}
+
+	for i, s := range config.Stages {
+		dur, err := time.ParseDuration(s.Duration)
+		if err != nil {
+			return nil, fmt.Errorf("stage %d: Duration %s is invalid: %w", i+1, s.Duration, err)
+		}
+		if dur <= 0 {
+			return nil, fmt.Errorf("stage %d: Duration %s must be greater than zero", i+1, s.Duration)
+		}
+		if s.MaxConcurrentRqsts < 1 {
+			return nil, fmt.Errorf("stage %d: MaxConcurrentRqsts must be at least 1, not %d", i+1, s.MaxConcurrentRqsts)
+		}
+		// A linear stage with a RqstRate of 0 ramps down to, or stays at, no requests
+		if s.RqstRate < 0 {
+			return nil, fmt.Errorf("stage %d: RqstRate is %d, it can't be negative", i+1, s.RqstRate)
+		}
+		ls := loadStage{dur: dur, rqstRate: float64(s.RqstRate), concurrency: s.MaxConcurrentRqsts}
+		if s.FromRqstRate != nil {
+			if !p.linear {
+				return nil, fmt.Errorf("stage %d: FromRqstRate can only be specified when StageInterpolation is %s",
+					i+1, LinearInterpolation)
+			}
+			if *s.FromRqstRate < 0 {
+				return nil, fmt.Errorf("stage %d: FromRqstRate is %d, it can't be negative", i+1, *s.FromRqstRate)
+			}
+			fromRate := float64(*s.FromRqstRate)
+			ls.fromRate = &fromRate
+		}
+		p.stages = append(p.stages, ls)
+	}
+
+	p.initRate, p.initConcurrency = p.stages[0].rqstRate, p.stages[0].concurrency
+	if config.RqstRate > 0 {
+		p.initRate = float64(config.RqstRate)
+	}
+	if config.MaxConcurrentRqsts > 0 {
+		p.initConcurrency = config.MaxConcurrentRqsts
+	}
+
+	return &p, nil
+}
+
+// Duration returns the total duration of all stages
+func (p *LoadProfile) Duration() time.Duration {
+	var dur time.Duration
+	for _, s := range p.stages {
+		dur += s.dur
+	}
+	return dur
+}
+
+// MaxConcurrency returns the largest concurrency targeted at any point in the profile
+func (p *LoadProfile) MaxConcurrency() int {
+	max := 0
+	if p.linear {
+		max = p.initConcurrency
+	}
+	for _, s := range p.stages {
+		if s.concurrency > max {
+			max = s.concurrency
+		}
+	}
+	return max
+}
+
+// StageInfo returns the duration, target request rate, and target concurrency of
+// the stage at 'index'.
+func (p *LoadProfile) StageInfo(index int) (dur time.Duration, rqstRate int, concurrency int) {
+	s := p.stages[index]
+	return s.dur, int(s.rqstRate), s.concurrency
+}
+
+// NumStages returns the number of stages in the profile
+func (p *LoadProfile) NumStages() int {
+	return len(p.stages)
+}
+
+// TargetRate returns the target request rate 'elapsed' time after the start of the run.
+// It's 0 once the last stage has ended.
+func (p *LoadProfile) TargetRate(elapsed time.Duration) float64 {
+	rate, _, _, ok := p.at(elapsed)
+	if !ok {
+		return 0
+	}
+	return rate
+}
+
+// begin marks the start of the run
+func (p *LoadProfile) begin() {
+	p.start = time.Now()
+}
+
+// at returns the target request rate and concurrency, and the index of the current stage,
+// 'elapsed' time after the start of the run. 'ok' is false if 'elapsed' is beyond the end
+// of the last stage.
+func (p *LoadProfile) at(elapsed time.Duration) (rate float64, concurrency int, stage int, ok bool) {
+	prevRate, prevConcurrency := p.initRate, p.initConcurrency
+	for i, s := range p.stages {
+		if elapsed >= s.dur {
+			elapsed -= s.dur
+			prevRate, prevConcurrency = s.rqstRate, s.concurrency
+			continue
+		}
+		if !p.linear {
+			return s.rqstRate, s.concurrency, i, true
+		}
+		if s.fromRate != nil {
+			prevRate = *s.fromRate
+		}
+		frac := float64(elapsed) / float64(s.dur)
+		rate = prevRate + (s.rqstRate-prevRate)*frac
+		concurrency = int(math.Round(float64(prevConcurrency) + float64(s.concurrency-prevConcurrency)*frac))
+		if concurrency < 1 {
+			concurrency = 1
+		}
+		return rate, concurrency, i, true
+	}
+	return 0, 0, len(p.stages), false
+}
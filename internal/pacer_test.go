@@ -0,0 +1,250 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+func TestNewLoadProfile(t *testing.T) {
+	stages := []api.Stage{
+		{Duration: "10s", RqstRate: 100, MaxConcurrentRqsts: 10},
+		{Duration: "1m", RqstRate: 100, MaxConcurrentRqsts: 20},
+	}
+
+	tests := []struct {
+		name       string
+		config     api.LoadTestConfig
+		shouldFail bool
+		isNil      bool
+		dur        time.Duration
+		maxConc    int
+	}{
+		{name: "NoStages", config: api.LoadTestConfig{RunDuration: "10s"}, isNil: true},
+		{name: "Stages", config: api.LoadTestConfig{RunDuration: "0s", Stages: stages}, dur: 70 * time.Second, maxConc: 20},
+		{name: "RampFromInitialConcurrency", config: api.LoadTestConfig{MaxConcurrentRqsts: 50, Stages: stages},
+			dur: 70 * time.Second, maxConc: 50},
+		{name: "StepIgnoresInitialConcurrency", config: api.LoadTestConfig{MaxConcurrentRqsts: 50, Stages: stages,
+			StageInterpolation: StepInterpolation}, dur: 70 * time.Second, maxConc: 20},
+		{name: "FailPath - RunDuration specified", config: api.LoadTestConfig{RunDuration: "10s", Stages: stages}, shouldFail: true},
+		{name: "FailPath - NumRequests specified", config: api.LoadTestConfig{NumRequests: 10, Stages: stages}, shouldFail: true},
+		{name: "FailPath - invalid interpolation", config: api.LoadTestConfig{Stages: stages, StageInterpolation: "cubic"},
+			shouldFail: true},
+		{name: "FailPath - invalid duration", config: api.LoadTestConfig{Stages: []api.Stage{
+			{Duration: "10x", RqstRate: 1, MaxConcurrentRqsts: 1}}}, shouldFail: true},
+		{name: "FailPath - zero concurrency", config: api.LoadTestConfig{Stages: []api.Stage{
+			{Duration: "10s", RqstRate: 1}}}, shouldFail: true},
+		{name: "LinearZeroRate", config: api.LoadTestConfig{Stages: []api.Stage{
+			{Duration: "10s", MaxConcurrentRqsts: 1}}}, dur: 10 * time.Second, maxConc: 1},
+		{name: "FailPath - negative rate", config: api.LoadTestConfig{Stages: []api.Stage{
+			{Duration: "10s", RqstRate: -1, MaxConcurrentRqsts: 1}}}, shouldFail: true},
+		{name: "StepZeroRate", config: api.LoadTestConfig{StageInterpolation: StepInterpolation, Stages: []api.Stage{
+			{Duration: "10s", MaxConcurrentRqsts: 1}}}, dur: 10 * time.Second, maxConc: 1},
+		{name: "FromZeroRate", config: api.LoadTestConfig{Stages: []api.Stage{
+			{Duration: "10s", FromRqstRate: new(int), RqstRate: 1, MaxConcurrentRqsts: 1}}}, dur: 10 * time.Second, maxConc: 1},
+		{name: "FailPath - negative FromRqstRate", config: api.LoadTestConfig{Stages: []api.Stage{
+			{Duration: "10s", FromRqstRate: intPtr(-1), RqstRate: 1, MaxConcurrentRqsts: 1}}}, shouldFail: true},
+		{name: "FailPath - step FromRqstRate", config: api.LoadTestConfig{StageInterpolation: StepInterpolation, Stages: []api.Stage{
+			{Duration: "10s", FromRqstRate: new(int), RqstRate: 1, MaxConcurrentRqsts: 1}}}, shouldFail: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := NewLoadProfile(tc.config)
+			if tc.shouldFail {
+				if err == nil {
+					t.Errorf("expected error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if tc.isNil {
+				if p != nil {
+					t.Errorf("expected nil LoadProfile, got %+v", p)
+				}
+				return
+			}
+			if p.Duration() != tc.dur || p.MaxConcurrency() != tc.maxConc {
+				t.Errorf("expected duration %s and max concurrency %d, got %s and %d", tc.dur, tc.maxConc,
+					p.Duration(), p.MaxConcurrency())
+			}
+		})
+	}
+}
+
+func TestLoadProfileAt(t *testing.T) {
+	stages := []api.Stage{
+		{Duration: "10s", RqstRate: 100, MaxConcurrentRqsts: 10},
+		{Duration: "10s", RqstRate: 100, MaxConcurrentRqsts: 10},
+		{Duration: "10s", RqstRate: 10, MaxConcurrentRqsts: 2},
+	}
+
+	tests := []struct {
+		name          string
+		interpolation string
+		elapsed       time.Duration
+		rate          float64
+		concurrency   int
+		stage         int
+		ok            bool
+	}{
+		{name: "LinearStart", interpolation: LinearInterpolation, elapsed: 0, rate: 10, concurrency: 1, stage: 0, ok: true},
+		{name: "LinearRampUp", interpolation: LinearInterpolation, elapsed: 5 * time.Second, rate: 55, concurrency: 6, stage: 0, ok: true},
+		{name: "LinearSteady", interpolation: LinearInterpolation, elapsed: 15 * time.Second, rate: 100, concurrency: 10, stage: 1, ok: true},
+		{name: "LinearRampDown", interpolation: LinearInterpolation, elapsed: 25 * time.Second, rate: 55, concurrency: 6, stage: 2, ok: true},
+		{name: "StepStart", interpolation: StepInterpolation, elapsed: 0, rate: 100, concurrency: 10, stage: 0, ok: true},
+		{name: "StepLast", interpolation: StepInterpolation, elapsed: 25 * time.Second, rate: 10, concurrency: 2, stage: 2, ok: true},
+		{name: "Finished", interpolation: StepInterpolation, elapsed: 30 * time.Second, stage: 3, ok: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := NewLoadProfile(api.LoadTestConfig{RqstRate: 10, MaxConcurrentRqsts: 1, Stages: stages,
+				StageInterpolation: tc.interpolation})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			rate, concurrency, stage, ok := p.at(tc.elapsed)
+			if rate != tc.rate || concurrency != tc.concurrency || stage != tc.stage || ok != tc.ok {
+				t.Errorf("expected %f, %d, %d, %t, got %f, %d, %d, %t", tc.rate, tc.concurrency, tc.stage, tc.ok,
+					rate, concurrency, stage, ok)
+			}
+		})
+	}
+}
+
+// TestLoadProfileFromRqstRate verifies that a linear stage with a FromRqstRate ramps from
+// it rather than from the previous stage's rate, and that a ramp from zero starts gradually
+func TestLoadProfileFromRqstRate(t *testing.T) {
+	p, err := NewLoadProfile(api.LoadTestConfig{Stages: []api.Stage{
+		{Duration: "500ms", FromRqstRate: new(int), RqstRate: 40, MaxConcurrentRqsts: 1},
+		{Duration: "10s", FromRqstRate: intPtr(20), RqstRate: 40, MaxConcurrentRqsts: 1},
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, tc := range []struct {
+		elapsed time.Duration
+		rate    float64
+	}{{elapsed: 0, rate: 0}, {elapsed: 250 * time.Millisecond, rate: 20}, {elapsed: 5500 * time.Millisecond, rate: 30}} {
+		if rate, _, _, _ := p.at(tc.elapsed); rate != tc.rate {
+			t.Errorf("expected a rate of %f after %s, got %f", tc.rate, tc.elapsed, rate)
+		}
+	}
+
+	p.stages = p.stages[:1]
+	p.begin()
+	pacer := &stagedPacer{profile: p, epShare: 1, worker: 0, remaining: api.MaxRqsts, arrival: constantArrival{}}
+	var dues []time.Time
+	for due, _, ok := pacer.Next(context.Background()); ok; due, _, ok = pacer.Next(context.Background()) {
+		dues = append(dues, due)
+	}
+	// Ramping from 0 to 40 rqsts/sec over 500ms is 10 requests, the first after about 110ms
+	if len(dues) < 7 || len(dues) > 12 {
+		t.Errorf("expected about 10 requests, got %d", len(dues))
+	}
+	if len(dues) > 0 && dues[0].Sub(p.start) < 100*time.Millisecond {
+		t.Errorf("expected the first request to be sent after at least 100ms, it was sent after %s", dues[0].Sub(p.start))
+	}
+}
+
+// TestLoadProfileToZeroRate verifies that a linear stage ramps down to a RqstRate of 0
+func TestLoadProfileToZeroRate(t *testing.T) {
+	p, err := NewLoadProfile(api.LoadTestConfig{Stages: []api.Stage{
+		{Duration: "500ms", FromRqstRate: intPtr(40), RqstRate: 0, MaxConcurrentRqsts: 1},
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if rate, _, _, _ := p.at(250 * time.Millisecond); rate != 20 {
+		t.Errorf("expected a rate of 20 halfway through the ramp, got %f", rate)
+	}
+
+	p.begin()
+	pacer := &stagedPacer{profile: p, epShare: 1, worker: 0, remaining: api.MaxRqsts, arrival: constantArrival{}}
+	var dues []time.Time
+	for due, _, ok := pacer.Next(context.Background()); ok; due, _, ok = pacer.Next(context.Background()) {
+		dues = append(dues, due)
+	}
+	// Ramping from 40 to 0 rqsts/sec over 500ms is 10 requests, most of them early on
+	if len(dues) < 7 || len(dues) > 12 {
+		t.Errorf("expected about 10 requests, got %d", len(dues))
+	}
+	if len(dues) > 0 && dues[len(dues)-1].Sub(p.start) > 450*time.Millisecond {
+		t.Errorf("expected no requests near the end of the ramp, the last was sent after %s", dues[len(dues)-1].Sub(p.start))
+	}
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+// TestStagedPacer validates that a stagedPacer sends requests at the stage's rate,
+// that workers beyond the stage's concurrency don't send requests, and that no
+// requests are sent after the last stage.
+func TestStagedPacer(t *testing.T) {
+	p, err := NewLoadProfile(api.LoadTestConfig{
+		Stages:             []api.Stage{{Duration: "500ms", RqstRate: 40, MaxConcurrentRqsts: 1}},
+		StageInterpolation: StepInterpolation,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	p.begin()
+
+	active := &stagedPacer{profile: p, epShare: 1, worker: 0, remaining: api.MaxRqsts, arrival: constantArrival{}}
+	idle := &stagedPacer{profile: p, epShare: 1, worker: 1, remaining: api.MaxRqsts, arrival: constantArrival{}}
+
+	idleC := make(chan int)
+	go func() {
+		numRqsts := 0
+		for _, _, ok := idle.Next(context.Background()); ok; _, _, ok = idle.Next(context.Background()) {
+			numRqsts++
+		}
+		idleC <- numRqsts
+	}()
+
+	numRqsts := 0
+	for _, stage, ok := active.Next(context.Background()); ok; _, stage, ok = active.Next(context.Background()) {
+		if stage != 0 {
+			t.Errorf("expected stage 0, got %d", stage)
+		}
+		numRqsts++
+	}
+	// 40 rqsts/sec for 500ms
+	if numRqsts < 18 || numRqsts > 21 {
+		t.Errorf("expected about 20 requests, got %d", numRqsts)
+	}
+	if idleRqsts := <-idleC; idleRqsts != 0 {
+		t.Errorf("expected no requests from idle worker, got %d", idleRqsts)
+	}
+}
+
+func TestRatePacer(t *testing.T) {
+	p := newRatePacer(3, 0, constantArrival{})
+	numRqsts := 0
+	for _, _, ok := p.Next(context.Background()); ok; _, _, ok = p.Next(context.Background()) {
+		numRqsts++
+	}
+	if numRqsts != 3 {
+		t.Errorf("expected 3 requests, got %d", numRqsts)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p = newRatePacer(3, 1, constantArrival{})
+	if _, _, ok := p.Next(ctx); !ok {
+		t.Errorf("expected first request to be sent immediately")
+	}
+	if _, _, ok := p.Next(ctx); ok {
+		t.Errorf("expected no requests after context cancelled")
+	}
+}
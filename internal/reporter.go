@@ -8,7 +8,7 @@ import (
 	"fmt"
 	"math"
 	"os"
-	"sort"
+	"strings"
 	"text/template"
 	"time"
 
@@ -17,9 +17,9 @@ import (
 )
 
 // OutputType specifies the output formate of the final report. There are
-// 2 values, 'text' and 'json'. 'text' will present a human readable form.
+// 3 values, 'text', 'json', and 'html'. 'text' will present a human readable form.
 // 'json' will present the JSON structures that capture the detailed run
-// stats.
+// stats. 'html' will present a standalone HTML page with charts.
 type OutputType int
 
 const (
@@ -27,16 +27,24 @@ const (
 	Text OutputType = iota
 	// JSON indicates detailed reporting stats will be produced
 	JSON
+	// HTML indicates a self-contained HTML report, with charts, will be produced
+	HTML
 )
 
 var tmpltFuncs = template.FuncMap{
-	"formatFloat":      formatFloat,
-	"formatSeconds":    formatSeconds,
-	"formatPercentile": formatPercentile,
-	"formatMethod":     formatMethod,
-	"format100Million": format100Million,
+	"formatFloat":       formatFloat,
+	"formatSeconds":     formatSeconds,
+	"formatPercentile":  formatPercentile,
+	"formatMethod":      formatMethod,
+	"format100Million":  format100Million,
+	"formatPercent":     formatPercent,
+	"describeThreshold": describeThreshold,
+	"formatBar":         formatBar,
 }
 
+// timelineBarWidth is the width, in characters, of the longest bar in the time series timeline
+const timelineBarWidth = 25
+
 func formatFloat(f float64) string {
 	return fmt.Sprintf("%4.4f", f)
 }
@@ -45,8 +53,8 @@ func formatSeconds(d time.Duration) string {
 	return fmt.Sprintf("%04.4f", d.Seconds())
 }
 
-func formatPercentile(p int, d []time.Duration) string {
-	val := calcPercentiles(p, d)
+func formatPercentile(p float64, h *api.Histogram) string {
+	val := calcPercentiles(p, h)
 	return formatSeconds(val)
 }
 
@@ -66,16 +74,37 @@ func format100Million(i int64) string {
 	return fmt.Sprintf("%9v", i)
 }
 
+// formatBar returns a bar, padded to timelineBarWidth, whose length is proportional to
+// 'val' relative to 'max'
+func formatBar(val, max float64) string {
+	n := 0
+	if max > 0 {
+		n = int(math.Round(val / max * timelineBarWidth))
+	}
+	return fmt.Sprintf("%-*s", timelineBarWidth, strings.Repeat("#", n))
+}
+
+// formatPercent returns 'part' as a percentage of 'whole'
+func formatPercent(part, whole int64) string {
+	if whole == 0 {
+		return formatFloat(0)
+	}
+	return formatFloat(float64(part) * 100 / float64(whole))
+}
+
 var runSummTmplt = `
 Run Summary:
 	        Total Rqsts: {{ .RqstStats.TotalRqsts }}
 	          Rqsts/sec: {{ formatFloat .RqstRatePerSec }}
+	  Offered Rqsts/sec: {{ formatFloat .OfferedRqstRatePerSec }}
+	         Late Rqsts: {{ .LateRqsts }}
+	      Dropped Rqsts: {{ .DroppedRqsts }}
 	Run Duration (secs): {{ formatSeconds .RunDurationNanos }}
 `
 
 var rqstLatencyTmplt = `
-Request Latency (secs): Min      Median   P75      P90      P95      P99
-	                    {{ formatPercentile 0 .TimingResultsNanos }}   {{  formatPercentile 50 .TimingResultsNanos }}   {{  formatPercentile 75 .TimingResultsNanos }}   {{  formatPercentile 90 .TimingResultsNanos }}   {{  formatPercentile 95 .TimingResultsNanos }}   {{  formatPercentile 99 .TimingResultsNanos }}
+Request Latency (secs): Min      Median   P75      P90      P95      P99      P99.9    P99.99
+	                    {{ formatPercentile 0 .TimingResultsNanos }}   {{  formatPercentile 50 .TimingResultsNanos }}   {{  formatPercentile 75 .TimingResultsNanos }}   {{  formatPercentile 90 .TimingResultsNanos }}   {{  formatPercentile 95 .TimingResultsNanos }}   {{  formatPercentile 99 .TimingResultsNanos }}   {{  formatPercentile 99.9 .TimingResultsNanos }}   {{  formatPercentile 99.99 .TimingResultsNanos }}
 `
 
 var netDetailsTmplt = `
@@ -84,7 +113,8 @@ Network Details (secs):
 	    DNS Lookup: {{ formatPercentile 0 .DNSLookupNanos }}   {{ formatPercentile 50 .DNSLookupNanos }}   {{ formatPercentile 75 .DNSLookupNanos }}   {{ formatPercentile 90 .DNSLookupNanos }}   {{ formatPercentile 95 .DNSLookupNanos }}   {{ formatPercentile 99 .DNSLookupNanos }}       
 	TCP Conn Setup: {{ formatPercentile 0 .TCPConnSetupNanos }}   {{ formatPercentile 50 .TCPConnSetupNanos }}   {{ formatPercentile 75 .TCPConnSetupNanos }}   {{ formatPercentile 90 .TCPConnSetupNanos }}   {{ formatPercentile 95 .TCPConnSetupNanos }}   {{ formatPercentile 99 .TCPConnSetupNanos }}                  
 	 TLS Handshake: {{ formatPercentile 0 .TLSHandshakeNanos }}   {{ formatPercentile 50 .TLSHandshakeNanos }}   {{ formatPercentile 75 .TLSHandshakeNanos }}   {{ formatPercentile 90 .TLSHandshakeNanos }}   {{ formatPercentile 95 .TLSHandshakeNanos }}   {{ formatPercentile 99 .TLSHandshakeNanos }}        
-	Rqst Roundtrip: {{ formatPercentile 0 .RqstRoundTripNanos }}   {{ formatPercentile 50 .RqstRoundTripNanos }}   {{ formatPercentile 75 .RqstRoundTripNanos }}   {{ formatPercentile 90 .RqstRoundTripNanos }}   {{ formatPercentile 95 .RqstRoundTripNanos }}   {{ formatPercentile 99 .RqstRoundTripNanos }}        
+{{ with .QUICHandshakeNanos }}	QUIC Handshake: {{ formatPercentile 0 . }}   {{ formatPercentile 50 . }}   {{ formatPercentile 75 . }}   {{ formatPercentile 90 . }}   {{ formatPercentile 95 . }}   {{ formatPercentile 99 . }}
+{{ end }}	Rqst Roundtrip: {{ formatPercentile 0 .RqstRoundTripNanos }}   {{ formatPercentile 50 .RqstRoundTripNanos }}   {{ formatPercentile 75 .RqstRoundTripNanos }}   {{ formatPercentile 90 .RqstRoundTripNanos }}   {{ formatPercentile 95 .RqstRoundTripNanos }}   {{ formatPercentile 99 .RqstRoundTripNanos }}        
 `
 
 // Pass in a EndpointDetails keyed by URL and range over EndpointDetail
@@ -92,11 +122,114 @@ Network Details (secs):
 var endpointDetailsTmplt = `
 Endpoint Details(secs): {{ range $url, $epDetails := . }}    
   {{ $url }}:
-	            Requests   Min        Median     P75        P90        P95        P99 {{ range $method, $epDetail := .HTTPMethodRqstStats }}
-	  {{ formatMethod $method }}:  {{ format100Million .TotalRqsts }}   {{ formatPercentile 0 .TimingResultsNanos }}     {{  formatPercentile 50 .TimingResultsNanos }}     {{  formatPercentile 75 .TimingResultsNanos }}     {{  formatPercentile 90 .TimingResultsNanos }}     {{  formatPercentile 95 .TimingResultsNanos }}     {{  formatPercentile 99 .TimingResultsNanos }} {{ end }}
+	            Requests   Min        Median     P75        P90        P95        P99        P99.9      P99.99 {{ range $method, $epDetail := .HTTPMethodRqstStats }}
+	  {{ formatMethod $method }}:  {{ format100Million .TotalRqsts }}   {{ formatPercentile 0 .TimingResultsNanos }}     {{  formatPercentile 50 .TimingResultsNanos }}     {{  formatPercentile 75 .TimingResultsNanos }}     {{  formatPercentile 90 .TimingResultsNanos }}     {{  formatPercentile 95 .TimingResultsNanos }}     {{  formatPercentile 99 .TimingResultsNanos }}     {{  formatPercentile 99.9 .TimingResultsNanos }}     {{  formatPercentile 99.99 .TimingResultsNanos }} {{ end }}
 	{{ end }}
 `
 
+// Pass in a EndpointDetails keyed by URL and range over EndpointDetail ProtocolDist
+// (map[string]int64 keyed by protocol)
+var connDetailsTmplt = `
+Connection Details:
+{{ range $url, $epDetail := . }}{{ if or $epDetail.ConnsOpened $epDetail.ProtocolDist }}
+  {{ $url }}:
+	   Conns Opened: {{ format100Million $epDetail.ConnsOpened }}
+	     Rqsts/Conn: {{ formatFloat $epDetail.RqstsPerConn }}{{ range $protocol, $count := $epDetail.ProtocolDist }}
+	          {{ $protocol }}: {{ $count }}{{ end }}
+{{ end }}{{ end }}`
+
+// Pass in a EndpointDetails keyed by URL and range over those with WebSocket stats
+var wsDetailsTmplt = `
+WebSocket Details (secs):
+{{ range $url, $epDetail := . }}{{ with $epDetail.WebSocket }}
+  {{ $url }}:
+	                 Min      Median   P75      P90      P95      P99
+	      Connect: {{ formatPercentile 0 .ConnectNanos }}   {{ formatPercentile 50 .ConnectNanos }}   {{ formatPercentile 75 .ConnectNanos }}   {{ formatPercentile 90 .ConnectNanos }}   {{ formatPercentile 95 .ConnectNanos }}   {{ formatPercentile 99 .ConnectNanos }}
+	Msg Roundtrip: {{ formatPercentile 0 .MsgRoundTripNanos }}   {{ formatPercentile 50 .MsgRoundTripNanos }}   {{ formatPercentile 75 .MsgRoundTripNanos }}   {{ formatPercentile 90 .MsgRoundTripNanos }}   {{ formatPercentile 95 .MsgRoundTripNanos }}   {{ formatPercentile 99 .MsgRoundTripNanos }}
+	    Msgs Sent: {{ format100Million .MsgsSent }}   Msgs/sec: {{ formatFloat .MsgsSentPerSec }}
+	    Msgs Rcvd: {{ format100Million .MsgsRcvd }}   Msgs/sec: {{ formatFloat .MsgsRcvdPerSec }}{{ if .AbnormalCloseDist }}
+	  Abnormal Closes:{{ range $code, $count := .AbnormalCloseDist }}
+	          {{ $code }}: {{ $count }}{{ end }}{{ end }}
+{{ end }}{{ end }}`
+
+// Pass in a RunResults and range over EndpointDetails and their HTTPMethodErrorDist
+var errorDetailsTmplt = `
+Errors:
+	  Total Errors: {{ .RunSummary.RqstStats.TotalErrors }}
+	    Error Rate: {{ formatPercent .RunSummary.RqstStats.TotalErrors .RunSummary.RqstStats.TotalRqsts }}%
+{{ range $url, $epDetail := .EndpointDetails }}{{ if $epDetail.HTTPMethodErrorDist }}
+  {{ $url }}:{{ range $method, $errDist := $epDetail.HTTPMethodErrorDist }}
+	  {{ formatMethod $method }}:{{ range $errType, $count := $errDist }}
+	          {{ $errType }}: {{ $count }}{{ end }}{{ end }}
+{{ end }}{{ end }}`
+
+// Pass in a EndpointDetails keyed by URL and range over EndpointDetail
+// HTTPMethodAssertionResults (map[string]*AssertionResults keyed by Method)
+var assertionDetailsTmplt = `
+Assertions:
+{{ range $url, $epDetail := . }}{{ if $epDetail.HTTPMethodAssertionResults }}
+  {{ $url }}:
+	             Passed      Failed {{ range $method, $results := $epDetail.HTTPMethodAssertionResults }}
+	  {{ formatMethod $method }}:  {{ format100Million $results.Passed }}   {{ format100Million $results.Failed }}{{ range $failure, $count := $results.FailureDist }}
+	          {{ $failure }}: {{ $count }}{{ end }}{{ end }}
+{{ end }}{{ end }}`
+
+// Pass in RunResults.ThresholdResults
+var thresholdResultsTmplt = `
+Thresholds:{{ range . }}
+	{{ if .Passed }}[PASS]{{ else }}[FAIL]{{ end }} {{ describeThreshold .Threshold }}, actual {{ .Actual }}{{ end }}
+`
+
+// Pass in RunResults.StageSummaries
+var stageDetailsTmplt = `
+Stage Details (secs):
+	Stage  Duration   Target Rate  Target Conc  Rqsts/sec    Requests   Errors  Dropped     Median     P90        P99 {{ range . }}
+	{{ printf "%5d" .Stage }}  {{ formatSeconds .DurationNanos }}   {{ printf "%11d" .TargetRqstRate }}  {{ printf "%11d" .TargetConcurrentRqsts }}  {{ formatFloat .RqstRatePerSec }}   {{ format100Million .RqstStats.TotalRqsts }}  {{ printf "%7d" .RqstStats.TotalErrors }}  {{ printf "%7d" .DroppedRqsts }}     {{ formatPercentile 50 .RqstStats.TimingResultsNanos }}     {{ formatPercentile 90 .RqstStats.TimingResultsNanos }}     {{ formatPercentile 99 .RqstStats.TimingResultsNanos }}{{ end }}
+`
+
+// Pass in RunResults.ScenarioSummaries
+var scenarioDetailsTmplt = `
+Scenario Details (secs):{{ range . }}
+  {{ .Name }}:
+	                           Iterations   Failed     Median     P90        P99
+	                            {{ format100Million .IterationStats.TotalRqsts }}  {{ printf "%7d" .IterationStats.TotalErrors }}     {{ formatPercentile 50 .IterationStats.TimingResultsNanos }}     {{ formatPercentile 90 .IterationStats.TimingResultsNanos }}     {{ formatPercentile 99 .IterationStats.TimingResultsNanos }}
+	Step                         Requests   Errors     Median     P90        P99{{ range .Steps }}
+	{{ printf "%-26.26s" .Name }}  {{ format100Million .RqstStats.TotalRqsts }}  {{ printf "%7d" .RqstStats.TotalErrors }}     {{ formatPercentile 50 .RqstStats.TimingResultsNanos }}     {{ formatPercentile 90 .RqstStats.TimingResultsNanos }}     {{ formatPercentile 99 .RqstStats.TimingResultsNanos }}{{ end }}
+{{ end }}`
+
+var comparisonTmplt = `
+Comparison with Baseline (tolerance {{ printf "%.2f" .TolerancePct }}%, latencies in secs):
+	All Requests:
+		Metric         Baseline          Current        Delta{{ range .Overall }}
+		{{ printf "%-10s" .Metric }}  {{ printf "%13.4f" .Baseline }}    {{ printf "%13.4f" .Current }}    {{ printf "%+8.2f" .DeltaPct }}%{{ if .Regression }}  REGRESSION{{ end }}{{ end }}
+{{ range .Endpoints }}
+	{{ .URL }} {{ .Method }}:
+		Metric         Baseline          Current        Delta{{ range .Deltas }}
+		{{ printf "%-10s" .Metric }}  {{ printf "%13.4f" .Baseline }}    {{ printf "%13.4f" .Current }}    {{ printf "%+8.2f" .DeltaPct }}%{{ if .Regression }}  REGRESSION{{ end }}{{ end }}
+{{ end }}{{ if .MissingEndpoints }}
+	Not in this run:{{ range .MissingEndpoints }}
+		{{ .URL }} {{ .Method }}{{ end }}
+{{ end }}
+	Regressions: {{ .Regressions }}
+`
+
+// Pass in a timeline, see printTimeSeries()
+var timeSeriesTmplt = `
+Time Series (secs):
+	     Start    Requests   Errors  Rqsts/sec    Median        P99    Rqsts/sec{{ printf "%-*s" .BarPad "" }}P99{{ range .Buckets }}
+	{{ formatSeconds .StartNanos | printf "%10s" }}   {{ format100Million .Stats.TotalRqsts }}  {{ printf "%7d" .Stats.TotalErrors }}  {{ formatFloat .Stats.RqstRatePerSec | printf "%9s" }}    {{ formatSeconds .Stats.MedianRqstDurationNanos }}     {{ formatSeconds .Stats.P99RqstDurationNanos }}    |{{ formatBar .Stats.RqstRatePerSec $.MaxRqstRate }}|{{ formatBar .Stats.P99RqstDurationNanos.Seconds $.MaxP99 }}|{{ end }}
+`
+
+// Pass in a search curve, see PrintSearchResults()
+var searchTmplt = `
+Capacity Search (latencies in secs):
+	Thresholds:{{ range .Thresholds }} {{ describeThreshold . }};{{ end }}
+	Target Rate   Rqsts/sec   Error %     Median        P90        P99  Result  P99
+{{ range .Iterations }}	{{ printf "%11d" .TargetRqstRate }}  {{ formatFloat .RqstRatePerSec | printf "%10s" }}  {{ formatFloat .ErrorRatePct | printf "%8s" }}     {{ formatSeconds .MedianRqstDurationNanos }}     {{ formatSeconds .P90RqstDurationNanos }}     {{ formatSeconds .P99RqstDurationNanos }}  {{ if .Passed }}[PASS]{{ else }}[FAIL]{{ end }}  |{{ formatBar .P99RqstDurationNanos.Seconds $.MaxP99 }}|
+{{ end }}
+	{{ if .KneeRqstRate }}Max sustainable rate: {{ .KneeRqstRate }} rqsts/sec{{ else }}No rate met the thresholds{{ end }}
+`
+
 func printRunSummary(rs api.RunSummary) {
 	tmplt, err := template.New("runSummary").Funcs(tmpltFuncs).Parse(runSummTmplt)
 	if err != nil {
@@ -145,50 +278,185 @@ func printEndpointDetails(epd map[string]*api.EndpointDetail) {
 	}
 }
 
-func calcPercentiles(percentile int, results []time.Duration) time.Duration {
-	if len(results) == 0 {
-		return 0
+func printConnDetails(epd map[string]*api.EndpointDetail) {
+	tmplt, err := template.New("connDetails").Funcs(tmpltFuncs).Parse(connDetailsTmplt)
+	if err != nil {
+		log.Error().Err(err).Msg("error parsing connection details template")
 	}
 
-	if percentile == 0 {
-		return calcPMin(results)
+	err = tmplt.Execute(os.Stdout, epd)
+	if err != nil {
+		log.Error().Err(err).Msg("error executing connection details template")
 	}
+}
 
-	if percentile == 50 {
-		return calcPMedian(results)
+// hasConnDetails returns true if the protocol or connections used by any endpoint in
+// 'epd' were recorded
+func hasConnDetails(epd map[string]*api.EndpointDetail) bool {
+	for _, epDetail := range epd {
+		if epDetail.ConnsOpened > 0 || len(epDetail.ProtocolDist) > 0 {
+			return true
+		}
 	}
+	return false
+}
 
-	sort.Slice(results, func(i, j int) bool { return results[i] < results[j] })
+func printWebSocketDetails(epd map[string]*api.EndpointDetail) {
+	tmplt, err := template.New("wsDetails").Funcs(tmpltFuncs).Parse(wsDetailsTmplt)
+	if err != nil {
+		log.Error().Err(err).Msg("error parsing WebSocket details template")
+	}
 
-	// applying math.Ceil to the results of math.Ceil is required to round up
-	// to the next results cell when len(results) is a small number, e.g., like
-	// 2. Otherwise Median is greater than P99.
-	p := math.Ceil(math.Ceil(float64((len(results)-1)*percentile)) / 100)
-	return results[int(p)]
+	err = tmplt.Execute(os.Stdout, epd)
+	if err != nil {
+		log.Error().Err(err).Msg("error executing WebSocket details template")
+	}
 }
 
-func calcPMin(results []time.Duration) time.Duration {
-	if len(results) == 0 {
-		return 0
+// hasWebSocketDetails returns true if any endpoint in 'epd' is a WebSocket endpoint
+func hasWebSocketDetails(epd map[string]*api.EndpointDetail) bool {
+	for _, epDetail := range epd {
+		if epDetail.WebSocket != nil {
+			return true
+		}
 	}
-	sort.Slice(results, func(i, j int) bool { return results[i] < results[j] })
-	return results[0]
+	return false
 }
 
-func calcPMedian(results []time.Duration) time.Duration {
-	if len(results) == 0 {
-		return 0
+func printErrorDetails(rr api.RunResults) {
+	tmplt, err := template.New("errorDetails").Funcs(tmpltFuncs).Parse(errorDetailsTmplt)
+	if err != nil {
+		log.Error().Err(err).Msg("error parsing error details template")
 	}
 
-	sort.Slice(results, func(i, j int) bool { return results[i] < results[j] })
+	err = tmplt.Execute(os.Stdout, rr)
+	if err != nil {
+		log.Error().Err(err).Msg("error executing error details template")
+	}
+}
 
-	isEven := len(results)%2 == 0
-	mNumber := len(results) / 2
+func printAssertionDetails(epd map[string]*api.EndpointDetail) {
+	tmplt, err := template.New("assertionDetails").Funcs(tmpltFuncs).Parse(assertionDetailsTmplt)
+	if err != nil {
+		log.Error().Err(err).Msg("error parsing assertion details template")
+	}
 
-	if !isEven {
-		return results[mNumber]
+	err = tmplt.Execute(os.Stdout, epd)
+	if err != nil {
+		log.Error().Err(err).Msg("error executing assertion details template")
 	}
-	return (results[mNumber-1] + results[mNumber]) / time.Duration(2)
+}
+
+// hasAssertionResults returns true if any endpoint in 'epd' had its responses checked
+// against Assertions
+func hasAssertionResults(epd map[string]*api.EndpointDetail) bool {
+	for _, epDetail := range epd {
+		if len(epDetail.HTTPMethodAssertionResults) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func printThresholdResults(results []api.ThresholdResult) {
+	tmplt, err := template.New("thresholdResults").Funcs(tmpltFuncs).Parse(thresholdResultsTmplt)
+	if err != nil {
+		log.Error().Err(err).Msg("error parsing threshold results template")
+	}
+
+	err = tmplt.Execute(os.Stdout, results)
+	if err != nil {
+		log.Error().Err(err).Msg("error executing threshold results template")
+	}
+}
+
+// printTimeSeries prints a timeline of each bucket's request rate and P99 latency
+func printTimeSeries(buckets []*api.TimeSeriesBucket) {
+	timeline := struct {
+		Buckets     []*api.TimeSeriesBucket
+		MaxRqstRate float64
+		MaxP99      float64
+		BarPad      int
+	}{Buckets: buckets, BarPad: timelineBarWidth - len("Rqsts/sec") + 2}
+	for _, b := range buckets {
+		timeline.MaxRqstRate = math.Max(timeline.MaxRqstRate, b.Stats.RqstRatePerSec)
+		timeline.MaxP99 = math.Max(timeline.MaxP99, b.Stats.P99RqstDurationNanos.Seconds())
+	}
+
+	tmplt, err := template.New("timeSeries").Funcs(tmpltFuncs).Parse(timeSeriesTmplt)
+	if err != nil {
+		log.Error().Err(err).Msg("error parsing time series template")
+	}
+
+	err = tmplt.Execute(os.Stdout, timeline)
+	if err != nil {
+		log.Error().Err(err).Msg("error executing time series template")
+	}
+}
+
+func printStageDetails(stages []*api.StageSummary) {
+	tmplt, err := template.New("stageDetails").Funcs(tmpltFuncs).Parse(stageDetailsTmplt)
+	if err != nil {
+		log.Error().Err(err).Msg("error parsing stage details template")
+	}
+
+	err = tmplt.Execute(os.Stdout, stages)
+	if err != nil {
+		log.Error().Err(err).Msg("error executing stage details template")
+	}
+}
+
+func printScenarioDetails(scenarios []*api.ScenarioSummary) {
+	tmplt, err := template.New("scenarioDetails").Funcs(tmpltFuncs).Parse(scenarioDetailsTmplt)
+	if err != nil {
+		log.Error().Err(err).Msg("error parsing scenario details template")
+	}
+
+	err = tmplt.Execute(os.Stdout, scenarios)
+	if err != nil {
+		log.Error().Err(err).Msg("error executing scenario details template")
+	}
+}
+
+// PrintSearchResults prints a human readable form of 'sr', including a chart of each
+// iteration's P99 latency, to stdout
+func PrintSearchResults(sr api.SearchResults) {
+	curve := struct {
+		api.SearchResults
+		MaxP99 float64
+	}{SearchResults: sr}
+	for _, iter := range sr.Iterations {
+		curve.MaxP99 = math.Max(curve.MaxP99, iter.P99RqstDurationNanos.Seconds())
+	}
+
+	tmplt, err := template.New("search").Funcs(tmpltFuncs).Parse(searchTmplt)
+	if err != nil {
+		log.Error().Err(err).Msg("error parsing search template")
+	}
+
+	err = tmplt.Execute(os.Stdout, curve)
+	if err != nil {
+		log.Error().Err(err).Msg("error executing search template")
+	}
+}
+
+// PrintComparison prints a human readable form of 'c' to stdout
+func PrintComparison(c *api.RunComparison) {
+	tmplt, err := template.New("comparison").Funcs(tmpltFuncs).Parse(comparisonTmplt)
+	if err != nil {
+		log.Error().Err(err).Msg("error parsing comparison template")
+	}
+
+	err = tmplt.Execute(os.Stdout, c)
+	if err != nil {
+		log.Error().Err(err).Msg("error executing comparison template")
+	}
+}
+
+// calcPercentiles returns the duration at 'percentile' in 'results'. A 'percentile' of
+// 0 returns the smallest recorded duration.
+func calcPercentiles(percentile float64, results *api.Histogram) time.Duration {
+	return results.Percentile(percentile)
 }
 
 // func calcP90(results []time.Duration) time.Duration {
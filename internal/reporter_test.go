@@ -7,6 +7,8 @@ package internal
 import (
 	"testing"
 	"time"
+
+	"github.com/youngkin/heyyall/api"
 )
 
 func TestPercentileCalcs(t *testing.T) {
@@ -91,44 +93,42 @@ func TestPercentileCalcs(t *testing.T) {
 			},
 		},
 	}
-	// in := []time.Duration{time.Millisecond * 100, time.Millisecond * 1000}
-	// expectedMin := time.Millisecond * 100
-	// expectedMedian := time.Millisecond * 550
-	// expectedP75 := time.Millisecond * 1000
-	// expectedP90 := time.Millisecond * 1000
-	// expectedP95 := time.Millisecond * 1000
-	// expectedP99 := time.Millisecond * 1000
-
 	for _, tc := range tests {
 		t.Run(tc.testName, func(t *testing.T) {
-			resultsIn := make([]time.Duration, 0)
+			resultsIn := api.NewHistogram(0, 0)
 			for i, d := 0, tc.startVal; i < tc.numVals; i, d = i+1, d+tc.stepVal {
-				resultsIn = append(resultsIn, d)
+				resultsIn.Record(d)
+			}
+
+			// Percentiles are only accurate to the precision of the histogram so
+			// expected values only need to be equivalent to the actual values.
+			equiv := func(expected, actual time.Duration) bool {
+				return resultsIn.Equivalent(expected, actual)
 			}
 
-			actualMin := calcPMin(resultsIn)
-			actualMedian := calcPMedian(resultsIn)
+			actualMin := calcPercentiles(0, resultsIn)
+			actualMedian := calcPercentiles(50, resultsIn)
 			actualP75 := calcPercentiles(75, resultsIn)
 			actualP90 := calcPercentiles(90, resultsIn)
 			actualP95 := calcPercentiles(95, resultsIn)
 			actualP99 := calcPercentiles(99, resultsIn)
 
-			if tc.expectedVals[min] != actualMin {
+			if !equiv(tc.expectedVals[min], actualMin) {
 				t.Errorf("Min: expected %s, got %s", tc.expectedVals[min], actualMin)
 			}
-			if tc.expectedVals[median] != actualMedian {
+			if !equiv(tc.expectedVals[median], actualMedian) {
 				t.Errorf("Median: expected %s, got %s", tc.expectedVals[median], actualMedian)
 			}
-			if tc.expectedVals[p75] != actualP75 {
+			if !equiv(tc.expectedVals[p75], actualP75) {
 				t.Errorf("P75: expected %s, got %s", tc.expectedVals[p75], actualP75)
 			}
-			if tc.expectedVals[p90] != actualP90 {
+			if !equiv(tc.expectedVals[p90], actualP90) {
 				t.Errorf("P90: expected %s, got %s", tc.expectedVals[p90], actualP90)
 			}
-			if tc.expectedVals[p95] != actualP95 {
+			if !equiv(tc.expectedVals[p95], actualP95) {
 				t.Errorf("P95: expected %s, got %s", tc.expectedVals[p95], actualP95)
 			}
-			if tc.expectedVals[p99] != actualP99 {
+			if !equiv(tc.expectedVals[p99], actualP99) {
 				t.Errorf("P99: expected %s, got %s", tc.expectedVals[p99], actualP99)
 			}
 
@@ -8,11 +8,17 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptrace"
 	"net/url"
+	"syscall"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -28,6 +34,19 @@ type Requestor struct {
 	ResponseC chan Response
 	// Client is the target of the test run
 	Client http.Client
+	// Transport is the configuration Client was created with, see NewClient. Endpoints
+	// that override it get their own clients.
+	Transport api.Transport
+	// Metrics, if not nil, records the number of requests in flight
+	Metrics *Metrics
+	// Sequences holds the counters used by the 'seq' request template function. If it's
+	// nil each requestor counts its own requests.
+	Sequences *Sequences
+	// DataSources are the rows used by the 'data' request template function
+	DataSources *DataSources
+	// Clients are the clients of the endpoints that override Client, see NewClients. If
+	// it's nil each requestor creates its own.
+	Clients *Clients
 }
 
 // ResponseChan returns a chan Response
@@ -35,113 +54,499 @@ func (r Requestor) ResponseChan() chan Response {
 	return r.ResponseC
 }
 
-// ProcessRqst runs the requests configured by 'ep' at the requested rate for either
-// 'numRqsts' times or the configured run duration (set in Requestor.Ctx)
-func (r Requestor) ProcessRqst(ep api.Endpoint, numRqsts int, rqstRate int) {
+// ProcessRqst runs the requests configured by 'ep' when 'pacer' says they're due until
+// either 'pacer' has no more requests or the configured run duration (set in Requestor.Ctx)
+// expires
+func (r Requestor) ProcessRqst(ep api.Endpoint, pacer Pacer) {
+	if ep.GRPC != nil {
+		r.processGRPC(ep, pacer)
+		return
+	}
+	if isWebSocketURL(ep.URL) {
+		r.processWebSocket(ep, pacer)
+		return
+	}
 	if len(ep.URL) == 0 || len(ep.Method) == 0 {
 		log.Warn().Msgf("Requestor - request contains an invalid endpoint %+v, URL or Method is empty", ep)
 		return
 	}
 
-	req, err := http.NewRequestWithContext(r.Ctx, ep.Method, ep.URL, bytes.NewBuffer([]byte(ep.RqstBody)))
+	rows := newDataRows(r.DataSources, newRand())
+	tmplts, err := newRqstTemplates(ep, r.Sequences.counter(endpointKey(ep)), rows)
 	if err != nil {
-		log.Warn().Err(err).Msgf("Requestor unable to create http request")
+		log.Warn().Err(err).Msgf("Requestor - endpoint %s has an invalid template", ep.URL)
 		return
 	}
-	if ep.Headers != nil {
-		for headerName, headerValue := range ep.Headers {
-			req.Header.Add(headerName, headerValue)
-		}
+	// Validate the endpoint's configuration before starting the run
+	if _, _, err := r.newTemplatedRqst(ep, tmplts); err != nil {
+		log.Warn().Err(err).Msgf("Requestor unable to create http request")
+		return
 	}
 
-	var dnsStart, dnsDone, connStart, connDone, gotResp, tlsStart, tlsDone time.Time
-
-	trace := &httptrace.ClientTrace{
-		DNSStart:             func(_ httptrace.DNSStartInfo) { dnsStart = time.Now() },
-		DNSDone:              func(_ httptrace.DNSDoneInfo) { dnsDone = time.Now() },
-		GetConn:              func(_ string) { connStart = time.Now() },
-		GotConn:              func(_ httptrace.GotConnInfo) { connDone = time.Now() },
-		GotFirstResponseByte: func() { gotResp = time.Now() },
-		TLSHandshakeStart:    func() { tlsStart = time.Now() },
-		TLSHandshakeDone:     func(_ tls.ConnectionState, _ error) { tlsDone = time.Now() },
+	asserts, err := newAssertions(ep.Assertions)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Requestor - endpoint %s has invalid Assertions", ep.URL)
+		return
 	}
 
-	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	client, release, err := r.clientFor(ep)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Requestor - endpoint %s has an invalid client configuration", ep.URL)
+		return
+	}
+	defer release()
 
-	if numRqsts == 0 {
-		log.Debug().Msgf("ProcessRqst: EP: %s, numRqsts was 0, setting to %d", ep.URL, api.MaxRqsts)
-		numRqsts = api.MaxRqsts
+	var maxBodyRead int64
+	if asserts != nil && asserts.needsBody() {
+		maxBodyRead = asserts.maxBodyRead()
 	}
 
-	client := r.Client
-	if ep.CertFile != "" {
-		if ep.KeyFile == "" {
-			log.Fatal().Msgf("Endpoint: %s, Endpoint.CertFile specified: %s, Endpoint.KeyFile is not", ep.URL, ep.CertFile)
+	for {
+		due, stage, ok := pacer.Next(r.Ctx)
+		if !ok {
+			return
+		}
+
+		// A new request is needed each time since a request's body can only be read once,
+		// and its URL, headers, and body may be templates
+		rows.next()
+		tmplts.next()
+		req, trace, err := r.newTemplatedRqst(ep, tmplts)
+		if err != nil && rows.exhausted {
+			log.Debug().Err(err).Msgf("Requestor: endpoint %s has run out of data, exiting", ep.URL)
+			return
 		}
-		log.Debug().Msgf("Endpoint %s is overriding SSL certificate using certificate file %s", ep.URL, ep.CertFile)
-		cert, err := tls.LoadX509KeyPair(ep.CertFile, ep.KeyFile)
 		if err != nil {
-			log.Fatal().Err(err).Msg("Error creating x509 keypair")
+			log.Warn().Err(err).Msgf("Requestor unable to create http request, dropping remaining requests")
+			return
 		}
-		t1, ok := r.Client.Transport.(*http.Transport)
+
+		response, body, bodySize, ok := r.sendRqst(client, req, trace, ep, due, stage, maxBodyRead)
 		if !ok {
-			log.Fatal().Msg("Requestor.ProcessRqst(): Could not cast Client.Transport to *http.Transport")
+			r.sendCancelled(response)
+			return
 		}
-		t2 := &http.Transport{
-			MaxIdleConnsPerHost: t1.MaxConnsPerHost,
-			DisableCompression:  t1.DisableCompression,
-			DisableKeepAlives:   t1.DisableKeepAlives,
-			TLSClientConfig: &tls.Config{
-				Certificates: []tls.Certificate{cert},
-			},
+		if response.ErrorType == "" && asserts != nil {
+			response.Asserted = true
+			response.AssertionFailures = asserts.check(response.HTTPStatus, response.Header, body, bodySize)
+		}
+
+		if !r.sendResponse(response) {
+			return
 		}
-		client.Transport = t2
 	}
+}
 
-	for i := 0; i < numRqsts; i++ {
-		start := time.Now()
-		resp, err := client.Do(req)
-		if err != nil {
-			switch e := err.(type) {
-			case *url.Error:
-				if e.Timeout() {
-					return
-				}
-			default:
-				log.Warn().Err(err).Msgf("Requestor: error %s sending request, dropping %d remaining requests", err, numRqsts-(i+1))
-				return
-			}
+// clientFor returns the client used to send requests to 'ep'. It's the Requestor's
+// Client unless 'ep' overrides the SSL certificate or the Transport settings, in which
+// case it's the endpoint's client from Clients. If Clients doesn't have one a new client
+// is created, 'release' closes it when it's no longer needed.
+func (r Requestor) clientFor(ep api.Endpoint) (client http.Client, release func(), err error) {
+	if !overridesClient(ep) {
+		return r.Client, func() {}, nil
+	}
+	if client, ok := r.Clients.client(ep); ok {
+		return client, func() {}, nil
+	}
+	client, err = newEndpointClient(r.Client, r.Transport, ep)
+	if err != nil {
+		return http.Client{}, nil, err
+	}
+	return client, func() { CloseClient(client) }, nil
+}
+
+// overridesClient returns true if 'ep' needs its own client, i.e., it overrides the SSL
+// certificate or the Transport settings
+func overridesClient(ep api.Endpoint) bool {
+	return ep.CertFile != "" || ep.Transport != nil
+}
+
+// newEndpointClient returns the client used to send requests to 'ep', which overrides
+// the SSL certificate or Transport settings of 'base', a client created by NewClient
+// with the settings 'cfg'
+func newEndpointClient(base http.Client, cfg api.Transport, ep api.Endpoint) (http.Client, error) {
+	maxIdleConnsPerHost := 0
+	if base.Transport != nil {
+		t1, ok := base.Transport.(*clientTransport)
+		if !ok {
+			return http.Client{}, errors.New("Requestor.Client wasn't created by NewClient")
 		}
+		maxIdleConnsPerHost = t1.maxIdleConnsPerHost
+	}
+	tlsConfig, err := endpointTLSConfig(base, ep)
+	if err != nil {
+		return http.Client{}, err
+	}
 
-		io.Copy(ioutil.Discard, resp.Body)
-		resp.Body.Close()
+	if ep.Transport != nil {
+		log.Debug().Msgf("Endpoint %s is overriding Transport settings with %+v", ep.URL, *ep.Transport)
+		cfg = mergeTransport(cfg, *ep.Transport)
+	}
+	client, err := NewClient(cfg, tlsConfig, maxIdleConnsPerHost, base.Timeout)
+	if err != nil {
+		return http.Client{}, fmt.Errorf("Endpoint: %s, invalid Transport: %w", ep.URL, err)
+	}
+	return client, nil
+}
 
-		select {
-		case <-r.Ctx.Done():
-			log.Debug().Msg("Requestor cancelled or the run duration expired, exiting")
-			return
-		case r.ResponseC <- Response{
-			HTTPStatus:           resp.StatusCode,
-			Endpoint:             api.Endpoint{URL: ep.URL, Method: ep.Method},
-			Header:               resp.Header,
-			RequestDuration:      time.Since(start),
-			DNSLookupDuration:    dnsDone.Sub(dnsStart),
-			TCPConnDuration:      connDone.Sub(connStart),
-			RoundTripDuration:    gotResp.Sub(connDone),
-			TLSHandshakeDuration: tlsDone.Sub(tlsStart),
-		}:
-		}
-
-		// Zero request rate is completely unthrottled
-		if rqstRate == 0 {
+// Clients holds the clients of the endpoints, and scenario steps, that override the
+// Requestor's SSL certificate or Transport settings, and the connections of the gRPC
+// endpoints. Each endpoint's client is shared by all of its requestors so that its
+// connection limits apply to the endpoint as a whole, and so that HTTP/2 requests can
+// share connections.
+type Clients struct {
+	clients map[string]http.Client
+	grpcEps map[string]*grpcEndpoint
+}
+
+// NewClients creates the clients of the endpoints in 'eps' and the steps of 'scenarios'
+// that override the SSL certificate or Transport settings of 'client', a client created
+// by NewClient with the settings 'cfg'. It also connects to the servers of the gRPC
+// endpoints and looks up the methods they call, using 'ctx' for server reflection.
+func NewClients(ctx context.Context, client http.Client, cfg api.Transport, eps []api.Endpoint,
+	scenarios []api.Scenario) (*Clients, error) {
+
+	c := &Clients{clients: make(map[string]http.Client), grpcEps: make(map[string]*grpcEndpoint)}
+	add := func(ep api.Endpoint) error {
+		key := clientKey(ep)
+		if _, ok := c.clients[key]; ok || !overridesClient(ep) {
+			return nil
+		}
+		epClient, err := newEndpointClient(client, cfg, ep)
+		if err != nil {
+			return err
+		}
+		c.clients[key] = epClient
+		return nil
+	}
+
+	for _, ep := range eps {
+		if err := add(ep); err != nil {
+			c.Close()
+			return nil, err
+		}
+		if ep.GRPC == nil {
 			continue
 		}
-		since := time.Since(start)
-		delta := (time.Second / time.Duration(rqstRate)) - since
-		if delta < 0 {
+		key := grpcKey(ep)
+		if _, ok := c.grpcEps[key]; ok {
 			continue
 		}
-		time.Sleep(delta)
+		epClient, _ := c.client(ep)
+		if !overridesClient(ep) {
+			epClient = client
+		}
+		g, err := newGRPCEndpoint(ctx, ep, clientTLSConfig(epClient))
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("Endpoint: %s, unable to call gRPC method %s: %w", ep.URL, ep.GRPC.Method, err)
+		}
+		c.grpcEps[key] = g
+	}
+	for _, sc := range scenarios {
+		for _, step := range sc.Steps {
+			if err := add(step.Endpoint); err != nil {
+				c.Close()
+				return nil, err
+			}
+		}
+	}
+	return c, nil
+}
+
+// client returns the client of 'ep', 'ok' is false if there isn't one
+func (c *Clients) client(ep api.Endpoint) (client http.Client, ok bool) {
+	if c == nil {
+		return http.Client{}, false
+	}
+	client, ok = c.clients[clientKey(ep)]
+	return client, ok
+}
 
+// grpcEndpoint returns the gRPC endpoint of 'ep', 'ok' is false if there isn't one
+func (c *Clients) grpcEndpoint(ep api.Endpoint) (g *grpcEndpoint, ok bool) {
+	if c == nil {
+		return nil, false
+	}
+	g, ok = c.grpcEps[grpcKey(ep)]
+	return g, ok
+}
+
+// Close closes the clients' connections. It's called once the run is complete.
+func (c *Clients) Close() {
+	for _, client := range c.clients {
+		CloseClient(client)
+	}
+	for _, g := range c.grpcEps {
+		g.close()
 	}
 }
+
+// clientKey identifies the client of 'ep' in Clients
+func clientKey(ep api.Endpoint) string {
+	// Marshaling can't fail, the values are all strings, numbers, and booleans
+	transport, _ := json.Marshal(ep.Transport)
+	return fmt.Sprintf("%s %s %s %s", endpointKey(ep), ep.CertFile, ep.KeyFile, transport)
+}
+
+// grpcKey identifies the gRPC endpoint of 'ep' in Clients
+func grpcKey(ep api.Endpoint) string {
+	return fmt.Sprintf("%s %s %s", clientKey(ep), ep.GRPC.Method, ep.GRPC.DescriptorSetFile)
+}
+
+// endpointTLSConfig returns the TLS configuration used to connect to 'ep'. It's the
+// configuration of 'base', a client created by NewClient, unless 'ep' overrides the SSL
+// certificate.
+func endpointTLSConfig(base http.Client, ep api.Endpoint) (*tls.Config, error) {
+	if ep.CertFile == "" {
+		return clientTLSConfig(base), nil
+	}
+	if ep.KeyFile == "" {
+		return nil, fmt.Errorf("Endpoint: %s, Endpoint.CertFile specified: %s, Endpoint.KeyFile is not",
+			ep.URL, ep.CertFile)
+	}
+	log.Debug().Msgf("Endpoint %s is overriding SSL certificate using certificate file %s", ep.URL, ep.CertFile)
+	cert, err := tls.LoadX509KeyPair(ep.CertFile, ep.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Endpoint: %s, error creating x509 keypair: %w", ep.URL, err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}, nil
+}
+
+// endpointTimeout returns the request timeout of 'ep'. It's the timeout of 'base', a
+// client created by NewClient with the settings 'cfg', unless 'ep' overrides RqstTimeout.
+func endpointTimeout(base http.Client, cfg api.Transport, ep api.Endpoint) (time.Duration, error) {
+	if ep.Transport == nil {
+		return base.Timeout, nil
+	}
+	ts, err := newTransportSettings(mergeTransport(cfg, *ep.Transport))
+	if err != nil {
+		return 0, fmt.Errorf("Endpoint: %s, invalid Transport: %w", ep.URL, err)
+	}
+	if ts.rqstTimeout > 0 {
+		return ts.rqstTimeout, nil
+	}
+	return base.Timeout, nil
+}
+
+// clientTLSConfig returns the TLS configuration of 'client', a client created by
+// NewClient, for connections that aren't made by 'client' itself, e.g., gRPC connections
+func clientTLSConfig(client http.Client) *tls.Config {
+	if t, ok := client.Transport.(*clientTransport); ok {
+		return t.tlsConfig
+	}
+	return nil
+}
+
+// sendRqst sends 'req', a request to 'ep' that was due at 'due' during 'stage', using
+// 'client' and returns the Response describing its outcome. Up to 'maxBodyRead' bytes of
+// the response body are also returned, along with the body's size, the rest of the body
+// is discarded. 'ok' is false if the Requestor was cancelled, or the run duration expired,
+// before the request completed, see interrupted().
+func (r Requestor) sendRqst(client http.Client, req *http.Request, trace *rqstTrace, ep api.Endpoint,
+	due time.Time, stage int, maxBodyRead int64) (response Response, body []byte, bodySize int64, ok bool) {
+
+	// Latency is measured from when the request was due, not when it was sent, so
+	// that delays in sending requests aren't hidden from the results
+	late := time.Since(due) > lateRqstThreshold
+	r.Metrics.rqstStarted()
+	resp, err := client.Do(req)
+	if err != nil {
+		r.Metrics.rqstDone()
+		response = Response{
+			Endpoint:        api.Endpoint{URL: ep.URL, Method: ep.Method},
+			Stage:           stage,
+			Late:            late,
+			ErrorType:       classifyError(err),
+			Error:           err.Error(),
+			RequestDuration: time.Since(due),
+			NewConn:         trace.newConn,
+		}
+		if r.Ctx.Err() != nil {
+			return r.interrupted(response), nil, 0, false
+		}
+		log.Debug().Err(err).Msgf("Requestor: error sending request to %s", ep.URL)
+		return response, nil, 0, true
+	}
+
+	body, bodySize, err = readBody(resp, maxBodyRead)
+	resp.Body.Close()
+	r.Metrics.rqstDone()
+
+	response = Response{
+		HTTPStatus:            resp.StatusCode,
+		Endpoint:              api.Endpoint{URL: ep.URL, Method: ep.Method},
+		Stage:                 stage,
+		Late:                  late,
+		Header:                resp.Header,
+		RequestDuration:       time.Since(due),
+		DNSLookupDuration:     trace.dnsDone.Sub(trace.dnsStart),
+		TCPConnDuration:       trace.connDone.Sub(trace.connStart),
+		RoundTripDuration:     trace.gotResp.Sub(trace.connDone),
+		TLSHandshakeDuration:  trace.tlsDone.Sub(trace.tlsStart),
+		QUICHandshakeDuration: trace.quicDone.Sub(trace.quicStart),
+		Protocol:              resp.Proto,
+		NewConn:               trace.newConn,
+	}
+	if err != nil {
+		response.ErrorType = api.ErrBodyRead
+		response.Error = err.Error()
+		if r.Ctx.Err() != nil {
+			return r.interrupted(response), nil, 0, false
+		}
+		log.Debug().Err(err).Msgf("Requestor: error reading response body from %s", ep.URL)
+	}
+	return response, body, bodySize, true
+}
+
+// interrupted returns 'resp', the Response of a request that was in progress when the
+// Requestor was cancelled or the run duration expired, as it's to be reported. Requests
+// in progress when the Requestor was cancelled failed with api.ErrCtxCancelled. Requests
+// in progress when the run duration expired aren't failures and aren't reported, an empty
+// Response is returned for them.
+func (r Requestor) interrupted(resp Response) Response {
+	if !errors.Is(r.Ctx.Err(), context.Canceled) {
+		log.Debug().Msg("Requestor run duration expired, exiting")
+		return Response{}
+	}
+	log.Debug().Msg("Requestor cancelled, exiting")
+	resp.ErrorType = api.ErrCtxCancelled
+	if resp.Error == "" {
+		resp.Error = r.Ctx.Err().Error()
+	}
+	return resp
+}
+
+// readBody reads the body of 'resp' and returns its size. Up to 'maxBodyRead' bytes of
+// the body are also returned, the rest is discarded.
+func readBody(resp *http.Response, maxBodyRead int64) (body []byte, bodySize int64, err error) {
+	if maxBodyRead <= 0 {
+		bodySize, err = io.Copy(ioutil.Discard, resp.Body)
+		return nil, bodySize, err
+	}
+
+	body, err = ioutil.ReadAll(io.LimitReader(resp.Body, maxBodyRead))
+	if err != nil {
+		return nil, int64(len(body)), err
+	}
+	// Drain whatever is left so the connection can be reused
+	remaining, err := io.Copy(ioutil.Discard, resp.Body)
+	return body, int64(len(body)) + remaining, err
+}
+
+// rqstTrace records when each of the network phases of a single request occurred
+type rqstTrace struct {
+	dnsStart, dnsDone, connStart, connDone, gotResp, tlsStart, tlsDone time.Time
+	// quicStart and quicDone are only set for HTTP/3 requests
+	quicStart, quicDone time.Time
+	// newConn is true if the request was sent on a newly opened connection
+	newConn bool
+}
+
+func (t *rqstTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(_ httptrace.DNSStartInfo) { t.dnsStart = time.Now() },
+		DNSDone:  func(_ httptrace.DNSDoneInfo) { t.dnsDone = time.Now() },
+		GetConn:  func(_ string) { t.connStart = time.Now() },
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.connDone = time.Now()
+			t.newConn = !info.Reused
+		},
+		GotFirstResponseByte: func() { t.gotResp = time.Now() },
+		TLSHandshakeStart:    func() { t.tlsStart = time.Now() },
+		TLSHandshakeDone:     func(_ tls.ConnectionState, _ error) { t.tlsDone = time.Now() },
+	}
+}
+
+// rqstTraceKey is the request context key of a request's rqstTrace
+type rqstTraceKey struct{}
+
+// newRqst returns a new request for 'ep' with 'body' as its body, along with the trace
+// that will record the timing of its network phases. The request's body can be re-read,
+// via Request.GetBody, if the request has to be resent (e.g., on a redirect).
+func (r Requestor) newRqst(ep api.Endpoint, body []byte) (*http.Request, *rqstTrace, error) {
+	trace := &rqstTrace{}
+	ctx := httptrace.WithClientTrace(r.Ctx, trace.clientTrace())
+	ctx = context.WithValue(ctx, rqstTraceKey{}, trace)
+	req, err := http.NewRequestWithContext(ctx, ep.Method, ep.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	for headerName, headerValue := range ep.Headers {
+		req.Header.Add(headerName, headerValue)
+	}
+	return req, trace, nil
+}
+
+// newTemplatedRqst returns a new request for 'ep', along with its trace, whose URL, headers,
+// and body are rendered from 'tmplts'
+func (r Requestor) newTemplatedRqst(ep api.Endpoint, tmplts *rqstTemplates) (*http.Request, *rqstTrace, error) {
+	url, body, headers, err := tmplts.render(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	ep.URL, ep.Headers = url, headers
+	return r.newRqst(ep, []byte(body))
+}
+
+// sendResponse sends 'resp' to the response handler. It returns false if the
+// Requestor was cancelled, or the run duration expired, before 'resp' could be sent.
+func (r Requestor) sendResponse(resp Response) bool {
+	select {
+	case <-r.Ctx.Done():
+		log.Debug().Msg("Requestor cancelled or the run duration expired, exiting")
+		return false
+	case r.ResponseC <- resp:
+		return true
+	}
+}
+
+// sendCancelled sends 'resp', a Response returned by interrupted(), to the response handler
+// if it describes a cancelled request. Unlike sendResponse it sends 'resp' even though the
+// Requestor was cancelled, the response handler receives responses until every Requestor
+// has exited.
+func (r Requestor) sendCancelled(resp Response) {
+	if resp.ErrorType == api.ErrCtxCancelled {
+		r.ResponseC <- resp
+	}
+}
+
+// classifyError returns the api.Err... classification of 'err', an error
+// returned from http.Client.Do().
+func classifyError(err error) string {
+	var dnsErr *net.DNSError
+	var urlErr *url.Error
+	var recordHdrErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var certVerifyErr *tls.CertificateVerificationError
+	var unknownAuthErr x509.UnknownAuthorityError
+	var certInvalidErr x509.CertificateInvalidError
+	var hostnameErr x509.HostnameError
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return api.ErrCtxCancelled
+	case errors.Is(err, context.DeadlineExceeded):
+		return api.ErrTimeout
+	case errors.Is(err, errProtocolMismatch):
+		return api.ErrProtocol
+	case errors.Is(err, errWSClosed):
+		return api.ErrWSClosed
+	case errors.As(err, &dnsErr):
+		return api.ErrDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return api.ErrConnRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return api.ErrConnReset
+	case errors.As(err, &recordHdrErr), errors.As(err, &alertErr), errors.As(err, &certVerifyErr),
+		errors.As(err, &unknownAuthErr), errors.As(err, &certInvalidErr), errors.As(err, &hostnameErr):
+		return api.ErrTLSHandshake
+	case errors.As(err, &urlErr) && urlErr.Timeout():
+		return api.ErrTimeout
+	}
+
+	return api.ErrOther
+}
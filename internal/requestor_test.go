@@ -6,9 +6,18 @@ package internal
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
 	"sync"
+	"syscall"
 	"testing"
 	"time"
 
@@ -63,7 +72,7 @@ func TestHappyPath(t *testing.T) {
 	wg := sync.WaitGroup{}
 	wg.Add(1)
 	go func() {
-		rqstr.ProcessRqst(ep, 1, 1000)
+		rqstr.ProcessRqst(ep, newRatePacer(1, 1000, constantArrival{}))
 		wg.Done()
 	}()
 	resp := <-respC
@@ -80,15 +89,17 @@ func TestHappyPath(t *testing.T) {
 	wg.Wait()
 }
 
+// TestCtxCancel verifies that a request in progress when the Requestor is cancelled is
+// reported as cancelled
 func TestCtxCancel(t *testing.T) {
 	ep := api.Endpoint{
 		Method:      "GET",
 		RqstPercent: 100,
 	}
 
-	srvHandler := srvHandler{HTTPStatus: 200}
-
-	testSrv := httptest.NewServer(http.HandlerFunc(srvHandler.ServeHTTP))
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
 	defer testSrv.Close()
 
 	url := testSrv.URL + "/testme"
@@ -97,7 +108,7 @@ func TestCtxCancel(t *testing.T) {
 	client := http.Client{}
 	ctx, cancel := context.WithCancel(context.Background())
 
-	respC := make(chan Response)
+	respC := make(chan Response, 1)
 	rqstr := Requestor{
 		Ctx:       ctx,
 		ResponseC: respC,
@@ -107,7 +118,7 @@ func TestCtxCancel(t *testing.T) {
 	wg := sync.WaitGroup{}
 	wg.Add(1)
 	go func() {
-		rqstr.ProcessRqst(ep, 1, 1000)
+		rqstr.ProcessRqst(ep, newRatePacer(1, 1000, constantArrival{}))
 		wg.Done()
 	}()
 
@@ -115,6 +126,17 @@ func TestCtxCancel(t *testing.T) {
 	cancel()
 
 	wg.Wait()
+	close(respC)
+	numResps := 0
+	for resp := range respC {
+		numResps++
+		if resp.ErrorType != api.ErrCtxCancelled || resp.Endpoint.URL != url {
+			t.Errorf("expected a cancelled request to %s, got %+v", url, resp)
+		}
+	}
+	if numResps != 1 {
+		t.Errorf("expected 1 response, got %d", numResps)
+	}
 }
 
 func TestTimeout(t *testing.T) {
@@ -144,9 +166,219 @@ func TestTimeout(t *testing.T) {
 	wg := sync.WaitGroup{}
 	wg.Add(1)
 	go func() {
-		rqstr.ProcessRqst(ep, 0, 1000)
+		rqstr.ProcessRqst(ep, newRatePacer(0, 1000, constantArrival{}))
 		wg.Done()
 	}()
 
 	wg.Wait()
 }
+
+// TestRqstErrors verifies that failed requests are reported, with the correct
+// classification, and that the Requestor continues to send the remaining requests
+// after a failure.
+func TestRqstErrors(t *testing.T) {
+	refusedLsnr, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to create listener: %s", err)
+	}
+	refusedURL := "http://" + refusedLsnr.Addr().String() + "/testme"
+	refusedLsnr.Close()
+
+	slowSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slowSrv.Close()
+
+	truncatingSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, bufrw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		bufrw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\nnot 100 bytes")
+		bufrw.Flush()
+		conn.Close()
+	}))
+	defer truncatingSrv.Close()
+
+	tests := []struct {
+		name              string
+		url               string
+		clientTimeout     time.Duration
+		expectedErrorType string
+	}{
+		{name: "connection refused", url: refusedURL, expectedErrorType: api.ErrConnRefused},
+		{name: "client timeout", url: slowSrv.URL, clientTimeout: 20 * time.Millisecond, expectedErrorType: api.ErrTimeout},
+		{name: "body read error", url: truncatingSrv.URL, expectedErrorType: api.ErrBodyRead},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			numRqsts := 3
+			respC := make(chan Response, numRqsts)
+			rqstr := Requestor{
+				Ctx:       context.Background(),
+				ResponseC: respC,
+				Client:    http.Client{Timeout: tc.clientTimeout},
+			}
+			ep := api.Endpoint{URL: tc.url, Method: http.MethodGet, RqstPercent: 100}
+
+			rqstr.ProcessRqst(ep, newRatePacer(numRqsts, 0, constantArrival{}))
+			close(respC)
+
+			numResps := 0
+			for resp := range respC {
+				numResps++
+				if resp.ErrorType != tc.expectedErrorType {
+					t.Errorf("expected error type %s, got %s (%s)", tc.expectedErrorType, resp.ErrorType, resp.Error)
+				}
+			}
+			if numResps != numRqsts {
+				t.Errorf("expected %d responses, got %d", numRqsts, numResps)
+			}
+		})
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{name: "DNS", err: &url.Error{Op: "Get", URL: "x", Err: &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host"}}}, expected: api.ErrDNS},
+		{name: "refused", err: &url.Error{Op: "Get", URL: "x", Err: &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}}, expected: api.ErrConnRefused},
+		{name: "reset", err: &url.Error{Op: "Get", URL: "x", Err: &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}}, expected: api.ErrConnReset},
+		{name: "EOF", err: &url.Error{Op: "Get", URL: "x", Err: io.EOF}, expected: api.ErrConnReset},
+		{name: "TLS", err: &url.Error{Op: "Get", URL: "x", Err: x509.UnknownAuthorityError{}}, expected: api.ErrTLSHandshake},
+		{name: "TLS verification", err: &url.Error{Op: "Get", URL: "x", Err: &tls.CertificateVerificationError{Err: x509.HostnameError{}}}, expected: api.ErrTLSHandshake},
+		{name: "TLS record header", err: &url.Error{Op: "Get", URL: "x", Err: tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}}, expected: api.ErrTLSHandshake},
+		{name: "TLS alert", err: &url.Error{Op: "Get", URL: "x", Err: &net.OpError{Op: "remote error", Err: tls.AlertError(40)}}, expected: api.ErrTLSHandshake},
+		{name: "TLS in message", err: errors.New("tls: not a TLS error"), expected: api.ErrOther},
+		{name: "cancelled", err: &url.Error{Op: "Get", URL: "x", Err: context.Canceled}, expected: api.ErrCtxCancelled},
+		{name: "deadline", err: &url.Error{Op: "Get", URL: "x", Err: context.DeadlineExceeded}, expected: api.ErrTimeout},
+		{name: "other", err: errors.New("something else"), expected: api.ErrOther},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if actual := classifyError(tc.err); actual != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, actual)
+			}
+		})
+	}
+}
+
+// TestRqstBodyResent verifies that the configured request body and headers are sent
+// with every request, not just the first one.
+func TestRqstBodyResent(t *testing.T) {
+	body := `{"accountid":1,"name":"Brian Wilson"}`
+	var mux sync.Mutex
+	rcvdBodies := []string{}
+	rcvdHeaders := []string{}
+
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		mux.Lock()
+		rcvdBodies = append(rcvdBodies, string(b))
+		rcvdHeaders = append(rcvdHeaders, r.Header.Get("Content-Type"))
+		mux.Unlock()
+	}))
+	defer testSrv.Close()
+
+	numRqsts := 5
+	respC := make(chan Response, numRqsts)
+	rqstr := Requestor{
+		Ctx:       context.Background(),
+		ResponseC: respC,
+		Client:    http.Client{},
+	}
+	ep := api.Endpoint{
+		URL:         testSrv.URL + "/users/1",
+		Method:      http.MethodPut,
+		RqstBody:    body,
+		RqstPercent: 100,
+		Headers:     map[string]string{"Content-Type": "application/json"},
+	}
+
+	rqstr.ProcessRqst(ep, newRatePacer(numRqsts, 0, constantArrival{}))
+	close(respC)
+
+	for resp := range respC {
+		if resp.ErrorType != "" {
+			t.Errorf("unexpected error %s", resp.Error)
+		}
+	}
+	if len(rcvdBodies) != numRqsts {
+		t.Fatalf("expected %d requests, got %d", numRqsts, len(rcvdBodies))
+	}
+	for i := range rcvdBodies {
+		if rcvdBodies[i] != body {
+			t.Errorf("request %d: expected body %s, got %q", i, body, rcvdBodies[i])
+		}
+		if rcvdHeaders[i] != "application/json" {
+			t.Errorf("request %d: expected Content-Type application/json, got %q", i, rcvdHeaders[i])
+		}
+	}
+}
+
+// TestRqstAssertions verifies that a 200 response with an error payload fails the
+// endpoint's assertions while a correct response passes them.
+func TestRqstAssertions(t *testing.T) {
+	var mux sync.Mutex
+	numRcvd := 0
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		numRcvd++
+		n := numRcvd
+		mux.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if n%2 == 0 {
+			w.Write([]byte(`{"status": "error", "message": "database unavailable"}`))
+			return
+		}
+		w.Write([]byte(`{"status": "ok"}`))
+	}))
+	defer testSrv.Close()
+
+	numRqsts := 4
+	respC := make(chan Response, numRqsts)
+	rqstr := Requestor{
+		Ctx:       context.Background(),
+		ResponseC: respC,
+		Client:    http.Client{},
+	}
+	ep := api.Endpoint{
+		URL:         testSrv.URL,
+		Method:      http.MethodGet,
+		RqstPercent: 100,
+		Assertions: &api.Assertions{
+			Status:   []string{"2xx"},
+			Headers:  map[string]string{"Content-Type": "application/json"},
+			JSONPath: map[string]interface{}{"$.status": "ok"},
+		},
+	}
+
+	rqstr.ProcessRqst(ep, newRatePacer(numRqsts, 0, constantArrival{}))
+	close(respC)
+
+	passed, failed := 0, 0
+	for resp := range respC {
+		if !resp.Asserted {
+			t.Errorf("expected response to be checked against assertions")
+		}
+		if resp.HTTPStatus != http.StatusOK {
+			t.Errorf("expected HTTP status %d, got %d", http.StatusOK, resp.HTTPStatus)
+		}
+		if len(resp.AssertionFailures) == 0 {
+			passed++
+			continue
+		}
+		failed++
+		if len(resp.AssertionFailures) != 1 || resp.AssertionFailures[0] != api.AssertJSONPath {
+			t.Errorf("expected a %s failure, got %v", api.AssertJSONPath, resp.AssertionFailures)
+		}
+	}
+	if passed != 2 || failed != 2 {
+		t.Errorf("expected 2 passed and 2 failed, got %d and %d", passed, failed)
+	}
+}
@@ -9,10 +9,12 @@ import (
 	"fmt"
 	"math"
 	"net/http"
+	"os"
 	"sort"
 	"strings"
 	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/rs/zerolog/log"
 	"github.com/youngkin/heyyall/api"
 )
@@ -20,7 +22,14 @@ import (
 // Response contains information describing the results
 // of a request to a specific endpoint
 type Response struct {
-	HTTPStatus           int
+	// HTTPStatus is 0 if no response was received. gRPC calls that return OK have a
+	// status of 200.
+	HTTPStatus int
+	// ErrorType classifies why a request failed, e.g., api.ErrConnRefused. It's
+	// empty if the request succeeded.
+	ErrorType string
+	// Error is the error returned by the failed request
+	Error                string
 	Endpoint             api.Endpoint
 	Header               http.Header
 	RequestDuration      time.Duration
@@ -28,6 +37,52 @@ type Response struct {
 	TCPConnDuration      time.Duration
 	RoundTripDuration    time.Duration
 	TLSHandshakeDuration time.Duration
+	// QUICHandshakeDuration is only meaningful for HTTP/3 responses
+	QUICHandshakeDuration time.Duration
+	// Protocol is the protocol the response was received with, e.g., "HTTP/2.0"
+	Protocol string
+	// NewConn is true if the request was sent on a newly opened connection
+	NewConn bool
+	// WebSocket describes the messages exchanged on the connection opened by a request to
+	// a WebSocket endpoint. It's nil for other endpoints, and if the connection couldn't
+	// be opened.
+	WebSocket *wsResult
+	// Stage is the index of the load stage during which the request was sent. It's
+	// only meaningful for staged runs.
+	Stage int
+	// Late is true if the request was sent later than its intended send time
+	Late bool
+	// DroppedRqsts, if greater than zero, is the number of requests to Endpoint that
+	// weren't sent because all of its concurrent requests were in progress. Responses
+	// reporting dropped requests don't describe a request that was sent.
+	DroppedRqsts int
+	// Asserted is true if the response was checked against its Endpoint's Assertions
+	Asserted bool
+	// AssertionFailures lists the kinds of assertions, e.g., api.AssertStatus, the
+	// response failed. It's empty if the response passed, or wasn't checked.
+	AssertionFailures []string
+	// Scenario is the name of the scenario whose step sent the request. It's empty if
+	// the request wasn't sent by a scenario.
+	Scenario string
+	// Step is the position, starting from 1, of the scenario step that sent the request
+	Step int
+	// IterationDone is true if the request ended its scenario iteration, either because
+	// it was the iteration's last step or because the step failed
+	IterationDone bool
+	// IterationFailed is true if the iteration ended because the step failed
+	IterationFailed bool
+	// IterationDuration is how long the iteration took, from when its first step was
+	// due until the request completed. It's only meaningful if IterationDone is true.
+	IterationDuration time.Duration
+}
+
+// failure returns why the request failed, either its ErrorType or, if the response failed
+// its Endpoint's Assertions, api.ErrAssertion. It's empty if the request succeeded.
+func (resp Response) failure() string {
+	if resp.ErrorType == "" && len(resp.AssertionFailures) > 0 {
+		return api.ErrAssertion
+	}
+	return resp.ErrorType
 }
 
 // ResponseHandler is responsible for accepting, summarizing, and reporting
@@ -39,6 +94,43 @@ type ResponseHandler struct {
 	DoneC      chan interface{}
 	NumRqsts   int
 	NormFactor int
+	// HistSigDigits is the number of significant digits latencies are recorded with.
+	// If 0, api.DefaultHistogramSigDigits is used.
+	HistSigDigits int
+	// HistMaxLatency is the largest latency that can be recorded. If 0, api.MaxRunDuration
+	// is used.
+	HistMaxLatency time.Duration
+	// LoadProfile, if not nil, describes the stages of a staged run. Results will
+	// be summarized per stage in addition to over the entire run.
+	LoadProfile *LoadProfile
+	// Scenarios are the run's scenarios. Their iterations and steps are summarized in
+	// addition to the requests to each endpoint.
+	Scenarios []api.Scenario
+	// Thresholds are evaluated against the run's results once the run is complete
+	Thresholds []api.Threshold
+	// TimeSeriesInterval, if greater than zero, is the length of the intervals the
+	// results are also bucketed into, by when each response is received
+	TimeSeriesInterval time.Duration
+	// Metrics, if not nil, is updated with each response as it's received
+	Metrics *Metrics
+	// Baseline, if not nil, are the results of an earlier run. The run's results will be
+	// compared to them and regressions reported.
+	Baseline *api.RunResults
+	// TolerancePct is the percent a metric can worsen, relative to Baseline, before it's
+	// considered a regression
+	TolerancePct float64
+	// Silent, if true, prevents Start from reporting the run's results, e.g., when they're
+	// to be merged with those of other runs. They're available from Results().
+	Silent bool
+	// results are the run's results, they're set once the run is complete
+	results api.RunResults
+	// thresholdsFailed is true if any of the Thresholds weren't met
+	thresholdsFailed bool
+	// regressed is true if any metric regressed relative to Baseline
+	regressed bool
+	// err is the error, if any, that prevented the run's results from being summarized
+	// or reported
+	err error
 	// histogram contains a count of observations that are <= to the value of the key.
 	// The key is a number that represents response duration.
 	histogram map[float64]int
@@ -49,13 +141,15 @@ func (rh *ResponseHandler) Start() {
 	log.Debug().Msg("ResponseHandler starting")
 
 	epRunSummary := make(map[string]*api.EndpointDetail)
-	runSummary := api.RunSummary{RqstStats: api.RqstStats{MaxRqstDurationNanos: time.Duration(-1), MinRqstDurationNanos: time.Duration(math.MaxInt64)}}
-	runResults := api.RunResults{RunSummary: runSummary}
-	runResults.EndpointSummary = make(map[string]map[string]int)
+	runResults := rh.newRunResults()
 
 	start := time.Now()
 	var totalRunTime time.Duration
-	responses := make([]Response, 0, 10)
+
+	var ts *timeSeries
+	if rh.TimeSeriesInterval > 0 {
+		ts = newTimeSeries(rh.TimeSeriesInterval, rh.newRqstStats)
+	}
 
 	for {
 		select {
@@ -64,78 +158,265 @@ func (rh *ResponseHandler) Start() {
 				defer close(rh.DoneC)
 				log.Debug().Msg("ResponseHandler: Summarizing results and exiting")
 
-				for _, r := range responses {
-					rh.accumulateResponseStats(r, &totalRunTime, &runResults, epRunSummary)
-					runResults.RunSummary.DNSLookupNanos = append(runResults.RunSummary.DNSLookupNanos, r.DNSLookupDuration)
-					runResults.RunSummary.TCPConnSetupNanos = append(runResults.RunSummary.TCPConnSetupNanos, r.TCPConnDuration)
-					runResults.RunSummary.RqstRoundTripNanos = append(runResults.RunSummary.RqstRoundTripNanos, r.RoundTripDuration)
-					runResults.RunSummary.TLSHandshakeNanos = append(runResults.RunSummary.TLSHandshakeNanos, r.TLSHandshakeDuration)
-				}
-
 				err := rh.finalizeResponseStats(start, &totalRunTime, &runResults, epRunSummary)
 				if err != nil {
-					log.Error().Err(err)
+					log.Error().Err(err).Msg("error summarizing the run's results")
+					rh.err = fmt.Errorf("unable to summarize the run's results: %w", err)
 					return
 				}
+				if ts != nil {
+					runResults.TimeSeries = ts.finish(runResults.RunSummary.RunDurationNanos)
+				}
+				rh.results = runResults
+				if !rh.Silent {
+					rh.Report(runResults)
+				}
+				return
+			}
+
+			// Stats are accumulated as each response arrives so that memory use stays constant
+			// regardless of how many requests are made or how long the test runs.
+			rh.accumulateResponseStats(resp, &totalRunTime, &runResults, epRunSummary)
+			if ts != nil {
+				ts.add(resp, time.Since(start))
+			}
+			rh.Metrics.observe(resp)
+			// If rh.NumRqsts > 0 then the load test is being limited by total number of requests sent, not time.
+			// In this case each received request represents progress that must be recorded, as does
+			// each dropped request since it counts towards NumRqsts too.
+			if rh.NumRqsts > 0 {
+				progress := 1
+				if resp.DroppedRqsts > 0 {
+					progress = resp.DroppedRqsts
+				}
+				for i := 0; i < progress; i++ {
+					rh.ProgressC <- struct{}{}
+				}
+			}
+		}
+	}
+}
 
-				if rh.OutputType == Text {
-					fmt.Println("")
-					printRunSummary(runResults.RunSummary)
+// Report evaluates the Thresholds against 'runResults', compares them to the Baseline,
+// and reports them according to OutputType. Start reports the run's results once the
+// run is complete unless Silent is true.
+func (rh *ResponseHandler) Report(runResults api.RunResults) {
+	if len(rh.Thresholds) > 0 {
+		runResults.ThresholdResults = evaluateThresholds(rh.Thresholds, &runResults)
+		rh.thresholdsFailed = !thresholdsPassed(runResults.ThresholdResults)
+	}
+	if rh.Baseline != nil {
+		runResults.Comparison = CompareRunResults(*rh.Baseline, runResults, rh.TolerancePct)
+		rh.regressed = runResults.Comparison.Regressions > 0
+	}
 
-					fmt.Println("")
-					printRqstLatency(runResults.RunSummary.RqstStats)
+	if rh.OutputType == Text {
+		fmt.Println("")
+		printRunSummary(runResults.RunSummary)
 
-					min, max := rh.generateHistogram(&runResults)
-					fmt.Printf("\nRequest Latency Histogram (secs):\n")
-					fmt.Println(rh.generateHistogramString(min, max))
+		fmt.Println("")
+		printRqstLatency(runResults.RunSummary.RqstStats)
 
-					fmt.Println("")
-					printEndpointDetails(runResults.EndpointDetails)
+		min, max := rh.generateHistogram(&runResults)
+		fmt.Printf("\nRequest Latency Histogram (secs):\n")
+		fmt.Println(rh.generateHistogramString(min, max))
 
-					fmt.Println("")
-					printNetworkDetails(runResults.RunSummary)
+		fmt.Println("")
+		printEndpointDetails(runResults.EndpointDetails)
 
-					return
-				}
+		fmt.Println("")
+		printNetworkDetails(runResults.RunSummary)
 
-				rsjson, err := json.MarshalIndent(runResults, "    ", "  ")
-				if err != nil {
-					log.Error().Err(err).Msgf("error marshaling RunSummary into string: %+v.\n", runResults)
-					return
-				}
-				fmt.Printf("%s\n", string(rsjson[2:len(rsjson)-1]))
+		if hasConnDetails(runResults.EndpointDetails) {
+			fmt.Println("")
+			printConnDetails(runResults.EndpointDetails)
+		}
 
-				return
-			}
+		if hasWebSocketDetails(runResults.EndpointDetails) {
+			fmt.Println("")
+			printWebSocketDetails(runResults.EndpointDetails)
+		}
 
-			responses = append(responses, resp)
-			// If rh.NumRqsts > 0 then the load test is being limited by total number of requests sent, not time.
-			// In this case each received request represents progress that must be recorded.
-			if rh.NumRqsts > 0 {
-				rh.ProgressC <- struct{}{}
-			}
+		fmt.Println("")
+		printErrorDetails(runResults)
+
+		if hasAssertionResults(runResults.EndpointDetails) {
+			fmt.Println("")
+			printAssertionDetails(runResults.EndpointDetails)
+		}
+
+		if len(runResults.StageSummaries) > 0 {
+			fmt.Println("")
+			printStageDetails(runResults.StageSummaries)
+		}
+
+		if len(runResults.ScenarioSummaries) > 0 {
+			fmt.Println("")
+			printScenarioDetails(runResults.ScenarioSummaries)
+		}
+
+		if len(runResults.TimeSeries) > 0 {
+			fmt.Println("")
+			printTimeSeries(runResults.TimeSeries)
 		}
+
+		if len(runResults.ThresholdResults) > 0 {
+			fmt.Println("")
+			printThresholdResults(runResults.ThresholdResults)
+		}
+
+		if runResults.Comparison != nil {
+			PrintComparison(runResults.Comparison)
+		}
+
+		return
+	}
+
+	if rh.OutputType == HTML {
+		rh.generateHistogram(&runResults)
+		if err := writeHTMLReport(os.Stdout, runResults, rh.histogramChart()); err != nil {
+			log.Error().Err(err).Msg("error writing HTML report")
+			rh.err = fmt.Errorf("unable to write the HTML report: %w", err)
+		}
+		return
+	}
+
+	rsjson, err := json.MarshalIndent(runResults, "", "  ")
+	if err != nil {
+		log.Error().Err(err).Msgf("error marshaling RunSummary into string: %+v.\n", runResults)
+		rh.err = fmt.Errorf("unable to write the JSON report: %w", err)
+		return
+	}
+	fmt.Printf("%s\n", string(rsjson))
+}
+
+// Results returns the run's results. It's only valid after DoneC has been closed.
+func (rh *ResponseHandler) Results() api.RunResults {
+	return rh.results
+}
+
+// ThresholdsPassed returns true if all the Thresholds were met. It's only valid after
+// DoneC has been closed, or Report has returned.
+func (rh *ResponseHandler) ThresholdsPassed() bool {
+	return !rh.thresholdsFailed
+}
+
+// Regressed returns true if any metric regressed relative to Baseline. It's only valid
+// after DoneC has been closed, or Report has returned.
+func (rh *ResponseHandler) Regressed() bool {
+	return rh.regressed
+}
+
+// Err returns the error, if any, that prevented the run's results from being summarized
+// or reported, in which case the Thresholds may not have been evaluated. It's only valid
+// after DoneC has been closed, or Report has returned.
+func (rh *ResponseHandler) Err() error {
+	return rh.err
+}
+
+// newRunResults returns an api.RunResults that's ready to accumulate response stats
+func (rh *ResponseHandler) newRunResults() api.RunResults {
+	return api.RunResults{
+		Version: api.ReportVersion,
+		RunSummary: api.RunSummary{
+			RqstStats:          *rh.newRqstStats(),
+			DNSLookupNanos:     rh.newHistogram(),
+			TCPConnSetupNanos:  rh.newHistogram(),
+			RqstRoundTripNanos: rh.newHistogram(),
+			TLSHandshakeNanos:  rh.newHistogram(),
+		},
+		EndpointSummary:   make(map[string]map[string]int),
+		StageSummaries:    rh.newStageSummaries(),
+		ScenarioSummaries: rh.newScenarioSummaries(),
 	}
 }
 
+// newScenarioSummaries returns an api.ScenarioSummary for each of the Scenarios, if any
+func (rh *ResponseHandler) newScenarioSummaries() []*api.ScenarioSummary {
+	summaries := make([]*api.ScenarioSummary, 0, len(rh.Scenarios))
+	for _, sc := range rh.Scenarios {
+		summary := &api.ScenarioSummary{Name: sc.Name, IterationStats: *rh.newRqstStats()}
+		for _, step := range sc.Steps {
+			summary.Steps = append(summary.Steps, &api.StepSummary{Name: stepName(step), RqstStats: *rh.newRqstStats()})
+		}
+		summaries = append(summaries, summary)
+	}
+	if len(summaries) == 0 {
+		return nil
+	}
+	return summaries
+}
+
+// newStageSummaries returns an api.StageSummary for each stage of the LoadProfile, if any
+func (rh *ResponseHandler) newStageSummaries() []*api.StageSummary {
+	if rh.LoadProfile == nil {
+		return nil
+	}
+	stages := make([]*api.StageSummary, 0, rh.LoadProfile.NumStages())
+	for i := 0; i < rh.LoadProfile.NumStages(); i++ {
+		dur, rate, concurrency := rh.LoadProfile.StageInfo(i)
+		stages = append(stages, &api.StageSummary{
+			Stage:                 i + 1,
+			DurationNanos:         dur,
+			TargetRqstRate:        rate,
+			TargetConcurrentRqsts: concurrency,
+			RqstStats:             *rh.newRqstStats(),
+		})
+	}
+	return stages
+}
+
+// newRqstStats returns an api.RqstStats whose min and max durations are set so that
+// the first recorded duration will replace them.
+func (rh *ResponseHandler) newRqstStats() *api.RqstStats {
+	return &api.RqstStats{
+		TimingResultsNanos:   rh.newHistogram(),
+		MaxRqstDurationNanos: time.Duration(-1),
+		MinRqstDurationNanos: time.Duration(math.MaxInt64),
+	}
+}
+
+// newHistogram returns a latency histogram with the configured precision and range
+func (rh *ResponseHandler) newHistogram() *api.Histogram {
+	return api.NewHistogram(rh.HistSigDigits, rh.HistMaxLatency)
+}
+
 func (rh *ResponseHandler) finalizeResponseStats(start time.Time, totalRunTime *time.Duration,
 	runResults *api.RunResults, epRunSummary map[string]*api.EndpointDetail) error {
 
 	runResults.RunSummary.RunDurationNanos = time.Since(start)
 	runResults.RunSummary.RqstStats.AvgRqstDurationNanos = time.Duration(0)
-	if runResults.RunSummary.RqstStats.TotalRqsts > 0 {
-		runResults.RunSummary.RqstStats.AvgRqstDurationNanos = *totalRunTime / time.Duration(runResults.RunSummary.RqstStats.TotalRqsts)
+	if numOK := runResults.RunSummary.RqstStats.TotalRqsts - runResults.RunSummary.RqstStats.TotalErrors; numOK > 0 {
+		runResults.RunSummary.RqstStats.AvgRqstDurationNanos = *totalRunTime / time.Duration(numOK)
 	}
+	clearMinMax(&runResults.RunSummary.RqstStats)
 
 	runResults.RunSummary.RqstRatePerSec = (float64(runResults.RunSummary.RqstStats.TotalRqsts) / float64(runResults.RunSummary.RunDurationNanos)) * float64(time.Second)
+	runResults.RunSummary.OfferedRqstRatePerSec = (float64(runResults.RunSummary.RqstStats.TotalRqsts+runResults.RunSummary.DroppedRqsts) /
+		float64(runResults.RunSummary.RunDurationNanos)) * float64(time.Second)
 
 	runResults.EndpointDetails = epRunSummary
 
+	for _, stage := range runResults.StageSummaries {
+		finalizeRqstStats(&stage.RqstStats)
+		stage.RqstRatePerSec = float64(stage.RqstStats.TotalRqsts) / stage.DurationNanos.Seconds()
+	}
+
+	for _, summary := range runResults.ScenarioSummaries {
+		finalizeRqstStats(&summary.IterationStats)
+		for _, step := range summary.Steps {
+			finalizeRqstStats(&step.RqstStats)
+		}
+	}
+
 	for _, epDetail := range epRunSummary {
+		finalizeConnStats(epDetail)
+		finalizeWebSocketStats(epDetail, runResults.RunSummary.RunDurationNanos)
 		for _, methodRqstStats := range epDetail.HTTPMethodRqstStats {
-			if methodRqstStats.TotalRqsts > 0 {
-				methodRqstStats.AvgRqstDurationNanos = (methodRqstStats.TotalRequestDurationNanos / time.Duration(methodRqstStats.TotalRqsts))
+			if numOK := methodRqstStats.TotalRqsts - methodRqstStats.TotalErrors; numOK > 0 {
+				methodRqstStats.AvgRqstDurationNanos = (methodRqstStats.TotalRequestDurationNanos / time.Duration(numOK))
 			}
+			clearMinMax(methodRqstStats)
 			log.Debug().Msgf("EndpointSummary: %+v", epDetail)
 		}
 	}
@@ -146,16 +427,23 @@ func (rh *ResponseHandler) finalizeResponseStats(start time.Time, totalRunTime *
 func (rh *ResponseHandler) accumulateResponseStats(resp Response, totalRunTime *time.Duration,
 	runResults *api.RunResults, epRunSummary map[string]*api.EndpointDetail) {
 
-	runResults.RunSummary.RqstStats.TimingResultsNanos = append(runResults.RunSummary.RqstStats.TimingResultsNanos, resp.RequestDuration)
-	runResults.RunSummary.RqstStats.TotalRqsts++
-	runResults.RunSummary.RqstStats.TotalRequestDurationNanos += resp.RequestDuration
-	*totalRunTime = *totalRunTime + resp.RequestDuration
+	if resp.DroppedRqsts > 0 {
+		runResults.RunSummary.DroppedRqsts += int64(resp.DroppedRqsts)
+		if resp.Stage < len(runResults.StageSummaries) {
+			runResults.StageSummaries[resp.Stage].DroppedRqsts += int64(resp.DroppedRqsts)
+		}
+		return
+	}
 
-	if resp.RequestDuration > runResults.RunSummary.RqstStats.MaxRqstDurationNanos {
-		runResults.RunSummary.RqstStats.MaxRqstDurationNanos = resp.RequestDuration
+	runResults.RunSummary.RqstStats.TotalRqsts++
+	if resp.Late {
+		runResults.RunSummary.LateRqsts++
 	}
-	if resp.RequestDuration < runResults.RunSummary.RqstStats.MinRqstDurationNanos {
-		runResults.RunSummary.RqstStats.MinRqstDurationNanos = resp.RequestDuration
+	if resp.Stage < len(runResults.StageSummaries) {
+		accumulateRqstStats(&runResults.StageSummaries[resp.Stage].RqstStats, resp)
+	}
+	if resp.Scenario != "" {
+		accumulateScenarioStats(runResults.ScenarioSummaries, resp)
 	}
 
 	var epStatusCount map[string]int
@@ -172,6 +460,7 @@ func (rh *ResponseHandler) accumulateResponseStats(resp Response, totalRunTime *
 		epDetail = &api.EndpointDetail{
 			URL:                  resp.Endpoint.URL,
 			HTTPMethodStatusDist: make(map[string]map[int]int),
+			HTTPMethodErrorDist:  make(map[string]map[string]int),
 			HTTPMethodRqstStats:  make(map[string]*api.RqstStats),
 		}
 		epRunSummary[resp.Endpoint.URL] = epDetail
@@ -179,14 +468,62 @@ func (rh *ResponseHandler) accumulateResponseStats(resp Response, totalRunTime *
 
 	methodRqstStats, ok := epDetail.HTTPMethodRqstStats[resp.Endpoint.Method]
 	if !ok {
-		epDetail.HTTPMethodRqstStats[resp.Endpoint.Method] = &api.RqstStats{
-			MaxRqstDurationNanos: -1,
-			MinRqstDurationNanos: time.Duration(math.MaxInt64),
-		}
+		epDetail.HTTPMethodRqstStats[resp.Endpoint.Method] = rh.newRqstStats()
 		methodRqstStats = epDetail.HTTPMethodRqstStats[resp.Endpoint.Method]
 	}
-
 	methodRqstStats.TotalRqsts++
+	accumulateConnStats(epDetail, resp)
+	if resp.WebSocket != nil {
+		rh.accumulateWebSocketStats(epDetail, resp)
+	}
+
+	if resp.Asserted {
+		accumulateAssertionResults(epDetail, resp)
+	}
+	if resp.ErrorType == "" {
+		_, ok = epDetail.HTTPMethodStatusDist[resp.Endpoint.Method]
+		if !ok {
+			epDetail.HTTPMethodStatusDist[resp.Endpoint.Method] = make(map[int]int)
+			epDetail.HTTPMethodStatusDist[resp.Endpoint.Method][resp.HTTPStatus] = 0 // This is correct. It'll be incremented below
+		}
+		epDetail.HTTPMethodStatusDist[resp.Endpoint.Method][resp.HTTPStatus]++
+	}
+
+	// Failed requests are counted, but they aren't included in the latency stats since
+	// they're likely to be either much faster or much slower than successful requests.
+	// Responses that failed their assertions are failed requests too.
+	if failure := resp.failure(); failure != "" {
+		runResults.RunSummary.RqstStats.TotalErrors++
+		methodRqstStats.TotalErrors++
+		_, ok = epDetail.HTTPMethodErrorDist[resp.Endpoint.Method]
+		if !ok {
+			epDetail.HTTPMethodErrorDist[resp.Endpoint.Method] = make(map[string]int)
+		}
+		epDetail.HTTPMethodErrorDist[resp.Endpoint.Method][failure]++
+		return
+	}
+
+	runResults.RunSummary.RqstStats.TimingResultsNanos.Record(resp.RequestDuration)
+	runResults.RunSummary.DNSLookupNanos.Record(resp.DNSLookupDuration)
+	runResults.RunSummary.TCPConnSetupNanos.Record(resp.TCPConnDuration)
+	runResults.RunSummary.RqstRoundTripNanos.Record(resp.RoundTripDuration)
+	runResults.RunSummary.TLSHandshakeNanos.Record(resp.TLSHandshakeDuration)
+	if resp.Protocol == http3Proto {
+		if runResults.RunSummary.QUICHandshakeNanos == nil {
+			runResults.RunSummary.QUICHandshakeNanos = rh.newHistogram()
+		}
+		runResults.RunSummary.QUICHandshakeNanos.Record(resp.QUICHandshakeDuration)
+	}
+	runResults.RunSummary.RqstStats.TotalRequestDurationNanos += resp.RequestDuration
+	*totalRunTime = *totalRunTime + resp.RequestDuration
+
+	if resp.RequestDuration > runResults.RunSummary.RqstStats.MaxRqstDurationNanos {
+		runResults.RunSummary.RqstStats.MaxRqstDurationNanos = resp.RequestDuration
+	}
+	if resp.RequestDuration < runResults.RunSummary.RqstStats.MinRqstDurationNanos {
+		runResults.RunSummary.RqstStats.MinRqstDurationNanos = resp.RequestDuration
+	}
+
 	methodRqstStats.TotalRequestDurationNanos = methodRqstStats.TotalRequestDurationNanos + resp.RequestDuration
 
 	if resp.RequestDuration > methodRqstStats.MaxRqstDurationNanos {
@@ -195,15 +532,145 @@ func (rh *ResponseHandler) accumulateResponseStats(resp Response, totalRunTime *
 	if resp.RequestDuration < methodRqstStats.MinRqstDurationNanos {
 		methodRqstStats.MinRqstDurationNanos = resp.RequestDuration
 	}
-	methodRqstStats.TimingResultsNanos = append(methodRqstStats.TimingResultsNanos, resp.RequestDuration)
+	methodRqstStats.TimingResultsNanos.Record(resp.RequestDuration)
+}
+
+// accumulateConnStats adds the protocol and connection used by 'resp' to 'epDetail'
+func accumulateConnStats(epDetail *api.EndpointDetail, resp Response) {
+	if resp.NewConn {
+		epDetail.ConnsOpened++
+	}
+	if resp.Protocol == "" {
+		return
+	}
+	if epDetail.ProtocolDist == nil {
+		epDetail.ProtocolDist = make(map[string]int64)
+	}
+	epDetail.ProtocolDist[resp.Protocol]++
+}
+
+// finalizeConnStats calculates the average number of responses per connection opened
+// for 'epDetail', stats that were accumulated by accumulateConnStats
+func finalizeConnStats(epDetail *api.EndpointDetail) {
+	if epDetail.ConnsOpened == 0 {
+		return
+	}
+	var numResps int64
+	for _, count := range epDetail.ProtocolDist {
+		numResps += count
+	}
+	epDetail.RqstsPerConn = float64(numResps) / float64(epDetail.ConnsOpened)
+}
+
+// accumulateWebSocketStats adds the messages exchanged on the WebSocket connection
+// opened by 'resp' to 'epDetail'
+func (rh *ResponseHandler) accumulateWebSocketStats(epDetail *api.EndpointDetail, resp Response) {
+	if epDetail.WebSocket == nil {
+		epDetail.WebSocket = &api.WebSocketStats{
+			ConnectNanos:      rh.newHistogram(),
+			MsgRoundTripNanos: rh.newHistogram(),
+		}
+	}
+	stats, result := epDetail.WebSocket, resp.WebSocket
+	stats.ConnectNanos.Record(result.connectDuration)
+	for _, rtt := range result.msgRoundTrips {
+		stats.MsgRoundTripNanos.Record(rtt)
+	}
+	stats.MsgsSent += int64(result.msgsSent)
+	stats.MsgsRcvd += int64(result.msgsRcvd)
+	if result.closeCode != 0 && result.closeCode != websocket.CloseNormalClosure {
+		if stats.AbnormalCloseDist == nil {
+			stats.AbnormalCloseDist = make(map[int]int64)
+		}
+		stats.AbnormalCloseDist[result.closeCode]++
+	}
+}
 
-	_, ok = epDetail.HTTPMethodStatusDist[resp.Endpoint.Method]
+// finalizeWebSocketStats calculates the rates at which messages were sent and received
+// on the WebSocket connections of 'epDetail' over a run lasting 'runDur'
+func finalizeWebSocketStats(epDetail *api.EndpointDetail, runDur time.Duration) {
+	if epDetail.WebSocket == nil || runDur <= 0 {
+		return
+	}
+	epDetail.WebSocket.MsgsSentPerSec = float64(epDetail.WebSocket.MsgsSent) / runDur.Seconds()
+	epDetail.WebSocket.MsgsRcvdPerSec = float64(epDetail.WebSocket.MsgsRcvd) / runDur.Seconds()
+}
+
+// accumulateAssertionResults adds the results of checking 'resp' against its Endpoint's
+// Assertions to 'epDetail'
+func accumulateAssertionResults(epDetail *api.EndpointDetail, resp Response) {
+	if epDetail.HTTPMethodAssertionResults == nil {
+		epDetail.HTTPMethodAssertionResults = make(map[string]*api.AssertionResults)
+	}
+	results, ok := epDetail.HTTPMethodAssertionResults[resp.Endpoint.Method]
 	if !ok {
-		epDetail.HTTPMethodStatusDist[resp.Endpoint.Method] = make(map[int]int)
-		epDetail.HTTPMethodStatusDist[resp.Endpoint.Method][resp.HTTPStatus] = 0 // This is correct. It'll be incremented below
+		results = &api.AssertionResults{FailureDist: make(map[string]int64)}
+		epDetail.HTTPMethodAssertionResults[resp.Endpoint.Method] = results
+	}
+
+	if len(resp.AssertionFailures) == 0 {
+		results.Passed++
+		return
+	}
+	results.Failed++
+	for _, failure := range resp.AssertionFailures {
+		results.FailureDist[failure]++
+	}
+}
+
+// finalizeRqstStats calculates the average, min, and max durations of 'rs', stats that
+// were accumulated by accumulateRqstStats
+func finalizeRqstStats(rs *api.RqstStats) {
+	if numOK := rs.TotalRqsts - rs.TotalErrors; numOK > 0 {
+		rs.AvgRqstDurationNanos = rs.TotalRequestDurationNanos / time.Duration(numOK)
+	}
+	rs.MinRqstDurationNanos = rs.TimingResultsNanos.Min()
+	rs.MaxRqstDurationNanos = rs.TimingResultsNanos.Max()
+}
+
+// clearMinMax zeroes the min and max durations of 'rs' if none of its requests succeeded,
+// otherwise they'd be left at their initial values
+func clearMinMax(rs *api.RqstStats) {
+	if rs.TotalRqsts-rs.TotalErrors == 0 {
+		rs.MinRqstDurationNanos = 0
+		rs.MaxRqstDurationNanos = 0
+	}
+}
+
+// accumulateScenarioStats adds 'resp', a response to a scenario step, to the summaries of
+// the step and, if the response ended an iteration, of the scenario's iterations
+func accumulateScenarioStats(summaries []*api.ScenarioSummary, resp Response) {
+	for _, summary := range summaries {
+		if summary.Name != resp.Scenario {
+			continue
+		}
+		if resp.Step > 0 && resp.Step <= len(summary.Steps) {
+			accumulateRqstStats(&summary.Steps[resp.Step-1].RqstStats, resp)
+		}
+		if !resp.IterationDone {
+			return
+		}
+		summary.IterationStats.TotalRqsts++
+		if resp.IterationFailed {
+			summary.IterationStats.TotalErrors++
+			return
+		}
+		summary.IterationStats.TotalRequestDurationNanos += resp.IterationDuration
+		summary.IterationStats.TimingResultsNanos.Record(resp.IterationDuration)
+		return
 	}
-	epDetail.HTTPMethodStatusDist[resp.Endpoint.Method][resp.HTTPStatus]++
+}
 
+// accumulateRqstStats adds 'resp' to 'rs', e.g., the stats of the stage it was sent in.
+// Only the overall counts and latencies are accumulated.
+func accumulateRqstStats(rs *api.RqstStats, resp Response) {
+	rs.TotalRqsts++
+	if resp.failure() != "" {
+		rs.TotalErrors++
+		return
+	}
+	rs.TotalRequestDurationNanos += resp.RequestDuration
+	rs.TimingResultsNanos.Record(resp.RequestDuration)
 }
 
 // generateHistogram populates the histogram map, a map keyed by a float64 that's
@@ -211,8 +678,10 @@ func (rh *ResponseHandler) accumulateResponseStats(resp Response, totalRunTime *
 // of that number. It returns the min and max values for the histogram, i.e., the
 // min and max number of observations in the histogram.
 func (rh *ResponseHandler) generateHistogram(runResults *api.RunResults) (minBinCount, maxBinCount int) {
-	numBins := calcNumBinsSturgesMethod(len(runResults.RunSummary.RqstStats.TimingResultsNanos))
-	// numBins := calcNumBinsRiceMethod(len(runResults.RunSummary.RqstStats.TimingResultsNanos))
+	observations := runResults.RunSummary.RqstStats.TimingResultsNanos.Distribution()
+	numObservations := runResults.RunSummary.RqstStats.TimingResultsNanos.Count()
+	numBins := calcNumBinsSturgesMethod(int(numObservations))
+	// numBins := calcNumBinsRiceMethod(int(numObservations))
 	runResults.RunSummary.RqstStats.NormalizedMaxRqstDurationNanos = time.Duration(rh.NormFactor) * runResults.RunSummary.RqstStats.MinRqstDurationNanos
 
 	binWidth := float64(runResults.RunSummary.RqstStats.MaxRqstDurationNanos) / float64(numBins)
@@ -234,12 +703,16 @@ func (rh *ResponseHandler) generateHistogram(runResults *api.RunResults) (minBin
 	// NOTE: this algorithm depends on 'binValues' being sorted in ascending order. This ensures
 	// that the observation gets assigned to the correct bin, i.e., the lowest bin value that is
 	// >= to the observation. 'binValues' is a slice whose values are appended in ascending order,
-	// so it is already sorted.
-	for _, observation := range runResults.RunSummary.RqstStats.TimingResultsNanos {
+	// so it is already sorted. Each 'observation' is a histogram bar that covers a range of equivalent
+	// durations, the lowest of which is used to select the bin.
+	for _, observation := range observations {
+		if observation.Count == 0 {
+			continue
+		}
 		// TODO: Might be able to get this to O(n*Log(n))) if did a binary search on binKeys as it's sorted
 		for _, binVal := range binValues {
-			if float64(observation) <= binVal {
-				rh.histogram[binVal]++
+			if float64(observation.From) <= binVal {
+				rh.histogram[binVal] += int(observation.Count)
 				if rh.histogram[binVal] > maxBinCount {
 					maxBinCount = rh.histogram[binVal]
 				}
@@ -260,9 +733,9 @@ func (rh *ResponseHandler) generateHistogram(runResults *api.RunResults) (minBin
 		// MaxRqstDuration.
 		largestBinKey := binWidth * float64(numBins)
 		var tailBinCount int
-		for _, observation := range runResults.RunSummary.RqstStats.TimingResultsNanos {
-			if float64(observation) > largestBinKey {
-				tailBinCount++
+		for _, observation := range observations {
+			if float64(observation.From) > largestBinKey {
+				tailBinCount += int(observation.Count)
 			}
 		}
 		rh.histogram[float64(runResults.RunSummary.RqstStats.MaxRqstDurationNanos)] = tailBinCount
@@ -281,11 +754,7 @@ func (rh *ResponseHandler) generateHistogramString(min, max int) string {
 	// barUnit := "⭆"
 	// barUnit := '➯'
 
-	keys := make([]float64, 0, len(rh.histogram))
-	for k := range rh.histogram {
-		keys = append(keys, k)
-	}
-	sort.Float64s(keys)
+	keys := rh.histogramKeys()
 
 	var sb strings.Builder
 	sb.WriteString(fmt.Sprintf("\tLatency   Observations\n"))
@@ -303,6 +772,17 @@ func (rh *ResponseHandler) generateHistogramString(min, max int) string {
 	return sb.String()
 }
 
+// histogramKeys returns the keys of the histogram map, i.e., the upper bound of each
+// bin, in ascending order
+func (rh *ResponseHandler) histogramKeys() []float64 {
+	keys := make([]float64, 0, len(rh.histogram))
+	for k := range rh.histogram {
+		keys = append(keys, k)
+	}
+	sort.Float64s(keys)
+	return keys
+}
+
 func calcNumBinsSturgesMethod(numObservations int) int {
 	return int(math.Ceil(math.Log2(float64(numObservations) + 1)))
 }
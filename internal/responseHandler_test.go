@@ -91,18 +91,9 @@ func TestResponseStats(t *testing.T) {
 	url1 := "http://someurl/1"
 	url2 := "http://someurl/2"
 	url3 := "http://someurl/3"
-	runResults := api.RunResults{
-		RunSummary: api.RunSummary{
-			RqstStats: api.RqstStats{
-				MinRqstDurationNanos: math.MaxInt64,
-				MaxRqstDurationNanos: 0,
-			},
-		},
-		EndpointSummary: make(map[string]map[string]int),
-	}
-	epRunSummary := make(map[string]*api.EndpointDetail)
-
 	rh := ResponseHandler{OutputType: JSON}
+	runResults := rh.newRunResults()
+	epRunSummary := make(map[string]*api.EndpointDetail)
 
 	// URL1
 	resp := Response{
@@ -313,7 +304,7 @@ func TestGenHistogramSturges(t *testing.T) {
 					RqstStats: api.RqstStats{
 						MinRqstDurationNanos: time.Nanosecond * 1,
 						MaxRqstDurationNanos: time.Nanosecond * 1,
-						TimingResultsNanos:   []time.Duration{time.Nanosecond * 1},
+						TimingResultsNanos:   durationHistogram(time.Nanosecond * 1),
 					},
 				},
 			},
@@ -331,7 +322,7 @@ func TestGenHistogramSturges(t *testing.T) {
 					RqstStats: api.RqstStats{
 						MinRqstDurationNanos: time.Nanosecond * 3,
 						MaxRqstDurationNanos: time.Nanosecond * 4,
-						TimingResultsNanos:   []time.Duration{time.Nanosecond * 3, time.Nanosecond * 4},
+						TimingResultsNanos:   durationHistogram(time.Nanosecond*3, time.Nanosecond*4),
 					},
 				},
 			},
@@ -349,7 +340,7 @@ func TestGenHistogramSturges(t *testing.T) {
 					RqstStats: api.RqstStats{
 						MinRqstDurationNanos: time.Nanosecond * 2,
 						MaxRqstDurationNanos: time.Nanosecond * 4,
-						TimingResultsNanos:   []time.Duration{time.Nanosecond * 2, time.Nanosecond * 4},
+						TimingResultsNanos:   durationHistogram(time.Nanosecond*2, time.Nanosecond*4),
 					},
 				},
 			},
@@ -367,7 +358,7 @@ func TestGenHistogramSturges(t *testing.T) {
 					RqstStats: api.RqstStats{
 						MinRqstDurationNanos: time.Nanosecond * 1,
 						MaxRqstDurationNanos: time.Nanosecond * 4,
-						TimingResultsNanos:   []time.Duration{time.Nanosecond * 1, time.Nanosecond * 2, time.Nanosecond * 3, time.Nanosecond * 4},
+						TimingResultsNanos:   durationHistogram(time.Nanosecond*1, time.Nanosecond*2, time.Nanosecond*3, time.Nanosecond*4),
 					},
 				},
 			},
@@ -387,7 +378,7 @@ func TestGenHistogramSturges(t *testing.T) {
 					RqstStats: api.RqstStats{
 						MinRqstDurationNanos: time.Nanosecond * 1,
 						MaxRqstDurationNanos: time.Nanosecond * 4,
-						TimingResultsNanos:   []time.Duration{time.Nanosecond * 1, time.Nanosecond * 2, time.Nanosecond * 3, time.Nanosecond * 4},
+						TimingResultsNanos:   durationHistogram(time.Nanosecond*1, time.Nanosecond*2, time.Nanosecond*3, time.Nanosecond*4),
 					},
 				},
 			},
@@ -409,7 +400,7 @@ func TestGenHistogramSturges(t *testing.T) {
 					RqstStats: api.RqstStats{
 						MinRqstDurationNanos: time.Nanosecond * 1,
 						MaxRqstDurationNanos: time.Nanosecond * 4,
-						TimingResultsNanos:   []time.Duration{time.Nanosecond * 1, time.Nanosecond * 2, time.Nanosecond * 3, time.Nanosecond * 4},
+						TimingResultsNanos:   durationHistogram(time.Nanosecond*1, time.Nanosecond*2, time.Nanosecond*3, time.Nanosecond*4),
 					},
 				},
 			},
@@ -428,9 +419,9 @@ func TestGenHistogramSturges(t *testing.T) {
 					RqstStats: api.RqstStats{
 						MinRqstDurationNanos: time.Nanosecond * 1,
 						MaxRqstDurationNanos: time.Nanosecond * 200,
-						TimingResultsNanos: []time.Duration{time.Nanosecond * 1, time.Nanosecond * 2,
-							time.Nanosecond * 2, time.Nanosecond * 2, time.Nanosecond * 3, time.Nanosecond * 10,
-							time.Nanosecond * 100, time.Nanosecond * 200},
+						TimingResultsNanos: durationHistogram(time.Nanosecond*1, time.Nanosecond*2,
+							time.Nanosecond*2, time.Nanosecond*2, time.Nanosecond*3, time.Nanosecond*10,
+							time.Nanosecond*100, time.Nanosecond*200),
 					},
 				},
 			},
@@ -449,9 +440,9 @@ func TestGenHistogramSturges(t *testing.T) {
 					RqstStats: api.RqstStats{
 						MinRqstDurationNanos: time.Nanosecond * 1,
 						MaxRqstDurationNanos: time.Nanosecond * 200,
-						TimingResultsNanos: []time.Duration{time.Nanosecond * 1, time.Nanosecond * 2, time.Nanosecond * 2,
-							time.Nanosecond * 2, time.Nanosecond * 3, time.Nanosecond * 10, time.Nanosecond * 100,
-							time.Nanosecond * 200},
+						TimingResultsNanos: durationHistogram(time.Nanosecond*1, time.Nanosecond*2, time.Nanosecond*2,
+							time.Nanosecond*2, time.Nanosecond*3, time.Nanosecond*10, time.Nanosecond*100,
+							time.Nanosecond*200),
 					},
 				},
 			},
@@ -546,17 +537,17 @@ func generateNormalDistribution(mean float64, stdDev int) float64 {
 	// return x
 }
 
-func compareRqstStats(x, y api.RqstStats) bool {
-	if len(x.TimingResultsNanos) != len(y.TimingResultsNanos) {
-		return false
-	}
-	for i := 0; i < len(x.TimingResultsNanos); i++ {
-		if x.TimingResultsNanos[i] != y.TimingResultsNanos[i] {
-			return false
-		}
+func durationHistogram(durations ...time.Duration) *api.Histogram {
+	h := api.NewHistogram(0, 0)
+	for _, d := range durations {
+		h.Record(d)
 	}
+	return h
+}
 
-	if x.AvgRqstDurationNanos == y.AvgRqstDurationNanos &&
+func compareRqstStats(x, y api.RqstStats) bool {
+	if x.TimingResultsNanos.Count() == y.TimingResultsNanos.Count() &&
+		x.AvgRqstDurationNanos == y.AvgRqstDurationNanos &&
 		x.MaxRqstDurationNanos == y.MaxRqstDurationNanos &&
 		x.MinRqstDurationNanos == y.MinRqstDurationNanos &&
 		x.NormalizedMaxRqstDurationNanos == y.NormalizedMaxRqstDurationNanos &&
@@ -567,3 +558,340 @@ func compareRqstStats(x, y api.RqstStats) bool {
 
 	return false
 }
+
+// TestErrorStats verifies that failed requests are counted, by endpoint, method, and
+// error type, and that they're excluded from the latency stats.
+func TestErrorStats(t *testing.T) {
+	url1 := "http://someurl/1"
+	rh := ResponseHandler{OutputType: JSON}
+	runResults := rh.newRunResults()
+	epRunSummary := make(map[string]*api.EndpointDetail)
+	totalRunTime := time.Duration(0)
+
+	resps := []Response{
+		{HTTPStatus: http.StatusOK, Endpoint: api.Endpoint{URL: url1, Method: http.MethodGet}, RequestDuration: time.Millisecond * 100},
+		{HTTPStatus: http.StatusOK, Endpoint: api.Endpoint{URL: url1, Method: http.MethodGet}, RequestDuration: time.Millisecond * 300},
+		{ErrorType: api.ErrTimeout, Endpoint: api.Endpoint{URL: url1, Method: http.MethodGet}, RequestDuration: time.Second * 15},
+		{ErrorType: api.ErrTimeout, Endpoint: api.Endpoint{URL: url1, Method: http.MethodGet}, RequestDuration: time.Second * 15},
+		{ErrorType: api.ErrConnRefused, Endpoint: api.Endpoint{URL: url1, Method: http.MethodPost}, RequestDuration: time.Millisecond},
+	}
+	for _, resp := range resps {
+		rh.accumulateResponseStats(resp, &totalRunTime, &runResults, epRunSummary)
+	}
+	err := rh.finalizeResponseStats(time.Now(), &totalRunTime, &runResults, epRunSummary)
+	if err != nil {
+		t.Errorf("unexpected error finalizing response stats: %s", err)
+	}
+
+	rs := runResults.RunSummary.RqstStats
+	if rs.TotalRqsts != 5 || rs.TotalErrors != 3 {
+		t.Errorf("expected 5 requests and 3 errors, got %d and %d", rs.TotalRqsts, rs.TotalErrors)
+	}
+	if rs.TimingResultsNanos.Count() != 2 || rs.MaxRqstDurationNanos != time.Millisecond*300 {
+		t.Errorf("expected 2 timed requests with a max of 300ms, got %d and %s", rs.TimingResultsNanos.Count(), rs.MaxRqstDurationNanos)
+	}
+	if rs.AvgRqstDurationNanos != time.Millisecond*200 {
+		t.Errorf("expected average request duration of 200ms, got %s", rs.AvgRqstDurationNanos)
+	}
+
+	errDist := runResults.EndpointDetails[url1].HTTPMethodErrorDist
+	if errDist[http.MethodGet][api.ErrTimeout] != 2 || errDist[http.MethodPost][api.ErrConnRefused] != 1 {
+		t.Errorf("unexpected error distribution %+v", errDist)
+	}
+	getStats := runResults.EndpointDetails[url1].HTTPMethodRqstStats[http.MethodGet]
+	if getStats.TotalRqsts != 4 || getStats.TotalErrors != 2 {
+		t.Errorf("expected 4 GET requests and 2 GET errors, got %d and %d", getStats.TotalRqsts, getStats.TotalErrors)
+	}
+	if _, ok := runResults.EndpointDetails[url1].HTTPMethodStatusDist[http.MethodPost]; ok {
+		t.Errorf("expected no POST status distribution, got %+v", runResults.EndpointDetails[url1].HTTPMethodStatusDist)
+	}
+}
+
+func TestStageStats(t *testing.T) {
+	url1 := "http://someurl/1"
+	profile, err := NewLoadProfile(api.LoadTestConfig{
+		Stages: []api.Stage{
+			{Duration: "10s", RqstRate: 10, MaxConcurrentRqsts: 1},
+			{Duration: "5s", RqstRate: 20, MaxConcurrentRqsts: 2},
+		},
+		StageInterpolation: StepInterpolation,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating load profile: %s", err)
+	}
+	rh := ResponseHandler{OutputType: JSON, LoadProfile: profile}
+	runResults := rh.newRunResults()
+	epRunSummary := make(map[string]*api.EndpointDetail)
+	totalRunTime := time.Duration(0)
+
+	resps := []Response{
+		{HTTPStatus: http.StatusOK, Endpoint: api.Endpoint{URL: url1, Method: http.MethodGet}, RequestDuration: time.Millisecond * 100, Stage: 0},
+		{HTTPStatus: http.StatusOK, Endpoint: api.Endpoint{URL: url1, Method: http.MethodGet}, RequestDuration: time.Millisecond * 200, Stage: 1},
+		{HTTPStatus: http.StatusOK, Endpoint: api.Endpoint{URL: url1, Method: http.MethodGet}, RequestDuration: time.Millisecond * 400, Stage: 1},
+		{ErrorType: api.ErrTimeout, Endpoint: api.Endpoint{URL: url1, Method: http.MethodGet}, RequestDuration: time.Second, Stage: 1},
+		{Endpoint: api.Endpoint{URL: url1, Method: http.MethodGet}, DroppedRqsts: 3, Stage: 1},
+	}
+	for _, resp := range resps {
+		rh.accumulateResponseStats(resp, &totalRunTime, &runResults, epRunSummary)
+	}
+	err = rh.finalizeResponseStats(time.Now(), &totalRunTime, &runResults, epRunSummary)
+	if err != nil {
+		t.Errorf("unexpected error finalizing response stats: %s", err)
+	}
+
+	if len(runResults.StageSummaries) != 2 {
+		t.Fatalf("expected 2 stage summaries, got %d", len(runResults.StageSummaries))
+	}
+	s1, s2 := runResults.StageSummaries[0], runResults.StageSummaries[1]
+	if s1.Stage != 1 || s1.TargetRqstRate != 10 || s1.TargetConcurrentRqsts != 1 || s1.DurationNanos != 10*time.Second {
+		t.Errorf("unexpected stage 1 configuration %+v", s1)
+	}
+	if s1.RqstStats.TotalRqsts != 1 || s1.RqstRatePerSec != 0.1 {
+		t.Errorf("expected 1 stage 1 request at 0.1 rqsts/sec, got %d at %f", s1.RqstStats.TotalRqsts, s1.RqstRatePerSec)
+	}
+	if s2.RqstStats.TotalRqsts != 3 || s2.RqstStats.TotalErrors != 1 {
+		t.Errorf("expected 3 stage 2 requests and 1 error, got %d and %d", s2.RqstStats.TotalRqsts, s2.RqstStats.TotalErrors)
+	}
+	if s2.RqstStats.AvgRqstDurationNanos != time.Millisecond*300 {
+		t.Errorf("expected stage 2 average request duration of 300ms, got %s", s2.RqstStats.AvgRqstDurationNanos)
+	}
+	if s1.DroppedRqsts != 0 || s2.DroppedRqsts != 3 {
+		t.Errorf("expected 0 stage 1 and 3 stage 2 dropped requests, got %d and %d", s1.DroppedRqsts, s2.DroppedRqsts)
+	}
+}
+
+func TestOpenModelStats(t *testing.T) {
+	url1 := "http://someurl/1"
+	rh := ResponseHandler{OutputType: JSON}
+	runResults := rh.newRunResults()
+	epRunSummary := make(map[string]*api.EndpointDetail)
+	totalRunTime := time.Duration(0)
+
+	resps := []Response{
+		{HTTPStatus: http.StatusOK, Endpoint: api.Endpoint{URL: url1, Method: http.MethodGet}, RequestDuration: time.Millisecond * 100},
+		{HTTPStatus: http.StatusOK, Endpoint: api.Endpoint{URL: url1, Method: http.MethodGet}, RequestDuration: time.Millisecond * 300, Late: true},
+		{Endpoint: api.Endpoint{URL: url1, Method: http.MethodGet}, DroppedRqsts: 2},
+	}
+	for _, resp := range resps {
+		rh.accumulateResponseStats(resp, &totalRunTime, &runResults, epRunSummary)
+	}
+	err := rh.finalizeResponseStats(time.Now().Add(-time.Second), &totalRunTime, &runResults, epRunSummary)
+	if err != nil {
+		t.Errorf("unexpected error finalizing response stats: %s", err)
+	}
+
+	rs := runResults.RunSummary
+	if rs.RqstStats.TotalRqsts != 2 || rs.LateRqsts != 1 || rs.DroppedRqsts != 2 {
+		t.Errorf("expected 2 requests, 1 late, and 2 dropped, got %d, %d, and %d", rs.RqstStats.TotalRqsts,
+			rs.LateRqsts, rs.DroppedRqsts)
+	}
+	if math.Abs(rs.OfferedRqstRatePerSec-2*rs.RqstRatePerSec) > 0.0001 {
+		t.Errorf("expected offered rate to be twice the achieved rate %f, got %f", rs.RqstRatePerSec, rs.OfferedRqstRatePerSec)
+	}
+}
+
+func TestAssertionStats(t *testing.T) {
+	url1 := "http://someurl/1"
+	rh := ResponseHandler{OutputType: JSON}
+	runResults := rh.newRunResults()
+	epRunSummary := make(map[string]*api.EndpointDetail)
+	totalRunTime := time.Duration(0)
+
+	resps := []Response{
+		{HTTPStatus: http.StatusOK, Endpoint: api.Endpoint{URL: url1, Method: http.MethodGet}, Asserted: true},
+		{HTTPStatus: http.StatusOK, Endpoint: api.Endpoint{URL: url1, Method: http.MethodGet}, Asserted: true,
+			AssertionFailures: []string{api.AssertJSONPath}},
+		{HTTPStatus: http.StatusInternalServerError, Endpoint: api.Endpoint{URL: url1, Method: http.MethodGet}, Asserted: true,
+			AssertionFailures: []string{api.AssertStatus, api.AssertJSONPath}},
+		{HTTPStatus: http.StatusOK, Endpoint: api.Endpoint{URL: url1, Method: http.MethodPost}},
+	}
+	for _, resp := range resps {
+		rh.accumulateResponseStats(resp, &totalRunTime, &runResults, epRunSummary)
+	}
+
+	results := epRunSummary[url1].HTTPMethodAssertionResults
+	if len(results) != 1 {
+		t.Fatalf("expected assertion results for GET only, got %+v", results)
+	}
+	get := results[http.MethodGet]
+	if get.Passed != 1 || get.Failed != 2 {
+		t.Errorf("expected 1 passed and 2 failed, got %d and %d", get.Passed, get.Failed)
+	}
+	if get.FailureDist[api.AssertJSONPath] != 2 || get.FailureDist[api.AssertStatus] != 1 {
+		t.Errorf("unexpected failure distribution %+v", get.FailureDist)
+	}
+
+	// Responses that failed their assertions are failed requests
+	rs := runResults.RunSummary.RqstStats
+	if rs.TotalRqsts != 4 || rs.TotalErrors != 2 || rs.TimingResultsNanos.Count() != 2 {
+		t.Errorf("expected 4 requests, 2 errors, and 2 timed requests, got %d, %d, and %d", rs.TotalRqsts,
+			rs.TotalErrors, rs.TimingResultsNanos.Count())
+	}
+	if errs := epRunSummary[url1].HTTPMethodErrorDist[http.MethodGet][api.ErrAssertion]; errs != 2 {
+		t.Errorf("expected 2 %s errors, got %d", api.ErrAssertion, errs)
+	}
+	if dist := epRunSummary[url1].HTTPMethodStatusDist[http.MethodGet]; dist[http.StatusOK] != 2 || dist[http.StatusInternalServerError] != 1 {
+		t.Errorf("unexpected status distribution %+v", dist)
+	}
+}
+
+func TestConnStats(t *testing.T) {
+	url1, url2, url3 := "http://someurl/1", "http://someurl/2", "https://someurl/3"
+	rh := ResponseHandler{OutputType: JSON}
+	runResults := rh.newRunResults()
+	epRunSummary := make(map[string]*api.EndpointDetail)
+	totalRunTime := time.Duration(0)
+
+	resps := []Response{
+		{HTTPStatus: http.StatusOK, Endpoint: api.Endpoint{URL: url1, Method: http.MethodGet}, Protocol: "HTTP/2.0", NewConn: true},
+		{HTTPStatus: http.StatusOK, Endpoint: api.Endpoint{URL: url1, Method: http.MethodGet}, Protocol: "HTTP/2.0"},
+		{HTTPStatus: http.StatusOK, Endpoint: api.Endpoint{URL: url1, Method: http.MethodPost}, Protocol: "HTTP/2.0"},
+		{HTTPStatus: http.StatusOK, Endpoint: api.Endpoint{URL: url1, Method: http.MethodGet}, Protocol: "HTTP/2.0", NewConn: true},
+		{HTTPStatus: http.StatusOK, Endpoint: api.Endpoint{URL: url2, Method: http.MethodGet}, Protocol: "HTTP/1.1", NewConn: true},
+		{ErrorType: api.ErrConnRefused, Endpoint: api.Endpoint{URL: url2, Method: http.MethodGet}},
+		{HTTPStatus: http.StatusOK, Endpoint: api.Endpoint{URL: url3, Method: http.MethodGet}, Protocol: "HTTP/3.0", NewConn: true,
+			QUICHandshakeDuration: time.Millisecond * 5},
+		{HTTPStatus: http.StatusOK, Endpoint: api.Endpoint{URL: url3, Method: http.MethodGet}, Protocol: "HTTP/3.0"},
+	}
+	for _, resp := range resps {
+		rh.accumulateResponseStats(resp, &totalRunTime, &runResults, epRunSummary)
+	}
+	err := rh.finalizeResponseStats(time.Now(), &totalRunTime, &runResults, epRunSummary)
+	if err != nil {
+		t.Errorf("unexpected error finalizing response stats: %s", err)
+	}
+
+	ep1, ep2 := runResults.EndpointDetails[url1], runResults.EndpointDetails[url2]
+	if ep1.ConnsOpened != 2 || ep1.RqstsPerConn != 2 || ep1.ProtocolDist["HTTP/2.0"] != 4 {
+		t.Errorf("expected 2 connections carrying 2 HTTP/2.0 requests each, got %+v", ep1)
+	}
+	if ep2.ConnsOpened != 1 || ep2.RqstsPerConn != 1 || len(ep2.ProtocolDist) != 1 || ep2.ProtocolDist["HTTP/1.1"] != 1 {
+		t.Errorf("expected 1 connection carrying 1 HTTP/1.1 request, got %+v", ep2)
+	}
+	quic := runResults.RunSummary.QUICHandshakeNanos
+	if quic.Count() != 2 || !quic.Equivalent(quic.Max(), time.Millisecond*5) {
+		t.Errorf("expected 2 HTTP/3 responses with a max QUIC handshake of 5ms, got %d and %s", quic.Count(), quic.Max())
+	}
+}
+
+func TestWebSocketStats(t *testing.T) {
+	url1, url2 := "ws://someurl/chat", "http://someurl/users"
+	rh := ResponseHandler{OutputType: JSON}
+	runResults := rh.newRunResults()
+	epRunSummary := make(map[string]*api.EndpointDetail)
+	totalRunTime := time.Duration(0)
+
+	resps := []Response{
+		{HTTPStatus: http.StatusSwitchingProtocols, Endpoint: api.Endpoint{URL: url1, Method: http.MethodGet}, NewConn: true,
+			WebSocket: &wsResult{connectDuration: 2 * time.Millisecond, msgsSent: 2, msgsRcvd: 3,
+				msgRoundTrips: []time.Duration{time.Millisecond, 3 * time.Millisecond}}},
+		{HTTPStatus: http.StatusSwitchingProtocols, Endpoint: api.Endpoint{URL: url1, Method: http.MethodGet}, NewConn: true,
+			ErrorType: api.ErrWSClosed, WebSocket: &wsResult{connectDuration: 4 * time.Millisecond, msgsSent: 1, closeCode: 1011}},
+		{HTTPStatus: http.StatusSwitchingProtocols, Endpoint: api.Endpoint{URL: url1, Method: http.MethodGet}, NewConn: true,
+			ErrorType: api.ErrWSClosed, WebSocket: &wsResult{connectDuration: 4 * time.Millisecond, closeCode: 1000}},
+		{HTTPStatus: http.StatusForbidden, Endpoint: api.Endpoint{URL: url1, Method: http.MethodGet}, NewConn: true},
+		{HTTPStatus: http.StatusOK, Endpoint: api.Endpoint{URL: url2, Method: http.MethodGet}},
+	}
+	for _, resp := range resps {
+		rh.accumulateResponseStats(resp, &totalRunTime, &runResults, epRunSummary)
+	}
+	err := rh.finalizeResponseStats(time.Now().Add(-2*time.Second), &totalRunTime, &runResults, epRunSummary)
+	if err != nil {
+		t.Errorf("unexpected error finalizing response stats: %s", err)
+	}
+
+	if runResults.EndpointDetails[url2].WebSocket != nil {
+		t.Errorf("expected no WebSocket stats for %s, got %+v", url2, runResults.EndpointDetails[url2].WebSocket)
+	}
+	ws := runResults.EndpointDetails[url1].WebSocket
+	if ws == nil {
+		t.Fatalf("expected WebSocket stats for %s", url1)
+	}
+	if ws.ConnectNanos.Count() != 3 || !ws.ConnectNanos.Equivalent(ws.ConnectNanos.Max(), 4*time.Millisecond) {
+		t.Errorf("expected 3 connects with a max of 4ms, got %d and %s", ws.ConnectNanos.Count(), ws.ConnectNanos.Max())
+	}
+	if ws.MsgRoundTripNanos.Count() != 2 || !ws.MsgRoundTripNanos.Equivalent(ws.MsgRoundTripNanos.Max(), 3*time.Millisecond) {
+		t.Errorf("expected 2 round trips with a max of 3ms, got %d and %s", ws.MsgRoundTripNanos.Count(), ws.MsgRoundTripNanos.Max())
+	}
+	if ws.MsgsSent != 3 || ws.MsgsRcvd != 3 {
+		t.Errorf("expected 3 messages sent and received, got %d and %d", ws.MsgsSent, ws.MsgsRcvd)
+	}
+	if ws.MsgsSentPerSec <= 1 || ws.MsgsSentPerSec > 1.5 {
+		t.Errorf("expected about 1.5 messages sent per second, got %f", ws.MsgsSentPerSec)
+	}
+	if len(ws.AbnormalCloseDist) != 1 || ws.AbnormalCloseDist[1011] != 1 {
+		t.Errorf("expected 1 connection closed with 1011, got %v", ws.AbnormalCloseDist)
+	}
+}
+
+func TestScenarioStats(t *testing.T) {
+	url1 := "http://someurl/users"
+	url2 := "http://someurl/users/{{.id}}"
+	rh := ResponseHandler{OutputType: JSON, Scenarios: []api.Scenario{
+		{Name: "user", RqstPercent: 100, Steps: []api.Step{
+			{Name: "create", Endpoint: api.Endpoint{URL: url1, Method: http.MethodPost}},
+			{Endpoint: api.Endpoint{URL: url2, Method: http.MethodGet}},
+		}},
+	}}
+	runResults := rh.newRunResults()
+	epRunSummary := make(map[string]*api.EndpointDetail)
+	totalRunTime := time.Duration(0)
+
+	resps := []Response{
+		{HTTPStatus: http.StatusCreated, Endpoint: api.Endpoint{URL: url1, Method: http.MethodPost}, Scenario: "user", Step: 1,
+			RequestDuration: 100 * time.Millisecond},
+		{HTTPStatus: http.StatusOK, Endpoint: api.Endpoint{URL: url2, Method: http.MethodGet}, Scenario: "user", Step: 2,
+			RequestDuration: 50 * time.Millisecond, IterationDone: true, IterationDuration: 200 * time.Millisecond},
+		{HTTPStatus: http.StatusOK, Endpoint: api.Endpoint{URL: url1, Method: http.MethodPost}, Scenario: "user", Step: 1,
+			RequestDuration: 300 * time.Millisecond, ErrorType: api.ErrExtract, IterationDone: true, IterationFailed: true},
+	}
+	for _, resp := range resps {
+		rh.accumulateResponseStats(resp, &totalRunTime, &runResults, epRunSummary)
+	}
+	err := rh.finalizeResponseStats(time.Now(), &totalRunTime, &runResults, epRunSummary)
+	if err != nil {
+		t.Errorf("unexpected error finalizing response stats: %s", err)
+	}
+
+	if len(runResults.ScenarioSummaries) != 1 {
+		t.Fatalf("expected 1 scenario summary, got %d", len(runResults.ScenarioSummaries))
+	}
+	sc := runResults.ScenarioSummaries[0]
+	if sc.IterationStats.TotalRqsts != 2 || sc.IterationStats.TotalErrors != 1 {
+		t.Errorf("expected 2 iterations and 1 failure, got %d and %d", sc.IterationStats.TotalRqsts, sc.IterationStats.TotalErrors)
+	}
+	if sc.IterationStats.AvgRqstDurationNanos != 200*time.Millisecond {
+		t.Errorf("expected an average iteration duration of 200ms, got %s", sc.IterationStats.AvgRqstDurationNanos)
+	}
+	if len(sc.Steps) != 2 || sc.Steps[0].Name != "create" || sc.Steps[1].Name != "GET "+url2 {
+		t.Fatalf("unexpected steps %+v", sc.Steps)
+	}
+	create, get := sc.Steps[0].RqstStats, sc.Steps[1].RqstStats
+	if create.TotalRqsts != 2 || create.TotalErrors != 1 || create.AvgRqstDurationNanos != 100*time.Millisecond {
+		t.Errorf("expected 2 create requests, 1 error, and an average of 100ms, got %d, %d, and %s",
+			create.TotalRqsts, create.TotalErrors, create.AvgRqstDurationNanos)
+	}
+	if get.TotalRqsts != 1 || get.TotalErrors != 0 {
+		t.Errorf("expected 1 get request and no errors, got %d and %d", get.TotalRqsts, get.TotalErrors)
+	}
+	// Scenario requests are also included in the endpoint details
+	if n := epRunSummary[url1].HTTPMethodRqstStats[http.MethodPost].TotalRqsts; n != 2 {
+		t.Errorf("expected 2 requests to %s, got %d", url1, n)
+	}
+}
+
+func TestReportErr(t *testing.T) {
+	rh := ResponseHandler{OutputType: JSON, Silent: true}
+	runResults := rh.newRunResults()
+	rh.Report(runResults)
+	if err := rh.Err(); err != nil {
+		t.Fatalf("unexpected error reporting results: %s", err)
+	}
+
+	// NaN can't be marshaled to JSON
+	runResults.RunSummary.RqstRatePerSec = math.NaN()
+	rh.Report(runResults)
+	if rh.Err() == nil {
+		t.Errorf("expected an error reporting results, got none")
+	}
+}
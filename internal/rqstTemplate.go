@@ -0,0 +1,266 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// Sequences holds the counters behind the 'seq' request template function. There's a
+// counter for each endpoint, and scenario step, that's shared by all of its requestors so
+// that each of its requests gets a different sequence number.
+type Sequences struct {
+	mux      sync.Mutex
+	counters map[string]*int64
+}
+
+// NewSequences returns an initialized Sequences
+func NewSequences() *Sequences {
+	return &Sequences{counters: make(map[string]*int64)}
+}
+
+// counter returns the counter identified by 'key'. A nil Sequences returns a new counter
+// each time it's called.
+func (s *Sequences) counter(key string) *int64 {
+	if s == nil {
+		return new(int64)
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	c, ok := s.counters[key]
+	if !ok {
+		c = new(int64)
+		s.counters[key] = c
+	}
+	return c
+}
+
+// rqstFuncs provides the functions available to request templates. Each requestor has its
+// own rqstFuncs, so it isn't safe for concurrent use.
+type rqstFuncs struct {
+	// counter is shared by all the requestors of an endpoint or scenario step
+	counter *int64
+	// seq is the current request's sequence number
+	seq int64
+	// data are the data source rows being used by the requestor
+	data *dataRows
+	rnd  *rand.Rand
+}
+
+func newRqstFuncs(counter *int64, data *dataRows) *rqstFuncs {
+	return &rqstFuncs{counter: counter, data: data, rnd: newRand()}
+}
+
+// newRand returns a new source of random numbers. Requestors are started together, so
+// they're seeded from crypto/rand rather than the time to ensure they don't generate the
+// same values.
+func newRand() *rand.Rand {
+	var seed int64
+	var b [8]byte
+	if _, err := crand.Read(b[:]); err == nil {
+		seed = int64(binary.LittleEndian.Uint64(b[:]))
+	} else {
+		seed = time.Now().UnixNano()
+	}
+	return rand.New(rand.NewSource(seed))
+}
+
+// next moves on to the next request. 'seq' returns the same value until next is called
+// again so that a request's URL, headers, and body can all refer to the same sequence
+// number.
+func (f *rqstFuncs) next() {
+	f.seq = atomic.AddInt64(f.counter, 1)
+}
+
+// funcMap returns the functions available to request templates
+func (f *rqstFuncs) funcMap() template.FuncMap {
+	return template.FuncMap{
+		"seq":     func() int64 { return f.seq },
+		"randInt": f.randInt,
+		"choice":  f.choice,
+		"uuid":    f.uuid,
+		"now":     now,
+		"env":     env,
+		"data":    f.data.value,
+	}
+}
+
+// randInt returns a random integer between 'min' and 'max' inclusive
+func (f *rqstFuncs) randInt(min, max int) (int, error) {
+	if max < min {
+		return 0, fmt.Errorf("randInt max %d is less than min %d", max, min)
+	}
+	return min + f.rnd.Intn(max-min+1), nil
+}
+
+// choice returns one of 'choices' chosen at random
+func (f *rqstFuncs) choice(choices ...string) (string, error) {
+	if len(choices) == 0 {
+		return "", fmt.Errorf("choice requires at least one value to choose from")
+	}
+	return choices[f.rnd.Intn(len(choices))], nil
+}
+
+// uuid returns a random (version 4) UUID
+func (f *rqstFuncs) uuid() string {
+	var u [16]byte
+	f.rnd.Read(u[:])
+	u[6] = (u[6] & 0x0f) | 0x40
+	u[8] = (u[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+// now returns the current time formatted using 'layout', if specified, or RFC3339.
+// The layouts "unix" and "unixMilli" return the time since the Unix epoch in seconds
+// or milliseconds.
+func now(layout ...string) (string, error) {
+	if len(layout) > 1 {
+		return "", fmt.Errorf("now accepts at most one layout, got %d", len(layout))
+	}
+	t := time.Now()
+	if len(layout) == 0 {
+		return t.Format(time.RFC3339), nil
+	}
+	switch layout[0] {
+	case "unix":
+		return strconv.FormatInt(t.Unix(), 10), nil
+	case "unixMilli":
+		return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10), nil
+	}
+	return t.Format(layout[0]), nil
+}
+
+// env returns the value of the environment variable 'name', which must be set
+func env(name string) (string, error) {
+	val, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s isn't set", name)
+	}
+	return val, nil
+}
+
+// rqstTemplate is a template for a request's URL, headers, or body. Templates without
+// any actions aren't parsed so that static values are rendered without overhead.
+type rqstTemplate struct {
+	text  string
+	tmplt *template.Template
+}
+
+// newRqstTemplate parses 'text', 'name' identifies it in errors
+func newRqstTemplate(name, text string, funcs template.FuncMap) (rqstTemplate, error) {
+	if !strings.Contains(text, "{{") {
+		return rqstTemplate{text: text}, nil
+	}
+	tmplt, err := template.New(name).Option("missingkey=error").Funcs(funcs).Parse(text)
+	if err != nil {
+		return rqstTemplate{}, err
+	}
+	return rqstTemplate{text: text, tmplt: tmplt}, nil
+}
+
+// render executes the template with 'vars'
+func (t rqstTemplate) render(vars map[string]string) (string, error) {
+	if t.tmplt == nil {
+		return t.text, nil
+	}
+	var sb strings.Builder
+	if err := t.tmplt.Execute(&sb, vars); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// rqstTemplates are the templates for an endpoint's URL, headers, and body, and the
+// messages sent to a WebSocket endpoint
+type rqstTemplates struct {
+	url     rqstTemplate
+	body    rqstTemplate
+	headers map[string]rqstTemplate
+	msgs    []rqstTemplate
+	funcs   *rqstFuncs
+}
+
+// newRqstTemplates parses the URL, headers, body, and WebSocket messages of 'ep'. 'counter'
+// is the sequence counter for 'ep's requests, and 'data' the data source rows used by its
+// requestor.
+func newRqstTemplates(ep api.Endpoint, counter *int64, data *dataRows) (*rqstTemplates, error) {
+	t := &rqstTemplates{headers: make(map[string]rqstTemplate, len(ep.Headers)), funcs: newRqstFuncs(counter, data)}
+	funcs := t.funcs.funcMap()
+
+	var err error
+	if t.url, err = newRqstTemplate("URL", ep.URL, funcs); err != nil {
+		return nil, err
+	}
+	if t.body, err = newRqstTemplate("RqstBody", ep.RqstBody, funcs); err != nil {
+		return nil, err
+	}
+	for name, val := range ep.Headers {
+		if t.headers[name], err = newRqstTemplate(name, val, funcs); err != nil {
+			return nil, err
+		}
+	}
+	if ep.WebSocket != nil {
+		t.msgs = make([]rqstTemplate, len(ep.WebSocket.Messages))
+		for i, msg := range ep.WebSocket.Messages {
+			if t.msgs[i], err = newRqstTemplate(fmt.Sprintf("Messages[%d]", i), msg, funcs); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return t, nil
+}
+
+// next moves on to the next request, see rqstFuncs.next()
+func (t *rqstTemplates) next() {
+	t.funcs.next()
+}
+
+// render returns the URL, body, and headers of the current request with the variables in
+// 'vars' substituted
+func (t *rqstTemplates) render(vars map[string]string) (url string, body string, headers map[string]string, err error) {
+	if url, err = t.url.render(vars); err != nil {
+		return "", "", nil, err
+	}
+	if body, err = t.body.render(vars); err != nil {
+		return "", "", nil, err
+	}
+	headers = make(map[string]string, len(t.headers))
+	for name, ht := range t.headers {
+		if headers[name], err = ht.render(vars); err != nil {
+			return "", "", nil, err
+		}
+	}
+	return url, body, headers, nil
+}
+
+// renderMsgs returns the WebSocket messages of the current request with the variables in
+// 'vars' substituted
+func (t *rqstTemplates) renderMsgs(vars map[string]string) ([]string, error) {
+	msgs := make([]string, len(t.msgs))
+	for i, mt := range t.msgs {
+		var err error
+		if msgs[i], err = mt.render(vars); err != nil {
+			return nil, err
+		}
+	}
+	return msgs, nil
+}
+
+// endpointKey identifies 'ep' in Sequences
+func endpointKey(ep api.Endpoint) string {
+	return ep.Method + " " + ep.URL
+}
@@ -0,0 +1,221 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+func TestRqstTemplates(t *testing.T) {
+	os.Setenv("HEYYALL_TEST_TOKEN", "secret")
+	defer os.Unsetenv("HEYYALL_TEST_TOKEN")
+
+	tests := []struct {
+		name      string
+		ep        api.Endpoint
+		vars      map[string]string
+		expected  *regexp.Regexp
+		expectErr bool
+	}{
+		{
+			name:     "Static",
+			ep:       api.Endpoint{URL: "http://someurl/users/1"},
+			expected: regexp.MustCompile(`^http://someurl/users/1$`),
+		},
+		{
+			name:     "Seq",
+			ep:       api.Endpoint{URL: "http://someurl/users/{{ seq }}"},
+			expected: regexp.MustCompile(`^http://someurl/users/1$`),
+		},
+		{
+			name:     "RandInt",
+			ep:       api.Endpoint{URL: "http://someurl/users/{{ randInt 1 9 }}"},
+			expected: regexp.MustCompile(`^http://someurl/users/[1-9]$`),
+		},
+		{
+			name:     "Choice",
+			ep:       api.Endpoint{URL: `http://someurl/{{ choice "users" "accounts" }}`},
+			expected: regexp.MustCompile(`^http://someurl/(users|accounts)$`),
+		},
+		{
+			name:     "UUID",
+			ep:       api.Endpoint{URL: "http://someurl/users/{{ uuid }}"},
+			expected: regexp.MustCompile(`^http://someurl/users/[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`),
+		},
+		{
+			name:     "NowUnix",
+			ep:       api.Endpoint{URL: `http://someurl/users?since={{ now "unix" }}`},
+			expected: regexp.MustCompile(`^http://someurl/users\?since=\d{10}$`),
+		},
+		{
+			name:     "NowLayout",
+			ep:       api.Endpoint{URL: `http://someurl/users?day={{ now "2006-01-02" }}`},
+			expected: regexp.MustCompile(`^http://someurl/users\?day=\d{4}-\d{2}-\d{2}$`),
+		},
+		{
+			name:     "Env",
+			ep:       api.Endpoint{URL: `http://someurl/users?token={{ env "HEYYALL_TEST_TOKEN" }}`},
+			expected: regexp.MustCompile(`^http://someurl/users\?token=secret$`),
+		},
+		{
+			name:     "Vars",
+			ep:       api.Endpoint{URL: "http://someurl/users/{{ .id }}"},
+			vars:     map[string]string{"id": "42"},
+			expected: regexp.MustCompile(`^http://someurl/users/42$`),
+		},
+		{
+			name:      "EnvNotSet",
+			ep:        api.Endpoint{URL: `http://someurl/users?token={{ env "HEYYALL_TEST_UNSET" }}`},
+			expectErr: true,
+		},
+		{
+			name:      "RandIntBadRange",
+			ep:        api.Endpoint{URL: "http://someurl/users/{{ randInt 9 1 }}"},
+			expectErr: true,
+		},
+		{
+			name:      "ChoiceNoValues",
+			ep:        api.Endpoint{URL: "http://someurl/users/{{ choice }}"},
+			expectErr: true,
+		},
+		{
+			name:      "UnknownFunction",
+			ep:        api.Endpoint{URL: "http://someurl/users/{{ random }}"},
+			expectErr: true,
+		},
+		{
+			name:      "UnknownVar",
+			ep:        api.Endpoint{URL: "http://someurl/users/{{ .id }}"},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.ep.Headers = map[string]string{"X-Request": tc.ep.URL}
+			tc.ep.RqstBody = tc.ep.URL
+			tmplts, err := newRqstTemplates(tc.ep, new(int64), nil)
+			if err == nil {
+				tmplts.next()
+				var url, body string
+				var headers map[string]string
+				url, body, headers, err = tmplts.render(tc.vars)
+				if err == nil {
+					for _, rendered := range []string{url, body, headers["X-Request"]} {
+						if !tc.expected.MatchString(rendered) {
+							t.Errorf("expected a match for %s, got %s", tc.expected, rendered)
+						}
+					}
+				}
+			}
+			if tc.expectErr && err == nil {
+				t.Errorf("expected an error, got none")
+			}
+			if !tc.expectErr && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+// TestRqstSeq verifies that all the requestors for an endpoint share its sequence, and that
+// a request's URL, headers, and body all get the same sequence number
+func TestRqstTemplatesWebSocketMsgs(t *testing.T) {
+	ep := api.Endpoint{URL: "ws://someurl/chat/{{ seq }}", WebSocket: &api.WebSocket{
+		Messages: []string{`{"id": {{ seq }}}`, "static"},
+	}}
+	tmplts, err := newRqstTemplates(ep, new(int64), nil)
+	if err != nil {
+		t.Fatalf("unexpected error parsing templates: %s", err)
+	}
+	tmplts.next()
+	msgs, err := tmplts.renderMsgs(nil)
+	if err != nil {
+		t.Fatalf("unexpected error rendering messages: %s", err)
+	}
+	if len(msgs) != 2 || msgs[0] != `{"id": 1}` || msgs[1] != "static" {
+		t.Errorf("expected the messages to share the URL's sequence number, got %q", msgs)
+	}
+
+	ep.WebSocket.Messages = []string{"{{ nosuchfunc }}"}
+	if _, err = newRqstTemplates(ep, new(int64), nil); err == nil {
+		t.Errorf("expected an error for an invalid message template")
+	}
+}
+
+func TestRqstSeq(t *testing.T) {
+	var mux sync.Mutex
+	var seqs []int
+	mismatched := 0
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		defer mux.Unlock()
+		seq, err := strconv.Atoi(r.URL.Query().Get("seq"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("X-Seq") != strconv.Itoa(seq) {
+			mismatched++
+		}
+		seqs = append(seqs, seq)
+	}))
+	defer testSrv.Close()
+
+	ep := api.Endpoint{
+		URL:     testSrv.URL + "/users?seq={{ seq }}",
+		Method:  http.MethodGet,
+		Headers: map[string]string{"X-Seq": "{{ seq }}"},
+	}
+	numRqstrs, numRqsts := 3, 5
+	respC := make(chan Response, numRqstrs*numRqsts)
+	rqstr := Requestor{
+		Ctx:       context.Background(),
+		ResponseC: respC,
+		Client:    http.Client{Timeout: time.Second},
+		Sequences: NewSequences(),
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < numRqstrs; i++ {
+		wg.Add(1)
+		go func() {
+			rqstr.ProcessRqst(ep, newRatePacer(numRqsts, 0, constantArrival{}))
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	close(respC)
+
+	for resp := range respC {
+		if resp.HTTPStatus != http.StatusOK {
+			t.Errorf("expected HTTP status %d, got %d", http.StatusOK, resp.HTTPStatus)
+		}
+		// Responses are reported by the endpoint's URL template
+		if resp.Endpoint.URL != ep.URL {
+			t.Errorf("expected the response URL to be %s, got %s", ep.URL, resp.Endpoint.URL)
+		}
+	}
+	if mismatched > 0 {
+		t.Errorf("expected the URL and header to have the same sequence number, %d didn't", mismatched)
+	}
+	sort.Ints(seqs)
+	for i, seq := range seqs {
+		if seq != i+1 {
+			t.Fatalf("expected sequence numbers 1 to %d, got %v", numRqstrs*numRqsts, seqs)
+		}
+	}
+}
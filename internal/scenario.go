@@ -0,0 +1,338 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/youngkin/heyyall/api"
+)
+
+// varNameRegex matches valid scenario variable names, which must be usable as template
+// fields, e.g., {{.userID}}
+var varNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// extractor is the compiled form of api.Extract
+type extractor struct {
+	variable string
+	jsonPath *jsonPath
+	header   string
+	regex    *regexp.Regexp
+}
+
+// newExtractor compiles 'e'
+func newExtractor(e api.Extract) (extractor, error) {
+	if !varNameRegex.MatchString(e.Var) {
+		return extractor{}, fmt.Errorf("Extract.Var %q isn't a valid variable name", e.Var)
+	}
+
+	ex := extractor{variable: e.Var, header: e.Header}
+	numSources := 0
+	if e.JSONPath != "" {
+		numSources++
+		jp, err := parseJSONPath(e.JSONPath)
+		if err != nil {
+			return extractor{}, err
+		}
+		ex.jsonPath = &jp
+	}
+	if e.Header != "" {
+		numSources++
+	}
+	if e.Regex != "" {
+		numSources++
+		re, err := regexp.Compile(e.Regex)
+		if err != nil {
+			return extractor{}, fmt.Errorf("Extract.Regex %s is invalid: %w", e.Regex, err)
+		}
+		ex.regex = re
+	}
+	if numSources != 1 {
+		return extractor{}, fmt.Errorf("Extract %s must specify exactly one of JSONPath, Header, or Regex", e.Var)
+	}
+	return ex, nil
+}
+
+// needsBody returns true if the response body must be read to extract the value
+func (ex extractor) needsBody() bool {
+	return ex.jsonPath != nil || ex.regex != nil
+}
+
+// extract returns the value selected from a response with 'header' and 'body'. 'doc' is
+// the decoded JSON body, if it's needed and could be decoded. 'ok' is false if the
+// response doesn't contain the value.
+func (ex extractor) extract(header http.Header, body []byte, doc interface{}) (val string, ok bool) {
+	switch {
+	case ex.jsonPath != nil:
+		v, ok := ex.jsonPath.lookup(doc)
+		if !ok || v == nil {
+			return "", false
+		}
+		if s, isStr := v.(string); isStr {
+			return s, true
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", false
+		}
+		return string(b), true
+	case ex.regex != nil:
+		match := ex.regex.FindSubmatch(body)
+		if match == nil {
+			return "", false
+		}
+		if len(match) > 1 {
+			return string(match[1]), true
+		}
+		return string(match[0]), true
+	default:
+		vals, ok := header[http.CanonicalHeaderKey(ex.header)]
+		if !ok || len(vals) == 0 {
+			return "", false
+		}
+		return vals[0], true
+	}
+}
+
+// scenarioStep is the compiled form of api.Step
+type scenarioStep struct {
+	name     string
+	ep       api.Endpoint
+	tmplts   *rqstTemplates
+	asserts  *assertions
+	extracts []extractor
+	// maxBodyRead is how much of the response body is needed for the step's assertions
+	// and extracts
+	maxBodyRead int64
+	// needsJSON is true if any of the step's extracts use a JSONPath
+	needsJSON bool
+}
+
+// scenario is the compiled form of api.Scenario
+type scenario struct {
+	name  string
+	steps []*scenarioStep
+	// rows are the data source rows used by the virtual user running the scenario. They're
+	// shared by all the steps of an iteration.
+	rows *dataRows
+}
+
+// newScenario compiles and validates 'sc'. Each step may only refer to variables extracted
+// by the steps before it. The steps' sequence counters are taken from 'seqs', and their
+// data source rows from 'data'.
+func newScenario(sc api.Scenario, seqs *Sequences, data *DataSources) (*scenario, error) {
+	if sc.Name == "" {
+		return nil, fmt.Errorf("Scenario.Name must be specified")
+	}
+	if len(sc.Steps) == 0 {
+		return nil, fmt.Errorf("scenario %s has no Steps", sc.Name)
+	}
+
+	scn := &scenario{name: sc.Name, rows: newDataRows(data, newRand())}
+	// vars are the variables extracted by earlier steps, they're used to check that each
+	// step only refers to variables that will have been extracted
+	vars := make(map[string]string)
+	for i, step := range sc.Steps {
+		s, err := newScenarioStep(step, seqs.counter(fmt.Sprintf("scenario %s step %d", sc.Name, i+1)), scn.rows)
+		if err != nil {
+			return nil, fmt.Errorf("scenario %s step %d: %w", sc.Name, i+1, err)
+		}
+		if _, _, _, err = s.tmplts.render(vars); err != nil {
+			return nil, fmt.Errorf("scenario %s step %d: %w", sc.Name, i+1, err)
+		}
+		for _, ex := range s.extracts {
+			vars[ex.variable] = ""
+		}
+		scn.steps = append(scn.steps, s)
+	}
+	return scn, nil
+}
+
+// newScenarioStep compiles 'step', 'counter' is the step's sequence counter and 'rows'
+// its data source rows
+func newScenarioStep(step api.Step, counter *int64, rows *dataRows) (*scenarioStep, error) {
+	if step.URL == "" || step.Method == "" {
+		return nil, fmt.Errorf("URL and Method must be specified")
+	}
+	if step.GRPC != nil {
+		return nil, fmt.Errorf("gRPC endpoints can't be scenario steps")
+	}
+	if isWebSocketURL(step.URL) {
+		return nil, fmt.Errorf("WebSocket endpoints can't be scenario steps")
+	}
+
+	s := &scenarioStep{name: stepName(step), ep: step.Endpoint}
+
+	var err error
+	if s.tmplts, err = newRqstTemplates(step.Endpoint, counter, rows); err != nil {
+		return nil, err
+	}
+
+	if s.asserts, err = newAssertions(step.Assertions); err != nil {
+		return nil, err
+	}
+	if s.asserts != nil && s.asserts.needsBody() {
+		s.maxBodyRead = s.asserts.maxBodyRead()
+	}
+
+	if step.Transport != nil {
+		if _, err = newTransportSettings(*step.Transport); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, e := range step.Extract {
+		ex, err := newExtractor(e)
+		if err != nil {
+			return nil, err
+		}
+		if ex.needsBody() {
+			s.maxBodyRead = maxAssertedBodySize
+		}
+		s.needsJSON = s.needsJSON || ex.jsonPath != nil
+		s.extracts = append(s.extracts, ex)
+	}
+	return s, nil
+}
+
+// stepName returns the name 'step' is reported by
+func stepName(step api.Step) string {
+	if step.Name != "" {
+		return step.Name
+	}
+	return fmt.Sprintf("%s %s", step.Method, step.URL)
+}
+
+// extract adds the values the step extracts from a response with 'header' and 'body' to
+// 'vars'. It returns false if any of the values couldn't be extracted.
+func (s *scenarioStep) extract(header http.Header, body []byte, vars map[string]string) bool {
+	var doc interface{}
+	if s.needsJSON {
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return false
+		}
+	}
+	for _, ex := range s.extracts {
+		val, ok := ex.extract(header, body, doc)
+		if !ok {
+			return false
+		}
+		vars[ex.variable] = val
+	}
+	return true
+}
+
+// ProcessScenario runs iterations of 'sc' when 'pacer' says they're due until either 'pacer'
+// has no more requests or the configured run duration (set in Requestor.Ctx) expires. Each
+// request pacer.Next() allows is the next step of the current iteration. An iteration ends
+// early, and the next one begins, if any of its steps fail.
+func (r Requestor) ProcessScenario(sc api.Scenario, pacer Pacer) {
+	scn, err := newScenario(sc, r.Sequences, r.DataSources)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Requestor - invalid scenario")
+		return
+	}
+	clients := make([]http.Client, 0, len(scn.steps))
+	for _, s := range scn.steps {
+		client, release, err := r.clientFor(s.ep)
+		if err != nil {
+			log.Warn().Err(err).Msgf("Requestor - scenario %s step %s has an invalid client configuration", scn.name, s.name)
+			return
+		}
+		defer release()
+		clients = append(clients, client)
+	}
+
+	var (
+		step      int
+		iterStart time.Time
+		vars      map[string]string
+	)
+	for {
+		due, stage, ok := pacer.Next(r.Ctx)
+		if !ok {
+			return
+		}
+		if step == 0 {
+			iterStart = due
+			vars = make(map[string]string)
+			scn.rows.next()
+		}
+		s := scn.steps[step]
+
+		response, ok := r.runStep(scn, step, clients[step], vars, due, stage)
+		if response.ErrorType == "" && !ok {
+			return
+		}
+		response.Scenario = scn.name
+		response.Step = step + 1
+
+		step++
+		failed := response.failure() != ""
+		if failed || step == len(scn.steps) {
+			response.IterationDone = true
+			response.IterationFailed = failed
+			response.IterationDuration = time.Since(iterStart)
+			step = 0
+		}
+		log.Debug().Msgf("Requestor: scenario %s step %s, failed: %t", scn.name, s.name, failed)
+
+		if !ok {
+			r.sendCancelled(response)
+			return
+		}
+		if !r.sendResponse(response) {
+			return
+		}
+	}
+}
+
+// runStep sends the request for step 'step' of 'scn', due at 'due', and extracts its values
+// into 'vars'. 'ok' is false if the Requestor was cancelled, or the run duration expired,
+// before the request completed, see interrupted().
+func (r Requestor) runStep(scn *scenario, step int, client http.Client, vars map[string]string,
+	due time.Time, stage int) (response Response, ok bool) {
+
+	s := scn.steps[step]
+	s.tmplts.next()
+	// Responses are reported by the step's URL template rather than the rendered URL so that
+	// all the step's requests are summarized together
+	url, body, headers, err := s.tmplts.render(vars)
+	if err != nil && scn.rows.exhausted {
+		log.Debug().Err(err).Msgf("Requestor: scenario %s has run out of data, exiting", scn.name)
+		return Response{}, false
+	}
+	if err != nil {
+		return Response{Endpoint: api.Endpoint{URL: s.ep.URL, Method: s.ep.Method}, Stage: stage,
+			ErrorType: api.ErrOther, Error: err.Error()}, true
+	}
+
+	ep := s.ep
+	ep.URL, ep.Headers = url, headers
+	req, trace, err := r.newRqst(ep, []byte(body))
+	if err != nil {
+		return Response{Endpoint: api.Endpoint{URL: s.ep.URL, Method: s.ep.Method}, Stage: stage,
+			ErrorType: api.ErrOther, Error: err.Error()}, true
+	}
+
+	response, respBody, bodySize, ok := r.sendRqst(client, req, trace, s.ep, due, stage, s.maxBodyRead)
+	if !ok || response.ErrorType != "" {
+		return response, ok
+	}
+	if s.asserts != nil {
+		response.Asserted = true
+		response.AssertionFailures = s.asserts.check(response.HTTPStatus, response.Header, respBody, bodySize)
+	}
+	if !s.extract(response.Header, respBody, vars) {
+		response.ErrorType = api.ErrExtract
+		response.Error = fmt.Sprintf("scenario %s step %s: unable to extract values from the response", scn.name, s.name)
+	}
+	return response, true
+}
@@ -0,0 +1,262 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+func TestNewScenario(t *testing.T) {
+	url := "http://someurl/users"
+	create := api.Step{Name: "create", Endpoint: api.Endpoint{URL: url, Method: http.MethodPost},
+		Extract: []api.Extract{{Var: "id", JSONPath: "$.id"}}}
+
+	tests := []struct {
+		name      string
+		scenario  api.Scenario
+		expectErr bool
+	}{
+		{
+			name: "HappyPath",
+			scenario: api.Scenario{Name: "user", Steps: []api.Step{
+				create,
+				{Endpoint: api.Endpoint{URL: url + "/{{.id}}", Method: http.MethodPut, RqstBody: `{"id": {{.id}}}`,
+					Headers: map[string]string{"X-User": "{{.id}}"}}},
+			}},
+		},
+		{
+			name:      "NoName",
+			scenario:  api.Scenario{Steps: []api.Step{create}},
+			expectErr: true,
+		},
+		{
+			name:      "NoSteps",
+			scenario:  api.Scenario{Name: "user"},
+			expectErr: true,
+		},
+		{
+			name:      "NoMethod",
+			scenario:  api.Scenario{Name: "user", Steps: []api.Step{{Endpoint: api.Endpoint{URL: url}}}},
+			expectErr: true,
+		},
+		{
+			name: "GRPC",
+			scenario: api.Scenario{Name: "user", Steps: []api.Step{
+				{Endpoint: api.Endpoint{URL: "http://localhost:50051", Method: "POST", GRPC: &api.GRPC{Method: "pkg.Service/Method"}}},
+			}},
+			expectErr: true,
+		},
+		{
+			name: "WebSocket",
+			scenario: api.Scenario{Name: "user", Steps: []api.Step{
+				{Endpoint: api.Endpoint{URL: "ws://localhost/chat", Method: http.MethodGet}},
+			}},
+			expectErr: true,
+		},
+		{
+			name: "UnknownVariable",
+			scenario: api.Scenario{Name: "user", Steps: []api.Step{
+				create,
+				{Endpoint: api.Endpoint{URL: url + "/{{.userID}}", Method: http.MethodGet}},
+			}},
+			expectErr: true,
+		},
+		{
+			name: "VariableUsedBeforeExtracted",
+			scenario: api.Scenario{Name: "user", Steps: []api.Step{
+				{Endpoint: api.Endpoint{URL: url, Method: http.MethodGet, Headers: map[string]string{"X-User": "{{.id}}"}}},
+				create,
+			}},
+			expectErr: true,
+		},
+		{
+			name: "InvalidTemplate",
+			scenario: api.Scenario{Name: "user", Steps: []api.Step{
+				{Endpoint: api.Endpoint{URL: url + "/{{.id", Method: http.MethodGet}},
+			}},
+			expectErr: true,
+		},
+		{
+			name: "InvalidExtract",
+			scenario: api.Scenario{Name: "user", Steps: []api.Step{
+				{Endpoint: api.Endpoint{URL: url, Method: http.MethodGet}, Extract: []api.Extract{{Var: "id", JSONPath: "$.id", Header: "Location"}}},
+			}},
+			expectErr: true,
+		},
+		{
+			name: "InvalidVarName",
+			scenario: api.Scenario{Name: "user", Steps: []api.Step{
+				{Endpoint: api.Endpoint{URL: url, Method: http.MethodGet}, Extract: []api.Extract{{Var: "user-id", Header: "Location"}}},
+			}},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := newScenario(tc.scenario, nil, nil)
+			if tc.expectErr && err == nil {
+				t.Errorf("expected an error, got none")
+			}
+			if !tc.expectErr && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestExtractor(t *testing.T) {
+	header := http.Header{"Location": []string{"/users/42"}}
+	body := []byte(`{"id": 42, "name": "bob", "tags": ["a", "b"], "token": null}`)
+
+	tests := []struct {
+		name     string
+		extract  api.Extract
+		expected string
+		ok       bool
+	}{
+		{name: "JSONPathString", extract: api.Extract{Var: "v", JSONPath: "$.name"}, expected: "bob", ok: true},
+		{name: "JSONPathNumber", extract: api.Extract{Var: "v", JSONPath: "$.id"}, expected: "42", ok: true},
+		{name: "JSONPathArray", extract: api.Extract{Var: "v", JSONPath: "$.tags"}, expected: `["a","b"]`, ok: true},
+		{name: "JSONPathNull", extract: api.Extract{Var: "v", JSONPath: "$.token"}, ok: false},
+		{name: "JSONPathMissing", extract: api.Extract{Var: "v", JSONPath: "$.missing"}, ok: false},
+		{name: "Header", extract: api.Extract{Var: "v", Header: "location"}, expected: "/users/42", ok: true},
+		{name: "HeaderMissing", extract: api.Extract{Var: "v", Header: "X-Missing"}, ok: false},
+		{name: "RegexGroup", extract: api.Extract{Var: "v", Regex: `"name": "(\w+)"`}, expected: "bob", ok: true},
+		{name: "RegexNoGroup", extract: api.Extract{Var: "v", Regex: `\d+`}, expected: "42", ok: true},
+		{name: "RegexNoMatch", extract: api.Extract{Var: "v", Regex: `"email"`}, ok: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := newScenarioStep(api.Step{Endpoint: api.Endpoint{URL: "http://someurl", Method: http.MethodGet},
+				Extract: []api.Extract{tc.extract}}, new(int64), nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			vars := make(map[string]string)
+			ok := s.extract(header, body, vars)
+			if ok != tc.ok {
+				t.Fatalf("expected ok to be %t, got %t", tc.ok, ok)
+			}
+			if ok && vars["v"] != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, vars["v"])
+			}
+		})
+	}
+}
+
+// TestProcessScenario verifies that each step's request is built from the values extracted
+// by the steps before it, and that an iteration ends when one of its steps fails
+func TestProcessScenario(t *testing.T) {
+	var mux sync.Mutex
+	nextID := 0
+	users := make(map[string]bool)
+	var deletes []string
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		defer mux.Unlock()
+		id := strings.TrimPrefix(r.URL.Path, "/users/")
+		switch {
+		case r.Method == http.MethodPost:
+			nextID++
+			body, _ := ioutil.ReadAll(r.Body)
+			if string(body) != `{"name": "bob"}` {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			// The second user can't be created
+			if nextID == 2 {
+				w.Write([]byte(`{}`))
+				return
+			}
+			users[fmt.Sprint(nextID)] = true
+			w.Write([]byte(fmt.Sprintf(`{"id": %d}`, nextID)))
+		case r.Method == http.MethodGet && users[id]:
+			w.Header().Set("ETag", "v"+id)
+		case r.Method == http.MethodDelete && users[id] && r.Header.Get("If-Match") == "v"+id:
+			deletes = append(deletes, id)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer testSrv.Close()
+
+	sc := api.Scenario{Name: "user", RqstPercent: 100, Steps: []api.Step{
+		{Name: "create", Endpoint: api.Endpoint{URL: testSrv.URL + "/users", Method: http.MethodPost, RqstBody: `{"name": "bob"}`},
+			Extract: []api.Extract{{Var: "id", JSONPath: "$.id"}}},
+		{Name: "get", Endpoint: api.Endpoint{URL: testSrv.URL + "/users/{{.id}}", Method: http.MethodGet},
+			Extract: []api.Extract{{Var: "etag", Header: "ETag"}}},
+		{Name: "delete", Endpoint: api.Endpoint{URL: testSrv.URL + "/users/{{.id}}", Method: http.MethodDelete,
+			Headers:    map[string]string{"If-Match": "{{.etag}}"},
+			Assertions: &api.Assertions{Status: []string{"204"}}}},
+	}}
+
+	// 2 complete iterations, and the failed one in between, take 7 requests
+	numRqsts := 7
+	respC := make(chan Response, numRqsts)
+	rqstr := Requestor{
+		Ctx:       context.Background(),
+		ResponseC: respC,
+		Client:    http.Client{},
+	}
+	rqstr.ProcessScenario(sc, newRatePacer(numRqsts, 0, constantArrival{}))
+	close(respC)
+
+	var resps []Response
+	for resp := range respC {
+		resps = append(resps, resp)
+	}
+	if len(resps) != numRqsts {
+		t.Fatalf("expected %d responses, got %d", numRqsts, len(resps))
+	}
+
+	expected := []struct {
+		step       int
+		errorType  string
+		iterDone   bool
+		iterFailed bool
+	}{
+		{step: 1},
+		{step: 2},
+		{step: 3, iterDone: true},
+		{step: 1, errorType: api.ErrExtract, iterDone: true, iterFailed: true},
+		{step: 1},
+		{step: 2},
+		{step: 3, iterDone: true},
+	}
+	for i, exp := range expected {
+		resp := resps[i]
+		if resp.Scenario != sc.Name || resp.Step != exp.step || resp.ErrorType != exp.errorType ||
+			resp.IterationDone != exp.iterDone || resp.IterationFailed != exp.iterFailed {
+			t.Errorf("response %d: expected %+v, got scenario %s, step %d, error type %q, done %t, failed %t", i, exp,
+				resp.Scenario, resp.Step, resp.ErrorType, resp.IterationDone, resp.IterationFailed)
+		}
+		if len(resp.AssertionFailures) > 0 {
+			t.Errorf("response %d: unexpected assertion failures %v", i, resp.AssertionFailures)
+		}
+		if exp.iterDone && !exp.iterFailed && resp.IterationDuration <= 0 {
+			t.Errorf("response %d: expected the iteration's duration to be recorded", i)
+		}
+	}
+
+	// Responses are reported by the step's URL template
+	if url := resps[1].Endpoint.URL; url != sc.Steps[1].URL {
+		t.Errorf("expected the response URL to be %s, got %s", sc.Steps[1].URL, url)
+	}
+	if len(deletes) != 2 || deletes[0] != "1" || deletes[1] != "3" {
+		t.Errorf("expected users 1 and 3 to be deleted, got %v", deletes)
+	}
+}
@@ -5,6 +5,7 @@
 package internal
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"sync"
@@ -16,12 +17,13 @@ import (
 
 // IRequestor declares the functionality needed to make requests to an endpoint
 type IRequestor interface {
-	ProcessRqst(ep api.Endpoint, numRqsts int, rqstRate int)
+	ProcessRqst(ep api.Endpoint, pacer Pacer)
+	ProcessScenario(sc api.Scenario, pacer Pacer)
 	ResponseChan() chan Response
 }
 
 // Scheduler determines which requests to make over the schedC
-// channel based on each Endpoint's 'RqstPercent'
+// channel based on each Endpoint's, and Scenario's, 'RqstPercent'
 type Scheduler struct {
 	// concurrency is the overall number of simulataneously
 	// running requests
@@ -43,15 +45,25 @@ type Scheduler struct {
 	numRqsts int
 	// endpoints represents the set of endpoints getting requests
 	endpoints []api.Endpoint
+	// scenarios are run by virtual users alongside the requests to endpoints
+	scenarios []api.Scenario
 	// rqstr is responsible for making client requests to endpoints
 	rqstr IRequestor
+	// profile, if not nil, overrides rqstRate and concurrency with targets that
+	// vary over the course of the run
+	profile *LoadProfile
+	// openModel is true if requests are sent at their intended times by a central
+	// dispatcher rather than paced by each requestor goroutine
+	openModel bool
+	// arrival describes how the times between requests are distributed
+	arrival api.Arrival
 }
 
 // NewScheduler returns a valid Scheduler instance
 func NewScheduler(concurrency int, rate int, runDur time.Duration, numRqsts int,
-	eps []api.Endpoint, rqstr IRequestor) (*Scheduler, error) {
+	eps []api.Endpoint, scenarios []api.Scenario, data *DataSources, rqstr IRequestor) (*Scheduler, error) {
 
-	err := validateConfig(concurrency, rate, runDur, numRqsts, eps)
+	err := validateConfig(concurrency, rate, runDur, numRqsts, eps, scenarios, data)
 	if err != nil {
 		return nil, err
 	}
@@ -62,6 +74,7 @@ func NewScheduler(concurrency int, rate int, runDur time.Duration, numRqsts int,
 		runDur:      runDur,
 		numRqsts:    numRqsts,
 		endpoints:   eps,
+		scenarios:   scenarios,
 		rqstr:       rqstr,
 	}
 	log.Debug().Msgf("Scheduler: %+v", schedlr)
@@ -69,24 +82,90 @@ func NewScheduler(concurrency int, rate int, runDur time.Duration, numRqsts int,
 	return &schedlr, nil
 }
 
-// Start begins the scheduling process
-func (s Scheduler) Start() error {
+// SetLoadProfile configures the Scheduler to run the stages described by 'profile'
+// instead of a constant request rate and concurrency. The Scheduler's concurrency must
+// be the profile's maximum concurrency.
+func (s *Scheduler) SetLoadProfile(profile *LoadProfile) {
+	s.profile = profile
+}
+
+// SetLoadModel configures how requests are scheduled, either ClosedModel or OpenModel.
+// An empty 'model' is ClosedModel. OpenModel requires a request rate greater than zero
+// or a LoadProfile, so SetLoadProfile must be called first if there is one.
+func (s *Scheduler) SetLoadModel(model string) error {
+	switch model {
+	case "", ClosedModel:
+		s.openModel = false
+	case OpenModel:
+		if s.profile == nil && s.rqstRate < 1 {
+			return fmt.Errorf("RqstRate must be greater than zero when LoadModel is %s", OpenModel)
+		}
+		// A step stage with a RqstRate of 0 is unthrottled in the closed model, the open
+		// model has no unthrottled rate to send requests at
+		if s.profile != nil && !s.profile.linear {
+			for i, stage := range s.profile.stages {
+				if stage.rqstRate <= 0 {
+					return fmt.Errorf("stage %d: RqstRate must be greater than zero when LoadModel is %s", i+1, OpenModel)
+				}
+			}
+		}
+		s.openModel = true
+	default:
+		return fmt.Errorf("LoadModel %s is invalid, it must be either %s or %s", model, ClosedModel, OpenModel)
+	}
+	return nil
+}
+
+// SetArrival configures how the times between requests are distributed
+func (s *Scheduler) SetArrival(arrival api.Arrival) error {
+	if _, err := newArrivalProcess(arrival); err != nil {
+		return err
+	}
+	s.arrival = arrival
+	return nil
+}
+
+// newArrivalProcess returns a new instance of the Scheduler's arrival process. Each
+// pacer needs its own instance since arrival processes aren't safe for concurrent use.
+func (s Scheduler) newArrivalProcess() arrivalProcess {
+	// The arrival process was validated by SetArrival()
+	arrival, err := newArrivalProcess(s.arrival)
+	if err != nil {
+		log.Error().Err(err).Msg("invalid arrival process, using constant arrivals")
+		return constantArrival{}
+	}
+	return arrival
+}
+
+// Start begins the scheduling process. Requests stop being scheduled when 'ctx' is done.
+func (s Scheduler) Start(ctx context.Context) error {
 	var wg sync.WaitGroup
 
+	if s.profile != nil {
+		s.profile.begin()
+	}
+
+	var dsptchr *dispatcher
+	if s.openModel {
+		dsptchr = newDispatcher(s.rqstRate, s.runDur, s.numRqsts, s.profile, s.newArrivalProcess(),
+			s.rqstr.ResponseChan())
+	}
+
 	for _, ep := range s.endpoints {
 		ep := ep
-		numRqstsPerGoroutine, epConcurrency, goroutineRqstRate := s.calcEPConfig(ep)
-		for i := 0; i < epConcurrency; i++ {
-			wg.Add(1)
-			go func() {
-
-				log.Debug().Msgf("Starting Endpoint Goroutine for EP: %s numRqsts: %d, runDur: %d, and rqstRate: %d", ep.URL,
-					numRqstsPerGoroutine, s.runDur/time.Second, goroutineRqstRate)
+		s.startRequestors(&wg, ep, dsptchr, func(pacer Pacer) { s.rqstr.ProcessRqst(ep, pacer) })
+	}
+	for _, sc := range s.scenarios {
+		sc := sc
+		s.startRequestors(&wg, scenarioEndpoint(sc), dsptchr, func(pacer Pacer) { s.rqstr.ProcessScenario(sc, pacer) })
+	}
 
-				s.rqstr.ProcessRqst(ep, numRqstsPerGoroutine, goroutineRqstRate)
-				wg.Done()
-			}()
-		}
+	if dsptchr != nil {
+		wg.Add(1)
+		go func() {
+			dsptchr.run(ctx)
+			wg.Done()
+		}()
 	}
 
 	wg.Wait()
@@ -95,6 +174,50 @@ func (s Scheduler) Start() error {
 	return nil
 }
 
+// startRequestors starts the goroutines that send 'ep's share of the requests, each of
+// which calls 'process' with its Pacer. 'dsptchr' is nil unless the run uses OpenModel.
+func (s Scheduler) startRequestors(wg *sync.WaitGroup, ep api.Endpoint, dsptchr *dispatcher, process func(pacer Pacer)) {
+	numRqstsPerGoroutine, epConcurrency, goroutineRqstRate := s.calcEPConfig(ep)
+	var epd *epDispatch
+	if dsptchr != nil {
+		epd = dsptchr.addEndpoint(ep, epConcurrency)
+	}
+	for i := 0; i < epConcurrency; i++ {
+		var pacer Pacer = newRatePacer(numRqstsPerGoroutine, goroutineRqstRate, s.newArrivalProcess())
+		var dp *dispatchPacer
+		if epd != nil {
+			dp = &dispatchPacer{epd: epd}
+			pacer = dp
+		} else if s.profile != nil {
+			pacer = &stagedPacer{
+				profile:   s.profile,
+				epShare:   float64(ep.RqstPercent) / float64(100),
+				worker:    i,
+				remaining: api.MaxRqsts,
+				arrival:   s.newArrivalProcess(),
+			}
+		}
+		wg.Add(1)
+		go func() {
+
+			log.Debug().Msgf("Starting Endpoint Goroutine for EP: %s numRqsts: %d, runDur: %d, and rqstRate: %d", ep.URL,
+				numRqstsPerGoroutine, s.runDur/time.Second, goroutineRqstRate)
+
+			process(pacer)
+			if dp != nil {
+				dp.close()
+			}
+			wg.Done()
+		}()
+	}
+}
+
+// scenarioEndpoint returns the api.Endpoint used to schedule the requests of 'sc'. Only
+// its URL, which identifies the scenario, and RqstPercent are meaningful.
+func scenarioEndpoint(sc api.Scenario) api.Endpoint {
+	return api.Endpoint{URL: "scenario " + sc.Name, RqstPercent: sc.RqstPercent}
+}
+
 func (s Scheduler) calcEPConfig(ep api.Endpoint) (numRqstsPerGoroutine int, numEPGoroutines int, epGoroutineRqstRate int) {
 	numEPGoroutines = int(math.Ceil(float64(s.concurrency) * (float64(ep.RqstPercent) / float64(100))))
 	if numEPGoroutines != int(float64(s.concurrency)*(float64(ep.RqstPercent)/float64(100))) {
@@ -128,7 +251,8 @@ func (s Scheduler) calcEPConfig(ep api.Endpoint) (numRqstsPerGoroutine int, numE
 	return numRqstsPerGoroutine, numEPGoroutines, epGoroutineRqstRate
 }
 
-func validateConfig(concurrency int, rate int, runDur time.Duration, numRqsts int, eps []api.Endpoint) error {
+func validateConfig(concurrency int, rate int, runDur time.Duration, numRqsts int, eps []api.Endpoint,
+	scenarios []api.Scenario, data *DataSources) error {
 	if numRqsts > 0 && runDur > 0 {
 		return fmt.Errorf("number of requests is %d and requested duration is %s, one must be zero",
 			numRqsts, runDur)
@@ -136,19 +260,59 @@ func validateConfig(concurrency int, rate int, runDur time.Duration, numRqsts in
 	if runDur < 1 && numRqsts < concurrency {
 		return fmt.Errorf("number of requests %d, must be greater than the concurrency level %d", numRqsts, concurrency)
 	}
-	if runDur < 1 && len(eps) > numRqsts {
-		return fmt.Errorf("there are more endpoints, %d, than requests, %d", len(eps), numRqsts)
+	// Each scenario is scheduled like an endpoint
+	numEPs := len(eps) + len(scenarios)
+	if runDur < 1 && numEPs > numRqsts {
+		return fmt.Errorf("there are more endpoints and scenarios, %d, than requests, %d", numEPs, numRqsts)
 	}
-	if concurrency < len(eps) {
-		return fmt.Errorf("MaxConcurrentRqsts must be greater than the number of endpoints. MaxConcurrentRqsts is %d and there are %d endpoints", concurrency, len(eps))
+	if concurrency < numEPs {
+		return fmt.Errorf("MaxConcurrentRqsts must be greater than the number of endpoints and scenarios. MaxConcurrentRqsts is %d and there are %d endpoints and scenarios",
+			concurrency, numEPs)
 	}
 
 	rqstPct := 0
 	for _, ep := range eps {
 		rqstPct += ep.RqstPercent
+		if _, err := newAssertions(ep.Assertions); err != nil {
+			return fmt.Errorf("endpoint %s: %w", ep.URL, err)
+		}
+		if ep.Transport != nil {
+			if _, err := newTransportSettings(*ep.Transport); err != nil {
+				return fmt.Errorf("endpoint %s: %w", ep.URL, err)
+			}
+		}
+		// Endpoints are validated as they're run by Requestor.ProcessRqst(), a GRPC section
+		// takes precedence over a WebSocket URL
+		if ep.GRPC != nil {
+			if err := validateGRPC(ep); err != nil {
+				return fmt.Errorf("endpoint %s: %w", ep.URL, err)
+			}
+		} else if isWebSocketURL(ep.URL) {
+			if err := validateWebSocket(ep); err != nil {
+				return fmt.Errorf("endpoint %s: %w", ep.URL, err)
+			}
+		}
+		tmplts, err := newRqstTemplates(ep, new(int64), newDataRows(data, newRand()))
+		if err != nil {
+			return fmt.Errorf("endpoint %s: %w", ep.URL, err)
+		}
+		if _, _, _, err = tmplts.render(nil); err != nil {
+			return fmt.Errorf("endpoint %s: %w", ep.URL, err)
+		}
+	}
+	names := make(map[string]bool)
+	for _, sc := range scenarios {
+		rqstPct += sc.RqstPercent
+		if _, err := newScenario(sc, nil, data); err != nil {
+			return err
+		}
+		if names[sc.Name] {
+			return fmt.Errorf("there's more than one scenario named %s", sc.Name)
+		}
+		names[sc.Name] = true
 	}
 	if rqstPct != 100 {
-		return fmt.Errorf("endpoint.RqstPercents must add up to 100 not %d", rqstPct)
+		return fmt.Errorf("endpoint and scenario RqstPercents must add up to 100 not %d", rqstPct)
 	}
 
 	if numRqsts > api.MaxRqsts {
@@ -5,6 +5,7 @@
 package internal
 
 import (
+	"context"
 	"flag"
 	"os"
 	"sync"
@@ -25,12 +26,20 @@ type MockRequestor struct {
 	mux               *sync.Mutex
 }
 
-func (r *MockRequestor) ProcessRqst(ep api.Endpoint, numRqsts int, rqstRate int) {
+func (r *MockRequestor) ProcessRqst(ep api.Endpoint, pacer Pacer) {
+	numRqsts := 0
+	for _, _, ok := pacer.Next(context.Background()); ok; _, _, ok = pacer.Next(context.Background()) {
+		numRqsts++
+	}
 	r.mux.Lock()
 	r.actualNumRqstrs += numRqsts
 	r.mux.Unlock()
 }
 
+func (r *MockRequestor) ProcessScenario(sc api.Scenario, pacer Pacer) {
+	r.ProcessRqst(scenarioEndpoint(sc), pacer)
+}
+
 func (r *MockRequestor) ResponseChan() chan Response {
 	return r.responseC
 }
@@ -250,6 +259,7 @@ func TestValidation(t *testing.T) {
 		numRqsts    int
 		concurrency int
 		eps         []api.Endpoint
+		scenarios   []api.Scenario
 		rqstr       IRequestor
 		shouldFail  bool
 	}{
@@ -457,6 +467,90 @@ func TestValidation(t *testing.T) {
 			},
 			shouldFail: true,
 		},
+		{
+			name:        "HappyPath - endpoint and scenario",
+			rqstRate:    goFastRate,
+			runDur:      "1s",
+			concurrency: 10,
+			eps:         []api.Endpoint{{URL: url1, Method: "GET", RqstPercent: 50}},
+			scenarios: []api.Scenario{
+				{Name: "createUser", RqstPercent: 50, Steps: []api.Step{
+					{Endpoint: api.Endpoint{URL: url1, Method: "POST"}, Extract: []api.Extract{{Var: "id", JSONPath: "$.id"}}},
+					{Endpoint: api.Endpoint{URL: url1 + "/{{.id}}", Method: "GET"}},
+				}},
+			},
+		},
+		{
+			name:        "FailPath - scenario refers to a variable before it's extracted",
+			rqstRate:    goFastRate,
+			runDur:      "1s",
+			concurrency: 10,
+			scenarios: []api.Scenario{
+				{Name: "createUser", RqstPercent: 100, Steps: []api.Step{
+					{Endpoint: api.Endpoint{URL: url1 + "/{{.id}}", Method: "GET"}},
+					{Endpoint: api.Endpoint{URL: url1, Method: "POST"}, Extract: []api.Extract{{Var: "id", JSONPath: "$.id"}}},
+				}},
+			},
+			shouldFail: true,
+		},
+		{
+			name:        "FailPath - invalid endpoint template",
+			rqstRate:    goFastRate,
+			runDur:      "1s",
+			concurrency: 10,
+			eps:         []api.Endpoint{{URL: url1 + "/{{ randInt 10 }}", Method: "GET", RqstPercent: 100}},
+			shouldFail:  true,
+		},
+		{
+			name:        "FailPath - unknown data source",
+			rqstRate:    goFastRate,
+			runDur:      "1s",
+			concurrency: 10,
+			eps:         []api.Endpoint{{URL: url1 + `/{{ data "users" "id" }}`, Method: "GET", RqstPercent: 100}},
+			shouldFail:  true,
+		},
+		{
+			name:        "FailPath - invalid endpoint Transport",
+			rqstRate:    goFastRate,
+			runDur:      "1s",
+			concurrency: 10,
+			eps:         []api.Endpoint{{URL: url1, Method: "GET", RqstPercent: 100, Transport: &api.Transport{RqstTimeout: "10"}}},
+			shouldFail:  true,
+		},
+		{
+			name:        "FailPath - invalid scenario step Transport",
+			rqstRate:    goFastRate,
+			runDur:      "1s",
+			concurrency: 10,
+			scenarios: []api.Scenario{
+				{Name: "getUser", RqstPercent: 100, Steps: []api.Step{
+					{Endpoint: api.Endpoint{URL: url1, Method: "GET", Transport: &api.Transport{MaxConnsPerHost: -1}}},
+				}},
+			},
+			shouldFail: true,
+		},
+		{
+			name:        "FailPath - duplicate scenario names",
+			rqstRate:    goFastRate,
+			runDur:      "1s",
+			concurrency: 10,
+			scenarios: []api.Scenario{
+				{Name: "getUser", RqstPercent: 50, Steps: []api.Step{{Endpoint: api.Endpoint{URL: url1, Method: "GET"}}}},
+				{Name: "getUser", RqstPercent: 50, Steps: []api.Step{{Endpoint: api.Endpoint{URL: url2, Method: "GET"}}}},
+			},
+			shouldFail: true,
+		},
+		{
+			name:        "FailPath - scenario RqstPercent not counted",
+			rqstRate:    goFastRate,
+			runDur:      "1s",
+			concurrency: 10,
+			eps:         []api.Endpoint{{URL: url1, Method: "GET", RqstPercent: 50}},
+			scenarios: []api.Scenario{
+				{Name: "getUser", RqstPercent: 40, Steps: []api.Step{{Endpoint: api.Endpoint{URL: url1, Method: "GET"}}}},
+			},
+			shouldFail: true,
+		},
 	}
 
 	for _, tc := range tests {
@@ -467,7 +561,7 @@ func TestValidation(t *testing.T) {
 			}
 
 			_, err = NewScheduler(tc.concurrency, tc.rqstRate, runDir,
-				tc.numRqsts, tc.eps, tc.rqstr)
+				tc.numRqsts, tc.eps, tc.scenarios, nil, tc.rqstr)
 
 			if err == nil && tc.shouldFail == true {
 				t.Fatalf("unexpected success creating Scheduler")
@@ -498,12 +592,12 @@ func TestRqstrInteractions(t *testing.T) {
 		},
 	}
 
-	s, err := NewScheduler(concurrency, 1000, time.Duration(0), numRqsts, eps, rqstr)
+	s, err := NewScheduler(concurrency, 1000, time.Duration(0), numRqsts, eps, nil, nil, rqstr)
 	if err != nil {
 		t.Errorf("unexpected error calling NewScheduler(): %s", err)
 	}
 
-	go s.Start()
+	go s.Start(context.Background())
 
 	timesUp := time.After(time.Millisecond * 100)
 	select {
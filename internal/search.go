@@ -0,0 +1,153 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/youngkin/heyyall/api"
+)
+
+// minAchievedRatePct is the percent of its target request rate an iteration of a capacity
+// search must achieve to pass. A service that can't keep up with the target rate, e.g.,
+// because MaxConcurrentRqsts requests are always in progress, isn't sustaining it.
+const minAchievedRatePct = 90
+
+// SearchConfig controls a capacity search
+type SearchConfig struct {
+	// MinRqstRate is the first, and lowest, request rate tried
+	MinRqstRate int
+	// MaxRqstRate is the highest request rate tried
+	MaxRqstRate int
+	// IterationDuration is how long each rate is run for
+	IterationDuration time.Duration
+	// PrecisionPct ends the search once the highest passing rate and the lowest failing
+	// rate are within this percent of each other
+	PrecisionPct float64
+}
+
+// Search looks for the highest request rate at which 'config's Thresholds are met. Each
+// rate is tried by running 'config' with 'run' at that rate for IterationDuration. The
+// rate is doubled, starting from MinRqstRate, until an iteration fails or MaxRqstRate is
+// reached, then the highest passing rate is binary searched for between the last passing
+// and the first failing rates.
+func Search(config api.LoadTestConfig, sc SearchConfig, run RunFunc) (api.SearchResults, error) {
+	if err := validateSearch(config, sc); err != nil {
+		return api.SearchResults{}, err
+	}
+
+	results := api.SearchResults{Thresholds: config.Thresholds}
+	tryRate := func(rate int) (bool, error) {
+		log.Info().Msgf("search: running at %d rqsts/sec for %s", rate, sc.IterationDuration)
+		rr, err := run(searchIterationConfig(config, rate, sc.IterationDuration), time.Time{})
+		if err != nil {
+			return false, fmt.Errorf("iteration at %d rqsts/sec failed: %w", rate, err)
+		}
+		iter := newSearchIteration(config.Thresholds, rate, rr)
+		results.Iterations = append(results.Iterations, iter)
+		log.Info().Msgf("search: %d rqsts/sec passed: %t", rate, iter.Passed)
+		return iter.Passed, nil
+	}
+
+	// lo is the highest rate known to pass and hi the lowest rate known to fail. A hi of
+	// 0 means no rate has failed yet.
+	lo, hi := 0, 0
+	for rate := sc.MinRqstRate; ; rate *= 2 {
+		if rate > sc.MaxRqstRate {
+			rate = sc.MaxRqstRate
+		}
+		passed, err := tryRate(rate)
+		if err != nil {
+			return results, err
+		}
+		if !passed {
+			hi = rate
+			break
+		}
+		lo = rate
+		if rate == sc.MaxRqstRate {
+			break
+		}
+	}
+
+	for lo > 0 && hi > 0 && float64(hi-lo) > float64(lo)*sc.PrecisionPct/100 && hi-lo > 1 {
+		rate := lo + (hi-lo)/2
+		passed, err := tryRate(rate)
+		if err != nil {
+			return results, err
+		}
+		if passed {
+			lo = rate
+		} else {
+			hi = rate
+		}
+	}
+
+	results.KneeRqstRate = lo
+	sort.Slice(results.Iterations, func(i, j int) bool {
+		return results.Iterations[i].TargetRqstRate < results.Iterations[j].TargetRqstRate
+	})
+	return results, nil
+}
+
+// validateSearch returns an error if 'config' or 'sc' can't be used for a capacity search
+func validateSearch(config api.LoadTestConfig, sc SearchConfig) error {
+	if len(config.Thresholds) == 0 {
+		return fmt.Errorf("a capacity search requires Thresholds, e.g., a P99 latency or an ErrorRate limit")
+	}
+	if err := ValidateThresholds(config.Thresholds); err != nil {
+		return err
+	}
+	if sc.MinRqstRate < 1 {
+		return fmt.Errorf("the minimum request rate must be greater than 0, it's %d", sc.MinRqstRate)
+	}
+	if sc.MaxRqstRate < sc.MinRqstRate {
+		return fmt.Errorf("the maximum request rate, %d, is less than the minimum request rate, %d", sc.MaxRqstRate, sc.MinRqstRate)
+	}
+	if sc.IterationDuration <= 0 {
+		return fmt.Errorf("the iteration duration must be greater than 0, it's %s", sc.IterationDuration)
+	}
+	if sc.PrecisionPct <= 0 {
+		return fmt.Errorf("the precision must be greater than 0, it's %.2f", sc.PrecisionPct)
+	}
+	return nil
+}
+
+// searchIterationConfig returns 'config' modified to run at 'rate' for 'dur'
+func searchIterationConfig(config api.LoadTestConfig, rate int, dur time.Duration) api.LoadTestConfig {
+	config.RqstRate = rate
+	config.RunDuration = dur.String()
+	config.NumRequests = 0
+	config.Stages = nil
+	// Thresholds are evaluated by the search, and time series aren't reported
+	config.Thresholds = nil
+	config.TimeSeriesInterval = ""
+	return config
+}
+
+// newSearchIteration summarizes 'rr', the results of running at 'rate'
+func newSearchIteration(thresholds []api.Threshold, rate int, rr api.RunResults) api.SearchIteration {
+	stats := rr.RunSummary.RqstStats
+	iter := api.SearchIteration{
+		TargetRqstRate:   rate,
+		RqstRatePerSec:   rr.RunSummary.RqstRatePerSec,
+		TotalRqsts:       stats.TotalRqsts,
+		ThresholdResults: evaluateThresholds(thresholds, &rr),
+	}
+	if stats.TotalRqsts > 0 {
+		iter.ErrorRatePct = float64(stats.TotalErrors) / float64(stats.TotalRqsts) * 100
+	}
+	if stats.TimingResultsNanos != nil {
+		iter.MedianRqstDurationNanos = stats.TimingResultsNanos.Percentile(50)
+		iter.P90RqstDurationNanos = stats.TimingResultsNanos.Percentile(90)
+		iter.P99RqstDurationNanos = stats.TimingResultsNanos.Percentile(99)
+	}
+	iter.Passed = thresholdsPassed(iter.ThresholdResults) &&
+		iter.RqstRatePerSec >= float64(rate)*minAchievedRatePct/100
+	return iter
+}
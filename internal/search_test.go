@@ -0,0 +1,126 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// fakeService returns a RunFunc simulating a service whose P99 latency is 10ms up to
+// 'kneeRate', and 1s above it. Above 'maxRate' the service can't keep up and only
+// achieves 'maxRate'. 'errorRate' is the rate above which all requests fail.
+func fakeService(kneeRate, maxRate, errorRate int, rates *[]int) RunFunc {
+	return func(config api.LoadTestConfig, startAt time.Time) (api.RunResults, error) {
+		*rates = append(*rates, config.RqstRate)
+		if config.RunDuration != "1s" || config.NumRequests != 0 || config.Thresholds != nil {
+			return api.RunResults{}, fmt.Errorf("unexpected iteration config %+v", config)
+		}
+		rate := config.RqstRate
+		if rate > maxRate {
+			rate = maxRate
+		}
+		latency := 10 * time.Millisecond
+		if rate > kneeRate {
+			latency = time.Second
+		}
+		h := api.NewHistogram(0, 0)
+		for i := 0; i < rate; i++ {
+			h.Record(latency)
+		}
+		stats := api.RqstStats{TotalRqsts: int64(rate), TimingResultsNanos: h}
+		if config.RqstRate > errorRate {
+			stats.TotalErrors = stats.TotalRqsts
+		}
+		return api.RunResults{RunSummary: api.RunSummary{RqstRatePerSec: float64(rate), RqstStats: stats}}, nil
+	}
+}
+
+func TestSearch(t *testing.T) {
+	thresholds := []api.Threshold{{Metric: "P99", Max: "100ms"}, {Metric: "ErrorRate", Max: "1%"}}
+	sc := SearchConfig{MinRqstRate: 10, MaxRqstRate: 1000, IterationDuration: time.Second, PrecisionPct: 5}
+
+	tests := []struct {
+		name       string
+		thresholds []api.Threshold
+		sc         SearchConfig
+		kneeRate   int
+		maxRate    int
+		errorRate  int
+		// expectedKnee is the highest rate the service sustains, the search may find a
+		// rate up to PrecisionPct lower
+		expectedKnee int
+		expectErr    bool
+	}{
+		{name: "LatencyKnee", thresholds: thresholds, sc: sc, kneeRate: 300, maxRate: 5000, errorRate: 5000, expectedKnee: 300},
+		{name: "ErrorKnee", thresholds: thresholds, sc: sc, kneeRate: 5000, maxRate: 5000, errorRate: 123, expectedKnee: 123},
+		{name: "RateNotAchieved", thresholds: thresholds, sc: sc, kneeRate: 5000, maxRate: 200, errorRate: 5000, expectedKnee: 222},
+		{name: "MaxRatePasses", thresholds: thresholds, sc: sc, kneeRate: 5000, maxRate: 5000, errorRate: 5000, expectedKnee: 1000},
+		{name: "MinRateFails", thresholds: thresholds, sc: sc, kneeRate: 5, maxRate: 5000, errorRate: 5000, expectedKnee: 0},
+		{
+			name:         "Exact",
+			thresholds:   thresholds,
+			sc:           SearchConfig{MinRqstRate: 1, MaxRqstRate: 100, IterationDuration: time.Second, PrecisionPct: 0.1},
+			kneeRate:     37,
+			maxRate:      5000,
+			errorRate:    5000,
+			expectedKnee: 37,
+		},
+		{name: "NoThresholds", sc: sc, expectErr: true},
+		{name: "InvalidThreshold", thresholds: []api.Threshold{{Metric: "P101", Max: "1s"}}, sc: sc, expectErr: true},
+		{name: "InvalidMin", thresholds: thresholds, sc: SearchConfig{MaxRqstRate: 10, IterationDuration: time.Second, PrecisionPct: 5}, expectErr: true},
+		{name: "MaxBelowMin", thresholds: thresholds, sc: SearchConfig{MinRqstRate: 10, MaxRqstRate: 5, IterationDuration: time.Second, PrecisionPct: 5}, expectErr: true},
+		{name: "InvalidDuration", thresholds: thresholds, sc: SearchConfig{MinRqstRate: 10, MaxRqstRate: 100, PrecisionPct: 5}, expectErr: true},
+		{name: "InvalidPrecision", thresholds: thresholds, sc: SearchConfig{MinRqstRate: 10, MaxRqstRate: 100, IterationDuration: time.Second}, expectErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var rates []int
+			config := api.LoadTestConfig{
+				RunDuration: "1m",
+				NumRequests: 100,
+				Thresholds:  tc.thresholds,
+			}
+			results, err := Search(config, tc.sc, fakeService(tc.kneeRate, tc.maxRate, tc.errorRate, &rates))
+			if tc.expectErr {
+				if err == nil {
+					t.Errorf("expected an error, got none")
+				}
+				if len(rates) != 0 {
+					t.Errorf("expected no iterations to be run, got %v", rates)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			minKnee := int(float64(tc.expectedKnee) * 100 / (100 + tc.sc.PrecisionPct))
+			if results.KneeRqstRate < minKnee || results.KneeRqstRate > tc.expectedKnee {
+				t.Errorf("expected a knee between %d and %d, got %d", minKnee, tc.expectedKnee, results.KneeRqstRate)
+			}
+			if len(results.Iterations) != len(rates) {
+				t.Fatalf("expected %d iterations, got %d", len(rates), len(results.Iterations))
+			}
+			for i, iter := range results.Iterations {
+				if i > 0 && iter.TargetRqstRate <= results.Iterations[i-1].TargetRqstRate {
+					t.Errorf("expected iterations to be ordered by rate, got %d after %d", iter.TargetRqstRate,
+						results.Iterations[i-1].TargetRqstRate)
+				}
+				if iter.Passed != (iter.TargetRqstRate <= results.KneeRqstRate) {
+					t.Errorf("%d rqsts/sec: expected Passed to be %t, got %t", iter.TargetRqstRate,
+						iter.TargetRqstRate <= results.KneeRqstRate, iter.Passed)
+				}
+				if len(iter.ThresholdResults) != len(tc.thresholds) {
+					t.Errorf("expected %d threshold results, got %d", len(tc.thresholds), len(iter.ThresholdResults))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,281 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// metricKind determines how a threshold's metric is measured and how its limits are expressed
+type metricKind int
+
+const (
+	latencyMetric metricKind = iota
+	percentMetric
+	rateMetric
+)
+
+// threshold is a parsed api.Threshold
+type threshold struct {
+	api.Threshold
+	kind metricKind
+	// percentile is the latency percentile being checked, or -1 if the metric isn't a percentile
+	percentile float64
+	max, min   float64
+	hasMax     bool
+	hasMin     bool
+}
+
+// ValidateThresholds returns an error if any of 'thresholds' is invalid
+func ValidateThresholds(thresholds []api.Threshold) error {
+	for _, t := range thresholds {
+		if _, err := newThreshold(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newThreshold(t api.Threshold) (threshold, error) {
+	th := threshold{Threshold: t, percentile: -1}
+	switch {
+	case t.Metric == api.MetricMinLatency, t.Metric == api.MetricMaxLatency, t.Metric == api.MetricAvgLatency:
+		th.kind = latencyMetric
+	case t.Metric == api.MetricErrorRate, t.Metric == api.MetricAssertionFailureRate:
+		th.kind = percentMetric
+	case t.Metric == api.MetricRqstRate:
+		th.kind = rateMetric
+	case strings.HasPrefix(t.Metric, "P"):
+		p, err := strconv.ParseFloat(t.Metric[1:], 64)
+		if err != nil || p <= 0 || p > 100 {
+			return threshold{}, fmt.Errorf("threshold metric %s is an invalid percentile", t.Metric)
+		}
+		th.kind = latencyMetric
+		th.percentile = p
+	default:
+		return threshold{}, fmt.Errorf("threshold metric %s is invalid", t.Metric)
+	}
+
+	if t.Method != "" && t.URL == "" {
+		return threshold{}, fmt.Errorf("threshold %s specifies Method %s without a URL", t.Metric, t.Method)
+	}
+	if t.Max == "" && t.Min == "" {
+		return threshold{}, fmt.Errorf("threshold %s must specify Max, Min, or both", t.Metric)
+	}
+
+	var err error
+	if t.Max != "" {
+		th.hasMax = true
+		if th.max, err = th.parseLimit(t.Max); err != nil {
+			return threshold{}, err
+		}
+	}
+	if t.Min != "" {
+		th.hasMin = true
+		if th.min, err = th.parseLimit(t.Min); err != nil {
+			return threshold{}, err
+		}
+	}
+	return th, nil
+}
+
+// parseLimit parses a Max or Min value. Latencies are returned in nanoseconds.
+func (th threshold) parseLimit(limit string) (float64, error) {
+	if th.kind == latencyMetric {
+		d, err := time.ParseDuration(limit)
+		if err != nil {
+			return 0, fmt.Errorf("threshold %s limit %s must be a duration (e.g., 200ms): %w", th.Metric, limit, err)
+		}
+		return float64(d), nil
+	}
+
+	v := limit
+	if th.kind == percentMetric {
+		v = strings.TrimSuffix(v, "%")
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil {
+		return 0, fmt.Errorf("threshold %s limit %s must be a number: %w", th.Metric, limit, err)
+	}
+	return f, nil
+}
+
+// evaluateThresholds evaluates each of 'thresholds' against 'rr'. Thresholds are
+// expected to have been validated by ValidateThresholds().
+func evaluateThresholds(thresholds []api.Threshold, rr *api.RunResults) []api.ThresholdResult {
+	results := make([]api.ThresholdResult, 0, len(thresholds))
+	for _, t := range thresholds {
+		result := api.ThresholdResult{Threshold: t}
+		th, err := newThreshold(t)
+		if err != nil {
+			result.Actual = err.Error()
+			results = append(results, result)
+			continue
+		}
+
+		actual, ok, reason := th.measure(rr)
+		if !ok {
+			result.Actual = reason
+			results = append(results, result)
+			continue
+		}
+		result.Actual = th.format(actual)
+		result.Passed = (!th.hasMax || actual <= th.max) && (!th.hasMin || actual >= th.min)
+		results = append(results, result)
+	}
+	return results
+}
+
+// measure returns the value of the threshold's metric in 'rr'. If the metric can't be
+// measured 'ok' is false and 'reason' explains why.
+func (th threshold) measure(rr *api.RunResults) (actual float64, ok bool, reason string) {
+	stats, asserted, assertFailed, found := scopedStats(rr, th.URL, th.Method)
+	if !found {
+		return 0, false, "no requests were made"
+	}
+	numOK := stats.TotalRqsts - stats.TotalErrors
+
+	switch {
+	case th.percentile > 0:
+		if numOK == 0 {
+			return 0, false, "no successful requests"
+		}
+		return float64(stats.TimingResultsNanos.Percentile(th.percentile)), true, ""
+	case th.Metric == api.MetricMinLatency:
+		if numOK == 0 {
+			return 0, false, "no successful requests"
+		}
+		return float64(stats.MinRqstDurationNanos), true, ""
+	case th.Metric == api.MetricMaxLatency:
+		if numOK == 0 {
+			return 0, false, "no successful requests"
+		}
+		return float64(stats.MaxRqstDurationNanos), true, ""
+	case th.Metric == api.MetricAvgLatency:
+		if numOK == 0 {
+			return 0, false, "no successful requests"
+		}
+		return float64(stats.TotalRequestDurationNanos) / float64(numOK), true, ""
+	case th.Metric == api.MetricErrorRate:
+		if stats.TotalRqsts == 0 {
+			return 0, false, "no requests were made"
+		}
+		return float64(stats.TotalErrors) * 100 / float64(stats.TotalRqsts), true, ""
+	case th.Metric == api.MetricAssertionFailureRate:
+		if asserted == 0 {
+			return 0, false, "no responses were checked against assertions"
+		}
+		return float64(assertFailed) * 100 / float64(asserted), true, ""
+	case th.Metric == api.MetricRqstRate:
+		if rr.RunSummary.RunDurationNanos <= 0 {
+			return 0, false, "the run duration is unknown"
+		}
+		return float64(stats.TotalRqsts) / rr.RunSummary.RunDurationNanos.Seconds(), true, ""
+	}
+	return 0, false, fmt.Sprintf("metric %s is invalid", th.Metric)
+}
+
+// format returns a human readable form of 'v', a value of the threshold's metric
+func (th threshold) format(v float64) string {
+	switch th.kind {
+	case latencyMetric:
+		return time.Duration(v).Round(time.Microsecond).String()
+	case percentMetric:
+		return fmt.Sprintf("%.2f%%", v)
+	}
+	return fmt.Sprintf("%.2f", v)
+}
+
+// scopedStats returns the combined stats of the requests to 'url' using 'method'. An empty
+// 'method' combines all methods and an empty 'url' combines all requests. It also returns
+// the number of responses checked against assertions and how many of them failed. 'found'
+// is false if no requests were made to 'url' using 'method'.
+func scopedStats(rr *api.RunResults, url, method string) (stats api.RqstStats, asserted, assertFailed int64, found bool) {
+	for epURL, epDetail := range rr.EndpointDetails {
+		if url != "" && epURL != url {
+			continue
+		}
+		for m, ar := range epDetail.HTTPMethodAssertionResults {
+			if method == "" || m == method {
+				asserted += ar.Passed + ar.Failed
+				assertFailed += ar.Failed
+			}
+		}
+	}
+
+	if url == "" {
+		return rr.RunSummary.RqstStats, asserted, assertFailed, rr.RunSummary.RqstStats.TotalRqsts > 0
+	}
+
+	epDetail, ok := rr.EndpointDetails[url]
+	if !ok {
+		return api.RqstStats{}, 0, 0, false
+	}
+	for m, ms := range epDetail.HTTPMethodRqstStats {
+		if method != "" && m != method {
+			continue
+		}
+		if !found {
+			found = true
+			stats = api.RqstStats{
+				TimingResultsNanos:   api.NewHistogram(ms.TimingResultsNanos.SigDigits(), ms.TimingResultsNanos.MaxTrackable()),
+				MinRqstDurationNanos: ms.MinRqstDurationNanos,
+				MaxRqstDurationNanos: ms.MaxRqstDurationNanos,
+			}
+		}
+		stats.TotalRqsts += ms.TotalRqsts
+		stats.TotalErrors += ms.TotalErrors
+		stats.TotalRequestDurationNanos += ms.TotalRequestDurationNanos
+		stats.TimingResultsNanos.Merge(ms.TimingResultsNanos)
+		if ms.MinRqstDurationNanos < stats.MinRqstDurationNanos {
+			stats.MinRqstDurationNanos = ms.MinRqstDurationNanos
+		}
+		if ms.MaxRqstDurationNanos > stats.MaxRqstDurationNanos {
+			stats.MaxRqstDurationNanos = ms.MaxRqstDurationNanos
+		}
+	}
+	return stats, asserted, assertFailed, found
+}
+
+// describeThreshold returns a human readable description of 't', e.g., "P95 <= 200ms"
+func describeThreshold(t api.Threshold) string {
+	var sb strings.Builder
+	if t.URL != "" {
+		sb.WriteString(t.URL)
+		sb.WriteString(" ")
+	}
+	if t.Method != "" {
+		sb.WriteString(t.Method)
+		sb.WriteString(" ")
+	}
+	sb.WriteString(t.Metric)
+	if t.Max != "" {
+		sb.WriteString(" <= ")
+		sb.WriteString(t.Max)
+	}
+	if t.Min != "" {
+		if t.Max != "" {
+			sb.WriteString(" and")
+		}
+		sb.WriteString(" >= ")
+		sb.WriteString(t.Min)
+	}
+	return sb.String()
+}
+
+// thresholdsPassed returns false if any of 'results' failed
+func thresholdsPassed(results []api.ThresholdResult) bool {
+	for _, r := range results {
+		if !r.Passed {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+func TestEvaluateThresholds(t *testing.T) {
+	url1 := "http://someurl/users"
+	url2 := "http://someurl/accounts"
+	rh := ResponseHandler{OutputType: JSON}
+	runResults := rh.newRunResults()
+	epRunSummary := make(map[string]*api.EndpointDetail)
+	totalRunTime := time.Duration(0)
+
+	// 100 url1 GETs from 1ms to 100ms, 10 url1 PUTs at 500ms, 1 failed url2 GET, 1 url2 GET that failed
+	// its assertions, and 8 successful url2 GETs
+	for i := 1; i <= 100; i++ {
+		rh.accumulateResponseStats(Response{HTTPStatus: http.StatusOK, Endpoint: api.Endpoint{URL: url1, Method: http.MethodGet},
+			RequestDuration: time.Duration(i) * time.Millisecond}, &totalRunTime, &runResults, epRunSummary)
+	}
+	for i := 0; i < 10; i++ {
+		rh.accumulateResponseStats(Response{HTTPStatus: http.StatusOK, Endpoint: api.Endpoint{URL: url1, Method: http.MethodPut},
+			RequestDuration: 500 * time.Millisecond, Asserted: true}, &totalRunTime, &runResults, epRunSummary)
+	}
+	rh.accumulateResponseStats(Response{ErrorType: api.ErrConnRefused, Endpoint: api.Endpoint{URL: url2, Method: http.MethodGet}},
+		&totalRunTime, &runResults, epRunSummary)
+	for i := 0; i < 9; i++ {
+		failures := []string{}
+		if i == 0 {
+			failures = append(failures, api.AssertStatus)
+		}
+		rh.accumulateResponseStats(Response{HTTPStatus: http.StatusOK, Endpoint: api.Endpoint{URL: url2, Method: http.MethodGet},
+			RequestDuration: 10 * time.Millisecond, Asserted: true, AssertionFailures: failures}, &totalRunTime, &runResults, epRunSummary)
+	}
+	err := rh.finalizeResponseStats(time.Now().Add(-10*time.Second), &totalRunTime, &runResults, epRunSummary)
+	if err != nil {
+		t.Fatalf("unexpected error finalizing response stats: %s", err)
+	}
+
+	tests := []struct {
+		name      string
+		threshold api.Threshold
+		passed    bool
+		actual    string
+	}{
+		{name: "GlobalP95Pass", threshold: api.Threshold{Metric: "P95", Max: "600ms"}, passed: true},
+		{name: "GlobalP95Fail", threshold: api.Threshold{Metric: "P95", Max: "200ms"}, passed: false},
+		{name: "MethodP99Pass", threshold: api.Threshold{Metric: "P99", URL: url1, Method: http.MethodGet, Max: "500ms"},
+			passed: true},
+		{name: "URLMaxAllMethods", threshold: api.Threshold{Metric: api.MetricMaxLatency, URL: url1, Max: "100ms"},
+			passed: false, actual: "500ms"},
+		{name: "URLMinAllMethods", threshold: api.Threshold{Metric: api.MetricMinLatency, URL: url1, Min: "1ms"},
+			passed: true, actual: "1ms"},
+		{name: "AvgLatency", threshold: api.Threshold{Metric: api.MetricAvgLatency, URL: url2, Max: "10ms"},
+			passed: true, actual: "10ms"},
+		{name: "ErrorRateFail", threshold: api.Threshold{Metric: api.MetricErrorRate, Max: "0.5%"}, passed: false, actual: "1.67%"},
+		{name: "URLErrorRate", threshold: api.Threshold{Metric: api.MetricErrorRate, URL: url2, Max: "10"}, passed: false, actual: "20.00%"},
+		{name: "RqstRate", threshold: api.Threshold{Metric: api.MetricRqstRate, Min: "10", Max: "13"}, passed: true, actual: "12.00"},
+		{name: "AssertionFailureRate", threshold: api.Threshold{Metric: api.MetricAssertionFailureRate, Max: "6%"},
+			passed: true, actual: "5.26%"},
+		{name: "UnknownURL", threshold: api.Threshold{Metric: "P50", URL: "http://someurl/nowhere", Max: "1s"},
+			passed: false, actual: "no requests were made"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := ValidateThresholds([]api.Threshold{tc.threshold}); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			results := evaluateThresholds([]api.Threshold{tc.threshold}, &runResults)
+			if len(results) != 1 {
+				t.Fatalf("expected 1 result, got %d", len(results))
+			}
+			if results[0].Passed != tc.passed {
+				t.Errorf("expected passed to be %t, got %t, actual %s", tc.passed, results[0].Passed, results[0].Actual)
+			}
+			if tc.actual != "" && results[0].Actual != tc.actual {
+				t.Errorf("expected actual %s, got %s", tc.actual, results[0].Actual)
+			}
+		})
+	}
+}
+
+func TestValidateThresholds(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold api.Threshold
+	}{
+		{name: "UnknownMetric", threshold: api.Threshold{Metric: "Median", Max: "1s"}},
+		{name: "InvalidPercentile", threshold: api.Threshold{Metric: "P101", Max: "1s"}},
+		{name: "NoLimits", threshold: api.Threshold{Metric: "P95"}},
+		{name: "LatencyNotDuration", threshold: api.Threshold{Metric: "P95", Max: "200"}},
+		{name: "RateNotNumber", threshold: api.Threshold{Metric: api.MetricRqstRate, Min: "fast"}},
+		{name: "MethodWithoutURL", threshold: api.Threshold{Metric: "P95", Method: http.MethodGet, Max: "1s"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := ValidateThresholds([]api.Threshold{tc.threshold}); err == nil {
+				t.Errorf("expected error, got none")
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+// timeSeries buckets responses into fixed length intervals by when they're received.
+// Responses arrive in time order so only the current interval's stats are kept in
+// full. Once an interval is complete it's summarized and its histograms are reused
+// for the next interval, keeping memory use independent of the run's duration.
+type timeSeries struct {
+	interval time.Duration
+	buckets  []*api.TimeSeriesBucket
+	// current is the index of the interval being accumulated
+	current int
+	stats   *api.RqstStats
+	// epStats is keyed by URL and then HTTP method
+	epStats      map[string]map[string]*api.RqstStats
+	newRqstStats func() *api.RqstStats
+}
+
+// newTimeSeries returns a timeSeries with intervals of length 'interval'. 'newRqstStats'
+// creates the stats used to accumulate each interval's responses.
+func newTimeSeries(interval time.Duration, newRqstStats func() *api.RqstStats) *timeSeries {
+	return &timeSeries{
+		interval:     interval,
+		stats:        newRqstStats(),
+		epStats:      make(map[string]map[string]*api.RqstStats),
+		newRqstStats: newRqstStats,
+	}
+}
+
+// add accumulates 'resp', which was received 'elapsed' after the start of the run
+func (ts *timeSeries) add(resp Response, elapsed time.Duration) {
+	if resp.DroppedRqsts > 0 {
+		// Dropped requests aren't sent, so they have no effect on the request rate or latency
+		return
+	}
+
+	ts.advance(int(elapsed / ts.interval))
+
+	methodStats, ok := ts.epStats[resp.Endpoint.URL]
+	if !ok {
+		methodStats = make(map[string]*api.RqstStats)
+		ts.epStats[resp.Endpoint.URL] = methodStats
+	}
+	rs, ok := methodStats[resp.Endpoint.Method]
+	if !ok {
+		rs = ts.newRqstStats()
+		methodStats[resp.Endpoint.Method] = rs
+	}
+
+	accumulateRqstStats(ts.stats, resp)
+	accumulateRqstStats(rs, resp)
+}
+
+// advance completes intervals until 'interval' is the current one. Intervals without
+// any responses are included so that gaps in the run are visible.
+func (ts *timeSeries) advance(interval int) {
+	for ts.current < interval {
+		ts.complete(ts.interval)
+		ts.current++
+	}
+}
+
+// finish completes the remaining intervals of a run that lasted 'runDur' and returns
+// all of the run's intervals. A final partial interval is omitted if it has no responses,
+// it's usually just the time taken to wait for the run to shut down.
+func (ts *timeSeries) finish(runDur time.Duration) []*api.TimeSeriesBucket {
+	last := int(runDur / ts.interval)
+	if runDur%ts.interval == 0 && last > 0 {
+		last--
+	}
+	ts.advance(last)
+	remaining := runDur - time.Duration(ts.current)*ts.interval
+	if ts.current > 0 && remaining < ts.interval && ts.stats.TotalRqsts == 0 {
+		return ts.buckets
+	}
+	ts.complete(remaining)
+	return ts.buckets
+}
+
+// complete summarizes the current interval, which lasted 'dur', and resets its stats
+func (ts *timeSeries) complete(dur time.Duration) {
+	bucket := &api.TimeSeriesBucket{
+		StartNanos:    time.Duration(ts.current) * ts.interval,
+		DurationNanos: dur,
+		Stats:         summarizeInterval(ts.stats, dur),
+	}
+	resetRqstStats(ts.stats)
+
+	for url, methodStats := range ts.epStats {
+		for method, rs := range methodStats {
+			if rs.TotalRqsts == 0 {
+				continue
+			}
+			if bucket.EndpointStats == nil {
+				bucket.EndpointStats = make(map[string]map[string]*api.IntervalStats)
+			}
+			if _, ok := bucket.EndpointStats[url]; !ok {
+				bucket.EndpointStats[url] = make(map[string]*api.IntervalStats)
+			}
+			is := summarizeInterval(rs, dur)
+			bucket.EndpointStats[url][method] = &is
+			resetRqstStats(rs)
+		}
+	}
+
+	ts.buckets = append(ts.buckets, bucket)
+}
+
+// summarizeInterval summarizes 'rs', the stats of an interval that lasted 'dur'
+func summarizeInterval(rs *api.RqstStats, dur time.Duration) api.IntervalStats {
+	is := api.IntervalStats{
+		TotalRqsts:              rs.TotalRqsts,
+		TotalErrors:             rs.TotalErrors,
+		MinRqstDurationNanos:    rs.TimingResultsNanos.Min(),
+		MedianRqstDurationNanos: rs.TimingResultsNanos.Percentile(50),
+		P90RqstDurationNanos:    rs.TimingResultsNanos.Percentile(90),
+		P99RqstDurationNanos:    rs.TimingResultsNanos.Percentile(99),
+		MaxRqstDurationNanos:    rs.TimingResultsNanos.Max(),
+	}
+	if dur > 0 {
+		is.RqstRatePerSec = float64(rs.TotalRqsts) / dur.Seconds()
+	}
+	return is
+}
+
+// resetRqstStats clears the stats accumulated by accumulateRqstStats()
+func resetRqstStats(rs *api.RqstStats) {
+	rs.TotalRqsts = 0
+	rs.TotalErrors = 0
+	rs.TotalRequestDurationNanos = 0
+	rs.TimingResultsNanos.Reset()
+}
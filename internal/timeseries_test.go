@@ -0,0 +1,157 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/youngkin/heyyall/api"
+)
+
+func TestTimeSeries(t *testing.T) {
+	url1 := "http://someurl/users"
+	url2 := "http://someurl/accounts"
+
+	type rqst struct {
+		elapsed time.Duration
+		url     string
+		failed  bool
+		latency time.Duration
+	}
+	type bucket struct {
+		start, dur    time.Duration
+		rqsts, errors int64
+		rate          float64
+		p99           time.Duration
+		url1Rqsts     int64
+		url2Rqsts     int64
+		numEPs        int
+	}
+
+	tests := []struct {
+		name     string
+		interval time.Duration
+		runDur   time.Duration
+		rqsts    []rqst
+		expected []bucket
+	}{
+		{
+			name:     "OneInterval",
+			interval: time.Second,
+			runDur:   500 * time.Millisecond,
+			rqsts: []rqst{
+				{elapsed: 100 * time.Millisecond, url: url1, latency: 10 * time.Millisecond},
+				{elapsed: 200 * time.Millisecond, url: url2, latency: 20 * time.Millisecond},
+			},
+			expected: []bucket{
+				{start: 0, dur: 500 * time.Millisecond, rqsts: 2, rate: 4, p99: 20 * time.Millisecond,
+					url1Rqsts: 1, url2Rqsts: 1, numEPs: 2},
+			},
+		},
+		{
+			name:     "Degrading",
+			interval: time.Second,
+			runDur:   3 * time.Second,
+			rqsts: []rqst{
+				{elapsed: 100 * time.Millisecond, url: url1, latency: 10 * time.Millisecond},
+				{elapsed: 900 * time.Millisecond, url: url1, latency: 10 * time.Millisecond},
+				{elapsed: 1100 * time.Millisecond, url: url1, latency: 100 * time.Millisecond},
+				{elapsed: 2500 * time.Millisecond, url: url2, failed: true},
+				{elapsed: 2600 * time.Millisecond, url: url1, latency: 500 * time.Millisecond},
+			},
+			expected: []bucket{
+				{start: 0, dur: time.Second, rqsts: 2, rate: 2, p99: 10 * time.Millisecond, url1Rqsts: 2, numEPs: 1},
+				{start: time.Second, dur: time.Second, rqsts: 1, rate: 1, p99: 100 * time.Millisecond, url1Rqsts: 1, numEPs: 1},
+				{start: 2 * time.Second, dur: time.Second, rqsts: 2, errors: 1, rate: 2, p99: 500 * time.Millisecond,
+					url1Rqsts: 1, url2Rqsts: 1, numEPs: 2},
+			},
+		},
+		{
+			name:     "GapAndPartialLastInterval",
+			interval: 10 * time.Second,
+			runDur:   35 * time.Second,
+			rqsts: []rqst{
+				{elapsed: time.Second, url: url1, latency: 10 * time.Millisecond},
+				{elapsed: 31 * time.Second, url: url2, latency: 10 * time.Millisecond},
+			},
+			expected: []bucket{
+				{start: 0, dur: 10 * time.Second, rqsts: 1, rate: 0.1, p99: 10 * time.Millisecond, url1Rqsts: 1, numEPs: 1},
+				{start: 10 * time.Second, dur: 10 * time.Second},
+				{start: 20 * time.Second, dur: 10 * time.Second},
+				{start: 30 * time.Second, dur: 5 * time.Second, rqsts: 1, rate: 0.2, p99: 10 * time.Millisecond,
+					url2Rqsts: 1, numEPs: 1},
+			},
+		},
+		{
+			name:     "EmptyPartialLastIntervalOmitted",
+			interval: time.Second,
+			runDur:   2*time.Second + time.Millisecond,
+			rqsts: []rqst{
+				{elapsed: 1500 * time.Millisecond, url: url1, latency: 10 * time.Millisecond},
+			},
+			expected: []bucket{
+				{start: 0, dur: time.Second},
+				{start: time.Second, dur: time.Second, rqsts: 1, rate: 1, p99: 10 * time.Millisecond, url1Rqsts: 1, numEPs: 1},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rh := ResponseHandler{}
+			ts := newTimeSeries(tc.interval, rh.newRqstStats)
+			for _, r := range tc.rqsts {
+				resp := Response{HTTPStatus: http.StatusOK, Endpoint: api.Endpoint{URL: r.url, Method: http.MethodGet},
+					RequestDuration: r.latency}
+				if r.failed {
+					resp = Response{ErrorType: api.ErrConnRefused, Endpoint: api.Endpoint{URL: r.url, Method: http.MethodGet}}
+				}
+				ts.add(resp, r.elapsed)
+			}
+			// Dropped requests aren't attributed to an interval
+			ts.add(Response{DroppedRqsts: 10, Endpoint: api.Endpoint{URL: url1, Method: http.MethodGet}}, tc.runDur)
+
+			buckets := ts.finish(tc.runDur)
+			if len(buckets) != len(tc.expected) {
+				t.Fatalf("expected %d buckets, got %d", len(tc.expected), len(buckets))
+			}
+			for i, expected := range tc.expected {
+				b := buckets[i]
+				if b.StartNanos != expected.start || b.DurationNanos != expected.dur {
+					t.Errorf("bucket %d: expected start %s and duration %s, got %s and %s", i, expected.start,
+						expected.dur, b.StartNanos, b.DurationNanos)
+				}
+				if b.Stats.TotalRqsts != expected.rqsts || b.Stats.TotalErrors != expected.errors {
+					t.Errorf("bucket %d: expected %d requests and %d errors, got %d and %d", i, expected.rqsts,
+						expected.errors, b.Stats.TotalRqsts, b.Stats.TotalErrors)
+				}
+				if b.Stats.RqstRatePerSec != expected.rate {
+					t.Errorf("bucket %d: expected rate %f, got %f", i, expected.rate, b.Stats.RqstRatePerSec)
+				}
+				if expected.p99 > 0 && b.Stats.P99RqstDurationNanos.Round(time.Millisecond) != expected.p99 {
+					t.Errorf("bucket %d: expected P99 %s, got %s", i, expected.p99, b.Stats.P99RqstDurationNanos)
+				}
+				if len(b.EndpointStats) != expected.numEPs {
+					t.Errorf("bucket %d: expected %d endpoints, got %d", i, expected.numEPs, len(b.EndpointStats))
+				}
+				if got := epRqsts(b, url1); got != expected.url1Rqsts {
+					t.Errorf("bucket %d: expected %d %s requests, got %d", i, expected.url1Rqsts, url1, got)
+				}
+				if got := epRqsts(b, url2); got != expected.url2Rqsts {
+					t.Errorf("bucket %d: expected %d %s requests, got %d", i, expected.url2Rqsts, url2, got)
+				}
+			}
+		})
+	}
+}
+
+func epRqsts(b *api.TimeSeriesBucket, url string) int64 {
+	if is, ok := b.EndpointStats[url][http.MethodGet]; ok {
+		return is.TotalRqsts
+	}
+	return 0
+}
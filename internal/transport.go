@@ -0,0 +1,321 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/rs/zerolog/log"
+	"github.com/youngkin/heyyall/api"
+	"golang.org/x/net/http2"
+)
+
+// http3Proto is the Response.Protocol of responses received via HTTP/3
+const http3Proto = "HTTP/3.0"
+
+// errProtocolMismatch indicates a response wasn't received via the required protocol
+var errProtocolMismatch = errors.New("response protocol mismatch")
+
+// transportSettings are the parsed form of an api.Transport
+type transportSettings struct {
+	dialTimeout           time.Duration
+	tlsHandshakeTimeout   time.Duration
+	responseHeaderTimeout time.Duration
+	rqstTimeout           time.Duration
+	idleConnTimeout       time.Duration
+	maxConnsPerHost       int
+	keepAlive             bool
+	compression           bool
+	noDelay               bool
+	protocol              string
+}
+
+// newTransportSettings validates and parses 'cfg'
+func newTransportSettings(cfg api.Transport) (transportSettings, error) {
+	ts := transportSettings{
+		maxConnsPerHost: cfg.MaxConnsPerHost,
+		keepAlive:       cfg.KeepAlive == nil || *cfg.KeepAlive,
+		compression:     cfg.Compression == nil || *cfg.Compression,
+		noDelay:         cfg.NoDelay == nil || *cfg.NoDelay,
+		protocol:        cfg.Protocol,
+	}
+	switch ts.protocol {
+	case "":
+		ts.protocol = api.ProtocolAuto
+	case api.ProtocolAuto, api.ProtocolHTTP1, api.ProtocolH2, api.ProtocolH2C, api.ProtocolH3:
+	default:
+		return transportSettings{}, fmt.Errorf("Transport.Protocol %s is invalid, it must be one of %s, %s, %s, %s, or %s",
+			cfg.Protocol, api.ProtocolAuto, api.ProtocolHTTP1, api.ProtocolH2, api.ProtocolH2C, api.ProtocolH3)
+	}
+	if cfg.MaxConnsPerHost < 0 {
+		return transportSettings{}, fmt.Errorf("Transport.MaxConnsPerHost is %d, it can't be negative", cfg.MaxConnsPerHost)
+	}
+
+	timeouts := []struct {
+		name  string
+		value string
+		dur   *time.Duration
+	}{
+		{name: "DialTimeout", value: cfg.DialTimeout, dur: &ts.dialTimeout},
+		{name: "TLSHandshakeTimeout", value: cfg.TLSHandshakeTimeout, dur: &ts.tlsHandshakeTimeout},
+		{name: "ResponseHeaderTimeout", value: cfg.ResponseHeaderTimeout, dur: &ts.responseHeaderTimeout},
+		{name: "RqstTimeout", value: cfg.RqstTimeout, dur: &ts.rqstTimeout},
+		{name: "IdleConnTimeout", value: cfg.IdleConnTimeout, dur: &ts.idleConnTimeout},
+	}
+	for _, t := range timeouts {
+		if t.value == "" {
+			continue
+		}
+		dur, err := time.ParseDuration(t.value)
+		if err != nil || dur <= 0 {
+			return transportSettings{}, fmt.Errorf("Transport.%s: %s, must be a positive duration, e.g., 5s or 500ms",
+				t.name, t.value)
+		}
+		*t.dur = dur
+	}
+	return ts, nil
+}
+
+// mergeTransport returns 'base' with the settings specified by 'override' replacing its own
+func mergeTransport(base, override api.Transport) api.Transport {
+	merged := base
+	if override.DialTimeout != "" {
+		merged.DialTimeout = override.DialTimeout
+	}
+	if override.TLSHandshakeTimeout != "" {
+		merged.TLSHandshakeTimeout = override.TLSHandshakeTimeout
+	}
+	if override.ResponseHeaderTimeout != "" {
+		merged.ResponseHeaderTimeout = override.ResponseHeaderTimeout
+	}
+	if override.RqstTimeout != "" {
+		merged.RqstTimeout = override.RqstTimeout
+	}
+	if override.IdleConnTimeout != "" {
+		merged.IdleConnTimeout = override.IdleConnTimeout
+	}
+	if override.MaxConnsPerHost != 0 {
+		merged.MaxConnsPerHost = override.MaxConnsPerHost
+	}
+	if override.KeepAlive != nil {
+		merged.KeepAlive = override.KeepAlive
+	}
+	if override.Compression != nil {
+		merged.Compression = override.Compression
+	}
+	if override.NoDelay != nil {
+		merged.NoDelay = override.NoDelay
+	}
+	if override.Protocol != "" {
+		merged.Protocol = override.Protocol
+	}
+	return merged
+}
+
+// clientTransport is the http.RoundTripper of the clients returned by NewClient. It keeps
+// the settings needed to create clients for endpoints that override the Transport settings.
+type clientTransport struct {
+	http.RoundTripper
+	tlsConfig           *tls.Config
+	maxIdleConnsPerHost int
+	// requireH2 fails responses that weren't received via HTTP/2
+	requireH2 bool
+}
+
+// RoundTrip sends 'req' and returns its response
+func (t *clientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err != nil || !t.requireH2 || resp.ProtoMajor == 2 {
+		return resp, err
+	}
+	resp.Body.Close()
+	return nil, fmt.Errorf("%w: the server used %s rather than HTTP/2", errProtocolMismatch, resp.Proto)
+}
+
+// NewClient returns a client configured by 'cfg' that uses 'tlsConfig' for HTTPS
+// connections. Up to 'maxIdleConnsPerHost' idle connections to each host are kept for
+// reuse. 'defaultTimeout' limits the duration of each request unless cfg.RqstTimeout
+// is specified.
+func NewClient(cfg api.Transport, tlsConfig *tls.Config, maxIdleConnsPerHost int,
+	defaultTimeout time.Duration) (http.Client, error) {
+	ts, err := newTransportSettings(cfg)
+	if err != nil {
+		return http.Client{}, err
+	}
+
+	dialer := &net.Dialer{Timeout: ts.dialTimeout}
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		// Go enables TCP_NODELAY by default
+		if tcpConn, ok := conn.(*net.TCPConn); ok && !ts.noDelay {
+			if err = tcpConn.SetNoDelay(false); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+		return conn, nil
+	}
+
+	ct := &clientTransport{
+		tlsConfig:           tlsConfig,
+		maxIdleConnsPerHost: maxIdleConnsPerHost,
+		requireH2:           ts.protocol == api.ProtocolH2,
+	}
+	switch ts.protocol {
+	case api.ProtocolH3:
+		udpConn, err := net.ListenUDP("udp", nil)
+		if err != nil {
+			return http.Client{}, fmt.Errorf("unable to create a UDP socket for HTTP/3: %w", err)
+		}
+		quicTransport := &quic.Transport{Conn: udpConn}
+		ct.RoundTripper = h3RoundTripper{
+			RoundTripper: &http3.RoundTripper{
+				TLSClientConfig:    tlsConfig,
+				DisableCompression: !ts.compression,
+				QuicConfig: &quic.Config{
+					HandshakeIdleTimeout: ts.dialTimeout,
+					MaxIdleTimeout:       ts.idleConnTimeout,
+				},
+				Dial: dialQUIC(quicTransport),
+			},
+			quicTransport: quicTransport,
+			udpConn:       udpConn,
+		}
+	case api.ProtocolH2C:
+		// Connections are "TLS" connections as far as the HTTP/2 transport is concerned,
+		// without TLS the transport would refuse to use them
+		ct.RoundTripper = &http2.Transport{
+			AllowHTTP:          true,
+			DisableCompression: !ts.compression,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dial(ctx, network, addr)
+			},
+		}
+	default:
+		t := &http.Transport{
+			DialContext:           dial,
+			TLSHandshakeTimeout:   ts.tlsHandshakeTimeout,
+			ResponseHeaderTimeout: ts.responseHeaderTimeout,
+			IdleConnTimeout:       ts.idleConnTimeout,
+			MaxIdleConnsPerHost:   maxIdleConnsPerHost,
+			MaxConnsPerHost:       ts.maxConnsPerHost,
+			DisableCompression:    !ts.compression,
+			DisableKeepAlives:     !ts.keepAlive,
+			TLSClientConfig:       tlsConfig,
+			// A custom dialer or TLS configuration stops HTTP/2 from being used unless
+			// it's asked for
+			ForceAttemptHTTP2: ts.protocol != api.ProtocolHTTP1,
+		}
+		if ts.protocol == api.ProtocolHTTP1 {
+			// A non-nil, empty, TLSNextProto disables HTTP/2
+			t.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+		}
+		ct.RoundTripper = t
+	}
+
+	timeout := defaultTimeout
+	if ts.rqstTimeout > 0 {
+		timeout = ts.rqstTimeout
+	}
+	return http.Client{Transport: ct, Timeout: timeout}, nil
+}
+
+// CloseClient closes the idle connections of 'client', a client returned by NewClient.
+// HTTP/3 clients also close their QUIC connections and UDP socket. It's called once the
+// client is no longer needed.
+func CloseClient(client http.Client) {
+	if t, ok := client.Transport.(*clientTransport); ok {
+		if rt, ok := t.RoundTripper.(h3RoundTripper); ok {
+			rt.close()
+			return
+		}
+	}
+	client.CloseIdleConnections()
+}
+
+// h3RoundTripper sends requests via HTTP/3. HTTP/3 connections aren't reported to
+// httptrace, so their network phases are recorded in the requests' rqstTrace directly.
+type h3RoundTripper struct {
+	*http3.RoundTripper
+	// quicTransport opens the QUIC connections using udpConn, neither are closed by
+	// http3.RoundTripper since they aren't created by it
+	quicTransport *quic.Transport
+	udpConn       *net.UDPConn
+}
+
+// close closes the QUIC connections, the QUIC transport, and the UDP socket
+func (rt h3RoundTripper) close() {
+	if err := rt.RoundTripper.Close(); err != nil {
+		log.Debug().Err(err).Msg("error closing HTTP/3 connections")
+	}
+	if err := rt.quicTransport.Close(); err != nil {
+		log.Debug().Err(err).Msg("error closing the QUIC transport")
+	}
+	if err := rt.udpConn.Close(); err != nil {
+		log.Debug().Err(err).Msg("error closing the HTTP/3 UDP socket")
+	}
+}
+
+// RoundTrip sends 'req' and returns its response
+func (rt h3RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace, ok := req.Context().Value(rqstTraceKey{}).(*rqstTrace)
+	if !ok {
+		return rt.RoundTripper.RoundTrip(req)
+	}
+	// dialQUIC overrides these if a new connection is opened
+	now := time.Now()
+	trace.connStart, trace.connDone = now, now
+	resp, err := rt.RoundTripper.RoundTrip(req)
+	trace.gotResp = time.Now()
+	return resp, err
+}
+
+// dialQUIC returns a function that opens QUIC connections using 'transport' and records
+// the time taken to resolve the address and complete the QUIC handshake in the rqstTrace
+// of the request that opened the connection
+func dialQUIC(transport *quic.Transport) func(context.Context, string, *tls.Config, *quic.Config) (quic.EarlyConnection, error) {
+	return func(ctx context.Context, addr string, tlsConfig *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
+		trace, ok := ctx.Value(rqstTraceKey{}).(*rqstTrace)
+		if !ok {
+			trace = &rqstTrace{}
+		}
+
+		trace.dnsStart = time.Now()
+		udpAddr, err := net.ResolveUDPAddr("udp", addr)
+		trace.dnsDone = time.Now()
+		if err != nil {
+			return nil, err
+		}
+
+		trace.quicStart = time.Now()
+		conn, err := transport.DialEarly(ctx, udpAddr, tlsConfig, cfg)
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case <-conn.HandshakeComplete():
+		case <-conn.Context().Done():
+			return nil, context.Cause(conn.Context())
+		case <-ctx.Done():
+			conn.CloseWithError(0, "")
+			return nil, ctx.Err()
+		}
+		trace.quicDone = time.Now()
+		trace.connStart, trace.connDone = trace.quicDone, trace.quicDone
+		trace.newConn = true
+		return conn, nil
+	}
+}
@@ -0,0 +1,364 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+	"github.com/youngkin/heyyall/api"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func TestNewTransportSettings(t *testing.T) {
+	off := false
+	tests := []struct {
+		name      string
+		cfg       api.Transport
+		expected  transportSettings
+		expectErr bool
+	}{
+		{
+			name:     "Defaults",
+			expected: transportSettings{keepAlive: true, compression: true, noDelay: true, protocol: api.ProtocolAuto},
+		},
+		{
+			name: "AllSettings",
+			cfg: api.Transport{
+				DialTimeout:           "1s",
+				TLSHandshakeTimeout:   "2s",
+				ResponseHeaderTimeout: "3s",
+				RqstTimeout:           "4s",
+				IdleConnTimeout:       "5m",
+				MaxConnsPerHost:       10,
+				KeepAlive:             &off,
+				Compression:           &off,
+				NoDelay:               &off,
+				Protocol:              api.ProtocolH2C,
+			},
+			expected: transportSettings{
+				dialTimeout:           time.Second,
+				tlsHandshakeTimeout:   2 * time.Second,
+				responseHeaderTimeout: 3 * time.Second,
+				rqstTimeout:           4 * time.Second,
+				idleConnTimeout:       5 * time.Minute,
+				maxConnsPerHost:       10,
+				protocol:              api.ProtocolH2C,
+			},
+		},
+		{name: "InvalidTimeout", cfg: api.Transport{DialTimeout: "5"}, expectErr: true},
+		{name: "ZeroTimeout", cfg: api.Transport{RqstTimeout: "0s"}, expectErr: true},
+		{name: "NegativeTimeout", cfg: api.Transport{IdleConnTimeout: "-1s"}, expectErr: true},
+		{name: "NegativeMaxConns", cfg: api.Transport{MaxConnsPerHost: -1}, expectErr: true},
+		{name: "InvalidProtocol", cfg: api.Transport{Protocol: "http3"}, expectErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ts, err := newTransportSettings(tc.cfg)
+			if tc.expectErr {
+				if err == nil {
+					t.Errorf("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if ts != tc.expected {
+				t.Errorf("expected %+v, got %+v", tc.expected, ts)
+			}
+		})
+	}
+}
+
+func TestMergeTransport(t *testing.T) {
+	on, off := true, false
+	base := api.Transport{DialTimeout: "1s", RqstTimeout: "10s", MaxConnsPerHost: 5, KeepAlive: &off, Compression: &off}
+	override := api.Transport{RqstTimeout: "2s", IdleConnTimeout: "1m", KeepAlive: &on, Protocol: api.ProtocolH2}
+
+	merged := mergeTransport(base, override)
+	if merged.DialTimeout != "1s" || merged.MaxConnsPerHost != 5 || merged.Compression != &off {
+		t.Errorf("expected unspecified settings to be inherited, got %+v", merged)
+	}
+	if merged.RqstTimeout != "2s" || merged.IdleConnTimeout != "1m" || merged.KeepAlive != &on ||
+		merged.Protocol != api.ProtocolH2 {
+		t.Errorf("expected specified settings to be overridden, got %+v", merged)
+	}
+	if base.RqstTimeout != "10s" || base.KeepAlive != &off {
+		t.Errorf("expected the base settings to be unchanged, got %+v", base)
+	}
+}
+
+// TestEndpointTransport verifies that an endpoint's Transport settings override the
+// Requestor's while other endpoints continue to use the Requestor's
+func TestEndpointTransport(t *testing.T) {
+	type rqstInfo struct {
+		close          bool
+		acceptEncoding string
+	}
+	var mux sync.Mutex
+	rqsts := make(map[string][]rqstInfo)
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		rqsts[r.URL.Path] = append(rqsts[r.URL.Path], rqstInfo{close: r.Close, acceptEncoding: r.Header.Get("Accept-Encoding")})
+		mux.Unlock()
+		if r.URL.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+	}))
+	defer testSrv.Close()
+
+	off := false
+	client, err := NewClient(api.Transport{}, nil, 10, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	respC := make(chan Response, 10)
+	rqstr := Requestor{
+		Ctx:       context.Background(),
+		ResponseC: respC,
+		Client:    client,
+	}
+
+	eps := []api.Endpoint{
+		{URL: testSrv.URL + "/default", Method: http.MethodGet},
+		{URL: testSrv.URL + "/override", Method: http.MethodGet, Transport: &api.Transport{KeepAlive: &off, Compression: &off}},
+		{URL: testSrv.URL + "/slow", Method: http.MethodGet, Transport: &api.Transport{RqstTimeout: "50ms"}},
+	}
+	for _, ep := range eps {
+		rqstr.ProcessRqst(ep, newRatePacer(2, 0, constantArrival{}))
+	}
+	close(respC)
+
+	for resp := range respC {
+		timedOut := resp.ErrorType == api.ErrTimeout
+		if expected := resp.Endpoint.URL == eps[2].URL; timedOut != expected {
+			t.Errorf("%s: expected timed out to be %t, got %t", resp.Endpoint.URL, expected, timedOut)
+		}
+	}
+
+	mux.Lock()
+	defer mux.Unlock()
+	for _, ri := range rqsts["/default"] {
+		if ri.close || ri.acceptEncoding != "gzip" {
+			t.Errorf("expected the default settings to keep connections alive and request compression, got %+v", ri)
+		}
+	}
+	for _, ri := range rqsts["/override"] {
+		if !ri.close || ri.acceptEncoding != "" {
+			t.Errorf("expected the overriding settings to close connections and not request compression, got %+v", ri)
+		}
+	}
+	if len(rqsts["/default"]) != 2 || len(rqsts["/override"]) != 2 {
+		t.Errorf("expected 2 requests to each endpoint, got %v", rqsts)
+	}
+}
+
+// TestClients verifies that the requestors of an endpoint that overrides the Transport
+// settings share a client, so that the endpoint's connection limit applies to all of them
+func TestClients(t *testing.T) {
+	var mux sync.Mutex
+	conns := make(map[string]bool)
+	testSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		conns[r.RemoteAddr] = true
+		mux.Unlock()
+		time.Sleep(5 * time.Millisecond)
+	}))
+	defer testSrv.Close()
+
+	client, err := NewClient(api.Transport{}, nil, 10, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	ep := api.Endpoint{URL: testSrv.URL, Method: http.MethodGet,
+		Transport: &api.Transport{MaxConnsPerHost: 1, Protocol: api.ProtocolHTTP1}}
+	clients, err := NewClients(context.Background(), client, api.Transport{}, []api.Endpoint{ep}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer clients.Close()
+
+	const concurrency, numRqsts = 4, 5
+	respC := make(chan Response, concurrency*numRqsts)
+	rqstr := Requestor{
+		Ctx:       context.Background(),
+		ResponseC: respC,
+		Client:    client,
+		Clients:   clients,
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rqstr.ProcessRqst(ep, newRatePacer(numRqsts, 0, constantArrival{}))
+		}()
+	}
+	wg.Wait()
+	close(respC)
+
+	numResps := 0
+	for resp := range respC {
+		if resp.ErrorType != "" {
+			t.Errorf("unexpected error: %+v", resp)
+		}
+		numResps++
+	}
+	if numResps != concurrency*numRqsts {
+		t.Errorf("expected %d responses, got %d", concurrency*numRqsts, numResps)
+	}
+	mux.Lock()
+	defer mux.Unlock()
+	if len(conns) != 1 {
+		t.Errorf("expected the requestors to share 1 connection, got %d connections", len(conns))
+	}
+
+	ep.CertFile = "cert.pem"
+	if _, err = NewClients(context.Background(), client, api.Transport{}, []api.Endpoint{ep}, nil); err == nil {
+		t.Errorf("expected an error for a CertFile without a KeyFile")
+	}
+}
+
+// TestProtocols verifies that requests are sent using the Transport's Protocol, and that
+// concurrent HTTP/2 and HTTP/3 requests share a connection
+func TestProtocols(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	h2Srv := httptest.NewUnstartedServer(handler)
+	h2Srv.EnableHTTP2 = true
+	h2Srv.StartTLS()
+	defer h2Srv.Close()
+	http1Srv := httptest.NewTLSServer(handler)
+	defer http1Srv.Close()
+	h2cSrv := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+	defer h2cSrv.Close()
+	plainSrv := httptest.NewServer(handler)
+	defer plainSrv.Close()
+
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("unable to create UDP socket: %s", err)
+	}
+	h3Srv := &http3.Server{Handler: handler, TLSConfig: http3.ConfigureTLSConfig(h2Srv.TLS.Clone())}
+	go h3Srv.Serve(udpConn)
+	defer h3Srv.Close()
+	h3URL := "https://" + udpConn.LocalAddr().String()
+
+	tests := []struct {
+		name     string
+		protocol string
+		url      string
+		// expected is the protocol the responses are expected to be received with, if
+		// empty the requests are expected to fail with an ErrProtocol error
+		expected string
+	}{
+		{name: "AutoHTTP2Server", protocol: api.ProtocolAuto, url: h2Srv.URL, expected: "HTTP/2.0"},
+		{name: "DefaultHTTP2Server", url: h2Srv.URL, expected: "HTTP/2.0"},
+		{name: "H2HTTP2Server", protocol: api.ProtocolH2, url: h2Srv.URL, expected: "HTTP/2.0"},
+		{name: "HTTP1HTTP2Server", protocol: api.ProtocolHTTP1, url: h2Srv.URL, expected: "HTTP/1.1"},
+		{name: "AutoHTTP1Server", protocol: api.ProtocolAuto, url: http1Srv.URL, expected: "HTTP/1.1"},
+		{name: "H2HTTP1Server", protocol: api.ProtocolH2, url: http1Srv.URL},
+		{name: "H2C", protocol: api.ProtocolH2C, url: h2cSrv.URL, expected: "HTTP/2.0"},
+		{name: "AutoCleartext", protocol: api.ProtocolAuto, url: plainSrv.URL, expected: "HTTP/1.1"},
+		{name: "H3", protocol: api.ProtocolH3, url: h3URL, expected: "HTTP/3.0"},
+	}
+
+	const concurrency, numRqsts = 4, 20
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// The protocol is an endpoint override so that the requestors share the
+			// endpoint's client, as they do in a run
+			client, err := NewClient(api.Transport{}, &tls.Config{InsecureSkipVerify: true}, 10, time.Second)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			defer CloseClient(client)
+			ep := api.Endpoint{URL: tc.url, Method: http.MethodGet, Transport: &api.Transport{Protocol: tc.protocol}}
+			clients, err := NewClients(context.Background(), client, api.Transport{}, []api.Endpoint{ep}, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			defer clients.Close()
+
+			respC := make(chan Response, numRqsts)
+			rqstr := Requestor{
+				Ctx:       context.Background(),
+				ResponseC: respC,
+				Client:    client,
+				Clients:   clients,
+			}
+			var wg sync.WaitGroup
+			for i := 0; i < concurrency; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					rqstr.ProcessRqst(ep, newRatePacer(numRqsts/concurrency, 0, constantArrival{}))
+				}()
+			}
+			wg.Wait()
+			close(respC)
+
+			connsOpened := 0
+			for resp := range respC {
+				if tc.expected == "" {
+					if resp.ErrorType != api.ErrProtocol {
+						t.Errorf("expected a %s error, got %+v", api.ErrProtocol, resp)
+					}
+					continue
+				}
+				if resp.ErrorType != "" || resp.Protocol != tc.expected {
+					t.Errorf("expected a %s response, got %+v", tc.expected, resp)
+				}
+				if resp.NewConn {
+					connsOpened++
+				}
+				// The QUIC handshake is only timed for the request that opened the connection
+				quicHandshake := resp.QUICHandshakeDuration > 0
+				if expected := tc.protocol == api.ProtocolH3 && resp.NewConn; quicHandshake != expected {
+					t.Errorf("expected the QUIC handshake to be timed to be %t, got a duration of %s", expected,
+						resp.QUICHandshakeDuration)
+				}
+				if resp.RoundTripDuration <= 0 || resp.RoundTripDuration > resp.RequestDuration {
+					t.Errorf("expected a round trip duration between 0 and %s, got %s", resp.RequestDuration,
+						resp.RoundTripDuration)
+				}
+			}
+			switch {
+			case tc.expected == "":
+			case tc.expected == "HTTP/1.1" && (connsOpened < 1 || connsOpened > concurrency):
+				t.Errorf("expected the requests to use 1 to %d connections, got %d connections", concurrency, connsOpened)
+			case tc.expected != "HTTP/1.1" && connsOpened != 1:
+				t.Errorf("expected the concurrent requests to share 1 connection, got %d connections", connsOpened)
+			}
+		})
+	}
+}
+
+// TestCloseClient verifies that closing an HTTP/3 client closes its UDP socket
+func TestCloseClient(t *testing.T) {
+	client, err := NewClient(api.Transport{Protocol: api.ProtocolH3}, nil, 10, time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	rt, ok := client.Transport.(*clientTransport).RoundTripper.(h3RoundTripper)
+	if !ok {
+		t.Fatalf("expected an HTTP/3 round tripper, got %T", client.Transport.(*clientTransport).RoundTripper)
+	}
+	CloseClient(client)
+	if _, err = rt.udpConn.WriteTo([]byte("x"), rt.udpConn.LocalAddr()); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected the UDP socket to be closed, got %v", err)
+	}
+}
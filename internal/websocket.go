@@ -0,0 +1,368 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog/log"
+	"github.com/youngkin/heyyall/api"
+)
+
+// wsCloseTimeout is how long the endpoint has to reply to a close message before the
+// connection is closed regardless
+const wsCloseTimeout = time.Second
+
+// errWSClosed indicates a WebSocket connection was closed by the endpoint before all of
+// its messages were sent and received
+var errWSClosed = errors.New("websocket connection closed")
+
+// isWebSocketURL returns true if 'rawURL' is a WebSocket, i.e., ws:// or wss://, URL
+func isWebSocketURL(rawURL string) bool {
+	lower := strings.ToLower(rawURL)
+	return strings.HasPrefix(lower, "ws://") || strings.HasPrefix(lower, "wss://")
+}
+
+// wsResult describes what happened on a single WebSocket connection
+type wsResult struct {
+	// connectDuration is the time taken to dial and complete the WebSocket handshake
+	connectDuration time.Duration
+	// msgRoundTrips are the round trip times of the messages that were replied to
+	msgRoundTrips []time.Duration
+	msgsSent      int
+	msgsRcvd      int
+	// closeCode is the close code the endpoint closed the connection with, or
+	// websocket.CloseAbnormalClosure if the connection was lost. It's 0 if the connection
+	// was closed by the Requestor.
+	closeCode int
+}
+
+// validateWebSocket returns an error if 'ep', a WebSocket endpoint, is invalid
+func validateWebSocket(ep api.Endpoint) error {
+	if as := ep.Assertions; as != nil && (as.BodyRegex != "" || len(as.JSONPath) > 0 || as.MaxBodySize > 0) {
+		return fmt.Errorf("only Status and Headers Assertions can be specified for a WebSocket endpoint")
+	}
+	if ep.WebSocket == nil {
+		return nil
+	}
+	if ep.WebSocket.MsgRate < 0 {
+		return fmt.Errorf("WebSocket.MsgRate is %d, it can't be negative", ep.WebSocket.MsgRate)
+	}
+	if ep.WebSocket.ExpectMsgs < 0 {
+		return fmt.Errorf("WebSocket.ExpectMsgs is %d, it can't be negative", ep.WebSocket.ExpectMsgs)
+	}
+	_, err := newRqstTemplates(ep, new(int64), nil)
+	return err
+}
+
+// processWebSocket is ProcessRqst for WebSocket endpoints. Each request opens a
+// connection, sends the endpoint's messages, and waits for the messages it expects.
+func (r Requestor) processWebSocket(ep api.Endpoint, pacer Pacer) {
+	ws := api.WebSocket{}
+	if ep.WebSocket != nil {
+		ws = *ep.WebSocket
+	}
+
+	rows := newDataRows(r.DataSources, newRand())
+	tmplts, err := newRqstTemplates(ep, r.Sequences.counter(endpointKey(ep)), rows)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Requestor - endpoint %s has an invalid template", ep.URL)
+		return
+	}
+	asserts, err := newAssertions(ep.Assertions)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Requestor - endpoint %s has invalid Assertions", ep.URL)
+		return
+	}
+
+	// Connections use the endpoint's TLS configuration and request timeout
+	tlsConfig, err := endpointTLSConfig(r.Client, ep)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Requestor - endpoint %s has an invalid TLS configuration", ep.URL)
+		return
+	}
+	timeout, err := endpointTimeout(r.Client, r.Transport, ep)
+	if err != nil {
+		log.Warn().Err(err).Msgf("Requestor - endpoint %s has an invalid Transport", ep.URL)
+		return
+	}
+	dialer := &websocket.Dialer{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+	}
+
+	for {
+		due, stage, ok := pacer.Next(r.Ctx)
+		if !ok {
+			return
+		}
+
+		rows.next()
+		tmplts.next()
+		url, _, headers, err := tmplts.render(nil)
+		var msgs []string
+		if err == nil {
+			msgs, err = tmplts.renderMsgs(nil)
+		}
+		if err != nil && rows.exhausted {
+			log.Debug().Err(err).Msgf("Requestor: endpoint %s has run out of data, exiting", ep.URL)
+			return
+		}
+		if err != nil {
+			log.Warn().Err(err).Msgf("Requestor unable to create WebSocket messages, dropping remaining requests")
+			return
+		}
+
+		response, ok := r.runWSConn(dialer, timeout, url, headers, msgs, ws, ep, due, stage)
+		if !ok {
+			r.sendCancelled(response)
+			return
+		}
+		if response.ErrorType == "" && asserts != nil {
+			response.Asserted = true
+			response.AssertionFailures = asserts.check(response.HTTPStatus, response.Header, nil, 0)
+		}
+
+		if !r.sendResponse(response) {
+			return
+		}
+	}
+}
+
+// runWSConn opens a connection to 'url', a connection to 'ep' that was due at 'due'
+// during 'stage', with 'headers' sent with the handshake. It then sends 'msgs', and
+// waits for the messages expected by 'ws', before closing the connection. All of this can
+// take up to 'timeout'. It returns the Response describing the connection's outcome.
+// 'ok' is false if the Requestor was cancelled, or the run duration expired, before the
+// connection was closed, see interrupted().
+func (r Requestor) runWSConn(dialer *websocket.Dialer, timeout time.Duration, url string, headers map[string]string,
+	msgs []string, ws api.WebSocket, ep api.Endpoint, due time.Time, stage int) (response Response, ok bool) {
+
+	ctx := r.Ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	trace := &rqstTrace{}
+	ctx = httptrace.WithClientTrace(ctx, trace.clientTrace())
+
+	header := make(http.Header, len(headers))
+	for name, val := range headers {
+		header.Add(name, val)
+	}
+
+	late := time.Since(due) > lateRqstThreshold
+	r.Metrics.rqstStarted()
+	start := time.Now()
+	conn, resp, err := dialer.DialContext(ctx, url, header)
+	connectDuration := time.Since(start)
+	if err != nil {
+		r.Metrics.rqstDone()
+		response = Response{
+			Endpoint:        api.Endpoint{URL: ep.URL, Method: http.MethodGet},
+			Stage:           stage,
+			Late:            late,
+			RequestDuration: time.Since(due),
+			NewConn:         trace.newConn,
+		}
+		if r.Ctx.Err() != nil {
+			response.Error = err.Error()
+			return r.interrupted(response), false
+		}
+		// An endpoint that refuses the handshake responds like any other HTTP endpoint
+		if errors.Is(err, websocket.ErrBadHandshake) && resp != nil {
+			response.HTTPStatus, response.Header, response.Protocol = resp.StatusCode, resp.Header, resp.Proto
+			return response, true
+		}
+		log.Debug().Err(err).Msgf("Requestor: error connecting to %s", ep.URL)
+		response.ErrorType = classifyError(err)
+		response.Error = err.Error()
+		return response, true
+	}
+
+	c := newWSConn(conn, ws.ExpectMsgs)
+	c.result.connectDuration = connectDuration
+	err = c.exchange(ctx, msgs, ws.MsgRate)
+	r.Metrics.rqstDone()
+
+	response = Response{
+		HTTPStatus:           resp.StatusCode,
+		Endpoint:             api.Endpoint{URL: ep.URL, Method: http.MethodGet},
+		Stage:                stage,
+		Late:                 late,
+		Header:               resp.Header,
+		RequestDuration:      time.Since(due),
+		DNSLookupDuration:    trace.dnsDone.Sub(trace.dnsStart),
+		TCPConnDuration:      trace.connDone.Sub(trace.connStart),
+		RoundTripDuration:    trace.gotResp.Sub(trace.connDone),
+		TLSHandshakeDuration: trace.tlsDone.Sub(trace.tlsStart),
+		Protocol:             resp.Proto,
+		NewConn:              true,
+		WebSocket:            &c.result,
+	}
+	if r.Ctx.Err() != nil {
+		return r.interrupted(response), false
+	}
+	if err != nil {
+		log.Debug().Err(err).Msgf("Requestor: error exchanging messages with %s", ep.URL)
+		response.ErrorType = classifyError(err)
+		response.Error = err.Error()
+	}
+	return response, true
+}
+
+// wsConn is an open WebSocket connection. Messages are received, and matched with the
+// messages sent to measure their round trip times, in the background.
+type wsConn struct {
+	conn *websocket.Conn
+	// expectMsgs is the number of messages to receive before the connection is closed
+	expectMsgs int
+	// rcvdAll is closed once expectMsgs messages have been received
+	rcvdAll chan struct{}
+	// readDone is closed, and readErr set, when receiving stops
+	readDone chan struct{}
+	readErr  error
+
+	mux sync.Mutex
+	// pending are the times the messages that haven't been replied to were sent, in order
+	pending []time.Time
+	// result is only safe to use without holding 'mux' once readDone is closed
+	result wsResult
+}
+
+func newWSConn(conn *websocket.Conn, expectMsgs int) *wsConn {
+	return &wsConn{
+		conn:       conn,
+		expectMsgs: expectMsgs,
+		rcvdAll:    make(chan struct{}),
+		readDone:   make(chan struct{}),
+	}
+}
+
+// exchange sends 'msgs', 'msgRate' per second, and waits for the expected messages
+// before closing the connection. The connection is closed, and ctx.Err() returned, if
+// 'ctx' is done first. An error wrapping errWSClosed is returned if the endpoint closed
+// the connection first.
+func (c *wsConn) exchange(ctx context.Context, msgs []string, msgRate int) error {
+	// Closing the connection unblocks a write in progress
+	stop := context.AfterFunc(ctx, func() { c.conn.Close() })
+	defer stop()
+	go c.receive()
+
+	err := c.send(ctx, msgs, msgRate)
+	if err == nil && c.expectMsgs > 0 {
+		select {
+		case <-c.rcvdAll:
+		case <-c.readDone:
+			if c.result.msgsRcvd < c.expectMsgs {
+				err = c.readErr
+			}
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	switch {
+	case err == nil:
+		c.close()
+		return nil
+	case ctx.Err() != nil:
+		c.conn.Close()
+		<-c.readDone
+		return ctx.Err()
+	}
+	c.conn.Close()
+	<-c.readDone
+	var closeErr *websocket.CloseError
+	if errors.As(c.readErr, &closeErr) {
+		c.result.closeCode = closeErr.Code
+	} else {
+		c.result.closeCode = websocket.CloseAbnormalClosure
+	}
+	return fmt.Errorf("%w: %v", errWSClosed, c.readErr)
+}
+
+// send sends 'msgs', 'msgRate' per second or as fast as possible if 'msgRate' is 0
+func (c *wsConn) send(ctx context.Context, msgs []string, msgRate int) error {
+	var interval time.Duration
+	if msgRate > 0 {
+		interval = time.Second / time.Duration(msgRate)
+	}
+	next := time.Now()
+	for _, msg := range msgs {
+		if wait := time.Until(next); wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-c.readDone:
+				timer.Stop()
+				return c.readErr
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			}
+		}
+		next = next.Add(interval)
+
+		// The reply may arrive before WriteMessage returns
+		c.mux.Lock()
+		c.pending = append(c.pending, time.Now())
+		c.mux.Unlock()
+		if err := c.conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			return err
+		}
+		c.mux.Lock()
+		c.result.msgsSent++
+		c.mux.Unlock()
+	}
+	return nil
+}
+
+// receive receives messages until the connection is closed
+func (c *wsConn) receive() {
+	defer close(c.readDone)
+	for {
+		_, _, err := c.conn.ReadMessage()
+		if err != nil {
+			c.readErr = err
+			return
+		}
+		rcvd := time.Now()
+
+		c.mux.Lock()
+		c.result.msgsRcvd++
+		if len(c.pending) > 0 {
+			c.result.msgRoundTrips = append(c.result.msgRoundTrips, rcvd.Sub(c.pending[0]))
+			c.pending = c.pending[1:]
+		}
+		if c.result.msgsRcvd == c.expectMsgs {
+			close(c.rcvdAll)
+		}
+		c.mux.Unlock()
+	}
+}
+
+// close closes the connection cleanly by sending a close message and waiting, up to
+// wsCloseTimeout, for the endpoint's reply
+func (c *wsConn) close() {
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	if err := c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsCloseTimeout)); err == nil {
+		select {
+		case <-c.readDone:
+		case <-time.After(wsCloseTimeout):
+		}
+	}
+	c.conn.Close()
+	<-c.readDone
+}
@@ -0,0 +1,279 @@
+// Copyright (c) 2020 Richard Youngkin. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/youngkin/heyyall/api"
+)
+
+// newWSTestHandler returns a handler for WebSocket connections. Depending on the
+// request path it echoes each message, echoes only the first 2 messages, closes the
+// connection with 1011 after the first message, drops the connection after the first
+// message, or refuses the handshake. The messages received are sent to 'msgC'.
+func newWSTestHandler(msgC chan string) http.Handler {
+	upgrader := websocket.Upgrader{}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/reject" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, http.Header{"X-Test": []string{r.Header.Get("X-Test")}})
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for i := 0; ; i++ {
+			msgType, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			msgC <- string(msg)
+			switch {
+			case r.URL.Path == "/error":
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "oops"))
+				return
+			case r.URL.Path == "/drop":
+				return
+			case r.URL.Path == "/echo2" && i >= 2:
+				continue
+			}
+			if err = conn.WriteMessage(msgType, msg); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// TestWebSocket verifies that WebSocket connections exchange the configured messages
+// and that their outcomes are reported
+func TestWebSocket(t *testing.T) {
+	msgC := make(chan string, 100)
+	srv := httptest.NewServer(newWSTestHandler(msgC))
+	defer srv.Close()
+	tlsSrv := httptest.NewTLSServer(newWSTestHandler(msgC))
+	defer tlsSrv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+	wssURL := "wss" + strings.TrimPrefix(tlsSrv.URL, "https")
+
+	tests := []struct {
+		name string
+		ep   api.Endpoint
+		// expectedMsgs are the messages the server is expected to receive on each connection
+		expectedMsgs []string
+		// expected is the expected outcome of each connection, its WebSocket is nil if the
+		// connection isn't expected to be opened
+		expected Response
+		// minDuration is the least time each connection is expected to last
+		minDuration time.Duration
+	}{
+		{
+			name: "Echo",
+			ep: api.Endpoint{URL: wsURL + "/echo", Headers: map[string]string{"X-Test": "abc"},
+				WebSocket:  &api.WebSocket{Messages: []string{"hello", "msg {{ seq }}"}, ExpectMsgs: 2},
+				Assertions: &api.Assertions{Status: []string{"101"}, Headers: map[string]string{"X-Test": "abc"}}},
+			expectedMsgs: []string{"hello", "msg "},
+			expected: Response{HTTPStatus: http.StatusSwitchingProtocols, Asserted: true,
+				WebSocket: &wsResult{msgsSent: 2, msgsRcvd: 2}},
+		},
+		{
+			name:         "TLS",
+			ep:           api.Endpoint{URL: wssURL + "/echo", WebSocket: &api.WebSocket{Messages: []string{"hello"}, ExpectMsgs: 1}},
+			expectedMsgs: []string{"hello"},
+			expected:     Response{HTTPStatus: http.StatusSwitchingProtocols, WebSocket: &wsResult{msgsSent: 1, msgsRcvd: 1}},
+		},
+		{
+			name:     "ConnectOnly",
+			ep:       api.Endpoint{URL: wsURL + "/echo"},
+			expected: Response{HTTPStatus: http.StatusSwitchingProtocols, WebSocket: &wsResult{}},
+		},
+		{
+			name:         "MsgRate",
+			ep:           api.Endpoint{URL: wsURL + "/echo", WebSocket: &api.WebSocket{Messages: []string{"1", "2", "3"}, MsgRate: 20, ExpectMsgs: 3}},
+			expectedMsgs: []string{"1", "2", "3"},
+			expected:     Response{HTTPStatus: http.StatusSwitchingProtocols, WebSocket: &wsResult{msgsSent: 3, msgsRcvd: 3}},
+			minDuration:  100 * time.Millisecond,
+		},
+		{
+			name: "Timeout",
+			ep: api.Endpoint{URL: wsURL + "/echo2", Transport: &api.Transport{RqstTimeout: "100ms"},
+				WebSocket: &api.WebSocket{Messages: []string{"1", "2", "3"}, ExpectMsgs: 3}},
+			expectedMsgs: []string{"1", "2", "3"},
+			expected: Response{HTTPStatus: http.StatusSwitchingProtocols, ErrorType: api.ErrTimeout,
+				WebSocket: &wsResult{msgsSent: 3, msgsRcvd: 2}},
+			minDuration: 100 * time.Millisecond,
+		},
+		{
+			name:         "AbnormalClose",
+			ep:           api.Endpoint{URL: wsURL + "/error", WebSocket: &api.WebSocket{Messages: []string{"1"}, ExpectMsgs: 1}},
+			expectedMsgs: []string{"1"},
+			expected: Response{HTTPStatus: http.StatusSwitchingProtocols, ErrorType: api.ErrWSClosed,
+				WebSocket: &wsResult{msgsSent: 1, closeCode: websocket.CloseInternalServerErr}},
+		},
+		{
+			name:         "Dropped",
+			ep:           api.Endpoint{URL: wsURL + "/drop", WebSocket: &api.WebSocket{Messages: []string{"1"}, ExpectMsgs: 1}},
+			expectedMsgs: []string{"1"},
+			expected: Response{HTTPStatus: http.StatusSwitchingProtocols, ErrorType: api.ErrWSClosed,
+				WebSocket: &wsResult{msgsSent: 1, closeCode: websocket.CloseAbnormalClosure}},
+		},
+		{
+			name:     "Rejected",
+			ep:       api.Endpoint{URL: wsURL + "/reject", WebSocket: &api.WebSocket{Messages: []string{"1"}}},
+			expected: Response{HTTPStatus: http.StatusForbidden},
+		},
+	}
+
+	const numConns = 2
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			client, err := NewClient(api.Transport{}, &tls.Config{InsecureSkipVerify: true}, 10, time.Second)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			respC := make(chan Response, numConns)
+			rqstr := Requestor{
+				Ctx:       context.Background(),
+				ResponseC: respC,
+				Client:    client,
+			}
+			rqstr.ProcessRqst(tc.ep, newRatePacer(numConns, 0, constantArrival{}))
+			close(respC)
+
+			numResps := 0
+			for resp := range respC {
+				numResps++
+				if resp.HTTPStatus != tc.expected.HTTPStatus || resp.ErrorType != tc.expected.ErrorType ||
+					resp.Asserted != tc.expected.Asserted || len(resp.AssertionFailures) > 0 {
+					t.Errorf("expected %+v, got %+v", tc.expected, resp)
+				}
+				if resp.Endpoint.URL != tc.ep.URL || resp.Endpoint.Method != http.MethodGet {
+					t.Errorf("expected the response to be for GET %s, got %+v", tc.ep.URL, resp.Endpoint)
+				}
+				if resp.RequestDuration < tc.minDuration {
+					t.Errorf("expected the connection to last at least %s, got %s", tc.minDuration, resp.RequestDuration)
+				}
+				if tc.expected.WebSocket == nil {
+					if resp.WebSocket != nil {
+						t.Errorf("expected no WebSocket result, got %+v", resp.WebSocket)
+					}
+					continue
+				}
+				checkWSResult(t, *tc.expected.WebSocket, resp.WebSocket)
+			}
+			if numResps != numConns {
+				t.Errorf("expected %d responses, got %d", numConns, numResps)
+			}
+
+			var msgs []string
+			for len(msgC) > 0 {
+				msgs = append(msgs, <-msgC)
+			}
+			if len(msgs) != numConns*len(tc.expectedMsgs) {
+				t.Fatalf("expected the server to receive %d messages, got %v", numConns*len(tc.expectedMsgs), msgs)
+			}
+			for i, msg := range msgs {
+				if !strings.HasPrefix(msg, tc.expectedMsgs[i%len(tc.expectedMsgs)]) {
+					t.Errorf("expected message %d to start with %q, got %q", i, tc.expectedMsgs[i%len(tc.expectedMsgs)], msg)
+				}
+			}
+		})
+	}
+}
+
+// checkWSResult verifies that the message counts and close code of 'actual' match
+// 'expected', and that each message received in reply has a round trip time
+func checkWSResult(t *testing.T, expected wsResult, actual *wsResult) {
+	t.Helper()
+	if actual == nil {
+		t.Fatalf("expected a WebSocket result, got none")
+	}
+	if actual.msgsSent != expected.msgsSent || actual.msgsRcvd != expected.msgsRcvd || actual.closeCode != expected.closeCode {
+		t.Errorf("expected %d messages sent, %d received, and close code %d, got %d, %d, and %d", expected.msgsSent,
+			expected.msgsRcvd, expected.closeCode, actual.msgsSent, actual.msgsRcvd, actual.closeCode)
+	}
+	if len(actual.msgRoundTrips) != actual.msgsRcvd {
+		t.Errorf("expected %d round trips, got %v", actual.msgsRcvd, actual.msgRoundTrips)
+	}
+	for _, rtt := range actual.msgRoundTrips {
+		if rtt <= 0 {
+			t.Errorf("expected a positive round trip time, got %s", rtt)
+		}
+	}
+	if actual.connectDuration <= 0 {
+		t.Errorf("expected a positive connect duration, got %s", actual.connectDuration)
+	}
+}
+
+func TestValidateWebSocket(t *testing.T) {
+	tests := []struct {
+		name      string
+		ep        api.Endpoint
+		expectErr bool
+	}{
+		{name: "NoWebSocket", ep: api.Endpoint{URL: "ws://localhost/chat"}},
+		{name: "Valid", ep: api.Endpoint{URL: "wss://localhost/chat",
+			WebSocket: &api.WebSocket{Messages: []string{"{{ seq }}"}, MsgRate: 10, ExpectMsgs: 1}}},
+		{name: "NegativeMsgRate", ep: api.Endpoint{URL: "ws://localhost/chat", WebSocket: &api.WebSocket{MsgRate: -1}},
+			expectErr: true},
+		{name: "NegativeExpectMsgs", ep: api.Endpoint{URL: "ws://localhost/chat", WebSocket: &api.WebSocket{ExpectMsgs: -1}},
+			expectErr: true},
+		{name: "InvalidMsgTemplate", ep: api.Endpoint{URL: "ws://localhost/chat",
+			WebSocket: &api.WebSocket{Messages: []string{"{{ seq"}}}, expectErr: true},
+		{name: "BodyAssertions", ep: api.Endpoint{URL: "ws://localhost/chat",
+			Assertions: &api.Assertions{BodyRegex: "ok"}}, expectErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateWebSocket(tc.ep)
+			if tc.expectErr != (err != nil) {
+				t.Errorf("expected an error to be %t, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
+
+// TestWSConnConcurrency verifies that messages received while messages are being sent
+// are safely matched with the messages sent
+func TestWSConnConcurrency(t *testing.T) {
+	srv := httptest.NewServer(newWSTestHandler(make(chan string, 1000)))
+	defer srv.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/echo", nil)
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+				return
+			}
+			msgs := make([]string, 100)
+			for j := range msgs {
+				msgs[j] = "msg"
+			}
+			c := newWSConn(conn, len(msgs))
+			if err = c.exchange(context.Background(), msgs, 0); err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if c.result.msgsRcvd != len(msgs) || len(c.result.msgRoundTrips) != len(msgs) {
+				t.Errorf("expected %d messages and round trips, got %d and %d", len(msgs), c.result.msgsRcvd,
+					len(c.result.msgRoundTrips))
+			}
+		}()
+	}
+	wg.Wait()
+}